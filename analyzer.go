@@ -0,0 +1,648 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The kinds of warning, as named in a "// lox:ignore" comment.
+const (
+	UnusedWarning          = "unused"
+	UnreachableWarning     = "unreachable"
+	ShadowWarning          = "shadow"
+	DuplicateMethodWarning = "duplicate-method"
+	ArityWarning           = "arity"
+	MatchWarning           = "match"
+)
+
+// IgnoreDirective in a comment turns off the warnings on its line: all of
+// them, or only the kinds listed after it, as in "// lox:ignore unused".
+const IgnoreDirective = "lox:ignore"
+
+// Suppressions holds, by line, the kinds of warning turned off there. An
+// empty list turns off every kind.
+type Suppressions map[int][]string
+
+func NewSuppressions(comments []*Token) Suppressions {
+	suppressions := make(Suppressions)
+	for _, comment := range comments {
+		text := strings.TrimSpace(strings.TrimPrefix(comment.Lexeme, "//"))
+		if !strings.HasPrefix(text, IgnoreDirective) {
+			continue
+		}
+
+		suppressions[comment.Line] = strings.FieldsFunc(text[len(IgnoreDirective):], func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	return suppressions
+}
+
+func (s Suppressions) Suppressed(warning *Error) bool {
+	return s.Ignores(warning.Line, warning.Code)
+}
+
+// Ignores reports whether warnings of kind code are turned off on line.
+func (s Suppressions) Ignores(line int, code string) bool {
+	codes, ok := s[line]
+	if !ok {
+		return false
+	}
+	if len(codes) == 0 {
+		return true
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// declaration is a name the analyzer has seen declared. fun is the
+// function a name was declared with, when calls to it can be checked.
+type declaration struct {
+	token      Token
+	kind       string
+	used       bool
+	reassigned bool
+	fun        *FunctionCommon
+}
+
+// pendingCall is a call of a declared function, checked once the whole
+// program is known not to assign the name elsewhere.
+type pendingCall struct {
+	token  Token
+	callee *declaration
+	name   string
+	// positional counts the positional arguments, named the named ones
+	positional int
+	named      int
+}
+
+// Analyzer looks for code that is likely a mistake but still runs: unused
+// locals and parameters, code that can't be reached, shadowed variables,
+// methods defined twice and calls with the wrong number of arguments.
+type Analyzer struct {
+	warnings *ErrorHandler
+	// scopes holds the declarations of each scope, innermost last; scopes[0]
+	// has the globals
+	scopes []map[string]*declaration
+	calls  []pendingCall
+}
+
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{warnings: NewErrorHandler()}
+}
+
+func (a *Analyzer) Warnings() *ErrorHandler {
+	return a.warnings
+}
+
+func (a *Analyzer) Analyze(program *Program) {
+	a.beginScope()
+	for _, stmt := range program.Statements {
+		a.hoist(stmt)
+	}
+	a.analyzeStatements(program.Statements)
+	a.scopes = a.scopes[:0]
+
+	for _, call := range a.calls {
+		a.checkArity(call)
+	}
+}
+
+func (a *Analyzer) warn(token Token, code string, format string, args ...interface{}) {
+	a.warnings.AddError(&Error{Token: token, Message: fmt.Sprintf(format, args...), Line: token.Line, Severity: SeverityWarning, Code: code})
+}
+
+func (a *Analyzer) beginScope() {
+	a.scopes = append(a.scopes, make(map[string]*declaration))
+}
+
+// endScope warns about the scope's locals that were never read. Names
+// starting with an underscore are meant to go unused.
+func (a *Analyzer) endScope() {
+	scope := a.scopes[len(a.scopes)-1]
+	a.scopes = a.scopes[:len(a.scopes)-1]
+
+	var unused []*declaration
+	for name, decl := range scope {
+		if !decl.used && !strings.HasPrefix(name, "_") {
+			unused = append(unused, decl)
+		}
+	}
+	// map order is random, report in source order
+	sort.Slice(unused, func(i, j int) bool {
+		if unused[i].token.Line != unused[j].token.Line {
+			return unused[i].token.Line < unused[j].token.Line
+		}
+		return unused[i].token.Lexeme < unused[j].token.Lexeme
+	})
+	for _, decl := range unused {
+		a.warn(decl.token, UnusedWarning, "%s '%s' is never used", decl.kind, decl.token.Lexeme)
+	}
+}
+
+// declare adds name to the innermost scope, warning when a local hides a
+// variable of an enclosing scope. Declaring a name again in the same scope
+// counts as assigning it.
+func (a *Analyzer) declare(name *Identifier, kind string) *declaration {
+	scope := a.scopes[len(a.scopes)-1]
+	if decl, ok := scope[name.Value]; ok {
+		decl.reassigned = true
+		return decl
+	}
+
+	if len(a.scopes) > 1 && !strings.HasPrefix(name.Value, "_") {
+		if outer := a.find(name.Value); outer != nil {
+			a.warn(name.Token, ShadowWarning, "%s '%s' shadows the one declared on line %d", kind, name.Value, outer.token.Line)
+		}
+	}
+
+	decl := &declaration{token: name.Token, kind: kind}
+	if len(a.scopes) == 1 {
+		// globals can be used from anywhere, even another module
+		decl.used = true
+	}
+	scope[name.Value] = decl
+	return decl
+}
+
+func (a *Analyzer) find(name string) *declaration {
+	for idx := len(a.scopes) - 1; idx >= 0; idx-- {
+		if decl, ok := a.scopes[idx][name]; ok {
+			return decl
+		}
+	}
+	return nil
+}
+
+// hoist declares a global ahead of the code that runs before it.
+func (a *Analyzer) hoist(stmt Statement) {
+	switch stmt := stmt.(type) {
+	case *VarStatement:
+		a.declare(stmt.Identifier, "variable")
+	case *DestructureStatement:
+		a.declarePattern(stmt.Pattern)
+	case *FunctionDeclaration:
+		if stmt.Name != nil {
+			a.declare(stmt.Name, "function").fun = &stmt.FunctionCommon
+		}
+	case *ClassStatement:
+		a.declare(stmt.Name, "class").fun = constructor(stmt)
+	case *ExportStatement:
+		a.hoist(stmt.Declaration)
+	case *ImportStatement:
+		if stmt.Alias != nil {
+			a.declare(stmt.Alias, "module")
+		}
+		for _, name := range stmt.Names {
+			a.declare(name, "import")
+		}
+	case *For:
+		if init, ok := stmt.Initializer.(*VarStatement); ok && init.Token.Type == VAR {
+			a.hoist(init)
+		}
+	}
+}
+
+// constructor returns the init method calls to class go to, or an empty
+// one when the class takes no arguments. An inherited init is unknown.
+func constructor(class *ClassStatement) *FunctionCommon {
+	for _, method := range class.Methods {
+		if method.Name.Value == "init" && !method.IsStatic {
+			return &method.FunctionCommon
+		}
+	}
+	if class.SuperClass != nil {
+		return nil
+	}
+	return &FunctionCommon{}
+}
+
+func (a *Analyzer) declarePattern(pattern Destructure) {
+	var elements []*DestructureElement
+	var rest Expression
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		elements = pattern.Elements
+		rest = pattern.Rest
+	case *HashDestructure:
+		elements = pattern.Entries
+	}
+
+	for _, element := range elements {
+		if element.Pattern != nil {
+			a.declarePattern(element.Pattern)
+		} else if name, ok := element.Target.(*Identifier); ok {
+			a.declare(name, "variable")
+		}
+	}
+	if name, ok := rest.(*Identifier); ok {
+		a.declare(name, "variable")
+	}
+}
+
+func (a *Analyzer) isGlobalScope() bool {
+	return len(a.scopes) == 1
+}
+
+// analyzeStatements analyzes statements run in order in the innermost
+// scope, warning once about the first that follows one control never
+// gets past.
+func (a *Analyzer) analyzeStatements(statements []Statement) {
+	reported := false
+	for idx, stmt := range statements {
+		if !reported && idx > 0 && terminates(statements[idx-1]) {
+			a.warn(nodeToken(stmt), UnreachableWarning, "unreachable code")
+			reported = true
+		}
+		a.analyzeStatement(stmt)
+	}
+}
+
+func (a *Analyzer) analyzeBlock(block *BlockStatement) {
+	if block == nil {
+		return
+	}
+
+	a.beginScope()
+	a.analyzeStatements(block.Statements)
+	a.endScope()
+}
+
+// terminates reports whether control never gets past stmt.
+func terminates(stmt Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ReturnStatement, *BreakStatement, *ContinueStatement, *ThrowStatement:
+		return true
+	case *BlockStatement:
+		for _, s := range stmt.Statements {
+			if terminates(s) {
+				return true
+			}
+		}
+	case *IfStatement:
+		return stmt.ElseBranch != nil && terminates(stmt.ThenBranch) && terminates(stmt.ElseBranch)
+	}
+	return false
+}
+
+func (a *Analyzer) analyzeStatement(stmt Statement) {
+	switch stmt := stmt.(type) {
+	case *ExpressionStatement:
+		a.analyzeExpression(stmt.Expression)
+	case *VarStatement:
+		a.analyzeExpression(stmt.Expression)
+		if !a.isGlobalScope() {
+			a.declare(stmt.Identifier, "variable")
+		}
+	case *DestructureStatement:
+		a.analyzeExpression(stmt.Value)
+		a.analyzeDestructureDefaults(stmt.Pattern)
+		if !a.isGlobalScope() {
+			a.declarePattern(stmt.Pattern)
+		}
+	case *BlockStatement:
+		a.analyzeBlock(stmt)
+	case *FunctionDeclaration:
+		if stmt.Name != nil && !a.isGlobalScope() {
+			a.declare(stmt.Name, "function").fun = &stmt.FunctionCommon
+		}
+		a.analyzeFunction(&stmt.FunctionCommon, false)
+	case *ClassStatement:
+		a.analyzeClass(stmt)
+	case *ExportStatement:
+		a.analyzeStatement(stmt.Declaration)
+	case *TestStatement:
+		a.analyzeBlock(stmt.Body)
+	case *IfStatement:
+		a.analyzeExpression(stmt.Condition)
+		a.analyzeBlock(stmt.ThenBranch)
+		a.analyzeBlock(stmt.ElseBranch)
+	case *While:
+		a.analyzeExpression(stmt.Condition)
+		a.analyzeBlock(stmt.Body)
+	case *For:
+		// let and const in the initializer belong to the loop, var to the
+		// enclosing scope
+		init, ok := stmt.Initializer.(*VarStatement)
+		scoped := ok && init.Token.Type != VAR
+		if scoped {
+			a.beginScope()
+		}
+		if stmt.Initializer != nil {
+			a.analyzeStatement(stmt.Initializer)
+		}
+		a.analyzeExpression(stmt.Condition)
+		a.analyzeExpression(stmt.Increment)
+		a.analyzeBlock(stmt.Body)
+		if scoped {
+			a.endScope()
+		}
+	case *ForInStatement:
+		a.analyzeExpression(stmt.Iterable)
+		a.beginScope()
+		if stmt.Key != nil {
+			a.declare(stmt.Key, "variable")
+		}
+		a.declare(stmt.Value, "variable")
+		a.analyzeBlock(stmt.Body)
+		a.endScope()
+	case *ReturnStatement:
+		a.analyzeExpression(stmt.ReturnValue)
+	case *ThrowStatement:
+		a.analyzeExpression(stmt.Value)
+	case *TryStatement:
+		a.analyzeBlock(stmt.Body)
+		if stmt.Catch != nil {
+			a.beginScope()
+			if stmt.CatchName != nil {
+				a.declare(stmt.CatchName, "variable")
+			}
+			a.analyzeBlock(stmt.Catch)
+			a.endScope()
+		}
+		a.analyzeBlock(stmt.Finally)
+	case *SelectStatement:
+		for _, c := range stmt.Cases {
+			a.analyzeExpression(c.Channel)
+			a.analyzeExpression(c.Value)
+			a.beginScope()
+			if c.Name != nil {
+				a.declare(c.Name, "variable")
+			}
+			a.analyzeBlock(c.Body)
+			a.endScope()
+		}
+		a.analyzeBlock(stmt.Default)
+	}
+}
+
+// analyzeFunction analyzes fun with its parameters in a scope of their own.
+// this is declared for methods, and never reported.
+func (a *Analyzer) analyzeFunction(fun *FunctionCommon, method bool) {
+	a.beginScope()
+	if method {
+		a.scopes[len(a.scopes)-1]["this"] = &declaration{used: true}
+	}
+	for idx, param := range fun.Params {
+		if idx < len(fun.Defaults) {
+			a.analyzeExpression(fun.Defaults[idx])
+		}
+		a.declare(param, "parameter")
+	}
+	if fun.Rest != nil {
+		a.declare(fun.Rest, "parameter")
+	}
+	a.analyzeBlock(fun.Body)
+	a.endScope()
+}
+
+func (a *Analyzer) analyzeClass(class *ClassStatement) {
+	if !a.isGlobalScope() {
+		a.declare(class.Name, "class").fun = constructor(class)
+	}
+	if class.SuperClass != nil {
+		a.analyzeExpression(class.SuperClass)
+	}
+
+	// instance and static methods share one namespace
+	seen := make(map[string]bool)
+	for _, method := range class.Methods {
+		if seen[method.Name.Value] {
+			a.warn(method.Name.Token, DuplicateMethodWarning, "method '%s' is already defined in class %s", method.Name.Value, class.Name.Value)
+		}
+		seen[method.Name.Value] = true
+
+		a.analyzeFunction(&method.FunctionCommon, !method.IsStatic)
+	}
+}
+
+func (a *Analyzer) analyzeDestructureDefaults(pattern Destructure) {
+	var elements []*DestructureElement
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		elements = pattern.Elements
+	case *HashDestructure:
+		elements = pattern.Entries
+	}
+
+	for _, element := range elements {
+		a.analyzeExpression(element.Default)
+		if element.Pattern != nil {
+			a.analyzeDestructureDefaults(element.Pattern)
+		}
+	}
+}
+
+// assign notes an assignment to name, which no longer holds what it was
+// declared with.
+func (a *Analyzer) assign(name *Identifier) {
+	if decl := a.find(name.Value); decl != nil {
+		decl.reassigned = true
+	}
+}
+
+// analyzeTarget analyzes an expression being assigned to; updating a
+// variable reads it too.
+func (a *Analyzer) analyzeTarget(target Expression, read bool) {
+	if name, ok := target.(*Identifier); ok {
+		a.assign(name)
+		if read {
+			a.analyzeExpression(name)
+		}
+		return
+	}
+	a.analyzeExpression(target)
+}
+
+func (a *Analyzer) analyzeAssignedPattern(pattern Destructure) {
+	var elements []*DestructureElement
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		elements = pattern.Elements
+		if pattern.Rest != nil {
+			a.analyzeTarget(pattern.Rest, false)
+		}
+	case *HashDestructure:
+		elements = pattern.Entries
+	}
+
+	for _, element := range elements {
+		a.analyzeExpression(element.Default)
+		if element.Pattern != nil {
+			a.analyzeAssignedPattern(element.Pattern)
+		} else {
+			a.analyzeTarget(element.Target, false)
+		}
+	}
+}
+
+func (a *Analyzer) analyzeCall(call *CallExpression) {
+	a.analyzeExpression(call.Callee)
+	for _, arg := range call.Arguments {
+		a.analyzeExpression(arg)
+	}
+	for _, named := range call.Named {
+		a.analyzeExpression(named.Value)
+	}
+
+	name, ok := call.Callee.(*Identifier)
+	if !ok {
+		return
+	}
+	decl := a.find(name.Value)
+	if decl == nil || decl.fun == nil {
+		return
+	}
+	for _, arg := range call.Arguments {
+		// a spread's length is only known when it runs
+		if _, ok := arg.(*SpreadExpression); ok {
+			return
+		}
+	}
+
+	a.calls = append(a.calls, pendingCall{token: name.Token, callee: decl, name: name.Value, positional: len(call.Arguments), named: len(call.Named)})
+}
+
+// checkArity warns about a call that will fail for its number of
+// arguments, unless the function may have been replaced.
+func (a *Analyzer) checkArity(call pendingCall) {
+	if call.callee.reassigned {
+		return
+	}
+
+	fun := call.callee.fun
+	params := len(fun.Params)
+	required := requiredParameters(params, fun.Defaults)
+	switch {
+	case call.positional > params && fun.Rest == nil:
+		a.warn(call.token, ArityWarning, "%s takes at most %d arguments, called with %d", call.name, params, call.positional)
+	case call.positional+call.named < required:
+		a.warn(call.token, ArityWarning, "%s takes at least %d arguments, called with %d", call.name, required, call.positional+call.named)
+	}
+}
+
+func (a *Analyzer) analyzeExpression(expr Expression) {
+	switch expr := expr.(type) {
+	case *Identifier:
+		if decl := a.find(expr.Value); decl != nil {
+			decl.used = true
+		}
+	case *Assignment:
+		a.analyzeExpression(expr.Expression)
+		a.assign(&expr.Identifier)
+	case *CompoundAssignment:
+		a.analyzeExpression(expr.Value)
+		a.analyzeTarget(expr.Target, true)
+	case *UpdateExpression:
+		a.analyzeTarget(expr.Target, true)
+	case *DestructureAssignment:
+		a.analyzeExpression(expr.Value)
+		a.analyzeAssignedPattern(expr.Pattern)
+	case *FunctionLiteral:
+		a.beginScope()
+		if expr.Name != nil {
+			a.scopes[len(a.scopes)-1][expr.Name.Value] = &declaration{token: expr.Name.Token, used: true, fun: &expr.FunctionCommon}
+		}
+		a.analyzeFunction(&expr.FunctionCommon, false)
+		a.endScope()
+	case *Binary:
+		a.analyzeExpression(expr.Left)
+		a.analyzeExpression(expr.Right)
+	case *Logical:
+		a.analyzeExpression(expr.Left)
+		a.analyzeExpression(expr.Right)
+	case *Unary:
+		a.analyzeExpression(expr.Right)
+	case *GroupedExpression:
+		a.analyzeExpression(expr.Expression)
+	case *TernaryExpression:
+		a.analyzeExpression(expr.Condition)
+		a.analyzeExpression(expr.ThenBranch)
+		a.analyzeExpression(expr.ElseBranch)
+	case *CallExpression:
+		a.analyzeCall(expr)
+	case *SpreadExpression:
+		a.analyzeExpression(expr.Value)
+	case *SpawnExpression:
+		a.analyzeExpression(expr.Call)
+	case *AwaitExpression:
+		a.analyzeExpression(expr.Value)
+	case *YieldExpression:
+		a.analyzeExpression(expr.Value)
+	case *GetExpression:
+		a.analyzeExpression(expr.Object)
+	case *SetExpression:
+		a.analyzeExpression(expr.Object)
+		a.analyzeExpression(expr.Value)
+	case *IndexExpression:
+		a.analyzeExpression(expr.Left)
+		a.analyzeExpression(expr.Index)
+	case *SliceExpression:
+		a.analyzeExpression(expr.Left)
+		a.analyzeExpression(expr.Start)
+		a.analyzeExpression(expr.End)
+	case *SetIndexExpression:
+		a.analyzeExpression(expr.Left)
+		a.analyzeExpression(expr.Index)
+		a.analyzeExpression(expr.Value)
+	case *SetSliceExpression:
+		a.analyzeExpression(expr.Left)
+		a.analyzeExpression(expr.Start)
+		a.analyzeExpression(expr.End)
+		a.analyzeExpression(expr.Value)
+	case *RangeExpression:
+		a.analyzeExpression(expr.Start)
+		a.analyzeExpression(expr.End)
+		a.analyzeExpression(expr.Step)
+	case *ArrayLiteral:
+		for _, element := range expr.Elements {
+			a.analyzeExpression(element)
+		}
+	case *HashLiteral:
+		for key, value := range expr.Pairs {
+			a.analyzeExpression(key)
+			a.analyzeExpression(value)
+		}
+	case *InterpolationExpression:
+		for _, part := range expr.Parts {
+			a.analyzeExpression(part)
+		}
+	case *MatchExpression:
+		a.analyzeExpression(expr.Subject)
+		for _, arm := range expr.Arms {
+			a.beginScope()
+			for _, pattern := range arm.Patterns {
+				a.declareBindings(pattern)
+			}
+			a.analyzeExpression(arm.Guard)
+			a.analyzeExpression(arm.Body)
+			a.analyzeBlock(arm.Block)
+			a.endScope()
+		}
+	}
+}
+
+func (a *Analyzer) declareBindings(pattern Pattern) {
+	switch pattern := pattern.(type) {
+	case *BindingPattern:
+		a.declare(pattern.Name, "variable")
+	case *ArrayPattern:
+		for _, element := range pattern.Elements {
+			a.declareBindings(element)
+		}
+	case *HashPattern:
+		for _, value := range pattern.Values {
+			a.declareBindings(value)
+		}
+	case *ClassPattern:
+		a.analyzeExpression(pattern.Class)
+		for _, field := range pattern.Fields {
+			a.declareBindings(field)
+		}
+	}
+}
@@ -25,6 +25,22 @@ type Statement interface {
 
 type Program struct {
 	Statements []Statement
+	// Comments are the program's comments, in source order
+	Comments []*Token
+}
+
+// Strict reports whether the program starts with the "use strict"
+// directive.
+func (p *Program) Strict() bool {
+	if len(p.Statements) == 0 {
+		return false
+	}
+	stmt, ok := p.Statements[0].(*ExpressionStatement)
+	if !ok {
+		return false
+	}
+	directive, ok := stmt.Expression.(*StringLiteral)
+	return ok && directive.Value == StrictDirective
 }
 
 func (p *Program) String() string {
@@ -140,7 +156,15 @@ type FunctionCommon struct {
 	Token  Token // ( token
 	Name   *Identifier
 	Params []*Identifier
-	Body   *BlockStatement
+	// Defaults holds each parameter's default value, nil when it has none.
+	Defaults []Expression
+	// Rest collects the extra positional arguments of a variadic function.
+	Rest *Identifier
+	Body *BlockStatement
+	// IsGenerator marks a function* whose calls return a generator.
+	IsGenerator bool
+	// IsAsync marks an async function whose calls return a promise.
+	IsAsync bool
 }
 
 func (fc *FunctionCommon) TokenLiteral() string {
@@ -151,11 +175,24 @@ func (fc *FunctionCommon) String() string {
 	var str strings.Builder
 
 	var params []string
-	for _, p := range fc.Params {
+	for idx, p := range fc.Params {
+		if idx < len(fc.Defaults) && fc.Defaults[idx] != nil {
+			params = append(params, p.String()+" = "+fc.Defaults[idx].String())
+			continue
+		}
 		params = append(params, p.String())
 	}
+	if fc.Rest != nil {
+		params = append(params, ELLIPSIS+fc.Rest.String())
+	}
 
+	if fc.IsAsync {
+		str.WriteString("async ")
+	}
 	str.WriteString(fc.TokenLiteral())
+	if fc.IsGenerator {
+		str.WriteString(STAR)
+	}
 	if fc.Name != nil {
 		str.WriteString(fc.Name.String())
 	}
@@ -167,6 +204,20 @@ func (fc *FunctionCommon) String() string {
 	return str.String()
 }
 
+// Required is the number of leading parameters without a default.
+func (fc *FunctionCommon) Required() int {
+	return requiredParameters(len(fc.Params), fc.Defaults)
+}
+
+func requiredParameters(params int, defaults []Expression) int {
+	for idx := 0; idx < params; idx++ {
+		if idx < len(defaults) && defaults[idx] != nil {
+			return idx
+		}
+	}
+	return params
+}
+
 type FunctionDeclaration struct {
 	FunctionCommon
 }
@@ -204,6 +255,9 @@ func (md *MethodDeclaration) String() string {
 		params = append(params, p.String())
 	}
 
+	if md.IsGenerator {
+		str.WriteString(STAR)
+	}
 	if md.Name != nil {
 		str.WriteString(md.Name.String())
 	}
@@ -231,6 +285,9 @@ func (es *ExpressionStatement) Accept(visitor Visitor, env *Environment) Object
 	return visitor.VisitExpressionStatement(es, env)
 }
 
+// VarStatement declares a variable with var, let or const, the keyword
+// being its Token. let and const are scoped to the enclosing block, and a
+// const can't be assigned after its declaration.
 type VarStatement struct {
 	Token      Token
 	Identifier *Identifier
@@ -257,9 +314,136 @@ func (vs *VarStatement) Accept(visitor Visitor, env *Environment) Object {
 	return visitor.VisitVarStatement(vs, env)
 }
 
+// Destructure is the left-hand side of a destructuring declaration,
+// assignment or parameter.
+type Destructure interface {
+	String() string
+	destructureNode()
+}
+
+// DestructureElement is one slot of a destructuring pattern. Target is an
+// identifier (or, in an assignment, any assignable expression) unless
+// Pattern holds a nested destructuring. Key names the hash entry and is
+// empty for array elements. Default is used when the slot is missing.
+type DestructureElement struct {
+	Key     string
+	Target  Expression
+	Pattern Destructure
+	Default Expression
+}
+
+func (de *DestructureElement) String() string {
+	var str strings.Builder
+
+	target := ""
+	if de.Pattern != nil {
+		target = de.Pattern.String()
+	} else {
+		target = de.Target.String()
+	}
+
+	if de.Key != "" && de.Key != target {
+		str.WriteString(de.Key + COLON + " ")
+	}
+	str.WriteString(target)
+	if de.Default != nil {
+		str.WriteString(" = " + de.Default.String())
+	}
+
+	return str.String()
+}
+
+// ArrayDestructure takes array elements by position. Rest, if present,
+// receives the elements left over as a new array.
+type ArrayDestructure struct {
+	Token    Token
+	Elements []*DestructureElement
+	Rest     Expression
+}
+
+func (ad *ArrayDestructure) destructureNode() {}
+func (ad *ArrayDestructure) String() string {
+	var elements []string
+	for _, e := range ad.Elements {
+		elements = append(elements, e.String())
+	}
+	if ad.Rest != nil {
+		elements = append(elements, ELLIPSIS+ad.Rest.String())
+	}
+
+	return LEFT_BRACE + strings.Join(elements, COMMA+" ") + RIGHT_BRACE
+}
+
+// Required is the number of leading elements that have to be present, the
+// ones up to the last element without a default.
+func (ad *ArrayDestructure) Required() int {
+	required := 0
+	for idx, e := range ad.Elements {
+		if e.Default == nil {
+			required = idx + 1
+		}
+	}
+
+	return required
+}
+
+// HashDestructure takes hash entries, or instance fields, by key.
+type HashDestructure struct {
+	Token   Token
+	Entries []*DestructureElement
+}
+
+func (hd *HashDestructure) destructureNode() {}
+func (hd *HashDestructure) String() string {
+	var entries []string
+	for _, e := range hd.Entries {
+		entries = append(entries, e.String())
+	}
+
+	return LEFT_BRACKET + strings.Join(entries, COMMA+" ") + RIGHT_BRACKET
+}
+
+// DestructureStatement declares every variable named in Pattern.
+type DestructureStatement struct {
+	Token   Token
+	Pattern Destructure
+	Value   Expression
+}
+
+func (ds *DestructureStatement) statementNode() {}
+func (ds *DestructureStatement) String() string {
+	return ds.TokenLiteral() + " " + ds.Pattern.String() + " = " + ds.Value.String()
+}
+func (ds *DestructureStatement) TokenLiteral() string {
+	return ds.Token.Lexeme
+}
+func (ds *DestructureStatement) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitDestructureStatement(ds, env)
+}
+
+// DestructureAssignment stores into existing targets and evaluates to Value.
+type DestructureAssignment struct {
+	Token   Token
+	Pattern Destructure
+	Value   Expression
+}
+
+func (da *DestructureAssignment) expressionNode() {}
+func (da *DestructureAssignment) String() string {
+	return da.Pattern.String() + " = " + da.Value.String()
+}
+func (da *DestructureAssignment) TokenLiteral() string {
+	return da.Token.Lexeme
+}
+func (da *DestructureAssignment) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitDestructureAssignment(da, env)
+}
+
 type BlockStatement struct {
 	Token      Token
 	Statements []Statement
+	// End is the closing brace
+	End Token
 }
 
 func (b *BlockStatement) statementNode() {}
@@ -360,6 +544,238 @@ func (r *ReturnStatement) Accept(visitor Visitor, env *Environment) Object {
 	return visitor.VisitReturnStatement(r, env)
 }
 
+// YieldExpression suspends a generator, handing Value (nil for a bare
+// yield) to the caller of next(). It evaluates to what next() was passed.
+type YieldExpression struct {
+	Token Token
+	Value Expression
+}
+
+func (y *YieldExpression) expressionNode() {}
+func (y *YieldExpression) TokenLiteral() string {
+	return y.Token.Lexeme
+}
+func (y *YieldExpression) String() string {
+	if y.Value == nil {
+		return y.TokenLiteral()
+	}
+	return y.TokenLiteral() + " " + y.Value.String()
+}
+func (y *YieldExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitYieldExpression(y, env)
+}
+
+// AwaitExpression waits for the promise Value evaluates to and evaluates
+// to its result; any other value is its own result.
+type AwaitExpression struct {
+	Token Token
+	Value Expression
+}
+
+func (a *AwaitExpression) expressionNode() {}
+func (a *AwaitExpression) TokenLiteral() string {
+	return a.Token.Lexeme
+}
+func (a *AwaitExpression) String() string {
+	return a.TokenLiteral() + " " + a.Value.String()
+}
+func (a *AwaitExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitAwaitExpression(a, env)
+}
+
+// SpawnExpression runs Call on a thread of its own and evaluates to a
+// handle for joining it.
+type SpawnExpression struct {
+	Token Token
+	Call  *CallExpression
+}
+
+func (s *SpawnExpression) expressionNode() {}
+func (s *SpawnExpression) TokenLiteral() string {
+	return s.Token.Lexeme
+}
+func (s *SpawnExpression) String() string {
+	return s.TokenLiteral() + " " + s.Call.String()
+}
+func (s *SpawnExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitSpawnExpression(s, env)
+}
+
+// SelectStatement waits until one of its cases can go ahead and runs that
+// case's body. Default, if present, runs instead of waiting.
+type SelectStatement struct {
+	Token   Token
+	Cases   []*SelectCase
+	Default *BlockStatement
+}
+
+// SelectCase receives from Channel into Name, which may be nil, or sends
+// Value to it when Value is set.
+type SelectCase struct {
+	Token   Token
+	Name    *Identifier
+	Channel Expression
+	Value   Expression
+	Body    *BlockStatement
+}
+
+func (sc *SelectCase) String() string {
+	var str strings.Builder
+
+	if sc.Name != nil {
+		str.WriteString("var " + sc.Name.String() + " = ")
+	}
+	str.WriteString(sc.Channel.String())
+	if sc.Value != nil {
+		str.WriteString("." + ChannelSendMethod + "(" + sc.Value.String() + ")")
+	} else {
+		str.WriteString("." + ChannelRecvMethod + "()")
+	}
+	str.WriteString(" => ")
+	str.WriteString(sc.Body.String())
+
+	return str.String()
+}
+
+func (s *SelectStatement) statementNode() {}
+func (s *SelectStatement) TokenLiteral() string {
+	return s.Token.Lexeme
+}
+func (s *SelectStatement) String() string {
+	var cases []string
+	for _, c := range s.Cases {
+		cases = append(cases, c.String())
+	}
+	if s.Default != nil {
+		cases = append(cases, "_ => "+s.Default.String())
+	}
+
+	return s.TokenLiteral() + "{" + strings.Join(cases, " ") + "}"
+}
+func (s *SelectStatement) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitSelectStatement(s, env)
+}
+
+// ImportStatement binds a module to Alias, or its exports listed in Names
+// to variables of the same name.
+type ImportStatement struct {
+	Token Token
+	Path  string
+	Alias *Identifier
+	Names []*Identifier
+}
+
+func (i *ImportStatement) statementNode() {}
+func (i *ImportStatement) TokenLiteral() string {
+	return i.Token.Lexeme
+}
+func (i *ImportStatement) String() string {
+	if i.Alias != nil {
+		return fmt.Sprintf("%s %q as %s", i.TokenLiteral(), i.Path, i.Alias.String())
+	}
+
+	var names []string
+	for _, name := range i.Names {
+		names = append(names, name.String())
+	}
+	return fmt.Sprintf("%s {%s} from %q", i.TokenLiteral(), strings.Join(names, COMMA+" "), i.Path)
+}
+func (i *ImportStatement) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitImportStatement(i, env)
+}
+
+// ExportStatement makes the variable, function or class Declaration
+// declares importable from the module.
+type ExportStatement struct {
+	Token       Token
+	Declaration Statement
+}
+
+func (e *ExportStatement) statementNode() {}
+func (e *ExportStatement) TokenLiteral() string {
+	return e.Token.Lexeme
+}
+func (e *ExportStatement) String() string {
+	return e.TokenLiteral() + " " + e.Declaration.String()
+}
+func (e *ExportStatement) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitExportStatement(e, env)
+}
+
+// TestStatement is a test named Name, run by "jlox test" on a VM of its
+// own after the top-level code of its file.
+type TestStatement struct {
+	Token Token
+	Name  string
+	Body  *BlockStatement
+}
+
+func (t *TestStatement) statementNode() {}
+func (t *TestStatement) TokenLiteral() string {
+	return t.Token.Lexeme
+}
+func (t *TestStatement) String() string {
+	return fmt.Sprintf("%s %q %s", t.TokenLiteral(), t.Name, t.Body.String())
+}
+func (t *TestStatement) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitTestStatement(t, env)
+}
+
+type ThrowStatement struct {
+	Token Token
+	Value Expression
+}
+
+func (t *ThrowStatement) statementNode() {}
+func (t *ThrowStatement) TokenLiteral() string {
+	return t.Token.Lexeme
+}
+func (t *ThrowStatement) String() string {
+	return t.TokenLiteral() + " " + t.Value.String()
+}
+func (t *ThrowStatement) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitThrowStatement(t, env)
+}
+
+// TryStatement has a catch block, a finally block or both; CatchName is nil
+// when the catch clause takes no parameter.
+type TryStatement struct {
+	Token     Token
+	Body      *BlockStatement
+	CatchName *Identifier
+	Catch     *BlockStatement
+	Finally   *BlockStatement
+}
+
+func (t *TryStatement) statementNode() {}
+func (t *TryStatement) TokenLiteral() string {
+	return t.Token.Lexeme
+}
+func (t *TryStatement) String() string {
+	var str strings.Builder
+
+	str.WriteString(t.TokenLiteral())
+	str.WriteString(t.Body.String())
+
+	if t.Catch != nil {
+		str.WriteString("catch")
+		if t.CatchName != nil {
+			str.WriteString("(" + t.CatchName.String() + ")")
+		}
+		str.WriteString(t.Catch.String())
+	}
+
+	if t.Finally != nil {
+		str.WriteString("finally")
+		str.WriteString(t.Finally.String())
+	}
+
+	return str.String()
+}
+func (t *TryStatement) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitTryStatement(t, env)
+}
+
 type SetExpression struct {
 	Token    Token
 	Object   Expression
@@ -409,6 +825,8 @@ type CallExpression struct {
 	Token     Token // '(' token
 	Callee    Expression
 	Arguments []Expression
+	// Named arguments follow the positional ones.
+	Named []*NamedArgument
 }
 
 func (ce *CallExpression) expressionNode()      {}
@@ -420,6 +838,9 @@ func (ce *CallExpression) String() string {
 	for _, a := range ce.Arguments {
 		args = append(args, a.String())
 	}
+	for _, a := range ce.Named {
+		args = append(args, a.String())
+	}
 
 	str.WriteString(ce.Callee.String())
 	str.WriteString(LEFT_PAREN)
@@ -432,6 +853,32 @@ func (ce *CallExpression) Accept(visitor Visitor, env *Environment) Object {
 	return visitor.VisitCallExpression(ce, env)
 }
 
+// NamedArgument passes Value to the parameter called Name.
+type NamedArgument struct {
+	Token Token
+	Name  *Identifier
+	Value Expression
+}
+
+func (na *NamedArgument) String() string {
+	return na.Name.String() + COLON + " " + na.Value.String()
+}
+
+// SpreadExpression passes the elements of an array as separate arguments.
+type SpreadExpression struct {
+	Token Token // ... token
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode()      {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Lexeme }
+func (se *SpreadExpression) String() string {
+	return ELLIPSIS + se.Value.String()
+}
+func (se *SpreadExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitSpreadExpression(se, env)
+}
+
 type TernaryExpression struct {
 	Token      Token
 	Condition  Expression
@@ -481,6 +928,64 @@ func (a *Assignment) Accept(visitor Visitor, env *Environment) Object {
 	return visitor.VisitAssignment(a, env)
 }
 
+// CompoundAssignment covers `target op= value` where target is an identifier,
+// a property or an index expression. Operator holds the binary operator
+// without the trailing "=" ("+", "-", "*", "/", "%" or "??").
+type CompoundAssignment struct {
+	Token    Token // operator token
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ca *CompoundAssignment) expressionNode() {}
+func (ca *CompoundAssignment) TokenLiteral() string {
+	return ca.Token.Lexeme
+}
+func (ca *CompoundAssignment) String() string {
+	var str strings.Builder
+
+	str.WriteString(ca.Target.String())
+	str.WriteString(" " + ca.Operator + "= ")
+	str.WriteString(ca.Value.String())
+
+	return str.String()
+}
+func (ca *CompoundAssignment) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitCompoundAssignment(ca, env)
+}
+
+// UpdateExpression is a prefix or postfix `++`/`--`.
+type UpdateExpression struct {
+	Token    Token // operator token
+	Operator string
+	Target   Expression
+	Prefix   bool
+}
+
+func (ue *UpdateExpression) expressionNode() {}
+func (ue *UpdateExpression) TokenLiteral() string {
+	return ue.Token.Lexeme
+}
+func (ue *UpdateExpression) String() string {
+	var str strings.Builder
+
+	str.WriteString("(")
+	if ue.Prefix {
+		str.WriteString(ue.Operator)
+		str.WriteString(ue.Target.String())
+	} else {
+		str.WriteString(ue.Target.String())
+		str.WriteString(ue.Operator)
+	}
+	str.WriteString(")")
+
+	return str.String()
+}
+func (ue *UpdateExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitUpdateExpression(ue, env)
+}
+
 type Identifier struct {
 	Token Token
 	Value string
@@ -578,6 +1083,39 @@ func (sl *StringLiteral) String() string {
 	return fmt.Sprintf("%q", sl.Value)
 }
 
+// InterpolationExpression is a string literal with embedded "${...}"
+// expressions. Parts alternates between string segments (*StringLiteral) and
+// the embedded expressions, empty segments are left out.
+type InterpolationExpression struct {
+	Token Token // the first string segment
+	Parts []Expression
+}
+
+func (ie *InterpolationExpression) expressionNode() {}
+func (ie *InterpolationExpression) TokenLiteral() string {
+	return ie.Token.Lexeme
+}
+func (ie *InterpolationExpression) String() string {
+	var str strings.Builder
+
+	str.WriteString("\"")
+	for _, part := range ie.Parts {
+		if literal, ok := part.(*StringLiteral); ok {
+			str.WriteString(literal.Value)
+			continue
+		}
+		str.WriteString("${")
+		str.WriteString(part.String())
+		str.WriteString("}")
+	}
+	str.WriteString("\"")
+
+	return str.String()
+}
+func (ie *InterpolationExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitInterpolationExpression(ie, env)
+}
+
 type Unary struct {
 	Token    Token
 	Operator string
@@ -685,6 +1223,44 @@ func (f *For) Accept(visitor Visitor, env *Environment) Object {
 	return visitor.VisitForStatement(f, env)
 }
 
+// ForInStatement is `for (var value in iterable)` or
+// `for (var key, value in iterable)`; Key is nil in the single-name form.
+type ForInStatement struct {
+	Token Token
+	// Declaration is the var, let or const keyword the loop variables are
+	// declared with
+	Declaration Token
+	Key         *Identifier
+	Value       *Identifier
+	Iterable    Expression
+	Body        *BlockStatement
+}
+
+func (f *ForInStatement) TokenLiteral() string {
+	return f.Token.Lexeme
+}
+func (f *ForInStatement) statementNode() {}
+func (f *ForInStatement) String() string {
+	var str strings.Builder
+
+	str.WriteString(f.TokenLiteral())
+	str.WriteString("(" + f.Declaration.Lexeme + " ")
+	if f.Key != nil {
+		str.WriteString(f.Key.String())
+		str.WriteString(", ")
+	}
+	str.WriteString(f.Value.String())
+	str.WriteString(" in ")
+	str.WriteString(f.Iterable.String())
+	str.WriteString(")")
+	str.WriteString(f.Body.String())
+
+	return str.String()
+}
+func (f *ForInStatement) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitForInStatement(f, env)
+}
+
 type While struct {
 	Token     Token
 	Condition Expression
@@ -759,9 +1335,132 @@ func (ie *IndexExpression) Accept(visitor Visitor, env *Environment) Object {
 	return visitor.VisitIndexExpression(ie, env)
 }
 
+// SliceExpression is left[start:end]; either bound may be nil when omitted.
+type SliceExpression struct {
+	Token Token
+	Left  Expression
+	Start Expression
+	End   Expression
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Lexeme }
+func (se *SliceExpression) String() string {
+	var str strings.Builder
+
+	str.WriteString(LEFT_PAREN)
+	str.WriteString(se.Left.String())
+	str.WriteString(LEFT_BRACKET)
+	if se.Start != nil {
+		str.WriteString(se.Start.String())
+	}
+	str.WriteString(COLON)
+	if se.End != nil {
+		str.WriteString(se.End.String())
+	}
+	str.WriteString(RIGHT_BRACKET)
+	str.WriteString(RIGHT_PAREN)
+
+	return str.String()
+}
+func (se *SliceExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitSliceExpression(se, env)
+}
+
+type SetIndexExpression struct {
+	Token Token
+	Left  Expression
+	Index Expression
+	Value Expression
+}
+
+func (si *SetIndexExpression) expressionNode()      {}
+func (si *SetIndexExpression) TokenLiteral() string { return si.Token.Lexeme }
+func (si *SetIndexExpression) String() string {
+	var str strings.Builder
+
+	str.WriteString(si.Left.String())
+	str.WriteString(LEFT_BRACKET)
+	str.WriteString(si.Index.String())
+	str.WriteString(RIGHT_BRACKET)
+	str.WriteString("=")
+	str.WriteString(si.Value.String())
+
+	return str.String()
+}
+func (si *SetIndexExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitSetIndexExpression(si, env)
+}
+
+// SetSliceExpression replaces left[start:end] with the elements of Value.
+type SetSliceExpression struct {
+	Token Token
+	Left  Expression
+	Start Expression
+	End   Expression
+	Value Expression
+}
+
+func (ss *SetSliceExpression) expressionNode()      {}
+func (ss *SetSliceExpression) TokenLiteral() string { return ss.Token.Lexeme }
+func (ss *SetSliceExpression) String() string {
+	var str strings.Builder
+
+	str.WriteString(ss.Left.String())
+	str.WriteString(LEFT_BRACKET)
+	if ss.Start != nil {
+		str.WriteString(ss.Start.String())
+	}
+	str.WriteString(COLON)
+	if ss.End != nil {
+		str.WriteString(ss.End.String())
+	}
+	str.WriteString(RIGHT_BRACKET)
+	str.WriteString("=")
+	str.WriteString(ss.Value.String())
+
+	return str.String()
+}
+func (ss *SetSliceExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitSetSliceExpression(ss, env)
+}
+
+// RangeExpression is start..end (inclusive) or start..<end, with an optional
+// step.
+type RangeExpression struct {
+	Token     Token
+	Start     Expression
+	End       Expression
+	Step      Expression
+	Inclusive bool
+}
+
+func (re *RangeExpression) expressionNode()      {}
+func (re *RangeExpression) TokenLiteral() string { return re.Token.Lexeme }
+func (re *RangeExpression) String() string {
+	var str strings.Builder
+
+	str.WriteString(LEFT_PAREN)
+	str.WriteString(re.Start.String())
+	str.WriteString(re.Token.Lexeme)
+	str.WriteString(re.End.String())
+	if re.Step != nil {
+		str.WriteString(" step ")
+		str.WriteString(re.Step.String())
+	}
+	str.WriteString(RIGHT_PAREN)
+
+	return str.String()
+}
+func (re *RangeExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitRangeExpression(re, env)
+}
+
 type HashLiteral struct {
 	Token Token
 	Pairs map[Expression]Expression
+	// Keys are the keys of Pairs in source order
+	Keys []Expression
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -770,8 +1469,8 @@ func (hl *HashLiteral) String() string {
 	var str strings.Builder
 
 	var pairs []string
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+COLON+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+COLON+hl.Pairs[key].String())
 	}
 
 	str.WriteString(LEFT_BRACKET)
@@ -783,3 +1482,181 @@ func (hl *HashLiteral) String() string {
 func (hl *HashLiteral) Accept(visitor Visitor, env *Environment) Object {
 	return visitor.VisitHashLiteral(hl, env)
 }
+
+// Pattern is the left-hand side of a match arm.
+type Pattern interface {
+	String() string
+	patternNode()
+}
+
+// LiteralPattern matches a number, string, boolean or nil by value.
+type LiteralPattern struct {
+	Token Token
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode()   {}
+func (lp *LiteralPattern) String() string { return lp.Value.String() }
+
+// WildcardPattern is `_`, matching anything without binding it.
+type WildcardPattern struct {
+	Token Token
+}
+
+func (wp *WildcardPattern) patternNode()   {}
+func (wp *WildcardPattern) String() string { return "_" }
+
+// BindingPattern matches anything and binds it to Name.
+type BindingPattern struct {
+	Name *Identifier
+}
+
+func (bp *BindingPattern) patternNode()   {}
+func (bp *BindingPattern) String() string { return bp.Name.String() }
+
+// ArrayPattern matches an array of exactly len(Elements) elements.
+type ArrayPattern struct {
+	Token    Token
+	Elements []Pattern
+}
+
+func (ap *ArrayPattern) patternNode() {}
+func (ap *ArrayPattern) String() string {
+	var elements []string
+	for _, e := range ap.Elements {
+		elements = append(elements, e.String())
+	}
+
+	return LEFT_BRACE + strings.Join(elements, COMMA+" ") + RIGHT_BRACE
+}
+
+// HashPattern matches a hash holding every key in Keys, whose values match
+// the pattern at the same position in Values. Other keys are ignored.
+type HashPattern struct {
+	Token  Token
+	Keys   []Expression
+	Values []Pattern
+}
+
+func (hp *HashPattern) patternNode() {}
+func (hp *HashPattern) String() string {
+	var pairs []string
+	for idx, key := range hp.Keys {
+		pairs = append(pairs, key.String()+COLON+" "+hp.Values[idx].String())
+	}
+
+	return LEFT_BRACKET + strings.Join(pairs, COMMA+" ") + RIGHT_BRACKET
+}
+
+// ClassPattern matches an instance of Class. Fields are matched positionally
+// against the fields named by the class's init parameters.
+type ClassPattern struct {
+	Token  Token
+	Class  *Identifier
+	Fields []Pattern
+}
+
+func (cp *ClassPattern) patternNode() {}
+func (cp *ClassPattern) String() string {
+	var fields []string
+	for _, f := range cp.Fields {
+		fields = append(fields, f.String())
+	}
+
+	return cp.Class.String() + LEFT_PAREN + strings.Join(fields, COMMA+" ") + RIGHT_PAREN
+}
+
+// MatchArm runs Body (an expression) or Block when any of Patterns matches
+// and Guard, if present, is truthy.
+type MatchArm struct {
+	Token    Token
+	Patterns []Pattern
+	Guard    Expression
+	Body     Expression
+	Block    *BlockStatement
+}
+
+func (ma *MatchArm) String() string {
+	var str strings.Builder
+
+	var patterns []string
+	for _, p := range ma.Patterns {
+		patterns = append(patterns, p.String())
+	}
+
+	str.WriteString(strings.Join(patterns, COMMA+" "))
+	if ma.Guard != nil {
+		str.WriteString(" if ")
+		str.WriteString(ma.Guard.String())
+	}
+	str.WriteString(" => ")
+	if ma.Block != nil {
+		str.WriteString(LEFT_BRACKET + ma.Block.String() + RIGHT_BRACKET)
+	} else {
+		str.WriteString(ma.Body.String())
+	}
+
+	return str.String()
+}
+
+// IsCatchAll reports whether the arm matches every value: it has no guard
+// and one of its patterns is a wildcard or a binding.
+func (ma *MatchArm) IsCatchAll() bool {
+	if ma.Guard != nil {
+		return false
+	}
+
+	for _, p := range ma.Patterns {
+		switch p.(type) {
+		case *WildcardPattern, *BindingPattern:
+			return true
+		}
+	}
+
+	return false
+}
+
+type MatchExpression struct {
+	Token   Token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Lexeme }
+func (me *MatchExpression) String() string {
+	var arms []string
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+
+	return "match (" + me.Subject.String() + ") {" + strings.Join(arms, ", ") + "}"
+}
+func (me *MatchExpression) Accept(visitor Visitor, env *Environment) Object {
+	return visitor.VisitMatchExpression(me, env)
+}
+
+// IsExhaustive reports whether some arm is guaranteed to match: a catch-all
+// arm, or unguarded arms covering both true and false.
+func (me *MatchExpression) IsExhaustive() bool {
+	covered := map[bool]bool{}
+
+	for _, arm := range me.Arms {
+		if arm.IsCatchAll() {
+			return true
+		}
+		if arm.Guard != nil {
+			continue
+		}
+
+		for _, p := range arm.Patterns {
+			if literal, ok := p.(*LiteralPattern); ok {
+				if boolean, ok := literal.Value.(*BooleanLiteral); ok {
+					covered[boolean.Value] = true
+				}
+			}
+		}
+	}
+
+	return covered[true] && covered[false]
+}
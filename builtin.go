@@ -1,9 +1,26 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+	"unicode/utf8"
+)
 
 const (
-	BuiltinFuncNamePrint = "print"
+	BuiltinFuncNamePrint     = "print"
+	BuiltinFuncNameLen       = "len"
+	BuiltinFuncNameFiber     = "Fiber"
+	BuiltinFuncNameChannel   = "Channel"
+	BuiltinFuncNameWaitGroup = "WaitGroup"
+	BuiltinFuncNameSleep     = "sleep"
+	BuiltinFuncNameAll       = "all"
+	BuiltinFuncNameRace      = "race"
+	BuiltinFuncNamePromise   = "Promise"
+	BuiltinFuncNameAssert    = "assert"
+	BuiltinFuncNameAssertEq  = "assertEqual"
 )
 
 var Builtins = []struct {
@@ -13,16 +30,233 @@ var Builtins = []struct {
 	{
 		BuiltinFuncNamePrint,
 		&Builtin{Fn: func(args ...Object) Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+			return printObjects(os.Stdout, args...)
+		},
+		},
+	},
+	{
+		BuiltinFuncNameLen,
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return &ErrorObject{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				return &FloatObject{Value: float64(len(arg.Elements))}
+			case *StringObject:
+				return &FloatObject{Value: float64(utf8.RuneCountInString(arg.Value))}
+			case *Hash:
+				return &FloatObject{Value: float64(len(arg.Pairs))}
+			case *Range:
+				return &FloatObject{Value: float64(arg.Len())}
+			default:
+				return &ErrorObject{Message: fmt.Sprintf("argument to `len` not supported, got %s", arg.Type())}
+			}
+		},
+		},
+	},
+	{
+		// Fiber is only available in the VM
+		BuiltinFuncNameFiber,
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return &ErrorObject{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+			}
+
+			closure, ok := args[0].(*Closure)
+			if !ok {
+				return &ErrorObject{Message: fmt.Sprintf("argument to `Fiber` must be a function, got %s", args[0].Type())}
+			}
+
+			return NewFiber(closure)
+		},
+		},
+	},
+	{
+		// Channel and WaitGroup are only available in the VM
+		BuiltinFuncNameChannel,
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return &ErrorObject{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0 to 1", len(args))}
+			}
+
+			capacity := 0
+			if len(args) == 1 {
+				size, ok := args[0].(*FloatObject)
+				if !ok || size.Value < 0 || size.Value != math.Trunc(size.Value) {
+					return &ErrorObject{Message: fmt.Sprintf("argument to `Channel` must be a non-negative integer, got %s", args[0].Inspect())}
+				}
+				capacity = int(size.Value)
+			}
+
+			return &Channel{Values: make(chan Object, capacity)}
+		},
+		},
+	},
+	{
+		BuiltinFuncNameWaitGroup,
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 0 {
+				return &ErrorObject{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+			}
+			return &WaitGroup{}
+		},
+		},
+	},
+	{
+		// the promise builtins need the VM's event loop
+		BuiltinFuncNameSleep,
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return &ErrorObject{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+			}
+
+			ms, ok := args[0].(*FloatObject)
+			if !ok || ms.Value < 0 {
+				return &ErrorObject{Message: fmt.Sprintf("argument to `sleep` must be a non-negative number, got %s", args[0].Inspect())}
+			}
+
+			duration := time.Duration(ms.Value * float64(time.Millisecond))
+			return &Promise{Job: func() (Object, error) {
+				time.Sleep(duration)
+				return Null, nil
+			}}
+		},
+		},
+	},
+	{
+		BuiltinFuncNameAll,
+		&Builtin{Fn: func(args ...Object) Object {
+			values, err := promiseArguments(BuiltinFuncNameAll, args)
+			if err != nil {
+				return err
+			}
+
+			// fulfilled with every result in order, or rejected with the
+			// first rejection
+			all := &Promise{}
+			results := make([]Object, len(values))
+			remaining := len(values)
+			for idx, value := range values {
+				idx := idx
+				promise, ok := value.(*Promise)
+				if !ok {
+					results[idx] = value
+					remaining--
+					continue
+				}
+				promise.Then(func(settled *Promise) {
+					if settled.State == PromiseRejected {
+						all.Settle(settled.Value, true)
+						return
+					}
+					results[idx] = settled.Value
+					if remaining--; remaining == 0 {
+						all.Settle(&Array{Elements: results}, false)
+					}
+				})
+			}
+			if remaining == 0 {
+				all.Settle(&Array{Elements: results}, false)
+			}
+
+			return all
+		},
+		},
+	},
+	{
+		BuiltinFuncNameRace,
+		&Builtin{Fn: func(args ...Object) Object {
+			values, err := promiseArguments(BuiltinFuncNameRace, args)
+			if err != nil {
+				return err
+			}
+
+			// settled like the first of values to settle; values that
+			// aren't promises count as settled already
+			race := &Promise{}
+			for _, value := range values {
+				promise, ok := value.(*Promise)
+				if !ok {
+					race.Settle(value, false)
+					continue
+				}
+				promise.Then(func(settled *Promise) {
+					race.Settle(settled.Value, settled.State == PromiseRejected)
+				})
+			}
+
+			return race
+		},
+		},
+	},
+	{
+		BuiltinFuncNamePromise,
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 0 {
+				return &ErrorObject{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=0", len(args))}
+			}
+			return &Promise{}
+		},
+		},
+	},
+	{
+		BuiltinFuncNameAssert,
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) < 1 || len(args) > 2 {
+				return &ErrorObject{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1 to 2", len(args))}
 			}
 
-			return nil
+			if isTruthy(args[0]) {
+				return nil
+			}
+			return assertionFailed("assertion failed", args[1:])
+		},
+		},
+	},
+	{
+		// arrays and hashes are equal when their elements are
+		BuiltinFuncNameAssertEq,
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) < 2 || len(args) > 3 {
+				return &ErrorObject{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=2 to 3", len(args))}
+			}
+
+			if deepEqual(args[0], args[1]) {
+				return nil
+			}
+			return assertionFailed(fmt.Sprintf("expected %s, got %s", args[1].Inspect(), args[0].Inspect()), args[2:])
 		},
 		},
 	},
 }
 
+// assertionFailed is the error of a failed assertion, with the message the
+// caller gave, if any, in front of what went wrong.
+func assertionFailed(reason string, message []Object) *ErrorObject {
+	if len(message) == 0 {
+		return &ErrorObject{Message: reason}
+	}
+	if str, ok := message[0].(*StringObject); ok {
+		return &ErrorObject{Message: str.Value + ": " + reason}
+	}
+	return &ErrorObject{Message: message[0].Inspect() + ": " + reason}
+}
+
+func promiseArguments(name string, args []Object) ([]Object, *ErrorObject) {
+	if len(args) != 1 {
+		return nil, &ErrorObject{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+
+	array, ok := args[0].(*Array)
+	if !ok {
+		return nil, &ErrorObject{Message: fmt.Sprintf("argument to `%s` must be an array, got %s", name, args[0].Type())}
+	}
+
+	return array.Elements, nil
+}
+
 func GetBuiltinByName(name string) *Builtin {
 	for _, def := range Builtins {
 		if def.Name == name {
@@ -32,3 +266,12 @@ func GetBuiltinByName(name string) *Builtin {
 
 	return nil
 }
+
+// printObjects writes each of objects to out, on a line of its own.
+func printObjects(out io.Writer, objects ...Object) Object {
+	for _, object := range objects {
+		fmt.Fprintln(out, object.Inspect())
+	}
+
+	return nil
+}
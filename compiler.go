@@ -3,38 +3,119 @@ package main
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
 )
 
 const (
 	UINT16_MAX = 1 << 16
 )
 
+// LineInfo says the next Count bytes of instructions come from Line.
 type LineInfo struct {
 	Line  int
 	Count int
 }
 
+// lineAt returns the line of the instruction at offset, or -1 if lines
+// doesn't cover it.
+func lineAt(lines []LineInfo, offset int) int {
+	accumulatedCount := 0
+
+	for _, info := range lines {
+		accumulatedCount += info.Count
+		if offset < accumulatedCount {
+			return info.Line
+		}
+	}
+
+	return -1 // In case of an invalid instruction index
+}
+
 type Compiler struct {
 	Constants   []Object
-	LineInfo    []LineInfo
 	SymbolTable *SymbolTable
 	Scopes      []Scope
 	ScopeIndex  int
+	warnings    *ErrorHandler
+	// suppressions are the warnings turned off by comments
+	suppressions Suppressions
+	// Path is the file being compiled, which imports are resolved against
+	Path string
+	// importer is the compiler of the module that imported this one, if any
+	importer  *Compiler
+	loader    *ModuleLoader
+	namespace int
+	// exports maps the names a module exports to their global slots
+	exports map[string]int
+	// classes are the classes whose methods are being compiled, innermost
+	// last
+	classes []*ClassStatement
+	// Test is the name of the test block to compile; the others, and all of
+	// them if Test is empty, are left out. Tests are the names of every test
+	// block of the program, in order.
+	Test  string
+	Tests []string
+	// Disassembly, when set, is where the bytecode of each function is
+	// written once it is compiled
+	Disassembly io.Writer
 }
 
 type Scope struct {
 	Instructions Instructions
+	// Lines is the line table of Instructions
+	Lines []LineInfo
+	// Tries holds the finally block, possibly nil, of each try the code
+	// being compiled is inside, so return can run them on the way out
+	Tries []*BlockStatement
+	// Loops holds each loop the code being compiled is inside, innermost
+	// last, for break and continue
+	Loops []*loopScope
+}
+
+// loopScope is a loop being compiled. Its body is left by break, whose
+// jumps are patched to the end of the loop, and by continue, which loops
+// back to start or, when start is -1, jumps forward to the increment of a
+// for loop.
+type loopScope struct {
+	start     int
+	breaks    []int
+	continues []int
+	// tries is how many tries are around the loop, which break and continue
+	// stay inside
+	tries int
 }
 
 type ByteCode struct {
-	Code      Instructions
-	Constants []Object
+	Code       Instructions
+	Lines      []LineInfo
+	Constants  []Object
+	ErrorClass int // global slot of the Error class, -1 if not defined
+	// Namespaces is the number of sets of globals: the program's and one
+	// for each module it imports
+	Namespaces int
+	// LocalNames names the stack slots the program's loop variables take
+	LocalNames []string
 }
 
 func (c *Compiler) ByteCode() *ByteCode {
+	errorClass := -1
+	if symbol, ok := c.SymbolTable.Resolve(ErrorClassName); ok && symbol.Scope == GLOBAL_SCOPE {
+		errorClass = symbol.Index
+	}
+
+	namespaces := 1
+	if c.loader != nil {
+		namespaces += c.loader.count
+	}
+
 	return &ByteCode{
-		Code:      c.currentInstructions(),
-		Constants: c.Constants,
+		Code:       c.currentInstructions(),
+		Lines:      c.Scopes[c.ScopeIndex].Lines,
+		Constants:  c.Constants,
+		ErrorClass: errorClass,
+		Namespaces: namespaces,
+		LocalNames: c.SymbolTable.Locals(),
 	}
 }
 
@@ -45,22 +126,63 @@ func NewCompiler() *Compiler {
 
 	symbolTable := NewSymbolTable()
 
-	for name, _ := range builtins {
-		symbolTable.DefineBuiltin(name)
+	// in Builtins order, which is how OP_GET_BUILTIN indexes them
+	for _, def := range Builtins {
+		symbolTable.DefineBuiltin(def.Name)
 	}
 
-	return &Compiler{Constants: make([]Object, 0), LineInfo: make([]LineInfo, 0), SymbolTable: symbolTable, Scopes: []Scope{mainScope}, ScopeIndex: 0}
+	return &Compiler{Constants: make([]Object, 0), SymbolTable: symbolTable, Scopes: []Scope{mainScope}, ScopeIndex: 0, warnings: NewErrorHandler()}
 }
 
 func NewCompilerWithState(symbolTable *SymbolTable) *Compiler {
-	return &Compiler{Constants: make([]Object, 0), LineInfo: make([]LineInfo, 0), SymbolTable: symbolTable}
+	return &Compiler{Constants: make([]Object, 0), SymbolTable: symbolTable, warnings: NewErrorHandler()}
 }
 
-func (c *Compiler) Compile(ast Node) error {
+// Warnings are problems worth reporting that don't stop compilation.
+func (c *Compiler) Warnings() *ErrorHandler {
+	return c.warnings
+}
+
+// warn reports warning unless a comment on its line turns it off.
+func (c *Compiler) warn(warning *Error) {
+	if !c.suppressions.Suppressed(warning) {
+		c.warnings.AddError(warning)
+	}
+}
+
+// CompileError is an error the compiler ran into at Token.
+type CompileError struct {
+	Err   error
+	Token Token
+}
+
+func (e *CompileError) Error() string { return e.Err.Error() }
+func (e *CompileError) Unwrap() error { return e.Err }
+
+func (c *Compiler) Compile(ast Node) (err error) {
+	// the innermost node an error comes from is where it is reported
+	defer func() {
+		if _, ok := err.(*CompileError); err != nil && !ok {
+			if token := nodeToken(ast); token.Line > 0 {
+				err = &CompileError{Err: err, Token: token}
+			}
+		}
+	}()
 
 	switch node := ast.(type) {
 	case *Program:
-		for _, s := range node.Statements {
+		c.suppressions = NewSuppressions(node.Comments)
+		analyzer := NewAnalyzer()
+		analyzer.Analyze(node)
+		for _, warning := range analyzer.Warnings().Errors {
+			c.warn(warning)
+		}
+
+		statements := node.Statements
+		if _, ok := c.SymbolTable.Resolve(ErrorClassName); !ok && c.ScopeIndex == 0 {
+			statements = append(Prelude().Statements, statements...)
+		}
+		for _, s := range statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
@@ -72,10 +194,11 @@ func (c *Compiler) Compile(ast Node) error {
 			return err
 		}
 
-		if _, ok := node.Expression.(*Assignment); !ok {
-			c.WriteChunk(OP_POP, node.Token.Line)
-		}
+		c.WriteChunk(OP_POP, node.Token.Line)
 	case *BlockStatement:
+		c.SymbolTable.EnterBlock()
+		defer c.SymbolTable.LeaveBlock()
+
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -86,29 +209,138 @@ func (c *Compiler) Compile(ast Node) error {
 		if c.ScopeIndex == 0 {
 			return fmt.Errorf("Can't return from top-level code")
 		}
-		if err := c.Compile(node.ReturnValue); err != nil {
-			return err
+		if node.ReturnValue != nil {
+			if err := c.Compile(node.ReturnValue); err != nil {
+				return err
+			}
+		} else {
+			c.WriteChunk(OP_NIL, node.Token.Line)
 		}
 
+		if err := c.exitTries(node.Token.Line); err != nil {
+			return err
+		}
 		c.WriteChunk(OP_RETURN, node.Token.Line)
+	case *YieldExpression:
+		if node.Value != nil {
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+		} else {
+			c.WriteChunk(OP_NIL, node.Token.Line)
+		}
+		c.WriteChunk(OP_YIELD, node.Token.Line)
+	case *TestStatement:
+		for _, name := range c.Tests {
+			if name == node.Name {
+				return fmt.Errorf("Test %q is already declared", node.Name)
+			}
+		}
+		c.Tests = append(c.Tests, node.Name)
+		if c.Test == "" || node.Name != c.Test {
+			return nil
+		}
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+	case *ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.WriteChunk(OP_THROW, node.Token.Line)
+	case *TryStatement:
+		if err := c.compileTry(node); err != nil {
+			return err
+		}
 	case *CallExpression:
 		if err := c.Compile(node.Callee); err != nil {
 			return err
 		}
 
-		argCount := len(node.Arguments)
+		argCount := len(node.Arguments) + len(node.Named)
 
 		if argCount >= 255 {
 			return fmt.Errorf("Can't have more than 255 arguments.")
 		}
 
+		shape := &CallShape{}
+		extended := len(node.Named) > 0
 		for _, arg := range node.Arguments {
+			spread, isSpread := arg.(*SpreadExpression)
+			if isSpread {
+				arg = spread.Value
+				extended = true
+			}
+			shape.Spread = append(shape.Spread, isSpread)
+
+			if err := c.Compile(arg); err != nil {
+				return err
+			}
+		}
+
+		for _, named := range node.Named {
+			if err := c.Compile(named.Value); err != nil {
+				return err
+			}
+			shape.Names = append(shape.Names, named.Name.Value)
+		}
+
+		if extended {
+			c.WriteChunk(OP_CALL_EXTENDED, node.Token.Line, c.MakeConstant(shape))
+		} else {
+			c.WriteChunk(OP_CALL, node.Token.Line, argCount)
+		}
+	case *SpawnExpression:
+		call := node.Call
+		if len(call.Named) > 0 {
+			return fmt.Errorf("spawn takes no named arguments")
+		}
+		if err := c.Compile(call.Callee); err != nil {
+			return err
+		}
+		if len(call.Arguments) >= 255 {
+			return fmt.Errorf("Can't have more than 255 arguments.")
+		}
+		for _, arg := range call.Arguments {
+			if _, ok := arg.(*SpreadExpression); ok {
+				return fmt.Errorf("spawn takes no spread arguments")
+			}
 			if err := c.Compile(arg); err != nil {
 				return err
 			}
 		}
+		c.WriteChunk(OP_SPAWN, node.Token.Line, len(call.Arguments))
+	case *SelectStatement:
+		return c.compileSelect(node)
+	case *AwaitExpression:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.WriteChunk(OP_AWAIT, node.Token.Line)
+	case *ImportStatement:
+		return c.compileImport(node)
+	case *ExportStatement:
+		if err := c.Compile(node.Declaration); err != nil {
+			return err
+		}
 
-		c.WriteChunk(OP_CALL, node.Token.Line, argCount)
+		var name string
+		switch declaration := node.Declaration.(type) {
+		case *FunctionDeclaration:
+			name = declaration.Name.Value
+		case *ClassStatement:
+			name = declaration.Name.Value
+		case *VarStatement:
+			name = declaration.Identifier.Value
+		}
+		symbol, ok := c.SymbolTable.Resolve(name)
+		if !ok || symbol.Scope != GLOBAL_SCOPE {
+			return fmt.Errorf("Can only export top-level declarations: %s", name)
+		}
+		if c.exports == nil {
+			c.exports = make(map[string]int)
+		}
+		c.exports[name] = symbol.Index
 	case *ClassStatement:
 		symbol := c.SymbolTable.Define(node.Name.Value)
 		class := &CompiledClassObject{Name: node.Name, Methods: make(map[string]*Closure)}
@@ -122,17 +354,47 @@ func (c *Compiler) Compile(ast Node) error {
 			c.WriteChunk(OP_DEFINE_LOCAL, node.Token.Line, symbol.Index)
 		}
 
-		for index, method := range node.Methods {
-			if err := c.CompileMethod(method, node.Name.Value); err != nil {
+		// the superclass's methods are copied into the class, and those it
+		// declares itself replace them
+		if node.SuperClass != nil {
+			c.loadSymbol(symbol, node.Token.Line)
+			if err := c.Compile(node.SuperClass); err != nil {
 				return err
 			}
+			c.WriteChunk(OP_INHERIT, node.Token.Line)
+		}
 
-			if index != len(node.Methods)-1 {
-				c.WriteByte(byte(OP_POP))
+		c.classes = append(c.classes, node)
+		defer func() { c.classes = c.classes[:len(c.classes)-1] }()
+		for _, method := range node.Methods {
+			if err := c.CompileMethod(method, node.Name.Value); err != nil {
+				return err
 			}
+
+			c.WriteChunk(OP_POP, method.Token.Line)
+		}
+	case *Super:
+		if len(c.classes) == 0 {
+			return fmt.Errorf("Can't use 'super' outside of a class.")
+		}
+		class := c.classes[len(c.classes)-1]
+		if class.SuperClass == nil {
+			return fmt.Errorf("Can't use 'super' in a class with no superclass.")
 		}
 
-		c.WriteByte(byte(OP_POP))
+		// the method is looked up in the superclass of the class, which
+		// the method reaches like any other variable
+		this, ok := c.SymbolTable.Resolve("this")
+		if !ok {
+			return fmt.Errorf("Can't use 'super' outside of a method.")
+		}
+		c.loadSymbol(this, node.Token.Line)
+		symbol, ok := c.SymbolTable.Resolve(class.Name.Value)
+		if !ok {
+			return fmt.Errorf("Undefined class: %s", class.Name.Value)
+		}
+		c.loadSymbol(symbol, node.Token.Line)
+		c.WriteChunk(OP_GET_SUPER, node.Token.Line, c.MakeConstant(&StringObject{Value: node.Method.Value}))
 	case *This:
 		symbol, ok := c.SymbolTable.Resolve("this")
 		if !ok {
@@ -142,52 +404,28 @@ func (c *Compiler) Compile(ast Node) error {
 	case *FunctionDeclaration:
 
 		symbol := c.SymbolTable.Define(node.Name.Value)
-		c.enterScope()
-
-		for _, p := range node.Params {
-			c.SymbolTable.Define(p.Value)
-		}
-
-		if err := c.Compile(node.Body); err != nil {
+		if err := c.compileFunction(&node.FunctionCommon, node.Name.Value); err != nil {
 			return err
 		}
 
-		if !c.lastInstructionIsReturn() {
-			c.WriteChunk(OP_RETURN, node.Token.Line)
-		}
-
-		upvalues := c.SymbolTable.upvalues
-		numLocals := c.SymbolTable.numDefinitions
-
-		fmt.Printf("Bytecode for `%s`\n", node.Name.Value)
-		c.DisassembleChunks()
-		instructions := c.leaveScope()
-
-		for _, upvalue := range upvalues {
-			c.loadSymbol(upvalue, node.Token.Line)
-		}
-
-		compiledFunction := &CompiledFunction{
-			Instructions:  instructions,
-			NumLocals:     numLocals,
-			NumParameters: len(node.Params),
-			Name:          node.Name.Value,
-		}
-
-		fnIndex := c.MakeConstant(compiledFunction)
-		c.WriteChunk(OP_CLOSURE, node.Token.Line, fnIndex, len(upvalues))
-
 		if c.ScopeIndex == 0 {
 			c.WriteChunk(OP_DEFINE_GLOBAL, node.Token.Line, symbol.Index)
 		} else {
 			c.WriteChunk(OP_DEFINE_LOCAL, node.Token.Line, symbol.Index)
 		}
+	case *FunctionLiteral:
+		name := "<anonymous>"
+		if node.Name != nil {
+			name = node.Name.Value
+		}
+		if err := c.compileFunction(&node.FunctionCommon, name); err != nil {
+			return err
+		}
 	case *VarStatement:
-		_, ok := c.SymbolTable.ResolveInner(node.Identifier.Value)
-		if ok {
-			return fmt.Errorf("Already variable with this name in this scope: %s", node.Identifier.Value)
+		symbol, err := c.declare(node.Identifier.Value, node.Token)
+		if err != nil {
+			return err
 		}
-		symbol := c.SymbolTable.Define(node.Identifier.Value)
 
 		if node.Expression != nil {
 			err := c.Compile(node.Expression)
@@ -203,8 +441,22 @@ func (c *Compiler) Compile(ast Node) error {
 		} else {
 			c.WriteChunk(OP_DEFINE_LOCAL, node.Token.Line, symbol.Index)
 		}
+	case *DestructureStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		declare := func(target Expression, line int) error {
+			return c.declareTarget(target, node.Token, line)
+		}
+		if err := c.compileDestructure(node.Pattern, declare, node.Token.Line); err != nil {
+			return err
+		}
+		c.WriteChunk(OP_POP, node.Token.Line)
 	case *While:
+		loop := c.enterLoop(node.Token.Line)
 		loopStart := len(c.currentInstructions())
+		loop.start = loopStart
 
 		if err := c.Compile(node.Condition); err != nil {
 			return err
@@ -212,43 +464,99 @@ func (c *Compiler) Compile(ast Node) error {
 
 		c.WriteChunk(OP_JUMP_IF_FALSE, node.Token.Line, 9999)
 		exitJump := len(c.currentInstructions()) - 2 // offset of the emitted instruction
-		c.WriteByte(byte(OP_POP))
+		c.WriteChunk(OP_POP, node.Token.Line)
 
-		if len(node.Body.Statements) != 0 {
-			if err := c.Compile(node.Body); err != nil {
-				return err
-			}
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
 
-			if c.lastInstructionIsPop() {
-				c.removeLastPop()
-			}
+		c.emitLoop(loopStart, node.Token.Line)
+
+		if err := c.patchJump(exitJump); err != nil {
+			return err
+		}
+
+		c.WriteChunk(OP_POP, node.Token.Line)
+		if err := c.leaveLoop(); err != nil {
+			return err
+		}
+	case *BreakStatement:
+		loop, err := c.exitLoop("Break statement not within loop", node.Token.Line)
+		if err != nil {
+			return err
+		}
+		loop.breaks = append(loop.breaks, c.emitJump(OP_JUMP, node.Token.Line))
+	case *ContinueStatement:
+		loop, err := c.exitLoop("Continue statement not within loop", node.Token.Line)
+		if err != nil {
+			return err
+		}
+		if loop.start >= 0 {
+			c.emitLoop(loop.start, node.Token.Line)
 		} else {
-			c.WriteChunk(OP_NIL, node.Token.Line)
-			c.WriteByte(byte(OP_POP))
+			loop.continues = append(loop.continues, c.emitJump(OP_JUMP, node.Token.Line))
+		}
+	case *ForInStatement:
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
 		}
 
-		offset := len(c.currentInstructions()) - loopStart + 2
-		c.WriteChunk(OP_LOOP, node.Token.Line, offset)
+		keyed := 0
+		if node.Key != nil {
+			keyed = 1
+		}
+		c.WriteChunk(OP_ITERATOR, node.Token.Line, keyed)
 
-		if err := c.patchJump(exitJump); err != nil {
+		// the iterator stays on the stack for the whole loop; each OP_ITER_NEXT
+		// pushes the key (when keyed) and the value, or jumps out when exhausted
+		loop := c.enterLoop(node.Token.Line)
+		loopStart := len(c.currentInstructions())
+		loop.start = loopStart
+		c.WriteChunk(OP_ITER_NEXT, node.Token.Line, 9999)
+		exitJump := len(c.currentInstructions()) - 2
+
+		// the loop variables belong to the loop and live in stack slots, also
+		// at the top level, so a closure made in the body keeps the values of
+		// its own iteration
+		c.SymbolTable.EnterBlock()
+		defer c.SymbolTable.LeaveBlock()
+
+		for _, name := range []*Identifier{node.Value, node.Key} {
+			if name == nil {
+				continue
+			}
+
+			symbol := c.SymbolTable.DefineLocal(name.Value, node.Declaration.Type == CONST)
+			c.WriteChunk(OP_DEFINE_LOCAL, node.Token.Line, symbol.Index)
+		}
+
+		if err := c.Compile(node.Body); err != nil {
 			return err
 		}
 
-		c.WriteByte(byte(OP_POP))
+		c.emitLoop(loopStart, node.Token.Line)
+
+		if err := c.patchJump(exitJump); err != nil {
+			return err
+		}
+		// a break leaves the iterator on the stack too
+		if err := c.leaveLoop(); err != nil {
+			return err
+		}
 
+		c.WriteChunk(OP_POP, node.Token.Line)
 	case *For:
-		c.enterScope()
+		// a let in the initializer belongs to the loop
+		c.SymbolTable.EnterBlock()
+		defer c.SymbolTable.LeaveBlock()
 
 		if node.Initializer != nil {
 			if err := c.Compile(node.Initializer); err != nil {
 				return err
 			}
-
-			if c.lastInstructionIsPop() {
-				c.removeLastPop()
-			}
 		}
 
+		loop := c.enterLoop(node.Token.Line)
 		conditionalStart := len(c.currentInstructions())
 
 		exitJump := -1
@@ -257,40 +565,39 @@ func (c *Compiler) Compile(ast Node) error {
 				return err
 			}
 
-			if c.lastInstructionIsPop() {
-				c.removeLastPop()
-			}
-
 			c.WriteChunk(OP_JUMP_IF_FALSE, node.Token.Line, 9999)
 			exitJump = len(c.currentInstructions()) - 2
-			c.WriteByte(byte(OP_POP))
+			c.WriteChunk(OP_POP, node.Token.Line)
 		}
 
 		if err := c.Compile(node.Body); err != nil {
 			return err
 		}
 
+		for _, jump := range loop.continues {
+			if err := c.patchJump(jump); err != nil {
+				return err
+			}
+		}
 		if node.Increment != nil {
 			if err := c.Compile(node.Increment); err != nil {
 				return err
 			}
 
-			if c.lastInstructionIsPop() {
-				c.removeLastPop()
-			}
+			c.WriteChunk(OP_POP, node.Token.Line)
 		}
 
-		offset := len(c.currentInstructions()) - conditionalStart + 2
-		c.WriteChunk(OP_LOOP, node.Token.Line, offset)
+		c.emitLoop(conditionalStart, node.Token.Line)
 
 		if exitJump != -1 {
 			if err := c.patchJump(exitJump); err != nil {
 				return err
 			}
-			c.WriteByte(byte(OP_POP))
+			c.WriteChunk(OP_POP, node.Token.Line)
+		}
+		if err := c.leaveLoop(); err != nil {
+			return err
 		}
-
-		c.leaveScope()
 	case *GetExpression:
 		if err := c.Compile(node.Object); err != nil {
 			return err
@@ -309,6 +616,13 @@ func (c *Compiler) Compile(ast Node) error {
 
 		constant := c.MakeConstant(&StringObject{Value: node.Property.Value})
 		c.WriteChunk(OP_SET_PROPERTY, node.Token.Line, constant)
+	case *DestructureAssignment:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		// the destructured value is left as the result
+		return c.compileDestructure(node.Pattern, c.assignTarget, node.Token.Line)
 	case *Assignment:
 		if symbol, ok := c.SymbolTable.Resolve(node.Identifier.Value); !ok {
 			return fmt.Errorf("Undeclared identifier: %s", node.Identifier.Value)
@@ -322,8 +636,111 @@ func (c *Compiler) Compile(ast Node) error {
 				c.WriteChunk(OP_NIL, node.Token.Line)
 			}
 
-			c.setSymbol(symbol, node.Token.Line)
+			if err := c.setSymbol(symbol, node.Token.Line); err != nil {
+				return err
+			}
+		}
+	case *CompoundAssignment:
+		if err := c.compileCompoundAssignment(node); err != nil {
+			return err
+		}
+	case *UpdateExpression:
+		if err := c.compileUpdateExpression(node); err != nil {
+			return err
+		}
+	case *ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+
+		c.WriteChunk(OP_ARRAY, node.Token.Line, len(node.Elements))
+	case *HashLiteral:
+		for key, value := range node.Pairs {
+			if err := c.Compile(key); err != nil {
+				return err
+			}
+			if err := c.Compile(value); err != nil {
+				return err
+			}
+		}
+
+		c.WriteChunk(OP_HASH, node.Token.Line, len(node.Pairs))
+	case *IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+
+		c.WriteChunk(OP_INDEX, node.Token.Line)
+	case *MatchExpression:
+		if err := c.compileMatch(node); err != nil {
+			return err
+		}
+	case *RangeExpression:
+		if err := c.Compile(node.Start); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.End); err != nil {
+			return err
+		}
+
+		if node.Step != nil {
+			if err := c.Compile(node.Step); err != nil {
+				return err
+			}
+		} else {
+			c.WriteChunk(OP_NIL, node.Token.Line)
+		}
+
+		inclusive := 0
+		if node.Inclusive {
+			inclusive = 1
+		}
+		c.WriteChunk(OP_RANGE, node.Token.Line, inclusive)
+	case *SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		if err := c.compileSliceBounds(node.Start, node.End, node.Token.Line); err != nil {
+			return err
+		}
+
+		c.WriteChunk(OP_SLICE, node.Token.Line)
+	case *SetIndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		c.WriteChunk(OP_SET_INDEX, node.Token.Line)
+	case *SetSliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		if err := c.compileSliceBounds(node.Start, node.End, node.Token.Line); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.Value); err != nil {
+			return err
 		}
+
+		c.WriteChunk(OP_SET_SLICE, node.Token.Line)
 	case *Identifier:
 		symbol, ok := c.SymbolTable.Resolve(node.Value)
 		if !ok {
@@ -333,6 +750,18 @@ func (c *Compiler) Compile(ast Node) error {
 	case *StringLiteral:
 		value := &StringObject{Value: node.Value}
 		c.WriteChunk(OP_CONSTANT, node.Token.Line, c.MakeConstant(value))
+	case *InterpolationExpression:
+		for _, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+
+			if _, ok := part.(*StringLiteral); !ok {
+				c.WriteChunk(OP_TO_STRING, node.Token.Line)
+			}
+		}
+
+		c.WriteChunk(OP_INTERPOLATE, node.Token.Line, len(node.Parts))
 	case *NumberLiteral:
 		value := &FloatObject{Value: node.Value}
 		c.WriteChunk(OP_CONSTANT, node.Token.Line, c.MakeConstant(value))
@@ -348,8 +777,7 @@ func (c *Compiler) Compile(ast Node) error {
 		case "!":
 			c.WriteChunk(OP_NOT, node.Token.Line)
 		default:
-			fmt.Errorf("unknown unary operator: %s", node.Operator)
-
+			return fmt.Errorf("unknown unary operator: %s", node.Operator)
 		}
 	case *BooleanLiteral:
 		if node.Value {
@@ -381,6 +809,8 @@ func (c *Compiler) Compile(ast Node) error {
 			c.WriteChunk(OP_DIVIDE, node.Token.Line)
 		case "*":
 			c.WriteChunk(OP_MULTIPLY, node.Token.Line)
+		case "%":
+			c.WriteChunk(OP_MODULO, node.Token.Line)
 		case "!=":
 			c.WriteChunk(OP_EQUAL, node.Token.Line)
 			c.WriteChunk(OP_NOT, node.Token.Line)
@@ -396,6 +826,8 @@ func (c *Compiler) Compile(ast Node) error {
 		case "<=":
 			c.WriteChunk(OP_GREATER, node.Token.Line)
 			c.WriteChunk(OP_NOT, node.Token.Line)
+		case "in":
+			c.WriteChunk(OP_IN, node.Token.Line)
 		}
 	case *Logical:
 		switch node.Operator {
@@ -421,10 +853,8 @@ func (c *Compiler) Compile(ast Node) error {
 		c.WriteChunk(OP_JUMP_IF_FALSE, node.Token.Line, 9999)
 		thenJump := len(c.currentInstructions()) - 2 // offset of the emitted instruction
 
-		// in case not expression statement remove condition
-		if c.lastInstruction() != byte(OP_POP) {
-			c.WriteChunk(OP_POP, node.Token.Line)
-		}
+		// the condition stays on the stack, discard it on both branches
+		c.WriteChunk(OP_POP, node.Token.Line)
 
 		err := c.Compile(node.ThenBranch)
 		if err != nil {
@@ -439,18 +869,13 @@ func (c *Compiler) Compile(ast Node) error {
 		if err != nil {
 			return err
 		}
-		if c.lastInstruction() != byte(OP_POP) {
-			c.WriteChunk(OP_POP, node.Token.Line)
-		}
+		c.WriteChunk(OP_POP, node.Token.Line)
 
 		if node.ElseBranch != nil {
 			err = c.Compile(node.ElseBranch)
 			if err != nil {
 				return err
 			}
-
-		} else {
-			c.WriteChunk(OP_NIL, node.Token.Line)
 		}
 
 		err = c.patchJump(elseJump)
@@ -475,34 +900,46 @@ func (c *Compiler) CompileMethod(method *MethodDeclaration, className string) er
 
 	c.SymbolTable.Define("this")
 
-	for _, p := range method.Params {
-		c.SymbolTable.Define(p.Value)
+	if err := c.compileParameters(&method.FunctionCommon); err != nil {
+		return err
 	}
 
 	if err := c.Compile(method.Body); err != nil {
 		return err
 	}
 
-	if !c.lastInstructionIsReturn() {
-		c.WriteChunk(OP_RETURN, method.Token.Line)
+	// initializers always hand back the instance stored in slot 0
+	if method.Name.Value == "init" {
+		c.WriteChunk(OP_GET_LOCAL, method.Token.Line, 0)
+	} else {
+		c.WriteChunk(OP_NIL, method.Token.Line)
 	}
+	c.WriteChunk(OP_RETURN, method.Token.Line)
 
 	upvalues := c.SymbolTable.upvalues
 	numLocals := c.SymbolTable.numDefinitions
 
-	fmt.Printf("Bytecode for `%s`\n", method.Name.Value)
-	c.DisassembleChunks()
-	instructions := c.leaveScope()
+	c.disassemble(method.Name.Value)
+	names := c.SymbolTable.Names()
+	instructions, lines := c.leaveScope()
 
 	for _, upvalue := range upvalues {
 		c.loadSymbol(upvalue, method.Token.Line)
 	}
 
 	compiledFunction := &CompiledFunction{
-		Instructions:  instructions,
-		NumLocals:     numLocals,
-		NumParameters: len(method.Params),
-		Name:          method.Name.Value,
+		Instructions:   instructions,
+		Lines:          lines,
+		LocalNames:     names,
+		UpvalueNames:   symbolNames(upvalues),
+		NumLocals:      numLocals,
+		NumParameters:  len(method.Params),
+		ParameterNames: parameterNames(method.Params),
+		NumRequired:    method.Required(),
+		Variadic:       method.Rest != nil,
+		IsGenerator:    method.IsGenerator,
+		Name:           method.Name.Value,
+		Namespace:      c.namespace,
 	}
 
 	fnIndex := c.MakeConstant(compiledFunction)
@@ -526,15 +963,314 @@ func (c *Compiler) loadSymbol(symbol Symbol, line int) {
 	}
 }
 
-func (c *Compiler) setSymbol(symbol Symbol, line int) {
+// declare defines a variable declared with keyword: var for the rest of
+// the function, let and const for the rest of the block.
+func (c *Compiler) declare(name string, keyword Token) (Symbol, error) {
+	if keyword.Type == VAR {
+		if _, ok := c.SymbolTable.ResolveInner(name); ok {
+			return Symbol{}, fmt.Errorf("Already variable with this name in this scope: %s", name)
+		}
+		return c.SymbolTable.Define(name), nil
+	}
+
+	if c.SymbolTable.DeclaredInBlock(name) {
+		return Symbol{}, fmt.Errorf("Already variable with this name in this scope: %s", name)
+	}
+	return c.SymbolTable.DefineBlock(name, keyword.Type == CONST), nil
+}
+
+func (c *Compiler) setSymbol(symbol Symbol, line int) error {
+	if symbol.Const {
+		return fmt.Errorf("Can't assign to constant: %s", symbol.Name)
+	}
+
 	switch symbol.Scope {
 	case GLOBAL_SCOPE:
 		c.WriteChunk(OP_SET_GLOBAL, line, symbol.Index)
 	case LOCAL_SCOPE:
 		c.WriteChunk(OP_SET_LOCAL, line, symbol.Index)
+	case UPVALUE_SCOPE:
+		c.WriteChunk(OP_SET_UPVALUE, line, symbol.Index)
+	default:
+		return fmt.Errorf("Can't assign to %s", symbol.Name)
+	}
+	return nil
+}
+
+// compileDestructure unpacks the value on top of the stack, which stays
+// there. Each element, or its default when it is missing, is pushed in turn
+// and handed to store, which has to pop it.
+func (c *Compiler) compileDestructure(pattern Destructure, store func(target Expression, line int) error, line int) error {
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		rest := 0
+		if pattern.Rest != nil {
+			rest = 1
+		}
+		c.WriteChunk(OP_DESTRUCTURE_ARRAY, line, pattern.Required(), len(pattern.Elements), rest)
+
+		for idx, element := range pattern.Elements {
+			c.WriteChunk(OP_DESTRUCTURE_INDEX, line, idx, 9999)
+			presentJump := len(c.currentInstructions()) - 2
+
+			if err := c.compileDestructureElement(element, presentJump, store, line); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			c.WriteChunk(OP_DUP, line, 1)
+			c.WriteChunk(OP_CONSTANT, line, c.MakeConstant(&FloatObject{Value: float64(len(pattern.Elements))}))
+			c.WriteChunk(OP_NIL, line)
+			c.WriteChunk(OP_SLICE, line)
+			return store(pattern.Rest, line)
+		}
+	case *HashDestructure:
+		c.WriteChunk(OP_DESTRUCTURE_HASH, line)
+
+		for _, entry := range pattern.Entries {
+			key := c.identifierConstant(entry.Key)
+			c.WriteChunk(OP_DESTRUCTURE_KEY, line, key, 9999)
+			presentJump := len(c.currentInstructions()) - 2
+
+			if entry.Default == nil {
+				c.WriteChunk(OP_DESTRUCTURE_MISSING, line, key)
+			}
+			if err := c.compileDestructureElement(entry, presentJump, store, line); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// compileDestructureElement compiles the default that is skipped when the
+// element is present, then stores the element.
+func (c *Compiler) compileDestructureElement(element *DestructureElement, presentJump int, store func(target Expression, line int) error, line int) error {
+	if element.Default != nil {
+		if err := c.Compile(element.Default); err != nil {
+			return err
+		}
+	}
+
+	if err := c.patchJump(presentJump); err != nil {
+		return err
+	}
+
+	if element.Pattern != nil {
+		if err := c.compileDestructure(element.Pattern, store, line); err != nil {
+			return err
+		}
+		c.WriteChunk(OP_POP, line)
+		return nil
+	}
+
+	return store(element.Target, line)
+}
+
+// declareTarget defines the variable named by target and pops the value on
+// top of the stack into it.
+func (c *Compiler) declareTarget(target Expression, keyword Token, line int) error {
+	symbol, err := c.declare(target.(*Identifier).Value, keyword)
+	if err != nil {
+		return err
+	}
+
+	if symbol.Scope == GLOBAL_SCOPE {
+		c.WriteChunk(OP_DEFINE_GLOBAL, line, symbol.Index)
+	} else {
+		c.WriteChunk(OP_DEFINE_LOCAL, line, symbol.Index)
+	}
+
+	return nil
+}
+
+// assignTarget pops the value on top of the stack into a variable, field or
+// index target.
+func (c *Compiler) assignTarget(target Expression, line int) error {
+	switch target := target.(type) {
+	case *Identifier:
+		symbol, ok := c.SymbolTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("Undeclared identifier: %s", target.Value)
+		}
+		if err := c.setSymbol(symbol, line); err != nil {
+			return err
+		}
+	case *GetExpression:
+		if err := c.Compile(target.Object); err != nil {
+			return err
+		}
+		c.WriteChunk(OP_BURY, line, 1)
+		c.WriteChunk(OP_SET_PROPERTY, line, c.MakeConstant(&StringObject{Value: target.Property.Value}))
+	case *IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		// rotate value, left, index into left, index, value
+		c.WriteChunk(OP_BURY, line, 2)
+		c.WriteChunk(OP_BURY, line, 2)
+		c.WriteChunk(OP_SET_INDEX, line)
+	default:
+		return fmt.Errorf("Invalid assignment target: %s", target.String())
+	}
+
+	c.WriteChunk(OP_POP, line)
+	return nil
+}
+
+// compileCompoundAssignment compiles `target op= value`. The target's object
+// and index are compiled once and duplicated on the stack, so that reading the
+// current value and storing the new one don't evaluate them twice.
+func (c *Compiler) compileCompoundAssignment(node *CompoundAssignment) error {
+	line := node.Token.Line
+
+	store, err := c.compileTargetLoad(node.Target, line)
+	if err != nil {
+		return err
+	}
+
+	if node.Operator == "??" {
+		// keep the current value when it is not nil, it is stored back unchanged
+		c.WriteChunk(OP_JUMP_IF_NOT_NIL, line, 9999)
+		keepJump := len(c.currentInstructions()) - 2
+		c.WriteChunk(OP_POP, line)
+
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		if err := c.patchJump(keepJump); err != nil {
+			return err
+		}
+	} else {
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		if err := c.compileArithmetic(node.Operator, line); err != nil {
+			return err
+		}
+	}
+
+	return store()
+}
+
+// compileUpdateExpression compiles prefix and postfix `++`/`--`. For the
+// postfix form a copy of the old value is buried below the target operands so
+// it is what remains on the stack once the new value has been stored and
+// discarded.
+func (c *Compiler) compileUpdateExpression(node *UpdateExpression) error {
+	line := node.Token.Line
+
+	store, err := c.compileTargetLoad(node.Target, line)
+	if err != nil {
+		return err
+	}
+
+	if !node.Prefix {
+		c.WriteChunk(OP_DUP, line, 1)
+		c.WriteChunk(OP_BURY, line, c.targetOperands(node.Target)+1)
+	}
+
+	c.WriteChunk(OP_CONSTANT, line, c.MakeConstant(&FloatObject{Value: 1}))
+	if node.Operator == "++" {
+		c.WriteChunk(OP_ADD, line)
+	} else {
+		c.WriteChunk(OP_SUBTRACT, line)
+	}
+
+	if err := store(); err != nil {
+		return err
+	}
+
+	if !node.Prefix {
+		c.WriteChunk(OP_POP, line)
+	}
+
+	return nil
+}
+
+// compileTargetLoad pushes the operands of an assignment target followed by
+// its current value, and returns a function that emits the matching store.
+func (c *Compiler) compileTargetLoad(target Expression, line int) (func() error, error) {
+	switch target := target.(type) {
+	case *Identifier:
+		symbol, ok := c.SymbolTable.Resolve(target.Value)
+		if !ok {
+			return nil, fmt.Errorf("Undeclared identifier: %s", target.Value)
+		}
+		c.loadSymbol(symbol, line)
+
+		return func() error { return c.setSymbol(symbol, line) }, nil
+	case *GetExpression:
+		if err := c.Compile(target.Object); err != nil {
+			return nil, err
+		}
+
+		constant := c.MakeConstant(&StringObject{Value: target.Property.Value})
+		c.WriteChunk(OP_DUP, line, 1)
+		c.WriteChunk(OP_GET_PROPERTY, line, constant)
+
+		return func() error {
+			c.WriteChunk(OP_SET_PROPERTY, line, constant)
+			return nil
+		}, nil
+	case *IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return nil, err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return nil, err
+		}
+
+		c.WriteChunk(OP_DUP, line, 2)
+		c.WriteChunk(OP_INDEX, line)
+
+		return func() error {
+			c.WriteChunk(OP_SET_INDEX, line)
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("Invalid assignment target: %s", target.String())
 	}
 }
 
+// targetOperands is the number of stack slots an assignment target occupies
+// below its current value.
+func (c *Compiler) targetOperands(target Expression) int {
+	switch target.(type) {
+	case *GetExpression:
+		return 1
+	case *IndexExpression:
+		return 2
+	default:
+		return 0
+	}
+}
+
+func (c *Compiler) compileArithmetic(operator string, line int) error {
+	switch operator {
+	case "+":
+		c.WriteChunk(OP_ADD, line)
+	case "-":
+		c.WriteChunk(OP_SUBTRACT, line)
+	case "*":
+		c.WriteChunk(OP_MULTIPLY, line)
+	case "/":
+		c.WriteChunk(OP_DIVIDE, line)
+	case "%":
+		c.WriteChunk(OP_MODULO, line)
+	default:
+		return fmt.Errorf("unknown operator: %s", operator)
+	}
+	return nil
+}
+
 func (c *Compiler) or(node *Logical) error {
 	if err := c.Compile(node.Left); err != nil {
 		return err
@@ -550,9 +1286,7 @@ func (c *Compiler) or(node *Logical) error {
 	if err := c.patchJump(elseJump); err != nil {
 		return err
 	}
-	if c.lastInstruction() != byte(OP_POP) {
-		c.WriteChunk(OP_POP, node.Token.Line)
-	}
+	c.WriteChunk(OP_POP, node.Token.Line)
 
 	if err := c.Compile(node.Right); err != nil {
 		return err
@@ -574,9 +1308,7 @@ func (c *Compiler) and(node *Logical) error {
 	c.WriteChunk(OP_JUMP_IF_FALSE, node.Token.Line, 9999)
 	endJump := len(c.currentInstructions()) - 2
 
-	if c.lastInstruction() != byte(OP_POP) {
-		c.WriteChunk(OP_POP, node.Token.Line)
-	}
+	c.WriteChunk(OP_POP, node.Token.Line)
 
 	right := c.Compile(node.Right)
 	if right != nil {
@@ -595,7 +1327,23 @@ func (c *Compiler) MakeConstant(value Object) int {
 	return int(c.writeValue(value))
 }
 
+// disassemble writes the bytecode of the function called name, the one
+// being compiled, to Disassembly, if set.
+func (c *Compiler) disassemble(name string) {
+	if c.Disassembly == nil {
+		return
+	}
+	fmt.Fprintf(c.Disassembly, "Bytecode for `%s`\n", name)
+	c.DisassembleChunks()
+}
+
+// DisassembleChunks writes the instructions of the function being compiled
+// to Disassembly, if set.
 func (c *Compiler) DisassembleChunks() {
+	if c.Disassembly == nil {
+		return
+	}
+
 	var offset int
 
 	length := len(c.currentInstructions())
@@ -605,15 +1353,16 @@ func (c *Compiler) DisassembleChunks() {
 	}
 }
 
-func (c *Compiler) WriteByte(b byte) {
-	c.Scopes[c.ScopeIndex].Instructions = append(c.Scopes[c.ScopeIndex].Instructions, b)
-}
-
 func (c *Compiler) WriteChunk(opcode OpCode, line int, operands ...int) {
-	if len(c.LineInfo) > 0 && c.LineInfo[len(c.LineInfo)-1].Line == line {
-		c.LineInfo[len(c.LineInfo)-1].Count++
+	scope := &c.Scopes[c.ScopeIndex]
+	width := 1
+	for _, operandWidth := range definitions[opcode].OperandWidths {
+		width += operandWidth
+	}
+	if len(scope.Lines) > 0 && scope.Lines[len(scope.Lines)-1].Line == line {
+		scope.Lines[len(scope.Lines)-1].Count += width
 	} else {
-		c.LineInfo = append(c.LineInfo, LineInfo{Line: line, Count: 1})
+		scope.Lines = append(scope.Lines, LineInfo{Line: line, Count: width})
 	}
 	c.Scopes[c.ScopeIndex].Instructions = append(c.Scopes[c.ScopeIndex].Instructions, byte(opcode))
 	definition := definitions[opcode]
@@ -630,21 +1379,281 @@ func (c *Compiler) WriteChunk(opcode OpCode, line int, operands ...int) {
 }
 
 func (c *Compiler) GetLine(insIndex int) int {
-	accumulatedCount := 0
+	return lineAt(c.Scopes[c.ScopeIndex].Lines, insIndex)
+}
 
-	for _, info := range c.LineInfo {
-		accumulatedCount += info.Count
-		if insIndex < accumulatedCount {
-			return info.Line
+// compileTry guards the body with a handler. When something is thrown the
+// VM unwinds to the handler with the exception on the stack, where it is
+// bound to the catch variable. Without a catch, or when the catch itself
+// throws, the finally block runs and the exception is thrown again.
+func (c *Compiler) compileTry(node *TryStatement) error {
+	line := node.Token.Line
+
+	handler := c.emitJump(OP_TRY, line)
+	if err := c.compileTryBlock(node.Body, node.Finally); err != nil {
+		return err
+	}
+	c.WriteChunk(OP_END_TRY, line)
+	if err := c.compileFinally(node.Finally); err != nil {
+		return err
+	}
+	exits := []int{c.emitJump(OP_JUMP, line)}
+
+	if err := c.patchJump(handler); err != nil {
+		return err
+	}
+
+	if node.Catch != nil {
+		if node.CatchName != nil {
+			symbol := c.bindingSymbol(node.CatchName.Value)
+			if symbol.Scope == GLOBAL_SCOPE {
+				c.WriteChunk(OP_DEFINE_GLOBAL, line, symbol.Index)
+			} else {
+				c.WriteChunk(OP_DEFINE_LOCAL, line, symbol.Index)
+			}
+		} else {
+			c.WriteChunk(OP_POP, line)
+		}
+
+		if node.Finally == nil {
+			if err := c.Compile(node.Catch); err != nil {
+				return err
+			}
+			return c.patchJump(exits[0])
+		}
+
+		handler = c.emitJump(OP_TRY, line)
+		if err := c.compileTryBlock(node.Catch, node.Finally); err != nil {
+			return err
+		}
+		c.WriteChunk(OP_END_TRY, line)
+		if err := c.compileFinally(node.Finally); err != nil {
+			return err
+		}
+		exits = append(exits, c.emitJump(OP_JUMP, line))
+
+		if err := c.patchJump(handler); err != nil {
+			return err
 		}
 	}
 
-	return -1 // In case of an invalid instruction index
+	if err := c.compileFinally(node.Finally); err != nil {
+		return err
+	}
+	c.WriteChunk(OP_THROW, line)
+
+	for _, exit := range exits {
+		if err := c.patchJump(exit); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (c *Compiler) lastInstruction() byte {
-	instructions := c.currentInstructions()
-	return instructions[len(instructions)-1]
+// compileSelect pushes the channel, and the value for a send, of every case
+// and lets OP_SELECT pick one. It leaves the value received, or nil, under
+// the index of the chosen case, which a jump table takes to its body.
+func (c *Compiler) compileSelect(node *SelectStatement) error {
+	line := node.Token.Line
+
+	shape := &SelectShape{Default: node.Default != nil}
+	for _, selectCase := range node.Cases {
+		if err := c.Compile(selectCase.Channel); err != nil {
+			return err
+		}
+		if selectCase.Value != nil {
+			if err := c.Compile(selectCase.Value); err != nil {
+				return err
+			}
+		}
+		shape.Sends = append(shape.Sends, selectCase.Value != nil)
+	}
+	c.WriteChunk(OP_SELECT, line, c.MakeConstant(shape))
+
+	table := &JumpTable{}
+	c.WriteChunk(OP_JUMP_TABLE, line, c.MakeConstant(table))
+
+	var exits []int
+	compileCase := func(name *Identifier, body *BlockStatement) error {
+		table.Targets = append(table.Targets, len(c.currentInstructions()))
+		c.WriteChunk(OP_POP, line)
+
+		if name != nil {
+			symbol := c.bindingSymbol(name.Value)
+			if symbol.Scope == GLOBAL_SCOPE {
+				c.WriteChunk(OP_DEFINE_GLOBAL, line, symbol.Index)
+			} else {
+				c.WriteChunk(OP_DEFINE_LOCAL, line, symbol.Index)
+			}
+		} else {
+			c.WriteChunk(OP_POP, line)
+		}
+
+		if err := c.Compile(body); err != nil {
+			return err
+		}
+		exits = append(exits, c.emitJump(OP_JUMP, line))
+		return nil
+	}
+
+	for _, selectCase := range node.Cases {
+		if err := compileCase(selectCase.Name, selectCase.Body); err != nil {
+			return err
+		}
+	}
+	if node.Default != nil {
+		if err := compileCase(nil, node.Default); err != nil {
+			return err
+		}
+	}
+
+	for _, exit := range exits {
+		if err := c.patchJump(exit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compileTryBlock compiles code guarded by a handler that return has to
+// leave through finally.
+// compileImport leaves the module on the stack with OP_IMPORT, then binds
+// it to the alias or copies each of the imported exports into a global
+// of the same name.
+func (c *Compiler) compileImport(node *ImportStatement) error {
+	line := node.Token.Line
+
+	module, err := c.importModule(node.Path)
+	if err != nil {
+		return err
+	}
+
+	c.WriteChunk(OP_IMPORT, line, c.MakeConstant(module))
+
+	if node.Alias != nil {
+		if _, ok := c.SymbolTable.ResolveInner(node.Alias.Value); ok {
+			return fmt.Errorf("Already variable with this name in this scope: %s", node.Alias.Value)
+		}
+		symbol := c.SymbolTable.Define(node.Alias.Value)
+		c.WriteChunk(OP_DEFINE_GLOBAL, line, symbol.Index)
+		return nil
+	}
+
+	for _, name := range node.Names {
+		if _, ok := module.Exports[name.Value]; !ok {
+			return fmt.Errorf("module %s has no export %s", module.Name, name.Value)
+		}
+		if _, ok := c.SymbolTable.ResolveInner(name.Value); ok {
+			return fmt.Errorf("Already variable with this name in this scope: %s", name.Value)
+		}
+
+		c.WriteChunk(OP_DUP, line, 1)
+		c.WriteChunk(OP_GET_PROPERTY, line, c.MakeConstant(&StringObject{Value: name.Value}))
+		symbol := c.SymbolTable.Define(name.Value)
+		c.WriteChunk(OP_DEFINE_GLOBAL, line, symbol.Index)
+	}
+	c.WriteChunk(OP_POP, line)
+
+	return nil
+}
+
+func (c *Compiler) compileTryBlock(block *BlockStatement, finally *BlockStatement) error {
+	scope := &c.Scopes[c.ScopeIndex]
+	scope.Tries = append(scope.Tries, finally)
+	defer func() {
+		scope := &c.Scopes[c.ScopeIndex]
+		scope.Tries = scope.Tries[:len(scope.Tries)-1]
+	}()
+
+	return c.Compile(block)
+}
+
+func (c *Compiler) compileFinally(finally *BlockStatement) error {
+	if finally == nil {
+		return nil
+	}
+	return c.Compile(finally)
+}
+
+// exitTries removes the handlers of every try a return is leaving and runs
+// their finally blocks, innermost first, while the return value waits on
+// the stack.
+func (c *Compiler) exitTries(line int) error {
+	tries := c.Scopes[c.ScopeIndex].Tries
+	defer func() { c.Scopes[c.ScopeIndex].Tries = tries }()
+
+	for idx := len(tries) - 1; idx >= 0; idx-- {
+		c.WriteChunk(OP_END_TRY, line)
+		// a return inside this finally only leaves the outer tries
+		c.Scopes[c.ScopeIndex].Tries = tries[:idx]
+		if err := c.compileFinally(tries[idx]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enterLoop starts a loop, recording the height of the stack for break and
+// continue to go back to.
+func (c *Compiler) enterLoop(line int) *loopScope {
+	scope := &c.Scopes[c.ScopeIndex]
+	loop := &loopScope{start: -1, tries: len(scope.Tries)}
+	c.WriteChunk(OP_LOOP_ENTER, line, len(scope.Loops))
+	scope.Loops = append(scope.Loops, loop)
+	return loop
+}
+
+// leaveLoop ends the innermost loop, patching its breaks to jump here.
+func (c *Compiler) leaveLoop() error {
+	scope := &c.Scopes[c.ScopeIndex]
+	loop := scope.Loops[len(scope.Loops)-1]
+	scope.Loops = scope.Loops[:len(scope.Loops)-1]
+
+	for _, jump := range loop.breaks {
+		if err := c.patchJump(jump); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exitLoop starts a break or continue out of the body of the innermost
+// loop: it removes the handlers of the tries inside the loop, running their
+// finally blocks, and drops what the body left on the stack.
+func (c *Compiler) exitLoop(message string, line int) (*loopScope, error) {
+	loops := c.Scopes[c.ScopeIndex].Loops
+	if len(loops) == 0 {
+		return nil, fmt.Errorf("%s", message)
+	}
+	loop := loops[len(loops)-1]
+
+	tries := c.Scopes[c.ScopeIndex].Tries
+	defer func() { c.Scopes[c.ScopeIndex].Tries = tries }()
+	for idx := len(tries) - 1; idx >= loop.tries; idx-- {
+		c.WriteChunk(OP_END_TRY, line)
+		c.Scopes[c.ScopeIndex].Tries = tries[:idx]
+		if err := c.compileFinally(tries[idx]); err != nil {
+			return nil, err
+		}
+	}
+
+	c.WriteChunk(OP_UNWIND, line, len(loops)-1)
+	return loop, nil
+}
+
+// emitJump writes a jump with a placeholder offset and returns where the
+// offset goes, for patchJump.
+func (c *Compiler) emitJump(opcode OpCode, line int) int {
+	c.WriteChunk(opcode, line, 9999)
+	return len(c.currentInstructions()) - 2
+}
+
+func (c *Compiler) emitLoop(loopStart int, line int) {
+	offset := len(c.currentInstructions()) - loopStart + 2
+	c.WriteChunk(OP_LOOP, line, offset)
 }
 
 func (c *Compiler) patchJump(jump int) error {
@@ -682,13 +1691,15 @@ func (c *Compiler) enterScope() {
 	c.SymbolTable = newSymbolTable
 }
 
-func (c *Compiler) leaveScope() Instructions {
-	instructions := c.currentInstructions()
+// leaveScope returns the instructions of the scope left and their line
+// table.
+func (c *Compiler) leaveScope() (Instructions, []LineInfo) {
+	scope := c.Scopes[c.ScopeIndex]
 
 	c.Scopes = c.Scopes[:len(c.Scopes)-1]
 	c.ScopeIndex -= 1
 	c.SymbolTable = c.SymbolTable.Outer
-	return instructions
+	return scope.Instructions, scope.Lines
 }
 
 func (c *Compiler) writeValue(value Object) uint16 {
@@ -702,44 +1713,570 @@ func (c *Compiler) disassembleInstruction(offset int) int {
 	newOffset := offset + 1
 
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(c.Disassembly, err)
 		return newOffset
 	}
-	fmt.Printf("%04d %4d %s", offset, c.GetLine(offset), definition.Name)
+	fmt.Fprintf(c.Disassembly, "%04d %4d %s", offset, c.GetLine(offset), definition.Name)
 
 	for _, w := range definition.OperandWidths {
 		switch w {
 		case 2:
-			fmt.Printf(" %v", ReadUint16(c.Scopes[c.ScopeIndex].Instructions[newOffset:]))
+			fmt.Fprintf(c.Disassembly, " %v", ReadUint16(c.Scopes[c.ScopeIndex].Instructions[newOffset:]))
 		case 1:
-			fmt.Printf(" %v", ReadUint8(c.Scopes[c.ScopeIndex].Instructions[newOffset:]))
+			fmt.Fprintf(c.Disassembly, " %v", ReadUint8(c.Scopes[c.ScopeIndex].Instructions[newOffset:]))
 		}
 		newOffset += w
 	}
-	fmt.Printf("\n")
+	fmt.Fprintf(c.Disassembly, "\n")
 
 	return newOffset
 }
 
-func (c *Compiler) lastInstructionIsReturn() bool {
-	instructions := c.currentInstructions()
-	return instructions[len(instructions)-1] == byte(OP_RETURN)
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
 }
 
-func (c *Compiler) lastInstructionIsPop() bool {
-	instructions := c.currentInstructions()
-	return instructions[len(instructions)-1] == byte(OP_POP)
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
 }
 
-func (c *Compiler) removeLastPop() {
-	instructions := c.currentInstructions()
-	c.Scopes[c.ScopeIndex].Instructions = c.Scopes[c.ScopeIndex].Instructions[:len(instructions)-1]
+// compileSliceBounds pushes both bounds of a slice, using nil for an omitted
+// bound.
+func (c *Compiler) compileSliceBounds(start, end Expression, line int) error {
+	for _, bound := range []Expression{start, end} {
+		if bound == nil {
+			c.WriteChunk(OP_NIL, line)
+			continue
+		}
+		if err := c.Compile(bound); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func ReadUint16(ins Instructions) uint16 {
-	return binary.BigEndian.Uint16(ins)
+// compileParameters defines the parameters of fun as locals, followed by its
+// rest parameter, and compiles the defaults: a parameter that is nil on
+// entry is set to its default.
+// compileFunction compiles the function fun, called name, leaving a
+// closure of it on the stack.
+func (c *Compiler) compileFunction(fun *FunctionCommon, name string) error {
+	c.enterScope()
+
+	if err := c.compileParameters(fun); err != nil {
+		return err
+	}
+
+	if err := c.Compile(fun.Body); err != nil {
+		return err
+	}
+
+	// implicit return, unreachable when the body already returned
+	c.WriteChunk(OP_NIL, fun.Token.Line)
+	c.WriteChunk(OP_RETURN, fun.Token.Line)
+
+	upvalues := c.SymbolTable.upvalues
+	numLocals := c.SymbolTable.numDefinitions
+
+	c.disassemble(name)
+	names := c.SymbolTable.Names()
+	instructions, lines := c.leaveScope()
+
+	for _, upvalue := range upvalues {
+		c.loadSymbol(upvalue, fun.Token.Line)
+	}
+
+	compiledFunction := &CompiledFunction{
+		Instructions:   instructions,
+		Lines:          lines,
+		LocalNames:     names,
+		UpvalueNames:   symbolNames(upvalues),
+		NumLocals:      numLocals,
+		NumParameters:  len(fun.Params),
+		ParameterNames: parameterNames(fun.Params),
+		NumRequired:    fun.Required(),
+		Variadic:       fun.Rest != nil,
+		IsGenerator:    fun.IsGenerator,
+		IsAsync:        fun.IsAsync,
+		Name:           name,
+		Namespace:      c.namespace,
+	}
+
+	fnIndex := c.MakeConstant(compiledFunction)
+	c.WriteChunk(OP_CLOSURE, fun.Token.Line, fnIndex, len(upvalues))
+	return nil
 }
 
-func ReadUint8(ins Instructions) uint8 {
-	return uint8(ins[0])
+func (c *Compiler) compileParameters(fun *FunctionCommon) error {
+	var symbols []Symbol
+	for _, p := range fun.Params {
+		symbols = append(symbols, c.SymbolTable.Define(p.Value))
+	}
+	if fun.Rest != nil {
+		c.SymbolTable.Define(fun.Rest.Value)
+	}
+
+	for idx, value := range fun.Defaults {
+		if value == nil {
+			continue
+		}
+		line := fun.Params[idx].Token.Line
+
+		c.WriteChunk(OP_GET_LOCAL, line, symbols[idx].Index)
+		c.WriteChunk(OP_JUMP_IF_NOT_NIL, line, 9999)
+		skipJump := len(c.currentInstructions()) - 2
+		c.WriteChunk(OP_POP, line)
+
+		if err := c.Compile(value); err != nil {
+			return err
+		}
+		c.WriteChunk(OP_SET_LOCAL, line, symbols[idx].Index)
+
+		if err := c.patchJump(skipJump); err != nil {
+			return err
+		}
+		c.WriteChunk(OP_POP, line)
+	}
+
+	return nil
+}
+
+func parameterNames(params []*Identifier) []string {
+	names := make([]string, len(params))
+	for idx, param := range params {
+		names[idx] = param.Value
+	}
+	return names
+}
+
+func symbolNames(symbols []Symbol) []string {
+	names := make([]string, len(symbols))
+	for idx, symbol := range symbols {
+		names[idx] = symbol.Name
+	}
+	return names
+}
+
+// compileMatch keeps the subject on the stack while the arms test it. Every
+// pattern check pushes a boolean and jumps to a failure point that pops it;
+// a matching arm replaces the subject with the value of its body.
+func (c *Compiler) compileMatch(node *MatchExpression) error {
+	line := node.Token.Line
+
+	if !node.IsExhaustive() {
+		c.warn(&Error{Token: node.Token, Message: "match is not exhaustive, unmatched values are a runtime error", Line: line, Severity: SeverityWarning, Code: MatchWarning})
+	}
+
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+
+	if table, ok := denseMatchTable(node); ok {
+		return c.compileMatchTable(node, table)
+	}
+
+	var endJumps []int
+	for _, arm := range node.Arms {
+		endJump, err := c.compileMatchArm(arm, line)
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, endJump)
+	}
+
+	c.WriteChunk(OP_NO_MATCH, line)
+
+	for _, jump := range endJumps {
+		if err := c.patchJump(jump); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compileMatchArm tests the subject against the patterns of arm, running its
+// body for the first that matches and going on to the next arm otherwise. It
+// returns the jump to the end of the match to patch.
+func (c *Compiler) compileMatchArm(arm *MatchArm, line int) (int, error) {
+	// the bindings of an arm are its own, like the environment the
+	// interpreter gives it, and hide variables of the same name until the
+	// arm ends
+	c.SymbolTable.EnterBlock()
+	defer c.SymbolTable.LeaveBlock()
+
+	// a guard may name bindings from any of the arm's alternatives
+	for _, pattern := range arm.Patterns {
+		for _, name := range patternBindings(pattern) {
+			c.armBinding(name)
+		}
+	}
+
+	var bodyJumps []int
+	for _, pattern := range arm.Patterns {
+		failJumps, err := c.compilePattern(pattern, c.dupSubject(line), line)
+		if err != nil {
+			return 0, err
+		}
+
+		if arm.Guard != nil {
+			if err := c.Compile(arm.Guard); err != nil {
+				return 0, err
+			}
+			failJumps = append(failJumps, c.emitPatternCheck(line))
+		}
+
+		c.WriteChunk(OP_JUMP, line, 9999)
+		bodyJumps = append(bodyJumps, len(c.currentInstructions())-2)
+
+		for _, jump := range failJumps {
+			if err := c.patchJump(jump); err != nil {
+				return 0, err
+			}
+		}
+		if len(failJumps) > 0 {
+			c.WriteChunk(OP_POP, line)
+		}
+	}
+
+	c.WriteChunk(OP_JUMP, line, 9999)
+	nextArm := len(c.currentInstructions()) - 2
+
+	for _, jump := range bodyJumps {
+		if err := c.patchJump(jump); err != nil {
+			return 0, err
+		}
+	}
+
+	endJump, err := c.compileMatchBody(arm, line)
+	if err != nil {
+		return 0, err
+	}
+	return endJump, c.patchJump(nextArm)
+}
+
+// dupSubject returns the load of compilePattern for the subject of a match,
+// which is on top of the stack.
+func (c *Compiler) dupSubject(line int) func() error {
+	return func() error {
+		c.WriteChunk(OP_DUP, line, 1)
+		return nil
+	}
+}
+
+// compileMatchBody replaces the subject with the arm's value and jumps to the
+// end of the match, returning the jump to patch.
+func (c *Compiler) compileMatchBody(arm *MatchArm, line int) (int, error) {
+	if arm.Block != nil {
+		if err := c.Compile(arm.Block); err != nil {
+			return 0, err
+		}
+		c.WriteChunk(OP_NIL, line)
+	} else if err := c.Compile(arm.Body); err != nil {
+		return 0, err
+	}
+
+	c.WriteChunk(OP_BURY, line, 1)
+	c.WriteChunk(OP_POP, line)
+
+	c.WriteChunk(OP_JUMP, line, 9999)
+	return len(c.currentInstructions()) - 2, nil
+}
+
+// emitPatternCheck consumes the boolean on top of the stack, jumping to a
+// failure point when it's false, and returns the jump to patch.
+func (c *Compiler) emitPatternCheck(line int) int {
+	c.WriteChunk(OP_JUMP_IF_FALSE, line, 9999)
+	jump := len(c.currentInstructions()) - 2
+	c.WriteChunk(OP_POP, line)
+	return jump
+}
+
+// compilePattern emits the checks and bindings for pattern against the value
+// that load pushes, returning the failure jumps. The bindings must be defined
+// in the block of the arm already.
+func (c *Compiler) compilePattern(pattern Pattern, load func() error, line int) ([]int, error) {
+	switch pattern := pattern.(type) {
+	case *WildcardPattern:
+		return nil, nil
+	case *BindingPattern:
+		if err := load(); err != nil {
+			return nil, err
+		}
+		symbol := c.armBinding(pattern.Name.Value)
+		if symbol.Scope == GLOBAL_SCOPE {
+			c.WriteChunk(OP_DEFINE_GLOBAL, line, symbol.Index)
+		} else {
+			c.WriteChunk(OP_DEFINE_LOCAL, line, symbol.Index)
+		}
+		return nil, nil
+	case *LiteralPattern:
+		if err := load(); err != nil {
+			return nil, err
+		}
+		if err := c.Compile(pattern.Value); err != nil {
+			return nil, err
+		}
+		c.WriteChunk(OP_MATCH_EQUAL, line)
+		return []int{c.emitPatternCheck(line)}, nil
+	case *ArrayPattern:
+		if err := load(); err != nil {
+			return nil, err
+		}
+		c.WriteChunk(OP_MATCH_ARRAY, line, len(pattern.Elements))
+		jumps := []int{c.emitPatternCheck(line)}
+
+		for idx, element := range pattern.Elements {
+			index := c.MakeConstant(&FloatObject{Value: float64(idx)})
+			elementJumps, err := c.compilePattern(element, func() error {
+				if err := load(); err != nil {
+					return err
+				}
+				c.WriteChunk(OP_CONSTANT, line, index)
+				c.WriteChunk(OP_INDEX, line)
+				return nil
+			}, line)
+			if err != nil {
+				return nil, err
+			}
+			jumps = append(jumps, elementJumps...)
+		}
+		return jumps, nil
+	case *HashPattern:
+		var jumps []int
+
+		for idx, key := range pattern.Keys {
+			if err := load(); err != nil {
+				return nil, err
+			}
+			if err := c.Compile(key); err != nil {
+				return nil, err
+			}
+			c.WriteChunk(OP_MATCH_KEY, line)
+			jumps = append(jumps, c.emitPatternCheck(line))
+
+			valueJumps, err := c.compilePattern(pattern.Values[idx], func() error {
+				if err := load(); err != nil {
+					return err
+				}
+				if err := c.Compile(key); err != nil {
+					return err
+				}
+				c.WriteChunk(OP_INDEX, line)
+				return nil
+			}, line)
+			if err != nil {
+				return nil, err
+			}
+			jumps = append(jumps, valueJumps...)
+		}
+
+		if len(pattern.Keys) == 0 {
+			// {} still requires a hash, which a nil key checks for
+			if err := load(); err != nil {
+				return nil, err
+			}
+			c.WriteChunk(OP_NIL, line)
+			c.WriteChunk(OP_MATCH_KEY, line)
+			jumps = append(jumps, c.emitPatternCheck(line))
+		}
+		return jumps, nil
+	case *ClassPattern:
+		if err := load(); err != nil {
+			return nil, err
+		}
+		if err := c.Compile(pattern.Class); err != nil {
+			return nil, err
+		}
+		c.WriteChunk(OP_MATCH_CLASS, line, len(pattern.Fields))
+		jumps := []int{c.emitPatternCheck(line)}
+
+		for idx, field := range pattern.Fields {
+			fieldJumps, err := c.compilePattern(field, func() error {
+				if err := load(); err != nil {
+					return err
+				}
+				c.WriteChunk(OP_MATCH_FIELD, line, idx)
+				return nil
+			}, line)
+			if err != nil {
+				return nil, err
+			}
+			jumps = append(jumps, fieldJumps...)
+		}
+		return jumps, nil
+	default:
+		return nil, fmt.Errorf("unknown pattern %s", pattern.String())
+	}
+}
+
+// bindingSymbol reuses a variable of the current scope for the name a catch
+// clause or select case binds, defining it when there is none.
+func (c *Compiler) bindingSymbol(name string) Symbol {
+	if symbol, ok := c.SymbolTable.ResolveInner(name); ok && symbol.Scope != BUILTIN_SCOPE {
+		return symbol
+	}
+	return c.SymbolTable.Define(name)
+}
+
+// armBinding returns the variable of a pattern binding in the block of its
+// match arm, defining it unless another alternative of the arm already did.
+func (c *Compiler) armBinding(name string) Symbol {
+	if symbol, ok := c.SymbolTable.ResolveInner(name); ok && c.SymbolTable.DeclaredInBlock(name) {
+		return symbol
+	}
+	return c.SymbolTable.DefineBlock(name, false)
+}
+
+func patternBindings(pattern Pattern) []string {
+	switch pattern := pattern.(type) {
+	case *BindingPattern:
+		return []string{pattern.Name.Value}
+	case *ArrayPattern:
+		var names []string
+		for _, element := range pattern.Elements {
+			names = append(names, patternBindings(element)...)
+		}
+		return names
+	case *HashPattern:
+		var names []string
+		for _, value := range pattern.Values {
+			names = append(names, patternBindings(value)...)
+		}
+		return names
+	case *ClassPattern:
+		var names []string
+		for _, field := range pattern.Fields {
+			names = append(names, patternBindings(field)...)
+		}
+		return names
+	default:
+		return nil
+	}
+}
+
+// minJumpTableCases is the fewest integer cases worth a jump table.
+const minJumpTableCases = 4
+
+// denseMatchTable decides whether a match can dispatch through a jump table:
+// unguarded arms of integer literals, optionally ending in a lone wildcard or
+// binding, whose values are dense enough. It returns the table's bounds.
+func denseMatchTable(node *MatchExpression) (*JumpTable, bool) {
+	values := map[int]bool{}
+	min, max := 0, 0
+
+	for idx, arm := range node.Arms {
+		if arm.Guard != nil {
+			return nil, false
+		}
+
+		if idx == len(node.Arms)-1 && len(arm.Patterns) == 1 && arm.IsCatchAll() {
+			break
+		}
+
+		for _, pattern := range arm.Patterns {
+			literal, ok := pattern.(*LiteralPattern)
+			if !ok {
+				return nil, false
+			}
+
+			number, ok := integerLiteral(literal.Value)
+			if !ok {
+				return nil, false
+			}
+
+			if len(values) == 0 || number < min {
+				min = number
+			}
+			if len(values) == 0 || number > max {
+				max = number
+			}
+			values[number] = true
+		}
+	}
+
+	span := max - min + 1
+	if len(values) < minJumpTableCases || span > 2*len(values) {
+		return nil, false
+	}
+
+	targets := make([]int, span)
+	for idx := range targets {
+		targets[idx] = -1
+	}
+
+	return &JumpTable{Min: min, Targets: targets}, true
+}
+
+func integerLiteral(expr Expression) (int, bool) {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		if expr.Value != math.Trunc(expr.Value) {
+			return 0, false
+		}
+		return int(expr.Value), true
+	case *Unary:
+		if expr.Operator != "-" {
+			return 0, false
+		}
+		number, ok := integerLiteral(expr.Right)
+		return -number, ok
+	default:
+		return 0, false
+	}
+}
+
+// compileMatchTable jumps straight to the arm body for the subject's value;
+// values missing from the table fall through to the catch-all arm, if any.
+func (c *Compiler) compileMatchTable(node *MatchExpression, table *JumpTable) error {
+	line := node.Token.Line
+	c.WriteChunk(OP_JUMP_TABLE, line, c.MakeConstant(table))
+
+	arms := node.Arms
+	last := arms[len(arms)-1]
+
+	var endJumps []int
+	if last.IsCatchAll() {
+		arms = arms[:len(arms)-1]
+
+		c.SymbolTable.EnterBlock()
+		_, err := c.compilePattern(last.Patterns[0], c.dupSubject(line), line)
+		endJump := 0
+		if err == nil {
+			endJump, err = c.compileMatchBody(last, line)
+		}
+		c.SymbolTable.LeaveBlock()
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, endJump)
+	} else {
+		c.WriteChunk(OP_NO_MATCH, line)
+	}
+
+	for _, arm := range arms {
+		target := len(c.currentInstructions())
+
+		for _, pattern := range arm.Patterns {
+			number, _ := integerLiteral(pattern.(*LiteralPattern).Value)
+			if table.Targets[number-table.Min] < 0 {
+				table.Targets[number-table.Min] = target
+			}
+		}
+
+		endJump, err := c.compileMatchBody(arm, line)
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, endJump)
+	}
+
+	for _, jump := range endJumps {
+		if err := c.patchJump(jump); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
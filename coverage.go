@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// Coverage records which lines and branches of the programs it is told
+// of ran, over as many runs as there are. A branch is an OP_JUMP_IF_FALSE,
+// with two outcomes: the condition is true and the VM goes on, or it is
+// false and the VM jumps. Files are merged by absolute path, so a module
+// imported by several programs is one file.
+type Coverage struct {
+	// Exclude tells the files to leave out, such as the tests themselves
+	Exclude func(path string) bool
+
+	files map[string]*FileCoverage
+	// functions are the functions of the programs that run, nil for those
+	// left out
+	functions map[*CompiledFunction]*coveredFunction
+	current   lineTracker
+}
+
+// FileCoverage is the coverage of a file. Lines maps each line with code
+// to the times it was got to.
+type FileCoverage struct {
+	Path      string
+	Lines     map[int]int
+	Functions []*FunctionCoverage
+	Branches  []*BranchCoverage
+
+	functions map[string]*FunctionCoverage
+	branches  map[string]*BranchCoverage
+}
+
+// FunctionCoverage is the coverage of a function declared on Line; Lines
+// are the lines of its own code, not counting the functions it declares.
+type FunctionCoverage struct {
+	Name  string
+	Line  int
+	Calls int
+	Lines []int
+}
+
+// BranchCoverage counts the outcomes of a branch on Line.
+type BranchCoverage struct {
+	Line  int
+	True  int
+	False int
+}
+
+// coveredFunction is what the VM records a function of a program into.
+type coveredFunction struct {
+	file     *FileCoverage
+	function *FunctionCoverage
+	table    []int
+	branches map[int]*BranchCoverage
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{
+		files:     make(map[string]*FileCoverage),
+		functions: make(map[*CompiledFunction]*coveredFunction),
+	}
+}
+
+// Instrument has vm record its coverage. files are the files of the
+// namespaces of its program.
+func (c *Coverage) Instrument(vm *VM, files map[int]string) {
+	vm.Coverage = c
+	// the main function is the only one that isn't a constant
+	c.register(vm.Frames[0].Closure.Function, files, false)
+	for _, constant := range vm.Constants {
+		switch constant := constant.(type) {
+		case *CompiledFunction:
+			c.register(constant, files, true)
+		case *ModuleObject:
+			c.register(constant.Body, files, false)
+		}
+	}
+}
+
+// register adds the lines, branches and, if named, the function itself of
+// function to the coverage of its file.
+func (c *Coverage) register(function *CompiledFunction, files map[int]string, named bool) {
+	if _, ok := c.functions[function]; ok {
+		return
+	}
+	c.functions[function] = nil
+
+	path, ok := files[function.Namespace]
+	if !ok || c.Exclude != nil && c.Exclude(path) {
+		return
+	}
+	table := function.LineTable()
+	start := -1
+	for _, line := range table {
+		if line > 0 {
+			start = line
+			break
+		}
+	}
+	// the functions of the prelude have no lines
+	if start < 0 {
+		return
+	}
+
+	file := c.file(path)
+	covered := &coveredFunction{file: file, table: table, branches: make(map[int]*BranchCoverage)}
+	// the implicit return of a function is on the line it is declared on,
+	// which is only its own if its code starts there
+	declared := start
+	seen := map[int]bool{}
+	var lines []int
+	for _, line := range table {
+		if line > 0 && !seen[line] {
+			seen[line] = true
+			declared = min(declared, line)
+			if line >= start {
+				lines = append(lines, line)
+			}
+			if _, ok := file.Lines[line]; !ok {
+				file.Lines[line] = 0
+			}
+		}
+	}
+	sort.Ints(lines)
+
+	if named {
+		key := fmt.Sprintf("%s:%d", function.Name, declared)
+		covered.function = file.functions[key]
+		if covered.function == nil {
+			covered.function = &FunctionCoverage{Name: function.Name, Line: declared, Lines: lines}
+			file.functions[key] = covered.function
+			file.Functions = append(file.Functions, covered.function)
+		}
+	}
+
+	for offset := 0; offset < len(function.Instructions); {
+		definition, err := Lookup(function.Instructions[offset])
+		if err != nil {
+			break
+		}
+		if OpCode(function.Instructions[offset]) == OP_JUMP_IF_FALSE && table[offset] > 0 {
+			key := fmt.Sprintf("%s:%d:%d", function.Name, start, offset)
+			branch := file.branches[key]
+			if branch == nil {
+				branch = &BranchCoverage{Line: table[offset]}
+				file.branches[key] = branch
+				file.Branches = append(file.Branches, branch)
+			}
+			covered.branches[offset] = branch
+		}
+		offset++
+		for _, width := range definition.OperandWidths {
+			offset += width
+		}
+	}
+	c.functions[function] = covered
+}
+
+func (c *Coverage) file(path string) *FileCoverage {
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	file := c.files[path]
+	if file == nil {
+		file = &FileCoverage{
+			Path:      path,
+			Lines:     make(map[int]int),
+			functions: make(map[string]*FunctionCoverage),
+			branches:  make(map[string]*BranchCoverage),
+		}
+		c.files[path] = file
+	}
+	return file
+}
+
+// merge adds what other recorded, such as the coverage of a test that ran
+// on a VM of its own, to c.
+func (c *Coverage) merge(other *Coverage) {
+	for path, from := range other.files {
+		file := c.file(path)
+		for line, hits := range from.Lines {
+			file.Lines[line] += hits
+		}
+		for _, function := range from.Functions {
+			key := fmt.Sprintf("%s:%d", function.Name, function.Line)
+			if into := file.functions[key]; into != nil {
+				into.Calls += function.Calls
+				continue
+			}
+			copied := *function
+			file.functions[key] = &copied
+			file.Functions = append(file.Functions, &copied)
+		}
+		// the branches of a line are kept in the order they were found
+		keys := make(map[*BranchCoverage]string, len(from.branches))
+		for key, branch := range from.branches {
+			keys[branch] = key
+		}
+		for _, branch := range from.Branches {
+			key := keys[branch]
+			if into := file.branches[key]; into != nil {
+				into.True += branch.True
+				into.False += branch.False
+				continue
+			}
+			copied := *branch
+			file.branches[key] = &copied
+			file.Branches = append(file.Branches, &copied)
+		}
+	}
+}
+
+// instruction runs before each instruction of the VM.
+func (c *Coverage) instruction(vm *VM) {
+	frame := vm.currentFrame()
+	covered := c.functions[frame.Closure.Function]
+	if covered == nil {
+		return
+	}
+
+	ip := frame.Ip
+	if ip == 0 && covered.function != nil {
+		covered.function.Calls++
+	}
+	if line := covered.table[ip]; c.current.enter(vm, line) {
+		covered.file.Lines[line]++
+	}
+	if branch := covered.branches[ip]; branch != nil {
+		if vm.isTruthy(vm.peek(0)) {
+			branch.True++
+		} else {
+			branch.False++
+		}
+	}
+}
+
+// Files returns the coverage of the files, by path.
+func (c *Coverage) Files() []*FileCoverage {
+	files := make([]*FileCoverage, 0, len(c.files))
+	for _, file := range c.files {
+		sort.Slice(file.Functions, func(i, j int) bool { return file.Functions[i].Line < file.Functions[j].Line })
+		sort.SliceStable(file.Branches, func(i, j int) bool { return file.Branches[i].Line < file.Branches[j].Line })
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// LinesCovered returns how many of the lines with code ran, and how many
+// there are.
+func (fc *FileCoverage) LinesCovered() (int, int) {
+	covered := 0
+	for _, hits := range fc.Lines {
+		if hits > 0 {
+			covered++
+		}
+	}
+	return covered, len(fc.Lines)
+}
+
+// BranchesCovered returns how many of the outcomes of the branches
+// happened, and how many there are.
+func (fc *FileCoverage) BranchesCovered() (int, int) {
+	covered := 0
+	for _, branch := range fc.Branches {
+		covered += min(branch.True, 1) + min(branch.False, 1)
+	}
+	return covered, 2 * len(fc.Branches)
+}
+
+// FunctionLinesCovered returns how many of the lines of function ran, and
+// how many there are.
+func (fc *FileCoverage) FunctionLinesCovered(function *FunctionCoverage) (int, int) {
+	covered := 0
+	for _, line := range function.Lines {
+		if fc.Lines[line] > 0 {
+			covered++
+		}
+	}
+	return covered, len(function.Lines)
+}
+
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// Coverable reports whether any line of code was recorded, which it isn't
+// when every file ran was excluded.
+func (c *Coverage) Coverable() bool {
+	for _, file := range c.files {
+		if len(file.Lines) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteSummary writes the coverage of all the files, then that of each
+// file and of each function in it.
+func (c *Coverage) WriteSummary(w io.Writer) {
+	if !c.Coverable() {
+		fmt.Fprintln(w, "coverage: no coverable code")
+		return
+	}
+	files := c.Files()
+	var lines, lineTotal, branches, branchTotal int
+	for _, file := range files {
+		covered, total := file.LinesCovered()
+		lines, lineTotal = lines+covered, lineTotal+total
+		covered, total = file.BranchesCovered()
+		branches, branchTotal = branches+covered, branchTotal+total
+	}
+	fmt.Fprintf(w, "coverage: %.1f%% of lines, %.1f%% of branches\n", percent(lines, lineTotal), percent(branches, branchTotal))
+
+	for _, file := range files {
+		name := displayPath(".", file.Path)
+		lines, lineTotal := file.LinesCovered()
+		branches, branchTotal := file.BranchesCovered()
+		functions := 0
+		for _, function := range file.Functions {
+			functions += min(function.Calls, 1)
+		}
+		fmt.Fprintf(w, "%s\tlines %.1f%% (%d/%d)\tbranches %.1f%% (%d/%d)\tfunctions %d/%d\n",
+			name, percent(lines, lineTotal), lines, lineTotal,
+			percent(branches, branchTotal), branches, branchTotal, functions, len(file.Functions))
+		for _, function := range file.Functions {
+			covered, total := file.FunctionLinesCovered(function)
+			fmt.Fprintf(w, "\t%s:%d:\t%s\t%.1f%%\n", filepath.Base(file.Path), function.Line, function.Name, percent(covered, total))
+		}
+	}
+}
+
+// WriteLCOV writes the coverage in the tracefile format of LCOV, which
+// coverage services and genhtml read.
+func (c *Coverage) WriteLCOV(w io.Writer) {
+	for _, file := range c.Files() {
+		fmt.Fprintf(w, "TN:\nSF:%s\n", file.Path)
+
+		functions := 0
+		for _, function := range file.Functions {
+			fmt.Fprintf(w, "FN:%d,%s\n", function.Line, function.Name)
+		}
+		for _, function := range file.Functions {
+			fmt.Fprintf(w, "FNDA:%d,%s\n", function.Calls, function.Name)
+			functions += min(function.Calls, 1)
+		}
+		fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(file.Functions), functions)
+
+		// the branches of a line are numbered as blocks, with the true
+		// outcome first
+		block := map[int]int{}
+		for _, branch := range file.Branches {
+			for outcome, taken := range []int{branch.True, branch.False} {
+				count := fmt.Sprint(taken)
+				if file.Lines[branch.Line] == 0 {
+					count = "-"
+				}
+				fmt.Fprintf(w, "BRDA:%d,%d,%d,%s\n", branch.Line, block[branch.Line], outcome, count)
+			}
+			block[branch.Line]++
+		}
+		branches, branchTotal := file.BranchesCovered()
+		fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", branchTotal, branches)
+
+		lines := make([]int, 0, len(file.Lines))
+		for line := range file.Lines {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		for _, line := range lines {
+			fmt.Fprintf(w, "DA:%d,%d\n", line, file.Lines[line])
+		}
+		covered, total := file.LinesCovered()
+		fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", total, covered)
+	}
+}
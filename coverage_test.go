@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, by name, to a temporary directory it returns.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCoverage(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"loop_test.lox": `var evens = 0;
+for (var i = 0; i < 4; i = i + 1) {
+	if (i % 2 == 0) {
+		evens = evens + 1;
+	}
+}
+while (evens > 10) {
+	evens = 0;
+}
+`,
+	})
+	path := filepath.Join(dir, "loop_test.lox")
+
+	coverage := NewCoverage()
+	if err := runTestFile(path, "", coverage, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	files := coverage.Files()
+	if len(files) != 1 || files[0].Path != path {
+		t.Fatalf("Expected the coverage of %s, got=%v", path, files)
+	}
+	file := files[0]
+
+	// the condition of the loop is got to once more than its body
+	expected := map[int]int{1: 1, 2: 5, 3: 4, 4: 2, 7: 1}
+	for line, hits := range expected {
+		if file.Lines[line] != hits {
+			t.Errorf("Expected line %d to be got to %d times, got=%d", line, hits, file.Lines[line])
+		}
+	}
+	if _, ok := file.Lines[5]; ok || file.Lines[8] != 0 {
+		t.Errorf("Expected a closing brace to have no code, and the body of the while not to run")
+	}
+
+	var outcomes []string
+	for _, branch := range file.Branches {
+		outcomes = append(outcomes, fmt.Sprintf("%d:%d/%d", branch.Line, branch.True, branch.False))
+	}
+	if strings.Join(outcomes, " ") != "2:4/1 3:2/2 7:0/1" {
+		t.Errorf("Expected the outcomes of each branch, got=%v", outcomes)
+	}
+	if covered, total := file.BranchesCovered(); covered != 5 || total != 6 {
+		t.Errorf("Expected 5 of 6 outcomes, got=%d of %d", covered, total)
+	}
+
+	// a file that is left out isn't recorded
+	coverage = NewCoverage()
+	coverage.Exclude = isTestFile
+	runTestFile(path, "", coverage, io.Discard)
+	if files := coverage.Files(); len(files) != 0 {
+		t.Errorf("Expected no files, got=%v", files)
+	}
+	var summary bytes.Buffer
+	coverage.WriteSummary(&summary)
+	if summary.String() != "coverage: no coverable code\n" {
+		t.Errorf("Expected no coverable code, got=%q", summary.String())
+	}
+}
+
+const coverageLibrary = `export function classify(n) {
+	if (n < 0) {
+		return "negative";
+	}
+	return "positive";
+}
+export function unused() {
+	return 1;
+}
+export function both(a, b) {
+	return a and b;
+}
+`
+
+func TestRunTestCover(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"lib.lox": coverageLibrary,
+		"lib_test.lox": `import { classify, both } from "lib";
+if (classify(1) != "positive") {
+	throw "classify(1)";
+}
+both(true, false);
+`,
+		"more_test.lox": `import { classify } from "lib";
+classify(2);
+`,
+	})
+	profile := filepath.Join(dir, "lcov.info")
+
+	var stdout, stderr bytes.Buffer
+	if code := runTest([]string{"-coverprofile", profile, dir + "/..."}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected the tests to pass, got=%d: %s%s", code, stdout.String(), stderr.String())
+	}
+	for _, expected := range []string{
+		"ok\t" + filepath.Join(dir, "lib_test.lox"),
+		"ok\t" + filepath.Join(dir, "more_test.lox"),
+		"coverage: 75.0% of lines, 50.0% of branches\n",
+		"lib.lox\tlines 75.0% (6/8)\tbranches 50.0% (2/4)\tfunctions 2/3\n",
+		"\tlib.lox:1:\tclassify\t66.7%\n",
+		"\tlib.lox:7:\tunused\t0.0%\n",
+	} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("Expected %q in\n%s", expected, stdout.String())
+		}
+	}
+
+	lcov, err := os.ReadFile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "TN:\nSF:" + filepath.Join(dir, "lib.lox") + `
+FN:1,classify
+FN:7,unused
+FN:10,both
+FNDA:2,classify
+FNDA:0,unused
+FNDA:1,both
+FNF:3
+FNH:2
+BRDA:2,0,0,0
+BRDA:2,0,1,2
+BRDA:11,0,0,1
+BRDA:11,0,1,0
+BRF:4
+BRH:2
+DA:1,2
+DA:2,2
+DA:3,0
+DA:5,2
+DA:7,2
+DA:8,0
+DA:10,2
+DA:11,1
+LF:8
+LH:6
+end_of_record
+`
+	if string(lcov) != expected {
+		t.Errorf("Expected the LCOV\n%s\ngot=\n%s", expected, lcov)
+	}
+}
+
+func TestRunTestCoverNothing(t *testing.T) {
+	// the test files are left out, so there is nothing to cover
+	dir := writeFiles(t, map[string]string{"only_test.lox": `assert(true);`})
+	profile := filepath.Join(dir, "lcov.info")
+
+	var stdout, stderr bytes.Buffer
+	if code := runTest([]string{"-coverprofile", profile, dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected the tests to pass, got=%d: %s%s", code, stdout.String(), stderr.String())
+	}
+	if !strings.HasSuffix(stdout.String(), "\ncoverage: no coverable code\n") {
+		t.Errorf("Expected no coverable code, got=\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "no coverable code, "+profile+" has no records\n") {
+		t.Errorf("Expected the empty profile to be reported, got=\n%s", stderr.String())
+	}
+}
+
+func TestRunTestFailures(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"pass_test.lox":   `var x = 1;`,
+		"throw_test.lox":  "var x = 1;\nthrow \"broken\";\n",
+		"syntax_test.lox": `var = 1;`,
+		"helper.lox":      `throw "not a test";`,
+	})
+
+	var stdout, stderr bytes.Buffer
+	if code := runTest([]string{dir}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected the tests to fail, got=%d", code)
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "ok\t"+filepath.Join(dir, "pass_test.lox")) ||
+		!strings.Contains(out, "FAIL\t"+filepath.Join(dir, "throw_test.lox")) ||
+		!strings.Contains(out, "throw_test.lox:2: uncaught broken\n") ||
+		!strings.Contains(out, "FAIL\t"+filepath.Join(dir, "syntax_test.lox")) ||
+		strings.Contains(out, "helper.lox") {
+		t.Errorf("Expected the test files to pass or fail, got=\n%s", out)
+	}
+
+	stdout.Reset()
+	if code := runTest([]string{filepath.Join(dir, "helper.lox")}, &stdout, &stderr); code != 0 || stdout.String() != "no test files\n" {
+		t.Errorf("Expected no test files, got=%d %q", code, stdout.String())
+	}
+}
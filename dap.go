@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dapThread is the one thread the debug adapter reports; fibers and
+// async calls all run on it.
+const dapThread = 1
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapSourceBreakpoint struct {
+	Line       int    `json:"line"`
+	Condition  string `json:"condition,omitempty"`
+	LogMessage string `json:"logMessage,omitempty"`
+}
+
+type dapBreakpoint struct {
+	ID       int    `json:"id"`
+	Verified bool   `json:"verified"`
+	Line     int    `json:"line"`
+	Message  string `json:"message,omitempty"`
+}
+
+type dapStackFrame struct {
+	ID     int        `json:"id"`
+	Name   string     `json:"name"`
+	Source *dapSource `json:"source,omitempty"`
+	Line   int        `json:"line"`
+	Column int        `json:"column"`
+}
+
+type dapScope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// DebugAdapter debugs a lox program for an editor over the Debug Adapter
+// Protocol.
+type DebugAdapter struct {
+	debugger    *Debugger
+	program     string
+	stopOnEntry bool
+	// breakpoints are the ones set before the program is launched
+	breakpoints []dapSourceBreakpoint
+
+	// handles hold the variables of each variablesReference given out
+	// since the program stopped; they are only good until it goes on
+	handles []func() []Variable
+
+	mu  sync.Mutex
+	out io.Writer
+	seq int
+	// events is closed once the events of the program have all been sent
+	events chan struct{}
+}
+
+func NewDebugAdapter() *DebugAdapter {
+	return &DebugAdapter{}
+}
+
+// Serve answers the requests read from in on out until the client
+// disconnects.
+func (a *DebugAdapter) Serve(in io.Reader, out io.Writer) error {
+	a.out = out
+	reader := bufio.NewReader(in)
+	for {
+		content, err := readMessage(reader)
+		if err == io.EOF {
+			a.disconnect()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var request dapRequest
+		if err := json.Unmarshal(content, &request); err != nil {
+			return err
+		}
+		if request.Type != "request" {
+			continue
+		}
+
+		handler, ok := dapHandlers[request.Command]
+		if !ok {
+			a.respond(request, nil, fmt.Errorf("unknown command %s", request.Command))
+			continue
+		}
+		body, err := handler(a, request.Arguments)
+		a.respond(request, body, err)
+
+		switch request.Command {
+		case "initialize":
+			a.event("initialized", nil)
+		case "disconnect":
+			a.disconnect()
+			return nil
+		}
+	}
+}
+
+func (a *DebugAdapter) send(message interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.seq++
+	switch message := message.(type) {
+	case *dapResponse:
+		message.Seq = a.seq
+	case *dapEvent:
+		message.Seq = a.seq
+	}
+	content, _ := json.Marshal(message)
+	fmt.Fprintf(a.out, "Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func (a *DebugAdapter) respond(request dapRequest, body interface{}, err error) {
+	response := &dapResponse{Type: "response", RequestSeq: request.Seq, Success: err == nil, Command: request.Command, Body: body}
+	if err != nil {
+		response.Message = err.Error()
+	}
+	a.send(response)
+}
+
+func (a *DebugAdapter) event(event string, body interface{}) {
+	a.send(&dapEvent{Type: "event", Event: event, Body: body})
+}
+
+// dapOutput sends what is written to it to the client as output events of
+// category.
+type dapOutput struct {
+	adapter  *DebugAdapter
+	category string
+}
+
+func (o *dapOutput) Write(p []byte) (int, error) {
+	o.adapter.event("output", map[string]string{"category": o.category, "output": string(p)})
+	return len(p), nil
+}
+
+// dapHandlers answer the requests the adapter knows.
+var dapHandlers = map[string]func(a *DebugAdapter, arguments json.RawMessage) (interface{}, error){
+	"initialize":              (*DebugAdapter).initialize,
+	"launch":                  (*DebugAdapter).launch,
+	"setBreakpoints":          (*DebugAdapter).setBreakpoints,
+	"setExceptionBreakpoints": (*DebugAdapter).setExceptionBreakpoints,
+	"configurationDone":       (*DebugAdapter).configurationDone,
+	"threads":                 (*DebugAdapter).threads,
+	"stackTrace":              (*DebugAdapter).stackTrace,
+	"scopes":                  (*DebugAdapter).scopes,
+	"variables":               (*DebugAdapter).variables,
+	"evaluate":                (*DebugAdapter).evaluate,
+	"continue":                dapResume((*Debugger).Continue),
+	"next":                    dapResume((*Debugger).StepOver),
+	"stepIn":                  dapResume((*Debugger).StepIn),
+	"stepOut":                 dapResume((*Debugger).StepOut),
+	"pause":                   (*DebugAdapter).pause,
+	"terminate":               (*DebugAdapter).terminate,
+	"disconnect":              (*DebugAdapter).terminate,
+}
+
+func (a *DebugAdapter) initialize(arguments json.RawMessage) (interface{}, error) {
+	return map[string]bool{
+		"supportsConfigurationDoneRequest": true,
+		"supportsConditionalBreakpoints":   true,
+		"supportsLogPoints":                true,
+		"supportsEvaluateForHovers":        true,
+		"supportsTerminateRequest":         true,
+	}, nil
+}
+
+func (a *DebugAdapter) launch(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if a.debugger != nil {
+		return nil, errors.New("the program is already launched")
+	}
+
+	source, err := os.ReadFile(args.Program)
+	if err != nil {
+		return nil, err
+	}
+	debugger, err := NewDebugger(source, args.Program)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", args.Program, err)
+	}
+	debugger.Log = func(message string) {
+		a.event("output", map[string]string{"category": "console", "output": message + "\n"})
+	}
+	debugger.VM.Stdout = &dapOutput{adapter: a, category: "stdout"}
+
+	a.debugger, a.program, a.stopOnEntry = debugger, args.Program, args.StopOnEntry
+	for _, breakpoint := range a.breakpoints {
+		debugger.AddBreakpoint(breakpoint.Line, breakpoint.Condition, breakpoint.LogMessage)
+	}
+	return nil, nil
+}
+
+func (a *DebugAdapter) setBreakpoints(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source      dapSource             `json:"source"`
+		Breakpoints []dapSourceBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	breakpoints := []dapBreakpoint{}
+	if a.debugger == nil {
+		a.breakpoints = args.Breakpoints
+		for _, breakpoint := range args.Breakpoints {
+			breakpoints = append(breakpoints, dapBreakpoint{Line: breakpoint.Line, Message: "the program is not launched yet"})
+		}
+		return map[string]interface{}{"breakpoints": breakpoints}, nil
+	}
+
+	// only the program itself has lines to stop at, not its modules
+	if !samePath(args.Source.Path, a.program) {
+		for _, breakpoint := range args.Breakpoints {
+			breakpoints = append(breakpoints, dapBreakpoint{Line: breakpoint.Line, Message: "not in the program being debugged"})
+		}
+		return map[string]interface{}{"breakpoints": breakpoints}, nil
+	}
+
+	a.debugger.ClearBreakpoints()
+	for _, spec := range args.Breakpoints {
+		breakpoint := a.debugger.AddBreakpoint(spec.Line, spec.Condition, spec.LogMessage)
+		set := dapBreakpoint{ID: breakpoint.ID, Verified: breakpoint.Verified, Line: breakpoint.Line}
+		if !breakpoint.Verified {
+			set.Message = "no code at or after this line"
+		}
+		breakpoints = append(breakpoints, set)
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func (a *DebugAdapter) setExceptionBreakpoints(arguments json.RawMessage) (interface{}, error) {
+	// uncaught exceptions always stop the program, and nothing else does
+	return map[string]interface{}{"breakpoints": []dapBreakpoint{}}, nil
+}
+
+// configurationDone starts the program, sending the events of what it
+// does until it exits.
+func (a *DebugAdapter) configurationDone(arguments json.RawMessage) (interface{}, error) {
+	if a.debugger == nil {
+		return nil, errors.New("no program was launched")
+	}
+
+	a.events = make(chan struct{})
+	a.debugger.Start(a.stopOnEntry)
+	go func() {
+		defer close(a.events)
+		for {
+			stop := a.debugger.Wait()
+			if stop.Reason == StopExited {
+				exitCode := 0
+				if stop.Err != nil {
+					exitCode = 1
+					a.event("output", map[string]string{"category": "stderr", "output": stop.Err.Error() + "\n"})
+				}
+				a.event("exited", map[string]int{"exitCode": exitCode})
+				a.event("terminated", nil)
+				return
+			}
+
+			body := map[string]interface{}{"reason": stop.Reason, "threadId": dapThread, "allThreadsStopped": true}
+			if stop.Breakpoint != nil {
+				body["hitBreakpointIds"] = []int{stop.Breakpoint.ID}
+			}
+			if stop.Err != nil {
+				body["text"] = stop.Err.Error()
+			}
+			a.event("stopped", body)
+		}
+	}()
+	return nil, nil
+}
+
+func (a *DebugAdapter) threads(arguments json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{"threads": []map[string]interface{}{{"id": dapThread, "name": "main"}}}, nil
+}
+
+// stopped returns the debugger if the program is stopped, which it must
+// be to be looked into.
+func (a *DebugAdapter) stopped() (*Debugger, error) {
+	if a.debugger == nil || a.debugger.Stopped() == nil {
+		return nil, errors.New("the program is not stopped")
+	}
+	return a.debugger, nil
+}
+
+func (a *DebugAdapter) stackTrace(arguments json.RawMessage) (interface{}, error) {
+	debugger, err := a.stopped()
+	if err != nil {
+		return nil, err
+	}
+
+	frames := []dapStackFrame{}
+	for _, frame := range debugger.Frames() {
+		stackFrame := dapStackFrame{ID: frame.Index + 1, Name: frame.Name, Line: max(frame.Line, 0), Column: 1}
+		if frame.Function.Namespace == 0 {
+			stackFrame.Source = &dapSource{Name: filepath.Base(a.program), Path: a.program}
+		}
+		frames = append(frames, stackFrame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// handle gives out a variablesReference for variables.
+func (a *DebugAdapter) handle(variables func() []Variable) int {
+	a.handles = append(a.handles, variables)
+	return len(a.handles)
+}
+
+func (a *DebugAdapter) frameIndex(frameID int) (int, error) {
+	if frameID < 1 || frameID > a.debugger.VM.FrameCount {
+		return 0, fmt.Errorf("no frame %d", frameID)
+	}
+	return frameID - 1, nil
+}
+
+func (a *DebugAdapter) scopes(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	debugger, err := a.stopped()
+	if err != nil {
+		return nil, err
+	}
+	index, err := a.frameIndex(args.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []dapScope{{Name: "Locals", VariablesReference: a.handle(func() []Variable { return debugger.Locals(index) })}}
+	if len(debugger.VM.Frames[index].Closure.Function.UpvalueNames) > 0 {
+		scopes = append(scopes, dapScope{Name: "Closure", VariablesReference: a.handle(func() []Variable { return debugger.Upvalues(index) })})
+	}
+	scopes = append(scopes, dapScope{Name: "Globals", VariablesReference: a.handle(debugger.Globals), Expensive: true})
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (a *DebugAdapter) variable(name string, value Object) dapVariable {
+	variable := dapVariable{Name: name, Value: value.Inspect(), Type: string(value.Type())}
+	if len(Fields(value)) > 0 {
+		variable.VariablesReference = a.handle(func() []Variable { return Fields(value) })
+	}
+	return variable
+}
+
+func (a *DebugAdapter) variables(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if _, err := a.stopped(); err != nil {
+		return nil, err
+	}
+	if args.VariablesReference < 1 || args.VariablesReference > len(a.handles) {
+		return nil, fmt.Errorf("no variables %d", args.VariablesReference)
+	}
+
+	variables := []dapVariable{}
+	for _, variable := range a.handles[args.VariablesReference-1]() {
+		variables = append(variables, a.variable(variable.Name, variable.Value))
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+func (a *DebugAdapter) evaluate(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	debugger, err := a.stopped()
+	if err != nil {
+		return nil, err
+	}
+
+	// without a frame, the expression is evaluated in the innermost one
+	index := debugger.VM.FrameCount - 1
+	if args.FrameID != 0 {
+		if index, err = a.frameIndex(args.FrameID); err != nil {
+			return nil, err
+		}
+	}
+
+	value, err := debugger.Evaluate(index, args.Expression)
+	if err != nil {
+		return nil, err
+	}
+	result := a.variable("", value)
+	return map[string]interface{}{"result": result.Value, "type": result.Type, "variablesReference": result.VariablesReference}, nil
+}
+
+// dapResume answers a request to go on with step, which makes the
+// handles given out so far useless.
+func dapResume(step func(*Debugger) error) func(*DebugAdapter, json.RawMessage) (interface{}, error) {
+	return func(a *DebugAdapter, arguments json.RawMessage) (interface{}, error) {
+		debugger, err := a.stopped()
+		if err != nil {
+			return nil, err
+		}
+		a.handles = nil
+		if err := step(debugger); err != nil {
+			return nil, err
+		}
+		return map[string]bool{"allThreadsContinued": true}, nil
+	}
+}
+
+func (a *DebugAdapter) pause(arguments json.RawMessage) (interface{}, error) {
+	if a.debugger == nil {
+		return nil, errors.New("no program was launched")
+	}
+	a.debugger.Pause()
+	return nil, nil
+}
+
+func (a *DebugAdapter) terminate(arguments json.RawMessage) (interface{}, error) {
+	if a.debugger != nil {
+		a.debugger.Terminate()
+	}
+	return nil, nil
+}
+
+// disconnect ends the program and waits for its last events.
+func (a *DebugAdapter) disconnect() {
+	if a.debugger == nil {
+		return
+	}
+	a.debugger.Terminate()
+	if a.events != nil {
+		<-a.events
+	}
+}
+
+func runDap(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dap", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: jlox dap\n\nServes the Debug Adapter Protocol over stdin and stdout.\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := NewDebugAdapter().Serve(os.Stdin, stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dapClient drives a DebugAdapter headless, the way an editor would. It
+// reads what the adapter sends as it comes, like an editor does, since the
+// adapter sends events while answering requests.
+type dapClient struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan dapMessage
+	seq      int
+	events   []dapMessage
+	done     chan error
+}
+
+// dapMessage is any message of the adapter.
+type dapMessage struct {
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func newDapClient(t *testing.T) *dapClient {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	c := &dapClient{t: t, in: clientOut, messages: make(chan dapMessage, 64), done: make(chan error, 1)}
+	go func() {
+		c.done <- NewDebugAdapter().Serve(serverIn, serverOut)
+		serverOut.Close()
+	}()
+	go func() {
+		defer close(c.messages)
+		reader := bufio.NewReader(clientIn)
+		for {
+			content, err := readMessage(reader)
+			if err != nil {
+				return
+			}
+			var message dapMessage
+			json.Unmarshal(content, &message)
+			c.messages <- message
+		}
+	}()
+	return c
+}
+
+func (c *dapClient) read() dapMessage {
+	select {
+	case message, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("the adapter stopped sending")
+		}
+		return message
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the adapter")
+	}
+	return dapMessage{}
+}
+
+// request sends a request and decodes the body of its response into
+// body, returning the response.
+func (c *dapClient) request(command string, arguments interface{}, body interface{}) dapMessage {
+	c.seq++
+	content, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": arguments})
+	if _, err := io.WriteString(c.in, "Content-Length: "+strconv.Itoa(len(content))+"\r\n\r\n"+string(content)); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		message := c.read()
+		if message.Type == "event" {
+			c.events = append(c.events, message)
+			continue
+		}
+		if message.RequestSeq != c.seq {
+			continue
+		}
+		if body != nil && message.Success {
+			if err := json.Unmarshal(message.Body, body); err != nil {
+				c.t.Fatalf("%s answered %s: %s", command, message.Body, err)
+			}
+		}
+		return message
+	}
+}
+
+// event returns the next event named event, decoding its body into body.
+func (c *dapClient) event(event string, body interface{}) {
+	for {
+		var message dapMessage
+		if len(c.events) > 0 {
+			message, c.events = c.events[0], c.events[1:]
+		} else {
+			message = c.read()
+		}
+		if message.Type == "event" && message.Event == event {
+			if body != nil {
+				json.Unmarshal(message.Body, body)
+			}
+			return
+		}
+	}
+}
+
+type dapStopped struct {
+	Reason           string `json:"reason"`
+	HitBreakpointIds []int  `json:"hitBreakpointIds"`
+	Text             string `json:"text"`
+}
+
+// stoppedAt waits for the program to stop and returns the innermost frame.
+func (c *dapClient) stoppedAt(reason string, line int) dapStackFrame {
+	c.t.Helper()
+	var stopped dapStopped
+	c.event("stopped", &stopped)
+	var trace struct {
+		StackFrames []dapStackFrame `json:"stackFrames"`
+	}
+	c.request("stackTrace", map[string]int{"threadId": dapThread}, &trace)
+	if stopped.Reason != reason || len(trace.StackFrames) == 0 || trace.StackFrames[0].Line != line {
+		c.t.Fatalf("Expected a %s stop at line %d, got=%+v in %+v", reason, line, stopped, trace.StackFrames)
+	}
+	return trace.StackFrames[0]
+}
+
+func (c *dapClient) variables(reference int) map[string]dapVariable {
+	var body struct {
+		Variables []dapVariable `json:"variables"`
+	}
+	c.request("variables", map[string]int{"variablesReference": reference}, &body)
+	variables := map[string]dapVariable{}
+	for _, variable := range body.Variables {
+		variables[variable.Name] = variable
+	}
+	return variables
+}
+
+const dapSourceProgram = `var config = {"name": "lox", "tags": ["a", "b"]};
+function scale(values, factor) {
+	var total = 0;
+	for (var v in values) {
+		total = total + v * factor;
+	}
+	return total;
+}
+var scaled = scale([1, 2, 3], 2);
+print(scaled);
+`
+
+func TestDebugAdapter(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "scale.lox")
+	if err := os.WriteFile(program, []byte(dapSourceProgram), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newDapClient(t)
+	var capabilities map[string]bool
+	c.request("initialize", map[string]string{"adapterID": "lox"}, &capabilities)
+	if !capabilities["supportsConditionalBreakpoints"] || !capabilities["supportsLogPoints"] {
+		t.Errorf("Expected conditional breakpoints and logpoints, got=%v", capabilities)
+	}
+	c.event("initialized", nil)
+
+	if response := c.request("launch", map[string]interface{}{"program": program, "stopOnEntry": true}, nil); !response.Success {
+		t.Fatalf("launch failed: %s", response.Message)
+	}
+	var set struct {
+		Breakpoints []dapBreakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", map[string]interface{}{
+		"source": map[string]string{"path": program},
+		"breakpoints": []map[string]interface{}{
+			{"line": 5, "condition": "v == 2"},
+			{"line": 7, "logMessage": "returning {total}"},
+			{"line": 40},
+		},
+	}, &set)
+	if len(set.Breakpoints) != 3 || !set.Breakpoints[0].Verified || !set.Breakpoints[1].Verified || set.Breakpoints[2].Verified {
+		t.Fatalf("Expected the first two breakpoints to be verified, got=%+v", set.Breakpoints)
+	}
+	c.request("setExceptionBreakpoints", map[string]interface{}{"filters": []string{}}, nil)
+	c.request("configurationDone", nil, nil)
+
+	c.stoppedAt("entry", 1)
+	c.request("continue", map[string]int{"threadId": dapThread}, nil)
+	frame := c.stoppedAt("breakpoint", 5)
+	if frame.Name != "scale" || frame.Source == nil || frame.Source.Path != program {
+		t.Errorf("Expected to stop in scale, got=%+v", frame)
+	}
+
+	var scopes struct {
+		Scopes []dapScope `json:"scopes"`
+	}
+	c.request("scopes", map[string]int{"frameId": frame.ID}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Locals" || scopes.Scopes[1].Name != "Globals" {
+		t.Fatalf("Expected locals and globals, got=%+v", scopes.Scopes)
+	}
+	locals := c.variables(scopes.Scopes[0].VariablesReference)
+	if locals["v"].Value != "2" || locals["factor"].Value != "2" || locals["total"].Value != "2" {
+		t.Errorf("Expected the locals of the second iteration, got=%+v", locals)
+	}
+	values := c.variables(locals["values"].VariablesReference)
+	if len(values) != 3 || values["[1]"].Value != "2" {
+		t.Errorf("Expected the elements of values, got=%+v", values)
+	}
+
+	globals := c.variables(scopes.Scopes[1].VariablesReference)
+	tags := c.variables(c.variables(globals["config"].VariablesReference)["tags"].VariablesReference)
+	if len(tags) != 2 || tags["[1]"].Value != "b" {
+		t.Errorf("Expected to expand config.tags, got=%+v", tags)
+	}
+
+	var evaluated struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", map[string]interface{}{"expression": "v * factor + len(values)", "frameId": frame.ID}, &evaluated)
+	if evaluated.Result != "7" {
+		t.Errorf("Expected 7, got=%s", evaluated.Result)
+	}
+	if failed := c.request("evaluate", map[string]interface{}{"expression": "v +", "frameId": frame.ID}, nil); failed.Success {
+		t.Errorf("Expected an expression that doesn't parse to fail")
+	}
+
+	c.request("stepOut", map[string]int{"threadId": dapThread}, nil)
+	var output struct {
+		Output string `json:"output"`
+	}
+	c.event("output", &output)
+	if output.Output != "returning 12\n" {
+		t.Errorf("Expected the logpoint message, got=%q", output.Output)
+	}
+	c.stoppedAt("step", 9)
+	c.request("next", map[string]int{"threadId": dapThread}, nil)
+	c.stoppedAt("step", 10)
+
+	if failed := c.request("stackTrace", map[string]int{"threadId": dapThread}, nil); !failed.Success {
+		t.Errorf("Expected a stack trace while stopped, got=%s", failed.Message)
+	}
+	c.request("continue", map[string]int{"threadId": dapThread}, nil)
+	var printed struct {
+		Category string `json:"category"`
+		Output   string `json:"output"`
+	}
+	c.event("output", &printed)
+	if printed.Category != "stdout" || printed.Output != "12\n" {
+		t.Errorf("Expected what the program prints as output, got=%+v", printed)
+	}
+	var exited struct {
+		ExitCode int `json:"exitCode"`
+	}
+	c.event("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("Expected exit code 0, got=%d", exited.ExitCode)
+	}
+	c.event("terminated", nil)
+
+	c.request("disconnect", nil, nil)
+	if err := <-c.done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDebugAdapterLaunchErrors(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "broken.lox")
+	os.WriteFile(program, []byte("var = 1;"), 0644)
+
+	c := newDapClient(t)
+	c.request("initialize", map[string]string{"adapterID": "lox"}, nil)
+	if response := c.request("launch", map[string]string{"program": program}, nil); response.Success || !strings.Contains(response.Message, "broken.lox") {
+		t.Errorf("Expected launching a program that doesn't parse to fail, got=%+v", response)
+	}
+	if response := c.request("stackTrace", map[string]int{"threadId": dapThread}, nil); response.Success {
+		t.Errorf("Expected no stack trace without a program")
+	}
+	if response := c.request("restartFrame", nil, nil); response.Success {
+		t.Errorf("Expected an unknown command to fail")
+	}
+	c.request("disconnect", nil, nil)
+	<-c.done
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var errNotRunning = errors.New("the program is not being run")
+
+// DebugShell is the prompt of "jlox debug". It takes the commands of gdb:
+// they set breakpoints, run the program under a Debugger and look into it
+// where it stops.
+type DebugShell struct {
+	Debugger *Debugger
+
+	out     io.Writer
+	lines   []string
+	started bool
+	exited  bool
+	// frame is the innermost call at the last stop, to tell when a step
+	// gets to another one
+	frame *CallFrame
+	// values numbers the values printed, as $1, $2 and so on
+	values int
+	// previous is the last command, which an empty line runs again
+	previous string
+}
+
+// debugCommand is a command of the prompt, which Alias is short for.
+type debugCommand struct {
+	Name  string
+	Alias string
+	Usage string
+	Run   func(s *DebugShell, arg string) error
+}
+
+var debugCommands = []debugCommand{
+	{"break", "b", "break [file:]line [if condition] | break function", (*DebugShell).breakCommand},
+	{"delete", "d", "delete [id], all of them without one", (*DebugShell).deleteCommand},
+	{"watch", "", "watch variable, to stop when it changes", (*DebugShell).watchCommand},
+	{"info", "i", "info breakpoints | info locals", (*DebugShell).infoCommand},
+	{"run", "r", "run the program", (*DebugShell).runCommand},
+	{"continue", "c", "run to the next breakpoint", (*DebugShell).continueCommand},
+	{"step", "s", "run to the next line, into the functions it calls", (*DebugShell).stepCommand},
+	{"next", "n", "run to the next line, over the functions it calls", (*DebugShell).nextCommand},
+	{"finish", "fin", "run until the function returns", (*DebugShell).finishCommand},
+	{"print", "p", "print expression, evaluated in the innermost frame", (*DebugShell).printCommand},
+	{"backtrace", "bt", "list the calls the program is in", (*DebugShell).backtraceCommand},
+	{"locals", "", "list the variables of the innermost frame", (*DebugShell).localsCommand},
+	{"disassemble", "disas", "disassemble [function], the innermost one without one", (*DebugShell).disassembleCommand},
+}
+
+// NewDebugShell makes a prompt for debugger, which runs source.
+func NewDebugShell(debugger *Debugger, source []byte, out io.Writer) *DebugShell {
+	return &DebugShell{
+		Debugger: debugger,
+		out:      out,
+		lines:    strings.Split(string(source), "\n"),
+	}
+}
+
+// Run reads commands from in until it ends or one quits, then ends the
+// program.
+func (s *DebugShell) Run(in io.Reader) error {
+	defer s.Debugger.Terminate()
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(s.out, "(lox) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(s.out)
+			return scanner.Err()
+		}
+		if s.Execute(scanner.Text()) {
+			return nil
+		}
+	}
+}
+
+// Execute runs the command on line, reporting whether it quits.
+func (s *DebugShell) Execute(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = s.previous
+	}
+	s.previous = line
+	if line == "" {
+		return false
+	}
+
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "quit", "q":
+		return true
+	case "help", "h":
+		for _, command := range debugCommands {
+			fmt.Fprintf(s.out, "%-12s %s\n", command.Name, command.Usage)
+		}
+		fmt.Fprintf(s.out, "%-12s %s\n", "quit", "end the program and the prompt")
+		return false
+	}
+
+	for _, command := range debugCommands {
+		if name == command.Name || name == command.Alias {
+			if err := command.Run(s, arg); err != nil {
+				fmt.Fprintln(s.out, err)
+			}
+			return false
+		}
+	}
+	fmt.Fprintf(s.out, "Undefined command: %q. Try \"help\".\n", name)
+	return false
+}
+
+func (s *DebugShell) breakCommand(arg string) error {
+	location, condition, _ := strings.Cut(arg, " if ")
+	location = strings.TrimSpace(location)
+	if location == "" {
+		return errors.New("break takes a line or a function")
+	}
+
+	line := location
+	if file, at, ok := strings.Cut(location, ":"); ok {
+		if file != s.Debugger.Path && file != filepath.Base(s.Debugger.Path) {
+			return fmt.Errorf("no source file named %s", file)
+		}
+		line = at
+	}
+
+	var breakpoint *Breakpoint
+	if number, err := strconv.Atoi(line); err == nil {
+		breakpoint = s.Debugger.AddBreakpoint(number, strings.TrimSpace(condition), "")
+		if !breakpoint.Verified {
+			s.Debugger.RemoveBreakpoint(breakpoint.ID)
+			return fmt.Errorf("no code at or after line %d", number)
+		}
+	} else {
+		breakpoint = s.Debugger.AddFunctionBreakpoint(line)
+		breakpoint.Condition = strings.TrimSpace(condition)
+		if !breakpoint.Verified {
+			s.Debugger.RemoveBreakpoint(breakpoint.ID)
+			return fmt.Errorf("no function %s", line)
+		}
+	}
+	fmt.Fprintf(s.out, "Breakpoint %d at %s:%d\n", breakpoint.ID, s.Debugger.Path, breakpoint.Line)
+	return nil
+}
+
+func (s *DebugShell) deleteCommand(arg string) error {
+	if arg == "" {
+		s.Debugger.ClearBreakpoints()
+		return nil
+	}
+	id, err := strconv.Atoi(arg)
+	if err != nil || !s.Debugger.RemoveBreakpoint(id) {
+		return fmt.Errorf("no breakpoint number %s", arg)
+	}
+	return nil
+}
+
+func (s *DebugShell) watchCommand(arg string) error {
+	if !isIdentifier(arg) {
+		return errors.New("watch takes the name of a variable")
+	}
+	watchpoint, err := s.Debugger.AddWatchpoint(arg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Watchpoint %d: %s\n", watchpoint.ID, watchpoint.Name)
+	return nil
+}
+
+func (s *DebugShell) infoCommand(arg string) error {
+	switch arg {
+	case "breakpoints", "b":
+		breakpoints, watchpoints := s.Debugger.Breakpoints(), s.Debugger.Watchpoints()
+		if len(breakpoints)+len(watchpoints) == 0 {
+			fmt.Fprintln(s.out, "No breakpoints or watchpoints.")
+		}
+		for _, breakpoint := range breakpoints {
+			fmt.Fprintf(s.out, "%d breakpoint at %s:%d", breakpoint.ID, s.Debugger.Path, breakpoint.Line)
+			if breakpoint.Function != "" {
+				fmt.Fprintf(s.out, " in %s", breakpoint.Function)
+			}
+			if breakpoint.Condition != "" {
+				fmt.Fprintf(s.out, " if %s", breakpoint.Condition)
+			}
+			fmt.Fprintf(s.out, ", hit %d times\n", breakpoint.Hits)
+		}
+		for _, watchpoint := range watchpoints {
+			fmt.Fprintf(s.out, "%d watchpoint on %s\n", watchpoint.ID, watchpoint.Name)
+		}
+		return nil
+	case "locals":
+		return s.localsCommand("")
+	}
+	return errors.New("info takes breakpoints or locals")
+}
+
+func (s *DebugShell) runCommand(string) error {
+	if s.started {
+		return errors.New("the program is already running")
+	}
+	s.started = true
+	s.Debugger.Start(false)
+	s.report(s.Debugger.Wait())
+	return nil
+}
+
+func (s *DebugShell) continueCommand(string) error {
+	return s.resume(s.Debugger.Continue)
+}
+
+func (s *DebugShell) stepCommand(string) error {
+	return s.resume(s.Debugger.StepIn)
+}
+
+func (s *DebugShell) nextCommand(string) error {
+	return s.resume(s.Debugger.StepOver)
+}
+
+func (s *DebugShell) finishCommand(string) error {
+	if err := s.stopped(); err != nil {
+		return err
+	}
+	if s.Debugger.VM.FrameCount == 1 {
+		return errors.New("\"finish\" is not meaningful in the outermost frame")
+	}
+	frame := s.Debugger.Frames()[0]
+	fmt.Fprintf(s.out, "Run till exit from %s\n", s.location(frame))
+	return s.resume(s.Debugger.StepOut)
+}
+
+// resume goes on with the stopped program and reports where it stops
+// next.
+func (s *DebugShell) resume(goOn func() error) error {
+	if err := s.stopped(); err != nil {
+		return err
+	}
+	if err := goOn(); err != nil {
+		return err
+	}
+	s.report(s.Debugger.Wait())
+	return nil
+}
+
+func (s *DebugShell) stopped() error {
+	if !s.started || s.exited {
+		return errNotRunning
+	}
+	return nil
+}
+
+func (s *DebugShell) printCommand(arg string) error {
+	if err := s.stopped(); err != nil {
+		return err
+	}
+	value, err := s.Debugger.Evaluate(s.Debugger.VM.FrameCount-1, arg)
+	if err != nil {
+		return err
+	}
+	s.printValue("", value)
+	return nil
+}
+
+// printValue prints value as the next numbered value.
+func (s *DebugShell) printValue(prefix string, value Object) {
+	s.values++
+	fmt.Fprintf(s.out, "%s$%d = %s\n", prefix, s.values, value.Inspect())
+}
+
+func (s *DebugShell) backtraceCommand(string) error {
+	if err := s.stopped(); err != nil {
+		return err
+	}
+	for idx, frame := range s.Debugger.Frames() {
+		fmt.Fprintf(s.out, "#%-2d %s\n", idx, s.location(frame))
+	}
+	return nil
+}
+
+func (s *DebugShell) localsCommand(string) error {
+	if err := s.stopped(); err != nil {
+		return err
+	}
+	index := s.Debugger.VM.FrameCount - 1
+	variables := append(s.Debugger.Locals(index), s.Debugger.Upvalues(index)...)
+	if index == 0 {
+		// the program's own variables are its globals
+		variables = s.Debugger.Globals()
+	}
+	if len(variables) == 0 {
+		fmt.Fprintln(s.out, "No locals.")
+	}
+	for _, variable := range variables {
+		fmt.Fprintf(s.out, "%s = %s\n", variable.Name, variable.Value.Inspect())
+	}
+	return nil
+}
+
+func (s *DebugShell) disassembleCommand(arg string) error {
+	var function *CompiledFunction
+	if arg == "" && s.Debugger.VM.FrameCount > 0 {
+		function = s.Debugger.VM.currentFrame().Closure.Function
+	}
+	for _, candidate := range s.Debugger.functions {
+		if arg != "" && candidate.Name == arg && candidate.Line(0) > 0 {
+			function = candidate
+			break
+		}
+	}
+	if function == nil {
+		return fmt.Errorf("no function %s", arg)
+	}
+
+	current := -1
+	if s.stopped() == nil {
+		if frame := s.Debugger.VM.currentFrame(); frame.Closure.Function == function {
+			current = frame.Ip
+		}
+	}
+	fmt.Fprintf(s.out, "Dump of code for function %s:\n", function.Name)
+	disassemble(s.out, function, current)
+	fmt.Fprintln(s.out, "End of dump.")
+	return nil
+}
+
+// disassemble writes the instructions of function, one per line with its
+// offset and source line, marking the one at current.
+func disassemble(w io.Writer, function *CompiledFunction, current int) {
+	instructions := function.Instructions
+	for offset := 0; offset < len(instructions); {
+		marker := "   "
+		if offset == current {
+			marker = "=> "
+		}
+		definition, err := Lookup(instructions[offset])
+		if err != nil {
+			fmt.Fprintf(w, "%s%04d %4d %s", marker, offset, function.Line(offset), err)
+			offset++
+			continue
+		}
+		fmt.Fprintf(w, "%s%04d %4d %s", marker, offset, function.Line(offset), definition.Name)
+
+		next := offset + 1
+		for _, width := range definition.OperandWidths {
+			switch width {
+			case 2:
+				fmt.Fprintf(w, " %d", ReadUint16(instructions[next:]))
+			case 1:
+				fmt.Fprintf(w, " %d", ReadUint8(instructions[next:]))
+			}
+			next += width
+		}
+		fmt.Fprintln(w)
+		offset = next
+	}
+}
+
+// report tells where the program stopped and why.
+func (s *DebugShell) report(stop *Stop) {
+	switch stop.Reason {
+	case StopExited:
+		s.exited = true
+		if stop.Err != nil {
+			fmt.Fprintf(s.out, "Program exited with error: %s\n", stop.Err)
+		} else {
+			fmt.Fprintln(s.out, "Program exited normally.")
+		}
+		return
+	case StopBreakpoint:
+		if stop.Err != nil {
+			fmt.Fprintf(s.out, "Error in breakpoint %d: %s\n", stop.Breakpoint.ID, stop.Err)
+		}
+		fmt.Fprintf(s.out, "Breakpoint %d, ", stop.Breakpoint.ID)
+	case StopWatch:
+		fmt.Fprintf(s.out, "Watchpoint %d: %s\nOld value = %s\nNew value = %s\n",
+			stop.Watchpoint.ID, stop.Watchpoint.Name, stop.OldValue.Inspect(), stop.Watchpoint.Value.Inspect())
+	case StopException:
+		fmt.Fprintf(s.out, "Program stopped: %s\n", stop.Err)
+	case StopPause:
+		fmt.Fprintln(s.out, "Program paused.")
+	}
+
+	frames := s.Debugger.Frames()
+	innermost := s.Debugger.VM.Frames[s.Debugger.VM.FrameCount-1]
+	if stop.Reason == StopBreakpoint || innermost != s.frame {
+		fmt.Fprintln(s.out, s.location(frames[0]))
+	}
+	s.frame = innermost
+	if stop.ReturnValue != nil {
+		s.printValue("Value returned is ", stop.ReturnValue)
+	}
+	if line := frames[0].Line; line > 0 && line <= len(s.lines) {
+		fmt.Fprintf(s.out, "%d\t%s\n", line, strings.TrimSpace(s.lines[line-1]))
+	}
+}
+
+func (s *DebugShell) location(frame DebugFrame) string {
+	return fmt.Sprintf("%s at %s:%d", frame.Name, s.Debugger.Path, frame.Line)
+}
+
+func runDebug(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: jlox debug script\n\nRuns the script under a prompt that takes the commands of gdb.\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	debugger, err := NewDebugger(source, path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	// an interrupt pauses the running program rather than ending it
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			if debugger.Stopped() == nil {
+				debugger.Pause()
+			}
+		}
+	}()
+
+	if err := NewDebugShell(debugger, source, stdout).Run(os.Stdin); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// runDebugShell runs the commands on a prompt for source and returns what
+// it printed.
+func runDebugShell(t *testing.T, source string, commands ...string) string {
+	var out bytes.Buffer
+	shell := NewDebugShell(newTestDebugger(t, source), []byte(source), &out)
+	if err := shell.Run(strings.NewReader(strings.Join(commands, "\n"))); err != nil {
+		t.Fatal(err)
+	}
+	return strings.ReplaceAll(out.String(), "(lox) ", "")
+}
+
+func TestDebugShell(t *testing.T) {
+	out := runDebugShell(t, debuggerSource,
+		"break add",
+		"break test.lox:16 if i == 2",
+		"run",
+		"backtrace",
+		"locals",
+		"next",
+		"print sum * 10",
+		"finish",
+		"continue",
+		"print i",
+		"step",
+		"",
+		"delete 1",
+		"continue",
+		"print i",
+		"delete",
+		"continue",
+		"print i",
+	)
+
+	expected := `Breakpoint 1 at test.lox:3
+Breakpoint 2 at test.lox:16
+Breakpoint 1, add at test.lox:3
+3	var sum = a + b;
+#0  add at test.lox:3
+#1  main at test.lox:16
+a = 0
+b = 0
+sum = nil
+4	return sum;
+$1 = 0
+Run till exit from add at test.lox:4
+main at test.lox:16
+Value returned is $2 = 0
+16	total = add(total, i);
+Breakpoint 1, add at test.lox:3
+3	var sum = a + b;
+$3 = 1
+4	return sum;
+main at test.lox:15
+15	for (var i = 0; i < 3; i = i + 1) {
+Breakpoint 2, main at test.lox:16
+16	total = add(total, i);
+$4 = 2
+Program exited normally.
+the program is not being run
+
+`
+	expected = strings.Replace(expected, "nil", Null.Inspect(), 1)
+	if out != expected {
+		t.Errorf("Expected the session\n%q\ngot=\n%q", expected, out)
+	}
+}
+
+func TestDebugShellWatchAndDisassemble(t *testing.T) {
+	out := runDebugShell(t, debuggerSource,
+		"break add",
+		"run",
+		"watch sum",
+		"watch total",
+		"delete 1",
+		"continue",
+		"disassemble",
+		"continue",
+		"info breakpoints",
+		"quit",
+		"run",
+	)
+
+	for _, expected := range []string{
+		"Watchpoint 2: sum\nWatchpoint 3: total\n",
+		"Watchpoint 2: sum\nOld value = nil\nNew value = 0\n4\treturn sum;\n",
+		"Dump of code for function add:\n   0000    3 OP_GET_LOCAL 0\n",
+		"=> 0007    4 OP_GET_LOCAL 2\n",
+		"Watchpoint 3: total\nOld value = 0\nNew value = 1\nmain at test.lox:15\n",
+		"3 watchpoint on total\n",
+	} {
+		if !strings.Contains(out, strings.Replace(expected, "nil", Null.Inspect(), 1)) {
+			t.Errorf("Expected %q in\n%s", expected, out)
+		}
+	}
+	// the watchpoint on sum goes away with the call to add
+	if strings.Contains(out, "2 watchpoint on sum") {
+		t.Errorf("Expected the watchpoint on sum to be gone, got=\n%s", out)
+	}
+	if strings.Contains(out, "already running") {
+		t.Errorf("Expected quit to end the prompt, got=\n%s", out)
+	}
+}
+
+func TestDebugShellErrors(t *testing.T) {
+	out := runDebugShell(t, "function fail() {\n\tthrow \"boom\";\n}\nfail();\n",
+		"print 1",
+		"break 40",
+		"break nowhere",
+		"break other.lox:2",
+		"frobnicate",
+		"run",
+		"backtrace",
+		"finish",
+		"continue",
+	)
+
+	expected := `the program is not being run
+no code at or after line 40
+no function nowhere
+no source file named other.lox
+Undefined command: "frobnicate". Try "help".
+Program stopped: uncaught boom
+fail at test.lox:2
+2	throw "boom";
+#0  fail at test.lox:2
+#1  main at test.lox:4
+Run till exit from fail at test.lox:2
+Program exited with error: uncaught boom
+the program is not being run
+
+`
+	if out != expected {
+		t.Errorf("Expected the session\n%q\ngot=\n%q", expected, out)
+	}
+}
@@ -0,0 +1,805 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Reasons a Debugger stops the program for
+const (
+	StopEntry      = "entry"
+	StopBreakpoint = "breakpoint"
+	StopStep       = "step"
+	StopPause      = "pause"
+	StopException  = "exception"
+	StopWatch      = "data breakpoint"
+	StopExited     = "exited"
+)
+
+// errTerminated ends a run the debugger was told to stop. No handler of
+// the program catches it.
+var errTerminated = errors.New("terminated by the debugger")
+
+// Stop tells why and where a program stopped.
+type Stop struct {
+	Reason     string
+	Line       int
+	Breakpoint *Breakpoint
+	// Watchpoint is the watchpoint of a watch stop, whose variable was
+	// OldValue before
+	Watchpoint *Watchpoint
+	OldValue   Object
+	// ReturnValue is what the function a step out left returned
+	ReturnValue Object
+	// Err is the error of an exception stop, or the one the program
+	// exited with
+	Err error
+}
+
+// Breakpoint stops the program when it gets to Line. A logpoint, which
+// has a LogMessage, logs it instead of stopping; the parts of the message
+// in braces are expressions, replaced by their values.
+type Breakpoint struct {
+	ID int
+	// Line is the first line with code at or after the one asked for;
+	// Verified is false if there is none
+	Line       int
+	Verified   bool
+	Condition  string
+	LogMessage string
+	// Function is the name of the functions a function breakpoint stops
+	// at the start of
+	Function string
+	// Hits counts the times the program got to it with Condition true
+	Hits int
+}
+
+// Watchpoint stops the program when the value of a variable changes. A
+// watchpoint on a variable of a function goes away when the call it was
+// set in returns.
+type Watchpoint struct {
+	ID   int
+	Name string
+	// Value is what the variable held when the program last got to a
+	// line
+	Value Object
+
+	// frame is the call whose variable it is, at index in the frames
+	// of fiber, or nil for a global
+	frame *CallFrame
+	index int
+	fiber *CompiledFiber
+	read  func() Object
+}
+
+type stepMode int
+
+const (
+	runToBreakpoint stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// Debugger runs a program on a VM that stops at breakpoints and steps.
+// The program runs on a goroutine of its own; while it is stopped the
+// state of the VM can be looked at and expressions evaluated in it.
+type Debugger struct {
+	VM   *VM
+	Path string
+	// Log receives the messages of logpoints
+	Log func(message string)
+
+	globals *SymbolTable
+	// lines are the lines of the program that have code
+	lines map[int]bool
+	// functions are the functions of the program
+	functions []*CompiledFunction
+
+	// mu guards what the client and the goroutine of the program share
+	mu          sync.Mutex
+	breakpoints []*Breakpoint
+	watchpoints []*Watchpoint
+	nextID      int
+	pausing     bool
+	current     *Stop
+
+	// mode is how the program goes on after the last stop, which was in
+	// fiber with depth frames
+	mode  stepMode
+	depth int
+	fiber *CompiledFiber
+	entry bool
+
+	exited *Stop
+	stops  chan *Stop
+	resume chan stepMode
+	quit   chan struct{}
+	once   sync.Once
+}
+
+// NewDebugger compiles source, the program at path, for debugging.
+func NewDebugger(source []byte, path string) (*Debugger, error) {
+	compiler, err := compileSource(source, path)
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode := compiler.ByteCode()
+	d := &Debugger{
+		VM:      NewVM(bytecode),
+		Path:    path,
+		globals: compiler.SymbolTable,
+		lines:   make(map[int]bool),
+		stops:   make(chan *Stop),
+		resume:  make(chan stepMode),
+		quit:    make(chan struct{}),
+	}
+	d.VM.Debugger = d
+
+	// the functions of the program are among its constants, and those
+	// of its modules have namespaces of their own
+	tables := [][]LineInfo{bytecode.Lines}
+	for _, constant := range bytecode.Constants {
+		if function, ok := constant.(*CompiledFunction); ok && function.Namespace == 0 {
+			tables = append(tables, function.Lines)
+			d.functions = append(d.functions, function)
+		}
+	}
+	for _, lines := range tables {
+		for _, info := range lines {
+			if info.Line > 0 {
+				d.lines[info.Line] = true
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// AddBreakpoint sets a breakpoint at line, which can be a conditional
+// breakpoint or a logpoint.
+func (d *Debugger) AddBreakpoint(line int, condition, logMessage string) *Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	breakpoint := &Breakpoint{ID: d.nextID, Line: line, Condition: condition, LogMessage: logMessage}
+	for last := d.lastLine(); breakpoint.Line <= last; breakpoint.Line++ {
+		if d.lines[breakpoint.Line] {
+			breakpoint.Verified = true
+			break
+		}
+	}
+	if !breakpoint.Verified {
+		breakpoint.Line = line
+	}
+	d.breakpoints = append(d.breakpoints, breakpoint)
+	return breakpoint
+}
+
+// AddFunctionBreakpoint sets a breakpoint at the start of the functions
+// and methods called name.
+func (d *Debugger) AddFunctionBreakpoint(name string) *Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	breakpoint := &Breakpoint{ID: d.nextID, Function: name}
+	for _, function := range d.functions {
+		// the functions of the prelude have no lines
+		if line := function.Line(0); function.Name == name && line > 0 {
+			breakpoint.Line, breakpoint.Verified = line, true
+			break
+		}
+	}
+	d.breakpoints = append(d.breakpoints, breakpoint)
+	return breakpoint
+}
+
+// AddWatchpoint watches the variable called name: a local or captured
+// variable of the innermost frame of the stopped program, or else a
+// global.
+func (d *Debugger) AddWatchpoint(name string) (*Watchpoint, error) {
+	watchpoint := &Watchpoint{Name: name}
+	if d.Stopped() != nil {
+		index := d.VM.FrameCount - 1
+		frame := d.VM.Frames[index]
+		function := frame.Closure.Function
+		// the innermost of the locals with the name is the last one
+		for slot := len(function.LocalNames) - 1; slot >= 0 && function.Namespace == 0; slot-- {
+			if function.LocalNames[slot] == name {
+				stack, at := d.VM.Stack, frame.BasePointer+slot
+				watchpoint.read = func() Object { return stack[at] }
+				break
+			}
+		}
+		for idx, upvalue := range function.UpvalueNames {
+			if upvalue == name && watchpoint.read == nil && function.Namespace == 0 {
+				upvalues, at := frame.Closure.UpValues, idx
+				watchpoint.read = func() Object { return upvalues[at] }
+			}
+		}
+		if watchpoint.read != nil {
+			watchpoint.frame, watchpoint.index, watchpoint.fiber = frame, index, d.VM.Fiber
+		}
+	}
+	if watchpoint.read == nil {
+		symbol, ok := d.globals.Resolve(name)
+		if !ok || symbol.Index < len(Builtins) {
+			return nil, fmt.Errorf("no variable %s", name)
+		}
+		watchpoint.read = func() Object { return d.VM.Namespaces[0][symbol.Index] }
+	}
+	watchpoint.Value = orNull(watchpoint.read())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	watchpoint.ID = d.nextID
+	d.watchpoints = append(d.watchpoints, watchpoint)
+	return watchpoint, nil
+}
+
+// Watchpoints returns the watchpoints in the order they were set.
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*Watchpoint(nil), d.watchpoints...)
+}
+
+func (d *Debugger) lastLine() int {
+	last := 0
+	for line := range d.lines {
+		last = max(last, line)
+	}
+	return last
+}
+
+// RemoveBreakpoint removes the breakpoint or watchpoint with id,
+// reporting whether there was one.
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for idx, breakpoint := range d.breakpoints {
+		if breakpoint.ID == id {
+			d.breakpoints = append(d.breakpoints[:idx], d.breakpoints[idx+1:]...)
+			return true
+		}
+	}
+	for idx, watchpoint := range d.watchpoints {
+		if watchpoint.ID == id {
+			d.watchpoints = append(d.watchpoints[:idx], d.watchpoints[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearBreakpoints removes every breakpoint and watchpoint.
+func (d *Debugger) ClearBreakpoints() {
+	d.mu.Lock()
+	d.breakpoints, d.watchpoints = nil, nil
+	d.mu.Unlock()
+}
+
+// Breakpoints returns the breakpoints in the order they were set.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*Breakpoint(nil), d.breakpoints...)
+}
+
+// functionBreakpoint returns the breakpoint at the start of function,
+// if any.
+func (d *Debugger) functionBreakpoint(function *CompiledFunction) *Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, breakpoint := range d.breakpoints {
+		if breakpoint.Verified && breakpoint.Function == function.Name {
+			return breakpoint
+		}
+	}
+	return nil
+}
+
+// watched returns the stop for the first watchpoint whose variable
+// changed, dropping those whose call returned.
+func (d *Debugger) watched(vm *VM) *Stop {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	watchpoints := d.watchpoints[:0]
+	var stop *Stop
+	for _, watchpoint := range d.watchpoints {
+		if watchpoint.frame != nil && vm.Fiber == watchpoint.fiber &&
+			(watchpoint.index >= vm.FrameCount || vm.Frames[watchpoint.index] != watchpoint.frame) {
+			continue
+		}
+		watchpoints = append(watchpoints, watchpoint)
+		if stop != nil || watchpoint.frame != nil && vm.Fiber != watchpoint.fiber {
+			continue
+		}
+		if value := orNull(watchpoint.read()); value.Inspect() != watchpoint.Value.Inspect() {
+			stop = &Stop{Reason: StopWatch, Watchpoint: watchpoint, OldValue: watchpoint.Value}
+			watchpoint.Value = value
+		}
+	}
+	d.watchpoints = watchpoints
+	return stop
+}
+
+func (d *Debugger) breakpointAt(line int) *Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, breakpoint := range d.breakpoints {
+		if breakpoint.Verified && breakpoint.Function == "" && breakpoint.Line == line {
+			return breakpoint
+		}
+	}
+	return nil
+}
+
+// Start runs the program, stopping before its first line if stopOnEntry
+// is set.
+func (d *Debugger) Start(stopOnEntry bool) {
+	if stopOnEntry {
+		d.mode, d.entry = stepIn, true
+	}
+
+	go func() {
+		err := d.VM.run()
+		if err == errTerminated {
+			err = nil
+		} else if err != nil {
+			frame := d.VM.currentFrame()
+			d.stop(&Stop{Reason: StopException, Line: frame.Closure.Function.Line(frame.Ip - 1), Err: err})
+		}
+		d.exited = &Stop{Reason: StopExited, Err: err}
+		close(d.stops)
+	}()
+}
+
+// Wait blocks until the program stops and returns why. Once the program
+// exited it returns the exited stop.
+func (d *Debugger) Wait() *Stop {
+	if stop, ok := <-d.stops; ok {
+		return stop
+	}
+	return d.exited
+}
+
+// Stopped returns the stop the program is at, or nil while it runs.
+func (d *Debugger) Stopped() *Stop {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current
+}
+
+// Continue runs the program until the next breakpoint.
+func (d *Debugger) Continue() error { return d.resumeWith(runToBreakpoint) }
+
+// StepIn runs the program to the next line, in a function it calls if
+// there is one.
+func (d *Debugger) StepIn() error { return d.resumeWith(stepIn) }
+
+// StepOver runs the program to the next line of the function it is in,
+// or of a caller if that function returns.
+func (d *Debugger) StepOver() error { return d.resumeWith(stepOver) }
+
+// StepOut runs the program until the function it is in returns.
+func (d *Debugger) StepOut() error { return d.resumeWith(stepOut) }
+
+func (d *Debugger) resumeWith(mode stepMode) error {
+	d.mu.Lock()
+	stopped := d.current != nil
+	d.current = nil
+	d.mu.Unlock()
+	if !stopped {
+		return errors.New("the program is not stopped")
+	}
+
+	select {
+	case d.resume <- mode:
+	case <-d.quit:
+	}
+	return nil
+}
+
+// Pause stops the program at the next line it gets to.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	d.pausing = true
+	d.mu.Unlock()
+}
+
+// Terminate ends the program, stopped or not.
+func (d *Debugger) Terminate() {
+	d.once.Do(func() { close(d.quit) })
+}
+
+// instruction runs before each instruction of the VM, stopping it where
+// it should stop. Only instructions that start a line can stop it, and
+// only in the program itself, not in its modules.
+func (d *Debugger) instruction(vm *VM) error {
+	select {
+	case <-d.quit:
+		return errTerminated
+	default:
+	}
+
+	frame := vm.currentFrame()
+	function := frame.Closure.Function
+	if function.Namespace != 0 {
+		return nil
+	}
+
+	// a step out stops as soon as the function it started in returns,
+	// where its result is on top of the stack
+	if d.mode == stepOut && vm.Fiber == d.fiber && vm.FrameCount < d.depth {
+		return d.stop(&Stop{Reason: StopStep, ReturnValue: vm.Stack[vm.Sp-1]})
+	}
+
+	line, ok := lineStart(function.Lines, frame.Ip)
+	if !ok || line <= 0 {
+		return nil
+	}
+
+	if stop := d.watched(vm); stop != nil {
+		return d.stop(stop)
+	}
+
+	if frame.Ip == 0 {
+		if breakpoint := d.functionBreakpoint(function); breakpoint != nil {
+			stop, err := d.hit(breakpoint)
+			if err != nil || stop {
+				return d.stop(&Stop{Reason: StopBreakpoint, Breakpoint: breakpoint, Err: err})
+			}
+		}
+	}
+
+	if breakpoint := d.breakpointAt(line); breakpoint != nil {
+		stop, err := d.hit(breakpoint)
+		if err != nil {
+			return d.stop(&Stop{Reason: StopBreakpoint, Breakpoint: breakpoint, Err: err})
+		}
+		if stop {
+			return d.stop(&Stop{Reason: StopBreakpoint, Breakpoint: breakpoint})
+		}
+	}
+
+	d.mu.Lock()
+	pausing := d.pausing
+	d.pausing = false
+	d.mu.Unlock()
+	if pausing {
+		return d.stop(&Stop{Reason: StopPause})
+	}
+
+	switch d.mode {
+	case stepIn:
+		if d.entry {
+			return d.stop(&Stop{Reason: StopEntry})
+		}
+		return d.stop(&Stop{Reason: StopStep})
+	case stepOver:
+		if vm.Fiber == d.fiber && vm.FrameCount <= d.depth || d.fiber.State == FiberDone {
+			return d.stop(&Stop{Reason: StopStep})
+		}
+	}
+	return nil
+}
+
+// lineStart returns the line of the instruction at offset, and whether
+// the instruction is the first of a run of that line.
+func lineStart(lines []LineInfo, offset int) (int, bool) {
+	start := 0
+	for _, info := range lines {
+		if offset < start+info.Count {
+			return info.Line, offset == start
+		}
+		start += info.Count
+	}
+	return -1, false
+}
+
+// hit reports whether the program stops at breakpoint, logging the
+// message of a logpoint.
+func (d *Debugger) hit(breakpoint *Breakpoint) (bool, error) {
+	if breakpoint.Condition != "" {
+		value, err := d.Evaluate(d.VM.FrameCount-1, breakpoint.Condition)
+		if err != nil {
+			return true, fmt.Errorf("condition %s: %w", breakpoint.Condition, err)
+		}
+		if !d.VM.isTruthy(value) {
+			return false, nil
+		}
+	}
+	breakpoint.Hits++
+
+	if breakpoint.LogMessage == "" {
+		return true, nil
+	}
+	if d.Log != nil {
+		d.Log(d.interpolate(breakpoint.LogMessage))
+	}
+	return false, nil
+}
+
+// interpolate replaces the expressions in braces in message by their
+// values in the innermost frame.
+func (d *Debugger) interpolate(message string) string {
+	var out strings.Builder
+	for {
+		open := strings.IndexByte(message, '{')
+		end := -1
+		if open >= 0 {
+			end = strings.IndexByte(message[open+1:], '}')
+		}
+		if end < 0 {
+			out.WriteString(message)
+			return out.String()
+		}
+		out.WriteString(message[:open])
+
+		expression := message[open+1 : open+1+end]
+		if value, err := d.Evaluate(d.VM.FrameCount-1, expression); err != nil {
+			out.WriteString("<" + err.Error() + ">")
+		} else {
+			out.WriteString(value.Inspect())
+		}
+		message = message[open+end+2:]
+	}
+}
+
+// stop parks the VM until the debugger is told to go on.
+func (d *Debugger) stop(stop *Stop) error {
+	if stop.Line == 0 {
+		frame := d.VM.currentFrame()
+		stop.Line = frame.Closure.Function.Line(frame.Ip)
+	}
+	d.entry = false
+	d.mu.Lock()
+	d.current = stop
+	d.mu.Unlock()
+
+	select {
+	case d.stops <- stop:
+	case <-d.quit:
+		return errTerminated
+	}
+
+	select {
+	case mode := <-d.resume:
+		d.mode, d.depth, d.fiber = mode, d.VM.FrameCount, d.VM.Fiber
+		return nil
+	case <-d.quit:
+		return errTerminated
+	}
+}
+
+// DebugFrame is a call the stopped program is in.
+type DebugFrame struct {
+	// Index is the place of the frame in VM.Frames, which the methods
+	// looking into a frame take
+	Index    int
+	Name     string
+	Line     int
+	Function *CompiledFunction
+}
+
+// Frames returns the calls the stopped program is in, innermost first.
+func (d *Debugger) Frames() []DebugFrame {
+	var frames []DebugFrame
+	for idx := d.VM.FrameCount - 1; idx >= 0; idx-- {
+		frame := d.VM.Frames[idx]
+		// the innermost frame is at the instruction it runs next, unless
+		// that instruction failed; the others are past the call they
+		// made
+		ip := frame.Ip - 1
+		if stop := d.Stopped(); idx == d.VM.FrameCount-1 && (stop == nil || stop.Reason != StopException) {
+			ip = frame.Ip
+		}
+		function := frame.Closure.Function
+		frames = append(frames, DebugFrame{Index: idx, Name: function.Name, Line: function.Line(ip), Function: function})
+	}
+	return frames
+}
+
+// Variable is a name and its value.
+type Variable struct {
+	Name  string
+	Value Object
+}
+
+// hiddenName tells the names the compiler makes up, such as those of
+// destructured parameters.
+func hiddenName(name string) bool {
+	return name == "" || strings.ContainsAny(name[:1], "[{")
+}
+
+// Locals returns the local variables of the frame at index, in slot
+// order. A name declared in more than one block is there more than once.
+func (d *Debugger) Locals(index int) []Variable {
+	frame := d.VM.Frames[index]
+	var locals []Variable
+	for slot, name := range frame.Closure.Function.LocalNames {
+		if hiddenName(name) {
+			continue
+		}
+		locals = append(locals, Variable{name, orNull(d.VM.Stack[frame.BasePointer+slot])})
+	}
+	return locals
+}
+
+// Upvalues returns the variables the function of the frame at index
+// captured.
+func (d *Debugger) Upvalues(index int) []Variable {
+	closure := d.VM.Frames[index].Closure
+	var upvalues []Variable
+	for idx, name := range closure.Function.UpvalueNames {
+		if idx < len(closure.UpValues) {
+			upvalues = append(upvalues, Variable{name, orNull(closure.UpValues[idx])})
+		}
+	}
+	return upvalues
+}
+
+// Globals returns the globals of the program that are defined so far.
+func (d *Debugger) Globals() []Variable {
+	var globals []Variable
+	for slot, name := range d.globals.Names() {
+		if slot < len(Builtins) {
+			continue
+		}
+		if value := d.VM.Namespaces[0][slot]; value != nil {
+			globals = append(globals, Variable{name, value})
+		}
+	}
+	return globals
+}
+
+func orNull(value Object) Object {
+	if value == nil {
+		return Null
+	}
+	return value
+}
+
+// Fields returns the elements of an array, the pairs of a hash or the
+// fields of an instance, and nil for the other values.
+func Fields(value Object) []Variable {
+	var fields []Variable
+	switch value := value.(type) {
+	case *Array:
+		for idx, element := range value.Elements {
+			fields = append(fields, Variable{fmt.Sprintf("[%d]", idx), orNull(element)})
+		}
+	case *Hash:
+		for _, pair := range value.SortedPairs() {
+			fields = append(fields, Variable{pair.Key.Inspect(), orNull(pair.Value)})
+		}
+	case *CompiledInstanceObject:
+		for name, field := range value.Fields {
+			fields = append(fields, Variable{name, orNull(field)})
+		}
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	}
+	return fields
+}
+
+// Evaluate evaluates expression in the frame at index of the stopped
+// program. The expression can use the variables of the frame and assign
+// them, and it can call the functions of the program.
+func (d *Debugger) Evaluate(index int, expression string) (Object, error) {
+	expression = strings.TrimSpace(expression)
+	if !strings.HasSuffix(expression, ";") {
+		expression += ";"
+	}
+	scanner := NewScanner([]byte(expression))
+	scanner.scanTokens()
+	if scanner.Errors().HasErrors() {
+		return nil, errors.New(scanner.Errors().Errors[0].Message)
+	}
+	program, errs := parseDocument(scanner.Tokens())
+	if len(errs) > 0 {
+		return nil, errors.New(errs[0].Message)
+	}
+	if len(program.Statements) != 1 {
+		return nil, errors.New("not an expression")
+	}
+	if _, ok := program.Statements[0].(*ExpressionStatement); !ok {
+		return nil, errors.New("not an expression")
+	}
+
+	// the variables of the frame become globals of their own for the
+	// expression, the innermost defined last, and are copied back after
+	frame := d.VM.Frames[index]
+	table := d.globals.Fork()
+	globals := d.VM.Namespaces[0]
+	var copies []func()
+	// unset holds the variables whose slots hold no value yet
+	unset := map[string]bool{}
+	bind := func(name string, variable *Object) {
+		if hiddenName(name) {
+			return
+		}
+		unset[name] = *variable == nil
+		symbol := table.Define(name)
+		globals[symbol.Index] = orNull(*variable)
+		copies = append(copies, func() {
+			*variable = globals[symbol.Index]
+			globals[symbol.Index] = nil
+		})
+	}
+	if function := frame.Closure.Function; function.Namespace == 0 {
+		for idx, name := range function.UpvalueNames {
+			bind(name, &frame.Closure.UpValues[idx])
+		}
+		for slot, name := range function.LocalNames {
+			bind(name, &d.VM.Stack[frame.BasePointer+slot])
+		}
+	}
+	defer func() {
+		for _, restore := range copies {
+			restore()
+		}
+	}()
+
+	// a variable not defined yet holds no value at all, which the VM
+	// doesn't expect
+	if name := unsetVariable(program, table, globals, unset); name != "" {
+		return nil, fmt.Errorf("%s is not defined yet", name)
+	}
+
+	compiler := NewCompiler()
+	compiler.SymbolTable = table
+	compiler.Constants = append([]Object(nil), d.VM.Constants...)
+	compiler.Path = d.Path
+	if err := compiler.Compile(program); err != nil {
+		return nil, err
+	}
+
+	// the expression runs on a VM of its own, with the globals of the
+	// program
+	vm := NewVM(compiler.ByteCode())
+	vm.Stdout = d.VM.Stdout
+	vm.Namespaces = d.VM.Namespaces
+	vm.Globals = globals
+	if err := vm.run(); err != nil {
+		return nil, err
+	}
+	return orNull(vm.LastPoppedStackElem()), nil
+}
+
+// unsetVariable returns the name of the first variable node reads that has
+// no value yet: one of unset, or a global of table the program hasn't got
+// to. It returns "" if there is none. The functions node defines are left
+// out, as their variables are their own.
+func unsetVariable(node Node, table *SymbolTable, globals []Object, unset map[string]bool) string {
+	name := ""
+	Walk(node, func(node Node) bool {
+		switch node := node.(type) {
+		case *Assignment:
+			// assigning gives the variable a value
+			name = unsetVariable(node.Expression, table, globals, unset)
+			return false
+		case *FunctionLiteral:
+			return false
+		case *Identifier:
+			if unset[node.Value] {
+				name = node.Value
+			} else if symbol, ok := table.ResolveInner(node.Value); ok && symbol.Scope == GLOBAL_SCOPE && globals[symbol.Index] == nil {
+				name = node.Value
+			}
+		}
+		return name == ""
+	})
+	return name
+}
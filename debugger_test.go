@@ -0,0 +1,264 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const debuggerSource = `var total = 0;
+function add(a, b) {
+	var sum = a + b;
+	return sum;
+}
+function counter() {
+	var count = 0;
+	function increment() {
+		count = count + 1;
+		return count;
+	}
+	return increment;
+}
+var next = counter();
+for (var i = 0; i < 3; i = i + 1) {
+	total = add(total, i);
+}
+next();
+print(total);
+`
+
+func newTestDebugger(t *testing.T, source string) *Debugger {
+	d, err := NewDebugger([]byte(source), "test.lox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Terminate)
+	return d
+}
+
+// expectStop waits for the next stop, checking its reason and line.
+func expectStop(t *testing.T, d *Debugger, reason string, line int) *Stop {
+	t.Helper()
+	stop := d.Wait()
+	if stop.Reason != reason || stop.Line != line {
+		t.Fatalf("Expected a %s stop at line %d, got=%s at line %d (%v)", reason, line, stop.Reason, stop.Line, stop.Err)
+	}
+	return stop
+}
+
+func TestDebuggerSteps(t *testing.T) {
+	d := newTestDebugger(t, debuggerSource)
+	d.AddBreakpoint(16, "", "")
+	d.Start(true)
+
+	expectStop(t, d, StopEntry, 1)
+	d.StepOver()
+	expectStop(t, d, StopStep, 2)
+	d.Continue()
+	expectStop(t, d, StopBreakpoint, 16)
+
+	d.StepIn()
+	expectStop(t, d, StopStep, 3)
+	frames := d.Frames()
+	if len(frames) != 2 || frames[0].Name != "add" || frames[0].Line != 3 || frames[1].Name != "main" || frames[1].Line != 16 {
+		t.Fatalf("Expected add called from main at line 16, got=%+v", frames)
+	}
+
+	d.StepOver()
+	expectStop(t, d, StopStep, 4)
+	locals := d.Locals(frames[0].Index)
+	var names []string
+	for _, local := range locals {
+		names = append(names, local.Name+"="+local.Value.Inspect())
+	}
+	if strings.Join(names, " ") != "a=0 b=0 sum=0" {
+		t.Errorf("Expected the locals of add, got=%v", names)
+	}
+
+	d.StepOut()
+	stop := expectStop(t, d, StopStep, 16)
+	if stop.ReturnValue == nil || stop.ReturnValue.Inspect() != "0" {
+		t.Errorf("Expected add to return 0, got=%v", stop.ReturnValue)
+	}
+
+	// the loop comes back to the breakpoint for the next element
+	d.StepOver()
+	expectStop(t, d, StopStep, 15)
+	d.StepOver()
+	expectStop(t, d, StopBreakpoint, 16)
+
+	d.ClearBreakpoints()
+	d.Continue()
+	if stop := d.Wait(); stop.Reason != StopExited || stop.Err != nil {
+		t.Errorf("Expected the program to exit, got=%+v", stop)
+	}
+}
+
+func TestDebuggerInspect(t *testing.T) {
+	d := newTestDebugger(t, debuggerSource)
+	d.AddBreakpoint(9, "", "")
+	d.Start(false)
+	expectStop(t, d, StopBreakpoint, 9)
+
+	frame := d.Frames()[0]
+	upvalues := d.Upvalues(frame.Index)
+	if len(upvalues) != 1 || upvalues[0].Name != "count" || upvalues[0].Value.Inspect() != "0" {
+		t.Errorf("Expected count captured, got=%v", upvalues)
+	}
+
+	globals := map[string]string{}
+	for _, global := range d.Globals() {
+		globals[global.Name] = global.Value.Inspect()
+	}
+	if globals["total"] != "3" || globals["i"] != "3" {
+		t.Errorf("Expected the globals after the loop, got=%v", globals)
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"count + total", "3"},
+		{"add(count, 10)", "10"},
+		{"count = 41", "41"},
+		{"[total, {\"a\": 1}]", "[3, [a: 1]]"},
+	}
+	for _, test := range tests {
+		value, err := d.Evaluate(frame.Index, test.expression)
+		if err != nil {
+			t.Errorf("Evaluate(%q) failed: %s", test.expression, err)
+			continue
+		}
+		if value.Inspect() != test.expected {
+			t.Errorf("Expected %s for %q, got=%s", test.expected, test.expression, value.Inspect())
+		}
+	}
+	if _, err := d.Evaluate(frame.Index, "var x = 1"); err == nil {
+		t.Errorf("Expected a declaration not to evaluate")
+	}
+
+	// the assignment went to the captured variable
+	d.StepOut()
+	stop := expectStop(t, d, StopStep, 18)
+	if stop.ReturnValue.Inspect() != "42" {
+		t.Errorf("Expected count to have been set to 41, got=%s", stop.ReturnValue.Inspect())
+	}
+
+	array := Fields(&Array{Elements: []Object{&FloatObject{Value: 1}}})
+	if len(array) != 1 || array[0].Name != "[0]" {
+		t.Errorf("Expected the elements of an array, got=%v", array)
+	}
+}
+
+func TestDebuggerConditionsAndLogpoints(t *testing.T) {
+	d := newTestDebugger(t, debuggerSource)
+	var logged []string
+	d.Log = func(message string) { logged = append(logged, message) }
+
+	d.AddBreakpoint(3, "a == 1", "")
+	d.AddBreakpoint(16, "", "i={i} total={total}")
+	if moved := d.AddBreakpoint(5, "", ""); !moved.Verified || moved.Line != 6 {
+		t.Errorf("Expected a breakpoint on a closing brace to move to line 6, got=%+v", moved)
+	}
+	if nowhere := d.AddBreakpoint(100, "", ""); nowhere.Verified {
+		t.Errorf("Expected a breakpoint past the end not to be verified")
+	}
+	d.Start(false)
+
+	expectStop(t, d, StopBreakpoint, 6)
+	d.Continue()
+	stop := expectStop(t, d, StopBreakpoint, 3)
+	if a, _ := d.Evaluate(d.Frames()[0].Index, "a"); a.Inspect() != "1" {
+		t.Errorf("Expected to stop where a is 1, got=%s", a.Inspect())
+	}
+	if stop.Breakpoint.Hits != 1 {
+		t.Errorf("Expected one hit, got=%d", stop.Breakpoint.Hits)
+	}
+
+	d.Continue()
+	if stop := d.Wait(); stop.Reason != StopExited {
+		t.Fatalf("Expected the program to exit, got=%+v", stop)
+	}
+	expected := "i=0 total=0|i=1 total=0|i=2 total=1"
+	if strings.Join(logged, "|") != expected {
+		t.Errorf("Expected %q logged, got=%q", expected, strings.Join(logged, "|"))
+	}
+}
+
+func TestDebuggerLoopVariable(t *testing.T) {
+	d := newTestDebugger(t, "var total = 0;\nfor (var x in [1, 2]) {\n\ttotal = total + x;\n}\n")
+	d.AddBreakpoint(3, "x == 2", "")
+	d.Start(false)
+	expectStop(t, d, StopBreakpoint, 3)
+
+	index := d.Frames()[0].Index
+	if locals := d.Locals(index); len(locals) != 1 || locals[0].Name != "x" || locals[0].Value.Inspect() != "2" {
+		t.Errorf("Expected the loop variable among the locals of main, got=%v", locals)
+	}
+	if value, err := d.Evaluate(index, "x + total"); err != nil || value.Inspect() != "3" {
+		t.Errorf("Expected x + total to be 3, got=%v (%v)", value, err)
+	}
+
+	d.Continue()
+	if stop := d.Wait(); stop.Reason != StopExited || stop.Err != nil {
+		t.Errorf("Expected the program to exit, got=%+v", stop)
+	}
+}
+
+func TestDebuggerEvaluateUndefined(t *testing.T) {
+	d := newTestDebugger(t, debuggerSource)
+	d.AddBreakpoint(3, "", "")
+	d.Start(true)
+	expectStop(t, d, StopEntry, 1)
+
+	index := d.Frames()[0].Index
+	for _, expression := range []string{"total", "add(1, 2)", "[1, next]"} {
+		if _, err := d.Evaluate(index, expression); err == nil || !strings.Contains(err.Error(), "is not defined yet") {
+			t.Errorf("Expected %q to fail before the program defines it, got=%v", expression, err)
+		}
+	}
+	if value, err := d.Evaluate(index, "total = 5"); err != nil || value.Inspect() != "5" {
+		t.Errorf("Expected to assign total, got=%v (%v)", value, err)
+	}
+
+	d.Continue()
+	expectStop(t, d, StopBreakpoint, 3)
+	index = d.Frames()[0].Index
+	if _, err := d.Evaluate(index, "sum"); err == nil || !strings.Contains(err.Error(), "sum is not defined yet") {
+		t.Errorf("Expected sum to have no value before its declaration runs, got=%v", err)
+	}
+	if value, err := d.Evaluate(index, "add(a, b + 2) + total"); err != nil || value.Inspect() != "2" {
+		t.Errorf("Expected add(a, b + 2) + total to be 2, got=%v (%v)", value, err)
+	}
+}
+
+func TestDebuggerException(t *testing.T) {
+	d := newTestDebugger(t, "function fail() {\n\tthrow \"boom\";\n}\nfail();\n")
+	d.Start(false)
+
+	stop := expectStop(t, d, StopException, 2)
+	if stop.Err == nil || !strings.Contains(stop.Err.Error(), "boom") {
+		t.Errorf("Expected the uncaught error, got=%v", stop.Err)
+	}
+	if frames := d.Frames(); len(frames) != 2 || frames[0].Line != 2 || frames[1].Line != 4 {
+		t.Errorf("Expected the frames where it was thrown, got=%+v", frames)
+	}
+
+	d.Continue()
+	if stop := d.Wait(); stop.Reason != StopExited || stop.Err == nil {
+		t.Errorf("Expected the program to exit with the error, got=%+v", stop)
+	}
+}
+
+func TestDebuggerTerminate(t *testing.T) {
+	d := newTestDebugger(t, "var i = 0;\nwhile (true) {\n\ti = i + 1;\n}\n")
+	d.Start(false)
+	d.Pause()
+	if stop := d.Wait(); stop.Reason != StopPause {
+		t.Fatalf("Expected the program to pause, got=%+v", stop)
+	}
+
+	d.Terminate()
+	if stop := d.Wait(); stop.Reason != StopExited || stop.Err != nil {
+		t.Errorf("Expected the program to end, got=%+v", stop)
+	}
+}
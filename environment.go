@@ -1,13 +1,18 @@
 package main
 
+// Environment holds the variables of one scope in slots, in the order
+// they were defined. The resolver works out that order ahead of time, so
+// the interpreter can reach a local by depth and slot instead of by name.
 type Environment struct {
-	store     map[string]Object
+	// slots maps each name defined here to its index in values
+	slots     map[string]int
+	names     []string
+	values    []Object
 	enclosing *Environment
 }
 
 func NewEnvironment() *Environment {
-	s := make(map[string]Object)
-	return &Environment{store: s}
+	return &Environment{slots: make(map[string]int)}
 }
 
 func NewEnclosingEnvironment(enclosing *Environment) *Environment {
@@ -16,37 +21,80 @@ func NewEnclosingEnvironment(enclosing *Environment) *Environment {
 	return env
 }
 
+// Define creates name in this scope, in the next free slot, or overwrites
+// it when it is already here.
 func (e *Environment) Define(name string, value Object) Object {
-	e.store[name] = value
+	if slot, ok := e.slots[name]; ok {
+		e.values[slot] = value
+		return value
+	}
+
+	e.slots[name] = len(e.values)
+	e.names = append(e.names, name)
+	e.values = append(e.values, value)
 	return value
 }
 
-// 1. Check Current Scope: If the variable exists in the current scope, update it.
-// 2. Check Enclosing Scope: If the variable does not exist in the current scope but exists in the enclosing scope, update it in the enclosing scope.
-// 3. Define in Current Scope: If the variable does not exist in any enclosing scope, define it in the current scope.
+// Set assigns to the innermost scope that has name, defining it as a
+// global when no scope does.
 func (e *Environment) Set(name string, value Object) Object {
-	if _, exists := e.store[name]; exists {
-		e.store[name] = value
-		return value
-	}
-	if e.enclosing != nil {
-		if _, exists := e.enclosing.Get(name); exists {
-			return e.enclosing.Set(name, value)
+	for env := e; env != nil; env = env.enclosing {
+		if slot, ok := env.slots[name]; ok {
+			env.values[slot] = value
+			return value
 		}
 	}
-	e.store[name] = value
-	return value
+
+	return e.global().Define(name, value)
 }
 
 func (e *Environment) GetCurrentScope(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	return obj, ok
+	if slot, ok := e.slots[name]; ok {
+		return e.values[slot], true
+	}
+	return nil, false
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.enclosing != nil {
-		obj, ok = e.enclosing.Get(name)
+	for env := e; env != nil; env = env.enclosing {
+		if slot, ok := env.slots[name]; ok {
+			return env.values[slot], true
+		}
+	}
+	return nil, false
+}
+
+// GetAt reads the variable the resolver placed in slot of the scope depth
+// levels out. It reports false when that slot doesn't hold name, which
+// means the resolver and the interpreter disagree about the scope.
+func (e *Environment) GetAt(depth, slot int, name string) (Object, bool) {
+	if env := e.ancestor(depth); env != nil && slot < len(env.names) && env.names[slot] == name {
+		return env.values[slot], true
 	}
-	return obj, ok
+	return nil, false
+}
+
+// SetAt is the assignment counterpart of GetAt.
+func (e *Environment) SetAt(depth, slot int, name string, value Object) bool {
+	if env := e.ancestor(depth); env != nil && slot < len(env.names) && env.names[slot] == name {
+		env.values[slot] = value
+		return true
+	}
+	return false
+}
+
+func (e *Environment) ancestor(depth int) *Environment {
+	env := e
+	for ; depth > 0 && env != nil; depth-- {
+		env = env.enclosing
+	}
+	return env
+}
+
+func (e *Environment) global() *Environment {
+	env := e
+	for env.enclosing != nil {
+		env = env.enclosing
+	}
+	return env
 }
@@ -2,13 +2,29 @@ package main
 
 import "fmt"
 
+// Severity tells errors, which stop a program, from warnings, which are
+// only reported.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
 type Error struct {
-	Token   Token
-	Message string
-	Line    int
+	Token    Token
+	Message  string
+	Line     int
+	Severity Severity
+	// Code names the kind of a warning, for turning it off with a
+	// "// lox:ignore <code>" comment
+	Code string
 }
 
 func (e *Error) Error() string {
+	if e.Severity == SeverityWarning {
+		return fmt.Sprintf("[Line: %d], Token: %s, Warning[%s]: %s\n", e.Line, e.Token.Lexeme, e.Code, e.Message)
+	}
 	return fmt.Sprintf("[Line: %d], Token: %s, Error: %s\n", e.Line, e.Token.Lexeme, e.Message)
 }
 
@@ -27,8 +43,14 @@ func (eh *ErrorHandler) AddError(err *Error) {
 	eh.Errors = append(eh.Errors, err)
 }
 
+// HasErrors reports whether anything but warnings was added.
 func (eh *ErrorHandler) HasErrors() bool {
-	return len(eh.Errors) > 0
+	for _, err := range eh.Errors {
+		if err.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
 }
 
 func (eh *ErrorHandler) PrintErrors() {
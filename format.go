@@ -0,0 +1,867 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatWidth is the line length lox fmt keeps to. A call, parameter list
+// or literal that doesn't fit on its line gets a line for each item.
+const FormatWidth = 80
+
+// formatTabWidth is how much of FormatWidth an indenting tab takes up.
+const formatTabWidth = 4
+
+// Format returns source in canonical form: indented with tabs and spaced
+// and wrapped the same way everywhere, with its comments and blank lines,
+// runs of them shortened to one, kept. Formatting its result again gives
+// the same result.
+func Format(source []byte) ([]byte, error) {
+	scanner := NewScanner(source)
+	scanner.scanTokens()
+	if scanner.Errors().HasErrors() {
+		return nil, scanner.Errors().Errors[0]
+	}
+
+	parser := NewParser(scanner.Tokens())
+	program := parser.parse()
+	if parser.Errors().HasErrors() {
+		return nil, parser.Errors().Errors[0]
+	}
+
+	f := newFormatter(scanner.Tokens())
+	f.statements(program.Statements, len(f.tokens)-1)
+	if f.out.Len() > 0 {
+		f.out.WriteByte('\n')
+	}
+	return f.out.Bytes(), nil
+}
+
+// formatter writes a program out in canonical form. Comments aren't part
+// of the tree, so it keeps its place in the tokens too: a comment is
+// written before the statement that follows it, or at the end of the line
+// when it follows code on its own line.
+type formatter struct {
+	out    bytes.Buffer
+	tokens []*Token
+	// positions finds a token by its line and column
+	positions map[[2]int]int
+	// next is the first token that might be a comment not written yet
+	next   int
+	indent int
+	// opened is set when nothing has been written in the block just
+	// opened, where no blank line goes
+	opened bool
+}
+
+func newFormatter(tokens []*Token) *formatter {
+	f := &formatter{tokens: tokens, positions: make(map[[2]int]int, len(tokens)), opened: true}
+	for idx, token := range tokens {
+		f.positions[[2]int{token.Line, token.Column}] = idx
+	}
+	return f
+}
+
+// position returns the index of token, or -1 for the tokens the parser
+// makes up.
+func (f *formatter) position(token Token) int {
+	if idx, ok := f.positions[[2]int{token.Line, token.Column}]; ok {
+		return idx
+	}
+	return -1
+}
+
+// after returns the index of the first token after idx that isn't a
+// comment.
+func (f *formatter) after(idx int) int {
+	for idx++; idx < len(f.tokens)-1 && f.tokens[idx].Type == COMMENT; idx++ {
+	}
+	return idx
+}
+
+// start returns the index of the token stmt starts with.
+func (f *formatter) start(stmt Statement) int {
+	idx := f.position(nodeToken(stmt))
+	if idx > 0 {
+		switch f.tokens[idx-1].Type {
+		case ASYNC, STATIC:
+			idx--
+		}
+	}
+	return idx
+}
+
+func (f *formatter) write(parts ...string) {
+	for _, part := range parts {
+		f.out.WriteString(part)
+	}
+}
+
+func (f *formatter) newline() {
+	f.out.WriteByte('\n')
+	f.out.WriteString(strings.Repeat("\t", f.indent))
+}
+
+// line starts the line of the token at idx, after a blank line when the
+// source has one there.
+func (f *formatter) line(idx int) {
+	if f.out.Len() == 0 {
+		f.opened = false
+		return
+	}
+	if !f.opened && idx > 0 && f.tokens[idx].Line-f.tokens[idx-1].Line > 1 {
+		f.out.WriteByte('\n')
+	}
+	f.opened = false
+	f.newline()
+}
+
+// column returns how wide the line being written is so far.
+func (f *formatter) column() int {
+	line := f.out.Bytes()
+	if idx := bytes.LastIndexByte(line, '\n'); idx >= 0 {
+		line = line[idx+1:]
+	}
+	return width(line)
+}
+
+func width(line []byte) int {
+	tabs := bytes.Count(line, []byte("\t"))
+	return utf8.RuneCount(line) - tabs + tabs*formatTabWidth
+}
+
+// statements writes stmts a line each, along with the comments before
+// them and, last, those before the token at end.
+func (f *formatter) statements(stmts []Statement, end int) {
+	for idx, stmt := range stmts {
+		start := f.start(stmt)
+		f.comments(start)
+		f.line(start)
+		f.statement(stmt)
+
+		limit := end
+		if idx+1 < len(stmts) {
+			limit = f.start(stmts[idx+1])
+		}
+		f.trailing(limit)
+	}
+	f.comments(end)
+}
+
+// comments writes the comments before the token at limit on lines of
+// their own.
+func (f *formatter) comments(limit int) {
+	for ; f.next < limit; f.next++ {
+		if token := f.tokens[f.next]; token.Type == COMMENT {
+			f.line(f.next)
+			f.write(strings.TrimRight(token.Lexeme, " \t\r"))
+		}
+	}
+}
+
+// trailing writes the first comment before the token at limit at the end
+// of the line when the comment follows code on its line in the source.
+func (f *formatter) trailing(limit int) {
+	for idx := f.next; idx < limit; idx++ {
+		token := f.tokens[idx]
+		if token.Type != COMMENT {
+			continue
+		}
+		if previous := f.tokens[idx-1]; previous.Type != COMMENT && previous.Line == token.Line {
+			f.write(" ", strings.TrimRight(token.Lexeme, " \t\r"))
+			f.next = idx + 1
+		}
+		return
+	}
+}
+
+// braces writes stmts as a block closed by end.
+func (f *formatter) braces(stmts []Statement, end Token) {
+	f.write(LEFT_BRACKET)
+	mark := f.out.Len()
+
+	f.indent++
+	f.opened = true
+	f.statements(stmts, f.position(end))
+	f.indent--
+
+	if f.out.Len() > mark {
+		f.newline()
+	}
+	f.opened = false
+	f.write(RIGHT_BRACKET)
+}
+
+func (f *formatter) block(block *BlockStatement) {
+	f.braces(block.Statements, block.End)
+}
+
+// list writes items between open and close on one line if they fit, or
+// else a line each. Only the last item may spread over lines and still
+// share the line, as a function literal passed last does.
+func (f *formatter) list(open, close string, items []func()) {
+	if len(items) == 0 {
+		f.write(open, close)
+		return
+	}
+
+	mark, next, column := f.out.Len(), f.next, f.column()
+	last := mark
+	f.write(open)
+	for idx, item := range items {
+		if idx > 0 {
+			f.write(COMMA, " ")
+		}
+		if idx == len(items)-1 {
+			last = f.out.Len()
+		}
+		item()
+	}
+	f.write(close)
+
+	written := f.out.Bytes()[mark:]
+	first := written
+	if idx := bytes.IndexByte(written, '\n'); idx >= 0 {
+		first = written[:idx]
+	}
+	if bytes.IndexByte(written[:last-mark], '\n') < 0 && column+width(first) <= FormatWidth {
+		return
+	}
+
+	f.out.Truncate(mark)
+	f.next = next
+	f.write(open)
+	f.indent++
+	for idx, item := range items {
+		f.newline()
+		item()
+		if idx < len(items)-1 {
+			f.write(COMMA)
+		}
+	}
+	f.indent--
+	f.newline()
+	f.write(close)
+}
+
+func (f *formatter) expressions(exprs []Expression) []func() {
+	var items []func()
+	for _, expr := range exprs {
+		expr := expr
+		items = append(items, func() { f.expr(expr) })
+	}
+	return items
+}
+
+func (f *formatter) statement(stmt Statement) {
+	switch stmt := stmt.(type) {
+	case *ExpressionStatement:
+		f.expr(stmt.Expression)
+		// a match statement ends with its closing brace
+		if _, ok := stmt.Expression.(*MatchExpression); !ok {
+			f.write(SEMICOLON)
+		}
+	case *VarStatement:
+		f.write(stmt.Token.Lexeme, " ", stmt.Identifier.Value)
+		// `var x;` is given a nil literal of no token
+		if literal, ok := stmt.Expression.(*NilLiteral); !ok || literal.Token.Type == NIL {
+			f.write(" = ")
+			f.expr(stmt.Expression)
+		}
+		f.write(SEMICOLON)
+	case *DestructureStatement:
+		f.write(stmt.Token.Lexeme, " ")
+		f.destructure(stmt.Pattern)
+		f.write(" = ")
+		f.expr(stmt.Value)
+		f.write(SEMICOLON)
+	case *FunctionDeclaration:
+		f.function(&stmt.FunctionCommon)
+	case *ClassStatement:
+		f.class(stmt)
+	case *MethodDeclaration:
+		if stmt.IsStatic {
+			f.write("static ")
+		}
+		if stmt.IsGenerator {
+			f.write(STAR)
+		}
+		f.write(stmt.Name.Value)
+		if stmt.IsGetter {
+			f.write(" ")
+			f.block(stmt.Body)
+			return
+		}
+		body := f.params(&stmt.FunctionCommon)
+		f.write(" ")
+		f.braces(body, stmt.Body.End)
+	case *IfStatement:
+		f.write(stmt.Token.Lexeme, " (")
+		f.expr(stmt.Condition)
+		f.write(") ")
+		f.block(stmt.ThenBranch)
+		if stmt.ElseBranch != nil {
+			f.write(" else ")
+			f.block(stmt.ElseBranch)
+		}
+	case *While:
+		f.write(stmt.Token.Lexeme, " (")
+		f.expr(stmt.Condition)
+		f.write(") ")
+		f.block(stmt.Body)
+	case *For:
+		f.write(stmt.Token.Lexeme, " (")
+		if stmt.Initializer != nil {
+			f.statement(stmt.Initializer)
+		} else {
+			f.write(SEMICOLON)
+		}
+		if stmt.Condition != nil {
+			f.write(" ")
+			f.expr(stmt.Condition)
+		}
+		f.write(SEMICOLON)
+		if stmt.Increment != nil {
+			f.write(" ")
+			f.expr(stmt.Increment)
+		}
+		f.write(") ")
+		f.block(stmt.Body)
+	case *ForInStatement:
+		f.write(stmt.Token.Lexeme, " (", stmt.Declaration.Lexeme, " ")
+		if stmt.Key != nil {
+			f.write(stmt.Key.Value, COMMA, " ")
+		}
+		f.write(stmt.Value.Value, " in ")
+		f.expr(stmt.Iterable)
+		f.write(") ")
+		f.block(stmt.Body)
+	case *ReturnStatement:
+		f.write(stmt.Token.Lexeme)
+		if stmt.ReturnValue != nil {
+			f.write(" ")
+			f.expr(stmt.ReturnValue)
+		}
+		f.write(SEMICOLON)
+	case *BreakStatement:
+		f.write(stmt.Token.Lexeme, SEMICOLON)
+	case *ContinueStatement:
+		f.write(stmt.Token.Lexeme, SEMICOLON)
+	case *ThrowStatement:
+		f.write(stmt.Token.Lexeme, " ")
+		f.expr(stmt.Value)
+		f.write(SEMICOLON)
+	case *TryStatement:
+		f.write(stmt.Token.Lexeme, " ")
+		f.block(stmt.Body)
+		if stmt.Catch != nil {
+			f.write(" catch ")
+			if stmt.CatchName != nil {
+				f.write("(", stmt.CatchName.Value, ") ")
+			}
+			f.block(stmt.Catch)
+		}
+		if stmt.Finally != nil {
+			f.write(" finally ")
+			f.block(stmt.Finally)
+		}
+	case *SelectStatement:
+		f.selectStatement(stmt)
+	case *ImportStatement:
+		f.write(stmt.Token.Lexeme, " ")
+		if stmt.Alias != nil {
+			f.write(`"`, stmt.Path, `" as `, stmt.Alias.Value, SEMICOLON)
+			return
+		}
+		var names []string
+		for _, name := range stmt.Names {
+			names = append(names, name.Value)
+		}
+		f.write(LEFT_BRACKET, strings.Join(names, COMMA+" "), RIGHT_BRACKET, ` from "`, stmt.Path, `"`, SEMICOLON)
+	case *ExportStatement:
+		f.write(stmt.Token.Lexeme, " ")
+		f.statement(stmt.Declaration)
+	case *TestStatement:
+		f.write(stmt.Token.Lexeme, ` "`, stmt.Name, `" `)
+		f.block(stmt.Body)
+	}
+}
+
+func (f *formatter) function(fun *FunctionCommon) {
+	if fun.IsAsync {
+		f.write("async ")
+	}
+	f.write(fun.Token.Lexeme)
+	if fun.IsGenerator {
+		f.write(STAR)
+	}
+	if fun.Name != nil {
+		f.write(" ", fun.Name.Value)
+	}
+	body := f.params(fun)
+	f.write(" ")
+	f.braces(body, fun.Body.End)
+}
+
+// params writes the parameter list of fun and returns the statements of
+// its body. The declarations the parser puts at the start of the body for
+// destructured parameters are left out, their patterns are written as
+// the parameters.
+func (f *formatter) params(fun *FunctionCommon) []Statement {
+	body := fun.Body.Statements
+	patterns := map[Expression]Destructure{}
+	for _, param := range fun.Params {
+		if len(body) == 0 {
+			break
+		}
+		if prologue, ok := body[0].(*DestructureStatement); ok && prologue.Value == Expression(param) {
+			patterns[param] = prologue.Pattern
+			body = body[1:]
+		}
+	}
+
+	var items []func()
+	for idx, param := range fun.Params {
+		param := param
+		var value Expression
+		if idx < len(fun.Defaults) {
+			value = fun.Defaults[idx]
+		}
+		items = append(items, func() {
+			if pattern, ok := patterns[param]; ok {
+				f.destructure(pattern)
+			} else {
+				f.write(param.Value)
+			}
+			if value != nil {
+				f.write(" = ")
+				f.expr(value)
+			}
+		})
+	}
+	if fun.Rest != nil {
+		items = append(items, func() { f.write(ELLIPSIS, fun.Rest.Value) })
+	}
+	f.list(LEFT_PAREN, RIGHT_PAREN, items)
+
+	return body
+}
+
+func (f *formatter) class(class *ClassStatement) {
+	f.write(class.Token.Lexeme, " ", class.Name.Value)
+	name := class.Name
+	if class.SuperClass != nil {
+		f.write(" extends ", class.SuperClass.Value)
+		name = class.SuperClass
+	}
+	f.write(" ")
+
+	// the class keeps no token for its closing brace, it is the one after
+	// the last method or after the opening brace
+	end := f.after(f.after(f.position(name.Token)))
+	var methods []Statement
+	for _, method := range class.Methods {
+		methods = append(methods, method)
+	}
+	if len(class.Methods) > 0 {
+		end = f.after(f.position(class.Methods[len(class.Methods)-1].Body.End))
+	}
+	f.braces(methods, *f.tokens[end])
+}
+
+func (f *formatter) selectStatement(stmt *SelectStatement) {
+	f.write(stmt.Token.Lexeme, " ", LEFT_BRACKET)
+	f.indent++
+	for _, c := range stmt.Cases {
+		f.newline()
+		if c.Name != nil {
+			f.write("var ", c.Name.Value, " = ")
+		}
+		f.expr(c.Channel)
+		if c.Value != nil {
+			f.write(".", ChannelSendMethod, LEFT_PAREN)
+			f.expr(c.Value)
+			f.write(RIGHT_PAREN)
+		} else {
+			f.write(".", ChannelRecvMethod, LEFT_PAREN, RIGHT_PAREN)
+		}
+		f.write(" => ")
+		f.block(c.Body)
+	}
+	if stmt.Default != nil {
+		f.newline()
+		f.write("_ => ")
+		f.block(stmt.Default)
+	}
+	f.indent--
+	f.newline()
+	f.write(RIGHT_BRACKET)
+}
+
+func (f *formatter) expr(expr Expression) {
+	switch expr := expr.(type) {
+	case *Identifier:
+		f.write(expr.Value)
+	case *NumberLiteral:
+		// the lexeme keeps the number as it was written
+		if expr.Token.Lexeme != "" {
+			f.write(expr.Token.Lexeme)
+		} else {
+			f.write(expr.String())
+		}
+	case *StringLiteral:
+		f.write(`"`, expr.Value, `"`)
+	case *BooleanLiteral, *NilLiteral, *This, *Super:
+		f.write(expr.String())
+	case *InterpolationExpression:
+		f.write(`"`)
+		for _, part := range expr.Parts {
+			if literal, ok := part.(*StringLiteral); ok {
+				f.write(literal.Value)
+				continue
+			}
+			f.write("${")
+			f.expr(part)
+			f.write("}")
+		}
+		f.write(`"`)
+	case *GroupedExpression:
+		f.write(LEFT_PAREN)
+		f.expr(expr.Expression)
+		f.write(RIGHT_PAREN)
+	case *Unary:
+		f.write(expr.Operator)
+		// - -x, not --x
+		if expr.Operator == MINUS && startsWithMinus(expr.Right) {
+			f.write(" ")
+		}
+		f.expr(expr.Right)
+	case *Binary:
+		f.expr(expr.Left)
+		f.write(" ", expr.Operator, " ")
+		f.expr(expr.Right)
+	case *Logical:
+		f.expr(expr.Left)
+		f.write(" ", expr.Operator, " ")
+		f.expr(expr.Right)
+	case *TernaryExpression:
+		f.expr(expr.Condition)
+		f.write(" ? ")
+		f.expr(expr.ThenBranch)
+		f.write(" : ")
+		f.expr(expr.ElseBranch)
+	case *Assignment:
+		f.write(expr.Identifier.Value, " = ")
+		f.expr(expr.Expression)
+	case *CompoundAssignment:
+		f.expr(expr.Target)
+		f.write(" ", expr.Operator, "= ")
+		f.expr(expr.Value)
+	case *UpdateExpression:
+		if expr.Prefix {
+			f.write(expr.Operator)
+			f.expr(expr.Target)
+		} else {
+			f.expr(expr.Target)
+			f.write(expr.Operator)
+		}
+	case *DestructureAssignment:
+		f.destructure(expr.Pattern)
+		f.write(" = ")
+		f.expr(expr.Value)
+	case *SetExpression:
+		f.expr(expr.Object)
+		f.write(".", expr.Property.Value, " = ")
+		f.expr(expr.Value)
+	case *SetIndexExpression:
+		f.index(expr.Left, expr.Index)
+		f.write(" = ")
+		f.expr(expr.Value)
+	case *SetSliceExpression:
+		f.slice(expr.Left, expr.Start, expr.End)
+		f.write(" = ")
+		f.expr(expr.Value)
+	case *GetExpression:
+		f.expr(expr.Object)
+		f.write(".", expr.Property.Value)
+	case *IndexExpression:
+		f.index(expr.Left, expr.Index)
+	case *SliceExpression:
+		f.slice(expr.Left, expr.Start, expr.End)
+	case *CallExpression:
+		f.expr(expr.Callee)
+		items := f.expressions(expr.Arguments)
+		for _, named := range expr.Named {
+			named := named
+			items = append(items, func() {
+				f.write(named.Name.Value, COLON, " ")
+				f.expr(named.Value)
+			})
+		}
+		f.list(LEFT_PAREN, RIGHT_PAREN, items)
+	case *SpreadExpression:
+		f.write(ELLIPSIS)
+		f.expr(expr.Value)
+	case *RangeExpression:
+		f.expr(expr.Start)
+		f.write(expr.Token.Lexeme)
+		f.expr(expr.End)
+		if expr.Step != nil {
+			f.write(" step ")
+			f.expr(expr.Step)
+		}
+	case *ArrayLiteral:
+		f.list(LEFT_BRACE, RIGHT_BRACE, f.expressions(expr.Elements))
+	case *HashLiteral:
+		var items []func()
+		for _, key := range expr.Keys {
+			key := key
+			items = append(items, func() {
+				f.expr(key)
+				f.write(COLON, " ")
+				f.expr(expr.Pairs[key])
+			})
+		}
+		f.list(LEFT_BRACKET, RIGHT_BRACKET, items)
+	case *FunctionLiteral:
+		f.function(&expr.FunctionCommon)
+	case *SpawnExpression:
+		f.write(expr.Token.Lexeme, " ")
+		f.expr(expr.Call)
+	case *AwaitExpression:
+		f.write(expr.Token.Lexeme, " ")
+		f.expr(expr.Value)
+	case *YieldExpression:
+		f.write(expr.Token.Lexeme)
+		if expr.Value != nil {
+			f.write(" ")
+			f.expr(expr.Value)
+		}
+	case *MatchExpression:
+		f.match(expr)
+	}
+}
+
+func startsWithMinus(expr Expression) bool {
+	switch expr := expr.(type) {
+	case *Unary:
+		return expr.Operator == MINUS
+	case *UpdateExpression:
+		return expr.Prefix && expr.Operator == MINUS_MINUS
+	}
+	return false
+}
+
+func (f *formatter) index(left, index Expression) {
+	f.expr(left)
+	f.write(LEFT_BRACE)
+	f.expr(index)
+	f.write(RIGHT_BRACE)
+}
+
+func (f *formatter) slice(left, start, end Expression) {
+	f.expr(left)
+	f.write(LEFT_BRACE)
+	if start != nil {
+		f.expr(start)
+	}
+	f.write(COLON)
+	if end != nil {
+		f.expr(end)
+	}
+	f.write(RIGHT_BRACE)
+}
+
+func (f *formatter) destructure(pattern Destructure) {
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		f.write(LEFT_BRACE)
+		for idx, element := range pattern.Elements {
+			if idx > 0 {
+				f.write(COMMA, " ")
+			}
+			f.destructureElement(element)
+		}
+		if pattern.Rest != nil {
+			if len(pattern.Elements) > 0 {
+				f.write(COMMA, " ")
+			}
+			f.write(ELLIPSIS)
+			f.expr(pattern.Rest)
+		}
+		f.write(RIGHT_BRACE)
+	case *HashDestructure:
+		f.write(LEFT_BRACKET)
+		for idx, entry := range pattern.Entries {
+			if idx > 0 {
+				f.write(COMMA, " ")
+			}
+			f.destructureElement(entry)
+		}
+		f.write(RIGHT_BRACKET)
+	}
+}
+
+func (f *formatter) destructureElement(element *DestructureElement) {
+	// {key} is short for {key: key}
+	if target, ok := element.Target.(*Identifier); !ok || element.Key != target.Value {
+		if element.Key != "" {
+			f.write(element.Key, COLON, " ")
+		}
+	}
+	if element.Pattern != nil {
+		f.destructure(element.Pattern)
+	} else {
+		f.expr(element.Target)
+	}
+	if element.Default != nil {
+		f.write(" = ")
+		f.expr(element.Default)
+	}
+}
+
+func (f *formatter) match(match *MatchExpression) {
+	f.write(match.Token.Lexeme, " (")
+	f.expr(match.Subject)
+	f.write(") ", LEFT_BRACKET)
+	f.indent++
+	for _, arm := range match.Arms {
+		f.newline()
+		for idx, pattern := range arm.Patterns {
+			if idx > 0 {
+				f.write(COMMA, " ")
+			}
+			f.pattern(pattern)
+		}
+		if arm.Guard != nil {
+			f.write(" if ")
+			f.expr(arm.Guard)
+		}
+		f.write(" => ")
+		if arm.Block != nil {
+			f.block(arm.Block)
+			continue
+		}
+		f.expr(arm.Body)
+		f.write(COMMA)
+	}
+	f.indent--
+	f.newline()
+	f.write(RIGHT_BRACKET)
+}
+
+func (f *formatter) pattern(pattern Pattern) {
+	switch pattern := pattern.(type) {
+	case *LiteralPattern:
+		f.expr(pattern.Value)
+	case *WildcardPattern:
+		f.write("_")
+	case *BindingPattern:
+		f.write(pattern.Name.Value)
+	case *ArrayPattern:
+		f.write(LEFT_BRACE)
+		for idx, element := range pattern.Elements {
+			if idx > 0 {
+				f.write(COMMA, " ")
+			}
+			f.pattern(element)
+		}
+		f.write(RIGHT_BRACE)
+	case *HashPattern:
+		f.write(LEFT_BRACKET)
+		for idx, key := range pattern.Keys {
+			if idx > 0 {
+				f.write(COMMA, " ")
+			}
+			f.expr(key)
+			f.write(COLON, " ")
+			f.pattern(pattern.Values[idx])
+		}
+		f.write(RIGHT_BRACKET)
+	case *ClassPattern:
+		f.write(pattern.Class.Value, LEFT_PAREN)
+		for idx, field := range pattern.Fields {
+			if idx > 0 {
+				f.write(COMMA, " ")
+			}
+			f.pattern(field)
+		}
+		f.write(RIGHT_PAREN)
+	}
+}
+
+// runFmt is lox fmt. It prints the formatted files, or with -w writes
+// them back. With -check it lists the files that aren't formatted and
+// exits with 1 if there are any. It exits with 2 when a file can't be
+// read or parsed.
+func runFmt(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	check := flags.Bool("check", false, "list the files that aren't formatted instead of formatting them")
+	write := flags.Bool("w", false, "write the result to the files instead of printing it")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: jlox fmt [flags] [path ...]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"./..."}
+	}
+	files, err := loxFiles(paths)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	code := 0
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			code = 2
+			continue
+		}
+
+		formatted, err := Format(source)
+		if err != nil {
+			if syntaxErr, ok := err.(*Error); ok {
+				fmt.Fprintf(stderr, "%s:%d: %s\n", file, syntaxErr.Line, syntaxErr.Message)
+			} else {
+				fmt.Fprintf(stderr, "%s: %s\n", file, err)
+			}
+			code = 2
+			continue
+		}
+
+		switch {
+		case *check:
+			if !bytes.Equal(source, formatted) {
+				fmt.Fprintln(stdout, file)
+				if code == 0 {
+					code = 1
+				}
+			}
+		case *write:
+			if bytes.Equal(source, formatted) {
+				continue
+			}
+			if err := os.WriteFile(file, formatted, 0644); err != nil {
+				fmt.Fprintln(stderr, err)
+				code = 2
+			}
+		default:
+			stdout.Write(formatted)
+		}
+	}
+
+	return code
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// parseSource parses source, reporting whether it is a program. Not every
+// string in the tests is, and the parser may panic on those.
+func parseSource(source []byte) (program *Program, ok bool) {
+	defer func() {
+		if recover() != nil {
+			program, ok = nil, false
+		}
+	}()
+
+	scanner := NewScanner(source)
+	scanner.scanTokens()
+	if scanner.Errors().HasErrors() {
+		return nil, false
+	}
+	parser := NewParser(scanner.Tokens())
+	program = parser.parse()
+	return program, !parser.Errors().HasErrors() && len(program.Statements) > 0
+}
+
+// TestFormatParserInputs formats every string in the parser tests that is
+// a program, checking that the result parses to the same tree and stays
+// the same when formatted again.
+func TestFormatParserInputs(t *testing.T) {
+	file, err := parser.ParseFile(gotoken.NewFileSet(), "parser_test.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := 0
+	ast.Inspect(file, func(node ast.Node) bool {
+		literal, ok := node.(*ast.BasicLit)
+		if !ok || literal.Kind != gotoken.STRING {
+			return true
+		}
+		input, err := strconv.Unquote(literal.Value)
+		if err != nil {
+			return true
+		}
+		program, ok := parseSource([]byte(input))
+		if !ok {
+			return true
+		}
+		inputs++
+
+		formatted, err := Format([]byte(input))
+		if err != nil {
+			t.Errorf("Format(%q) failed: %s", input, err)
+			return true
+		}
+		reparsed, ok := parseSource(formatted)
+		if !ok {
+			t.Errorf("Formatting %q gave %q, which doesn't parse", input, formatted)
+			return true
+		}
+		if reparsed.String() != program.String() {
+			t.Errorf("Formatting %q changed its tree: expected=%s, got=%s", input, program, reparsed)
+		}
+		again, err := Format(formatted)
+		if err != nil || !bytes.Equal(again, formatted) {
+			t.Errorf("Formatting %q isn't idempotent: %q, then %q", input, formatted, again)
+		}
+		return true
+	})
+
+	if inputs < 50 {
+		t.Errorf("Expected the parser tests to have at least 50 inputs, found %d", inputs)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var   x=1;var y;", "var x = 1;\nvar y;\n"},
+		{"if (x) { print(x); } else { }", "if (x) {\n\tprint(x);\n} else {}\n"},
+		{"function f([a, b], c = 1, ...rest) { return a; }", "function f([a, b], c = 1, ...rest) {\n\treturn a;\n}\n"},
+		{`var h = {"b": 1, "a": 2,};`, "var h = {\"b\": 1, \"a\": 2};\n"},
+		{"x = - -1; y = 1.50;", "x = - -1;\ny = 1.50;\n"},
+		{"match (x) { 1, 2 => \"a\" _ => { print(x); } };", "match (x) {\n\t1, 2 => \"a\",\n\t_ => {\n\t\tprint(x);\n\t}\n}\n"},
+		{"class A { get { return 1; } static make() { return A(); } }", "class A {\n\tget {\n\t\treturn 1;\n\t}\n\tstatic make() {\n\t\treturn A();\n\t}\n}\n"},
+		{
+			"var total = add(firstArgument, secondArgument, thirdArgument, fourthArgument, fifth);",
+			"var total = add(\n\tfirstArgument,\n\tsecondArgument,\n\tthirdArgument,\n\tfourthArgument,\n\tfifth\n);\n",
+		},
+		{"each(items, function(item) { print(item); });", "each(items, function(item) {\n\tprint(item);\n});\n"},
+	}
+
+	for _, test := range tests {
+		formatted, err := Format([]byte(test.input))
+		if err != nil {
+			t.Errorf("Format(%q) failed: %s", test.input, err)
+			continue
+		}
+		if string(formatted) != test.expected {
+			t.Errorf("Expected %q for %q, got=%q", test.expected, test.input, formatted)
+		}
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	input := "// header\n\n\n\nvar x = 1; // trailing\n\n// before f\nfunction f() {\n  // inside\n  return x; // returned\n  // at the end\n}\nclass A {\n  // no methods\n}\n"
+	expected := "// header\n\nvar x = 1; // trailing\n\n// before f\nfunction f() {\n\t// inside\n\treturn x; // returned\n\t// at the end\n}\nclass A {\n\t// no methods\n}\n"
+
+	formatted, err := Format([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(formatted) != expected {
+		t.Errorf("Expected %q, got=%q", expected, formatted)
+	}
+}
+
+func TestRunFmt(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ugly.lox":   "var x=1;\n",
+		"pretty.lox": "var x = 1;\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runFmt([]string{"-check", dir}, &stdout, &stderr); code != 1 {
+		t.Fatalf("Expected exit code 1, got=%d (%s)", code, stderr.String())
+	}
+	if stdout.String() != filepath.Join(dir, "ugly.lox")+"\n" {
+		t.Errorf("Expected ugly.lox to be listed, got=%q", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := runFmt([]string{"-w", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got=%d (%s)", code, stderr.String())
+	}
+	if code := runFmt([]string{"-check", dir}, &stdout, &stderr); code != 0 || stdout.Len() != 0 {
+		t.Errorf("Expected every file to be formatted, got=%d %q", code, stdout.String())
+	}
+
+	os.WriteFile(filepath.Join(dir, "broken.lox"), []byte("var = ;"), 0644)
+	if code := runFmt([]string{"-check", dir}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected exit code 2 for a file that doesn't parse, got=%d", code)
+	}
+}
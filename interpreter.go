@@ -1,6 +1,11 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+)
 
 type ContextType int
 
@@ -28,16 +33,31 @@ const (
 	notClassError           = "not a class error"
 	notInstanceError        = "not an instance of a class"
 	redeclare               = "variable redeclaration"
+	indexOutOfRange         = "index out of range"
+	notIterableError        = "not iterable"
+	noMatchError            = "no match arm"
+	destructureError        = "cannot destructure"
+	vmOnlyError             = "only supported by the VM"
+	resolveError            = "resolve error"
+	internalError           = "internal error"
 )
 
 var (
 	Null  = &NilObject{}
 	True  = &BooleanObject{Value: true}
 	False = &BooleanObject{Value: false}
+
+	// errGeneratorClosed unwinds the body of a closed generator from its
+	// yield; try statements don't catch it
+	errGeneratorClosed = &ErrorObject{Message: "generator closed"}
 )
 
 var builtins = map[string]*Builtin{
 	BuiltinFuncNamePrint: GetBuiltinByName(BuiltinFuncNamePrint),
+	BuiltinFuncNameLen:   GetBuiltinByName(BuiltinFuncNameLen),
+	// the prelude's assertThrows needs assert
+	BuiltinFuncNameAssert:   GetBuiltinByName(BuiltinFuncNameAssert),
+	BuiltinFuncNameAssertEq: GetBuiltinByName(BuiltinFuncNameAssertEq),
 }
 
 // java way is terrible but whatever :)
@@ -47,23 +67,39 @@ type Visitor interface {
 	VisitBinary(node *Binary, env *Environment) Object
 	VisitUnary(node *Unary, env *Environment) Object
 	VisitStringLiteral(node *StringLiteral, env *Environment) Object
+	VisitInterpolationExpression(node *InterpolationExpression, env *Environment) Object
 	VisitNumberLiteral(node *NumberLiteral, env *Environment) Object
 	VisitBooleanLiteral(node *BooleanLiteral, env *Environment) Object
 	VisitNilLiteral(node *NilLiteral, env *Environment) Object
 	VisitGroupedExpression(node *GroupedExpression, env *Environment) Object
 	VisitAssignment(node *Assignment, env *Environment) Object
+	VisitDestructureAssignment(node *DestructureAssignment, env *Environment) Object
+	VisitCompoundAssignment(node *CompoundAssignment, env *Environment) Object
+	VisitUpdateExpression(node *UpdateExpression, env *Environment) Object
 	VisitTernaryExpression(node *TernaryExpression, env *Environment) Object
 	VisitBlockStatement(node *BlockStatement, env *Environment) Object
 	VisitExpressionStatement(node *ExpressionStatement, env *Environment) Object
 	VisitReturnStatement(node *ReturnStatement, env *Environment) Object
+	VisitYieldExpression(node *YieldExpression, env *Environment) Object
+	VisitThrowStatement(node *ThrowStatement, env *Environment) Object
+	VisitTryStatement(node *TryStatement, env *Environment) Object
+	VisitSpawnExpression(node *SpawnExpression, env *Environment) Object
+	VisitAwaitExpression(node *AwaitExpression, env *Environment) Object
+	VisitSelectStatement(node *SelectStatement, env *Environment) Object
+	VisitImportStatement(node *ImportStatement, env *Environment) Object
+	VisitExportStatement(node *ExportStatement, env *Environment) Object
+	VisitTestStatement(node *TestStatement, env *Environment) Object
 	VisitVarStatement(node *VarStatement, env *Environment) Object
+	VisitDestructureStatement(node *DestructureStatement, env *Environment) Object
 	VisitIfStatement(node *IfStatement, env *Environment) Object
 	VisitLogical(node *Logical, env *Environment) Object
 	VisitWhileStatement(node *While, env *Environment) Object
 	VisitForStatement(node *For, env *Environment) Object
+	VisitForInStatement(node *ForInStatement, env *Environment) Object
 	VisitBreakStatement(node *BreakStatement, env *Environment) Object
 	VisitContinueStatement(node *ContinueStatement, env *Environment) Object
 	VisitCallExpression(node *CallExpression, env *Environment) Object
+	VisitSpreadExpression(node *SpreadExpression, env *Environment) Object
 	VisitFunctionLiteral(node *FunctionLiteral, env *Environment) Object
 	VisitFunctionDeclaration(node *FunctionDeclaration, env *Environment) Object
 	VisitMethodDeclaration(node *MethodDeclaration, env *Environment) Object
@@ -74,16 +110,30 @@ type Visitor interface {
 	VisitSuper(node *Super, env *Environment) Object
 	VisitArrayLiteral(node *ArrayLiteral, env *Environment) Object
 	VisitIndexExpression(node *IndexExpression, env *Environment) Object
+	VisitRangeExpression(node *RangeExpression, env *Environment) Object
+	VisitMatchExpression(node *MatchExpression, env *Environment) Object
+	VisitSliceExpression(node *SliceExpression, env *Environment) Object
+	VisitSetIndexExpression(node *SetIndexExpression, env *Environment) Object
+	VisitSetSliceExpression(node *SetSliceExpression, env *Environment) Object
 	VisitHashLiteral(node *HashLiteral, env *Environment) Object
 }
 
 type Interpreter struct {
 	contexts []Context
+	// frames names the functions being called, for stack traces
+	frames []string
+	// generator is the state of the generator whose body this interpreter
+	// runs, if any
+	generator *generatorState
+	// locals has where the resolver placed each local variable use;
+	// anything else is a global
+	locals  map[Expression]Location
+	globals *Environment
 }
 
 func NewInterpreter() *Interpreter {
 	contexts := make([]Context, 0)
-	interpreter := &Interpreter{contexts}
+	interpreter := &Interpreter{contexts: contexts, locals: make(map[Expression]Location)}
 	interpreter.pushContext(MainContext)
 	return interpreter
 }
@@ -105,6 +155,17 @@ func (i *Interpreter) currentContext() *Context {
 	return nil
 }
 
+// functionContext is the innermost context that is not a loop, so a return
+// or this inside a loop still sees the function or method around it
+func (i *Interpreter) functionContext() *Context {
+	for index := len(i.contexts) - 1; index >= 0; index-- {
+		if i.contexts[index].Type != LoopContext {
+			return &i.contexts[index]
+		}
+	}
+	return &Context{Type: MainContext}
+}
+
 func (i *Interpreter) nativeToBooleanObject(input bool) Object {
 	if input {
 		return True
@@ -157,7 +218,24 @@ func (i *Interpreter) isTruthy(value Object) bool {
 
 func (i *Interpreter) newError(format string, args ...interface{}) Object {
 	msg := fmt.Sprintf(format, args...)
-	return &ErrorObject{msg}
+	return &ErrorObject{Message: msg, Stack: i.stackTrace()}
+}
+
+func (i *Interpreter) pushFrame(name string) {
+	i.frames = append(i.frames, name)
+}
+
+func (i *Interpreter) popFrame() {
+	i.frames = i.frames[:len(i.frames)-1]
+}
+
+// stackTrace lists the active calls, innermost first.
+func (i *Interpreter) stackTrace() []string {
+	stack := make([]string, 0, len(i.frames)+1)
+	for idx := len(i.frames) - 1; idx >= 0; idx-- {
+		stack = append(stack, i.frames[idx])
+	}
+	return append(stack, "main")
 }
 
 func (i *Interpreter) VisitIndexExpression(node *IndexExpression, env *Environment) Object {
@@ -174,6 +252,238 @@ func (i *Interpreter) VisitIndexExpression(node *IndexExpression, env *Environme
 	return i.evalIndexExpression(left, index)
 }
 
+func (i *Interpreter) VisitRangeExpression(node *RangeExpression, env *Environment) Object {
+	start := node.Start.Accept(i, env)
+	if i.isError(start) {
+		return start
+	}
+
+	end := node.End.Accept(i, env)
+	if i.isError(end) {
+		return end
+	}
+
+	var step Object
+	if node.Step != nil {
+		step = node.Step.Accept(i, env)
+		if i.isError(step) {
+			return step
+		}
+	}
+
+	rng, err := NewRange(start, end, step, node.Inclusive)
+	if err != nil {
+		return i.newError("%s: %s", typeMissMatchError, err)
+	}
+
+	return rng
+}
+
+func (i *Interpreter) VisitMatchExpression(node *MatchExpression, env *Environment) Object {
+	subject := node.Subject.Accept(i, env)
+	if i.isError(subject) {
+		return subject
+	}
+
+	for _, arm := range node.Arms {
+		// the bindings of every alternative take the slots the resolver
+		// gave them up front, whichever alternative matches
+		armEnv := NewEnclosingEnvironment(env)
+		for _, pattern := range arm.Patterns {
+			for _, name := range patternBindings(pattern) {
+				armEnv.Define(name, Null)
+			}
+		}
+
+		matched, err := i.matchArm(arm, subject, armEnv)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		if arm.Block != nil {
+			result := i.executeBlock(arm.Block.Statements, armEnv)
+			if i.isBreak(result) || i.isReturn(result) || i.isError(result) || i.isContinue(result) {
+				return result
+			}
+			return Null
+		}
+
+		return arm.Body.Accept(i, armEnv)
+	}
+
+	return i.newError("%s: %s", noMatchError, subject.Inspect())
+}
+
+// matchArm reports whether any of the arm's patterns matches subject and its
+// guard holds, binding pattern variables in env.
+func (i *Interpreter) matchArm(arm *MatchArm, subject Object, env *Environment) (bool, Object) {
+	for _, pattern := range arm.Patterns {
+		matched, err := i.matchPattern(pattern, subject, env)
+		if err != nil {
+			return false, err
+		}
+		if !matched {
+			continue
+		}
+
+		if arm.Guard == nil {
+			return true, nil
+		}
+
+		guard := arm.Guard.Accept(i, env)
+		if i.isError(guard) {
+			return false, guard
+		}
+		if i.isTruthy(guard) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (i *Interpreter) matchPattern(pattern Pattern, value Object, env *Environment) (bool, Object) {
+	switch pattern := pattern.(type) {
+	case *WildcardPattern:
+		return true, nil
+	case *BindingPattern:
+		env.Define(pattern.Name.Value, value)
+		return true, nil
+	case *LiteralPattern:
+		literal := pattern.Value.Accept(i, env)
+		if i.isError(literal) {
+			return false, literal
+		}
+		return objectsEqual(literal, value), nil
+	case *ArrayPattern:
+		array, ok := value.(*Array)
+		if !ok || len(array.Elements) != len(pattern.Elements) {
+			return false, nil
+		}
+
+		for idx, element := range pattern.Elements {
+			if matched, err := i.matchPattern(element, array.Elements[idx], env); !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case *HashPattern:
+		hash, ok := value.(*Hash)
+		if !ok {
+			return false, nil
+		}
+
+		for idx, keyNode := range pattern.Keys {
+			key := keyNode.Accept(i, env).(Hashable)
+
+			pair, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				return false, nil
+			}
+			if matched, err := i.matchPattern(pattern.Values[idx], pair.Value, env); !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case *ClassPattern:
+		classValue, ok := env.Get(pattern.Class.Value)
+		if !ok {
+			return false, i.newError("%s: %s", identifierNotFoundError, pattern.Class.Value)
+		}
+		class, ok := classValue.(*ClassObject)
+		if !ok {
+			return false, i.newError("%s: %s", notClassError, pattern.Class.Value)
+		}
+
+		instance, ok := value.(*InstanceObject)
+		if !ok || !instance.Class.IsSubclassOf(class) {
+			return false, nil
+		}
+		if len(pattern.Fields) == 0 {
+			return true, nil
+		}
+
+		init, ok := instance.GetMethod("init")
+		if !ok || len(init.Parameters) != len(pattern.Fields) {
+			return false, nil
+		}
+
+		for idx, field := range pattern.Fields {
+			fieldValue, ok := instance.GetField(init.Parameters[idx].Value)
+			if !ok {
+				fieldValue = Null
+			}
+			if matched, err := i.matchPattern(field, fieldValue, env); !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	default:
+		return false, i.newError("%s: %s", invalidSyntax, "unknown pattern")
+	}
+}
+
+func (i *Interpreter) VisitSliceExpression(node *SliceExpression, env *Environment) Object {
+	left := node.Left.Accept(i, env)
+	if i.isError(left) {
+		return left
+	}
+
+	start, end := i.evalSliceBounds(node.Start, node.End, env)
+	if i.isError(start) {
+		return start
+	}
+	if i.isError(end) {
+		return end
+	}
+
+	return i.evalSliceExpression(left, start, end)
+}
+
+func (i *Interpreter) VisitSetIndexExpression(node *SetIndexExpression, env *Environment) Object {
+	left := node.Left.Accept(i, env)
+	if i.isError(left) {
+		return left
+	}
+
+	index := node.Index.Accept(i, env)
+	if i.isError(index) {
+		return index
+	}
+
+	value := node.Value.Accept(i, env)
+	if i.isError(value) {
+		return value
+	}
+
+	return i.evalIndexAssignment(left, index, value)
+}
+
+func (i *Interpreter) VisitSetSliceExpression(node *SetSliceExpression, env *Environment) Object {
+	left := node.Left.Accept(i, env)
+	if i.isError(left) {
+		return left
+	}
+
+	start, end := i.evalSliceBounds(node.Start, node.End, env)
+	if i.isError(start) {
+		return start
+	}
+	if i.isError(end) {
+		return end
+	}
+
+	value := node.Value.Accept(i, env)
+	if i.isError(value) {
+		return value
+	}
+
+	return i.evalSliceAssignment(left, start, end, value)
+}
+
 func (i *Interpreter) VisitArrayLiteral(node *ArrayLiteral, env *Environment) Object {
 	elements := i.evalExpressions(node.Elements, env)
 	if len(elements) == 1 && i.isError(elements[0]) {
@@ -209,7 +519,7 @@ func (i *Interpreter) VisitHashLiteral(node *HashLiteral, env *Environment) Obje
 }
 
 func (i *Interpreter) VisitSuper(node *Super, env *Environment) Object {
-	if i.currentContext().Type != ClassMethodContext && i.currentContext().Type != InitializerContext {
+	if context := i.functionContext(); context.Type != ClassMethodContext && context.Type != InitializerContext {
 		return i.newError("%s: %s", invalidSyntax, "[super] cannot be used outside of class method")
 	}
 	if obj, ok := env.Get(node.Token.Lexeme); ok {
@@ -217,7 +527,11 @@ func (i *Interpreter) VisitSuper(node *Super, env *Environment) Object {
 			return i.newError("%s: %s", invalidSyntax, "[super] must be a superclass")
 		}
 		class := obj.(*ClassObject)
-		if method, ok := class.GetSuperMethod(node.Method.Value); ok {
+		if method, ok := class.GetMethod(node.Method.Value); ok {
+			this, _ := env.Get("this")
+			if receiver, ok := this.(*InstanceObject); ok {
+				return &BoundMethod{Method: method, Receiver: receiver}
+			}
 			return method
 		}
 
@@ -228,10 +542,10 @@ func (i *Interpreter) VisitSuper(node *Super, env *Environment) Object {
 }
 
 func (i *Interpreter) VisitThisExpression(node *This, env *Environment) Object {
-	if i.currentContext().Type != ClassMethodContext && i.currentContext().Type != InitializerContext {
+	if context := i.functionContext(); context.Type != ClassMethodContext && context.Type != InitializerContext {
 		return i.newError("%s: %s", invalidSyntax, "[this] cannot be used outside of class method")
 	}
-	if obj, ok := env.Get(node.Token.Lexeme); ok {
+	if obj, ok := i.lookup(node, node.Token.Lexeme, env); ok {
 		return obj
 	}
 
@@ -254,10 +568,16 @@ func (i *Interpreter) VisitSetExpression(node *SetExpression, env *Environment)
 
 func (i *Interpreter) VisitGetExpression(node *GetExpression, env *Environment) Object {
 	object := node.Object.Accept(i, env)
+	if i.isError(object) {
+		return object
+	}
+
+	return i.evalGetProperty(object, node.Property.Value)
+}
 
+func (i *Interpreter) evalGetProperty(object Object, propertyName string) Object {
 	if object.Type() == ClassObj {
 		class := object.(*ClassObject)
-		propertyName := node.Property.Value
 
 		if staticMethod, ok := class.StaticMethods[propertyName]; ok {
 			return staticMethod
@@ -266,12 +586,21 @@ func (i *Interpreter) VisitGetExpression(node *GetExpression, env *Environment)
 		return i.newError("%s: %s", invalidSyntax, fmt.Sprintf("Undefined static property '%s' on class '%s'", propertyName, class.Name))
 	}
 
+	if generator, ok := object.(*Generator); ok && propertyName == NextMethod {
+		return &Builtin{Fn: func(args ...Object) Object {
+			var value Object = Null
+			if len(args) > 0 {
+				value = args[0]
+			}
+			return i.resumeGenerator(generator, value)
+		}}
+	}
+
 	if object.Type() != InstanceObj {
 		return i.newError("%s: %s %s", invalidSyntax, "Only instance have properties", object.Type())
 	}
 
 	instance := object.(*InstanceObject)
-	propertyName := node.Property.Value
 
 	if value, ok := instance.GetField(propertyName); ok {
 		return value
@@ -280,7 +609,7 @@ func (i *Interpreter) VisitGetExpression(node *GetExpression, env *Environment)
 	if method, ok := instance.GetMethod(propertyName); ok {
 		bm := &BoundMethod{Method: method, Receiver: instance}
 		if method.IsGetter {
-			return i.applyBoundMethod(bm, nil)
+			return i.applyBoundMethod(bm, nil, nil)
 		}
 		return &BoundMethod{Method: method, Receiver: instance}
 	}
@@ -308,9 +637,17 @@ func (i *Interpreter) VisitClassStatement(node *ClassStatement, env *Environment
 		}
 	}
 
+	// methods close over an environment holding their superclass, so super
+	// inside an inherited method still refers to the right class
+	methodEnv := env
+	if class.SuperClass != nil {
+		methodEnv = NewEnclosingEnvironment(env)
+		methodEnv.Define("super", class.SuperClass)
+	}
+
 	i.pushContext(ClassMethodContext)
 	for _, m := range node.Methods {
-		method := m.Accept(i, env)
+		method := m.Accept(i, methodEnv)
 		if method.Type() != FunctionObj {
 			return i.newError("%s: %s", invalidSyntax, "Invalid method declaration inside a class")
 		}
@@ -329,27 +666,27 @@ func (i *Interpreter) VisitClassStatement(node *ClassStatement, env *Environment
 		}
 	}
 	i.popContext()
-	env.Set(node.Name.Value, class)
+	env.Define(node.Name.Value, class)
 	return class
 }
 
 func (i *Interpreter) VisitFunctionDeclaration(node *FunctionDeclaration, env *Environment) Object {
-	function := &Function{Name: node.Name, Parameters: node.Params, Body: node.Body, Env: env}
+	function := &Function{Name: node.Name, Parameters: node.Params, Defaults: node.Defaults, Rest: node.Rest, Body: node.Body, Env: env, IsGenerator: node.IsGenerator, IsAsync: node.IsAsync}
 	if node.Name == nil {
 		return i.newError("%s: %s", invalidSyntax, "missing function name in declaration")
 	}
-	env.Set(node.Name.Value, function)
+	env.Define(node.Name.Value, function)
 	return function
 }
 
 func (i *Interpreter) VisitMethodDeclaration(node *MethodDeclaration, env *Environment) Object {
-	return &Function{Name: node.Name, Parameters: node.Params, Body: node.Body, Env: env, IsStatic: node.IsStatic, IsGetter: node.IsGetter}
+	return &Function{Name: node.Name, Parameters: node.Params, Defaults: node.Defaults, Rest: node.Rest, Body: node.Body, Env: env, IsStatic: node.IsStatic, IsGetter: node.IsGetter, IsGenerator: node.IsGenerator}
 }
 
 func (i *Interpreter) VisitFunctionLiteral(node *FunctionLiteral, env *Environment) Object {
-	function := &Function{Parameters: node.Params, Body: node.Body, Env: env}
+	function := &Function{Parameters: node.Params, Defaults: node.Defaults, Rest: node.Rest, Body: node.Body, Env: env, IsGenerator: node.IsGenerator, IsAsync: node.IsAsync}
 	if node.Name != nil {
-		env.Set(node.Name.Value, function)
+		env.Define(node.Name.Value, function)
 	}
 	return function
 }
@@ -369,6 +706,11 @@ func (i *Interpreter) VisitBreakStatement(node *BreakStatement, env *Environment
 }
 
 func (i *Interpreter) VisitForStatement(node *For, env *Environment) Object {
+	// let and const in the initializer belong to the loop
+	if decl, ok := node.Initializer.(*VarStatement); ok && decl.Token.Type != VAR {
+		env = NewEnclosingEnvironment(env)
+	}
+
 	if node.Initializer != nil {
 		initResult := node.Initializer.Accept(i, env)
 		if i.isError(initResult) {
@@ -392,7 +734,11 @@ func (i *Interpreter) VisitForStatement(node *For, env *Environment) Object {
 		if i.isContinue(body) {
 			goto increment
 		}
-		if i.isError(body) || i.isBreak(body) || i.isReturn(body) {
+		// a break ends this loop only, not the ones around it
+		if i.isBreak(body) {
+			return nil
+		}
+		if i.isError(body) || i.isReturn(body) {
 			return body
 		}
 
@@ -408,6 +754,179 @@ func (i *Interpreter) VisitForStatement(node *For, env *Environment) Object {
 	return nil
 }
 
+func (i *Interpreter) VisitForInStatement(node *ForInStatement, env *Environment) Object {
+	iterable := node.Iterable.Accept(i, env)
+	if i.isError(iterable) {
+		return iterable
+	}
+
+	next, generator := i.iterate(iterable, node.Key != nil)
+	// a generator the loop leaves before it finishes is closed, so the
+	// goroutine running it ends
+	if generator != nil {
+		defer i.closeGenerator(generator)
+	}
+	for {
+		key, value, ok := next()
+		if i.isError(value) {
+			return value
+		}
+		if !ok {
+			break
+		}
+
+		// every iteration gets fresh bindings so closures capture that iteration's values
+		loopEnv := NewEnclosingEnvironment(env)
+		if node.Key != nil {
+			loopEnv.Define(node.Key.Value, key)
+		}
+		loopEnv.Define(node.Value.Value, value)
+
+		i.pushContext(LoopContext)
+		body := node.Body.Accept(i, loopEnv)
+		i.popContext()
+		if i.isContinue(body) {
+			continue
+		}
+		// a break ends this loop only, not the ones around it
+		if i.isBreak(body) {
+			return nil
+		}
+		if i.isError(body) || i.isReturn(body) {
+			return body
+		}
+	}
+
+	return nil
+}
+
+// iterate returns a function yielding successive key/value pairs of a for-in
+// loop. Arrays and strings yield index and element, hashes yield key and
+// value in key order (or just the key when not keyed), and instances follow
+// the iterator protocol: an optional iterator() method returns the object
+// whose next() is called until it returns nil, with the key counting
+// iterations. Errors come back as value. It also returns the generator being
+// iterated, if any, for the loop to close if it ends early.
+func (i *Interpreter) iterate(iterable Object, keyed bool) (func() (Object, Object, bool), *Generator) {
+	var keys, values []Object
+
+	switch iterable := iterable.(type) {
+	case *Array:
+		values = append(values, iterable.Elements...)
+	case *StringObject:
+		for _, r := range iterable.Value {
+			values = append(values, &StringObject{Value: string(r)})
+		}
+	case *Hash:
+		for _, pair := range iterable.SortedPairs() {
+			if !keyed {
+				values = append(values, pair.Key)
+				continue
+			}
+			keys = append(keys, pair.Key)
+			values = append(values, pair.Value)
+		}
+	case *Range:
+		idx := 0
+		return func() (Object, Object, bool) {
+			if idx >= iterable.Len() {
+				return nil, nil, false
+			}
+
+			key := &FloatObject{Value: float64(idx)}
+			value := &FloatObject{Value: iterable.At(idx)}
+			idx++
+
+			return key, value, true
+		}, nil
+	case *InstanceObject:
+		return i.iterateInstance(iterable)
+	case *Generator:
+		return i.iterateGenerator(iterable), iterable
+	default:
+		err := i.newError("%s: %s", notIterableError, iterable.Type())
+		return func() (Object, Object, bool) { return nil, err, false }, nil
+	}
+
+	idx := 0
+	return func() (Object, Object, bool) {
+		if idx >= len(values) {
+			return nil, nil, false
+		}
+
+		var key Object = &FloatObject{Value: float64(idx)}
+		if keys != nil {
+			key = keys[idx]
+		}
+		value := values[idx]
+		idx++
+
+		return key, value, true
+	}, nil
+}
+
+func (i *Interpreter) iterateGenerator(generator *Generator) func() (Object, Object, bool) {
+	count := 0
+	return func() (Object, Object, bool) {
+		value := i.resumeGenerator(generator, Null)
+		if i.isError(value) {
+			return nil, value, false
+		}
+		if generator.Done {
+			return nil, nil, false
+		}
+
+		key := &FloatObject{Value: float64(count)}
+		count++
+
+		return key, value, true
+	}
+}
+
+func (i *Interpreter) iterateInstance(instance *InstanceObject) (func() (Object, Object, bool), *Generator) {
+	fail := func(err Object) (func() (Object, Object, bool), *Generator) {
+		return func() (Object, Object, bool) { return nil, err, false }, nil
+	}
+
+	iterator := instance
+	if method, ok := instance.GetMethod(IteratorMethod); ok {
+		result := i.unwrapReturnValue(i.applyBoundMethod(&BoundMethod{Receiver: instance, Method: method}, nil, nil))
+		if i.isError(result) {
+			return fail(result)
+		}
+
+		if generator, ok := result.(*Generator); ok {
+			return i.iterateGenerator(generator), generator
+		}
+
+		iterator, ok = result.(*InstanceObject)
+		if !ok {
+			return fail(i.newError("%s: %s() returned %s", notIterableError, IteratorMethod, result.Type()))
+		}
+	}
+
+	next, ok := iterator.GetMethod(NextMethod)
+	if !ok {
+		return fail(i.newError("%s: %s", notIterableError, iterator.Inspect()))
+	}
+
+	count := 0
+	return func() (Object, Object, bool) {
+		value := i.unwrapReturnValue(i.applyBoundMethod(&BoundMethod{Receiver: iterator, Method: next}, nil, nil))
+		if i.isError(value) {
+			return nil, value, false
+		}
+		if value == nil || value.Type() == NillObj {
+			return nil, nil, false
+		}
+
+		key := &FloatObject{Value: float64(count)}
+		count++
+
+		return key, value, true
+	}, nil
+}
+
 func (i *Interpreter) VisitWhileStatement(node *While, env *Environment) Object {
 	for {
 		condition := node.Condition.Accept(i, env)
@@ -425,7 +944,11 @@ func (i *Interpreter) VisitWhileStatement(node *While, env *Environment) Object
 		if i.isContinue(body) {
 			continue
 		}
-		if i.isError(body) || i.isBreak(body) || i.isReturn(body) {
+		// a break ends this loop only, not the ones around it
+		if i.isBreak(body) {
+			return nil
+		}
+		if i.isError(body) || i.isReturn(body) {
 			return body
 		}
 	}
@@ -434,7 +957,7 @@ func (i *Interpreter) VisitWhileStatement(node *While, env *Environment) Object
 }
 
 func (i *Interpreter) VisitIdentifier(node *Identifier, env *Environment) Object {
-	if obj, ok := env.Get(node.Value); ok {
+	if obj, ok := i.lookup(node, node.Value, env); ok {
 		if obj.Type() == NillObj {
 			return i.newError("%s: %s", notInitialzied, node.TokenLiteral())
 		}
@@ -498,57 +1021,214 @@ func (i *Interpreter) VisitVarStatement(node *VarStatement, env *Environment) Ob
 	return right
 }
 
-func (i *Interpreter) VisitBinary(node *Binary, env *Environment) Object {
-	left := node.Left.Accept(i, env)
-	right := node.Right.Accept(i, env)
+func (i *Interpreter) VisitDestructureStatement(node *DestructureStatement, env *Environment) Object {
+	value := node.Value.Accept(i, env)
+	if i.isError(value) {
+		return value
+	}
 
-	switch {
-	case left.Type() == ErrorObj:
-		return left
-	case right.Type() == ErrorObj:
-		return right
-	case left.Type() == StringObj && right.Type() == StringObj:
-		return i.stringarithmetic(left, right, node.Operator)
-	case left.Type() == FloatObj && right.Type() == FloatObj:
-		return i.floatarithmetic(left, right, node.Operator)
-	case node.Operator == "+" && ((left.Type() == FloatObj && right.Type() == StringObj) || (right.Type() == FloatObj && left.Type() == StringObj)):
-		if left.Type() == FloatObj {
-			left = &StringObject{Value: left.Inspect()}
-		} else {
-			right = &StringObject{Value: right.Inspect()}
+	return i.destructure(node.Pattern, value, env, func(target Expression, value Object) Object {
+		name := target.(*Identifier).Value
+		if _, ok := env.GetCurrentScope(name); ok {
+			return i.newError("%s: %s", redeclare, name)
 		}
-		return i.stringarithmetic(left, right, node.Operator)
-	case node.Operator == "==":
-		return i.nativeToBooleanObject(left == right)
-	case node.Operator == "!=":
-		return i.nativeToBooleanObject(left != right)
-	case left.Type() != right.Type():
-		return i.newError("%s: %s %s %s", typeMissMatchError, left.Type(), node.Operator, right.Type())
-	default:
-		return i.newError("%s: %s %s %s", unknownOperatorError, left.Type(), node.Operator, right.Type())
-	}
-}
 
-func (i *Interpreter) stringarithmetic(left Object, right Object, op string) Object {
-	l, _ := left.(*StringObject)
-	r, _ := right.(*StringObject)
+		env.Define(name, value)
+		return value
+	})
+}
 
-	switch op {
-	case "+":
-		return &StringObject{Value: l.Value + r.Value}
-	case ">":
-		return &BooleanObject{Value: l.Value > r.Value}
-	case "<":
-		return &BooleanObject{Value: l.Value < r.Value}
-	case ">=":
-		return &BooleanObject{Value: l.Value >= r.Value}
-	case "<=":
-		return &BooleanObject{Value: l.Value <= r.Value}
-	case "==":
-		return &BooleanObject{Value: l.Value == r.Value}
-	default:
-		return i.newError("%s: %s %s %s", unknownOperatorError, left.Type(), op, right.Type())
+func (i *Interpreter) VisitDestructureAssignment(node *DestructureAssignment, env *Environment) Object {
+	value := node.Value.Accept(i, env)
+	if i.isError(value) {
+		return value
 	}
+
+	return i.destructure(node.Pattern, value, env, func(target Expression, value Object) Object {
+		return i.assignTarget(target, value, env)
+	})
+}
+
+// destructure unpacks value according to pattern, handing every target and
+// the value meant for it to bind. Defaults are evaluated only for elements
+// and keys that are missing.
+func (i *Interpreter) destructure(pattern Destructure, value Object, env *Environment, bind func(target Expression, value Object) Object) Object {
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		array, ok := value.(*Array)
+		if !ok {
+			return i.newError("%s: expected an array, got %s", destructureError, value.Type())
+		}
+
+		length := len(array.Elements)
+		if msg := arrayShapeError(length, pattern.Required(), len(pattern.Elements), pattern.Rest != nil); msg != "" {
+			return i.newError("%s: %s", destructureError, msg)
+		}
+
+		for idx, element := range pattern.Elements {
+			var item Object
+			if idx < length {
+				item = array.Elements[idx]
+			}
+			if result := i.destructureElement(element, item, env, bind); i.isError(result) {
+				return result
+			}
+		}
+
+		if pattern.Rest != nil {
+			rest := []Object{}
+			if length > len(pattern.Elements) {
+				rest = append(rest, array.Elements[len(pattern.Elements):]...)
+			}
+			if result := bind(pattern.Rest, &Array{Elements: rest}); i.isError(result) {
+				return result
+			}
+		}
+	case *HashDestructure:
+		for _, entry := range pattern.Entries {
+			item, found, ok := destructureKey(value, entry.Key)
+			if !ok {
+				return i.newError("%s: expected a hash, got %s", destructureError, value.Type())
+			}
+			if !found && entry.Default == nil {
+				return i.newError("%s: missing key %s", destructureError, entry.Key)
+			}
+
+			if result := i.destructureElement(entry, item, env, bind); i.isError(result) {
+				return result
+			}
+		}
+	}
+
+	return value
+}
+
+// destructureElement binds one element of a pattern, using its default when
+// value is nil because the element is missing.
+func (i *Interpreter) destructureElement(element *DestructureElement, value Object, env *Environment, bind func(target Expression, value Object) Object) Object {
+	if value == nil {
+		value = element.Default.Accept(i, env)
+		if i.isError(value) {
+			return value
+		}
+	}
+
+	if element.Pattern != nil {
+		return i.destructure(element.Pattern, value, env, bind)
+	}
+
+	return bind(element.Target, value)
+}
+
+// assignTarget stores value into a variable, field or index target.
+func (i *Interpreter) assignTarget(target Expression, value Object, env *Environment) Object {
+	switch target := target.(type) {
+	case *Identifier:
+		if _, ok := i.lookup(target, target.Value, env); !ok {
+			return i.newError("%s: %s", identifierNotFoundError, target.Value)
+		}
+		if result := i.assign(target, target.Value, value, env); i.isError(result) {
+			return result
+		}
+	case *GetExpression:
+		object := target.Object.Accept(i, env)
+		if i.isError(object) {
+			return object
+		}
+
+		instance, ok := object.(*InstanceObject)
+		if !ok {
+			return i.newError("%s: %s %s", invalidSyntax, "Only instances have fields.", object.Type())
+		}
+		instance.SetField(target.Property.Value, value)
+	case *IndexExpression:
+		left := target.Left.Accept(i, env)
+		if i.isError(left) {
+			return left
+		}
+
+		index := target.Index.Accept(i, env)
+		if i.isError(index) {
+			return index
+		}
+
+		if result := i.evalIndexAssignment(left, index, value); i.isError(result) {
+			return result
+		}
+	default:
+		return i.newError("%s: %s", invalidSyntax, "Invalid assignment target.")
+	}
+
+	return value
+}
+
+func (i *Interpreter) VisitBinary(node *Binary, env *Environment) Object {
+	left := node.Left.Accept(i, env)
+	right := node.Right.Accept(i, env)
+
+	return i.evalBinary(left, right, node.Operator)
+}
+
+func (i *Interpreter) evalBinary(left Object, right Object, op string) Object {
+	switch {
+	case left.Type() == ErrorObj:
+		return left
+	case right.Type() == ErrorObj:
+		return right
+	case op == "in":
+		found, ok := containsObject(right, left)
+		if !ok {
+			return i.newError("%s: %s %s %s", unknownOperatorError, left.Type(), op, right.Type())
+		}
+		return i.nativeToBooleanObject(found)
+	case left.Type() == StringObj && right.Type() == StringObj:
+		return i.stringarithmetic(left, right, op)
+	case left.Type() == FloatObj && right.Type() == FloatObj:
+		return i.floatarithmetic(left, right, op)
+	case op == "+" && ((left.Type() == FloatObj && right.Type() == StringObj) || (right.Type() == FloatObj && left.Type() == StringObj)):
+		if left.Type() == FloatObj {
+			left = &StringObject{Value: left.Inspect()}
+		} else {
+			right = &StringObject{Value: right.Inspect()}
+		}
+		return i.stringarithmetic(left, right, op)
+	case op == "==":
+		return i.nativeToBooleanObject(left == right)
+	case op == "!=":
+		return i.nativeToBooleanObject(left != right)
+	case left.Type() != right.Type():
+		return i.newError("%s: %s %s %s", typeMissMatchError, left.Type(), op, right.Type())
+	default:
+		return i.newError("%s: %s %s %s", unknownOperatorError, left.Type(), op, right.Type())
+	}
+}
+
+func (i *Interpreter) stringarithmetic(left Object, right Object, op string) Object {
+	l, _ := left.(*StringObject)
+	r, _ := right.(*StringObject)
+
+	switch op {
+	case "+":
+		return &StringObject{Value: l.Value + r.Value}
+	case ">":
+		return &BooleanObject{Value: l.Value > r.Value}
+	case "<":
+		return &BooleanObject{Value: l.Value < r.Value}
+	case ">=":
+		return &BooleanObject{Value: l.Value >= r.Value}
+	case "<=":
+		return &BooleanObject{Value: l.Value <= r.Value}
+	case "==":
+		return &BooleanObject{Value: l.Value == r.Value}
+	default:
+		return i.newError("%s: %s %s %s", unknownOperatorError, left.Type(), op, right.Type())
+	}
+}
+
+// divisionByZeroMessage is the error of left op right when right is zero,
+// worded the same by the tree-walker and the VM.
+func divisionByZeroMessage(left *FloatObject, op string, right *FloatObject) string {
+	return fmt.Sprintf("%s: %s %s %s", divisionByZero, left.Inspect(), op, right.Inspect())
 }
 
 func (i *Interpreter) floatarithmetic(left Object, right Object, op string) Object {
@@ -562,9 +1242,14 @@ func (i *Interpreter) floatarithmetic(left Object, right Object, op string) Obje
 		return &FloatObject{Value: l.Value - r.Value}
 	case "/":
 		if r.Value == 0 {
-			return i.newError("%s: %f %s %f", divisionByZero, l.Value, op, r.Value)
+			return i.newError("%s", divisionByZeroMessage(l, op, r))
 		}
 		return &FloatObject{Value: l.Value / r.Value}
+	case "%":
+		if r.Value == 0 {
+			return i.newError("%s", divisionByZeroMessage(l, op, r))
+		}
+		return &FloatObject{Value: math.Mod(l.Value, r.Value)}
 	case "*":
 		return &FloatObject{Value: l.Value * r.Value}
 	case ">":
@@ -603,6 +1288,41 @@ func (i *Interpreter) VisitStringLiteral(node *StringLiteral, env *Environment)
 	return &StringObject{Value: node.Value}
 }
 
+func (i *Interpreter) VisitInterpolationExpression(node *InterpolationExpression, env *Environment) Object {
+	var str strings.Builder
+
+	for _, part := range node.Parts {
+		value := part.Accept(i, env)
+		if i.isError(value) {
+			return value
+		}
+
+		value = i.toString(value)
+		if i.isError(value) {
+			return value
+		}
+		str.WriteString(value.Inspect())
+	}
+
+	return &StringObject{Value: str.String()}
+}
+
+// toString converts a value to a string, calling the instance's toString
+// method when its class defines one.
+func (i *Interpreter) toString(obj Object) Object {
+	if instance, ok := obj.(*InstanceObject); ok {
+		if method, ok := instance.GetMethod(ToStringMethod); ok {
+			result := i.unwrapReturnValue(i.applyBoundMethod(&BoundMethod{Receiver: instance, Method: method}, nil, nil))
+			if i.isError(result) {
+				return result
+			}
+			return &StringObject{Value: result.Inspect()}
+		}
+	}
+
+	return &StringObject{Value: obj.Inspect()}
+}
+
 func (i *Interpreter) VisitNumberLiteral(node *NumberLiteral, env *Environment) Object {
 	return &FloatObject{Value: node.Value}
 }
@@ -618,11 +1338,129 @@ func (i *Interpreter) VisitNilLiteral(node *NilLiteral, env *Environment) Object
 func (i *Interpreter) VisitAssignment(node *Assignment, env *Environment) Object {
 	right := node.Expression.Accept(i, env)
 
-	if !i.isError(right) {
-		env.Set(node.Identifier.Value, right)
+	if i.isError(right) {
+		return right
 	}
 
-	return right
+	return i.assign(&node.Identifier, node.Identifier.Value, right, env)
+}
+
+func (i *Interpreter) VisitCompoundAssignment(node *CompoundAssignment, env *Environment) Object {
+	return i.evalUpdate(node.Target, env, func(current Object) (Object, bool) {
+		if node.Operator == "??" {
+			if current.Type() != NillObj {
+				return current, false
+			}
+			return node.Value.Accept(i, env), true
+		}
+
+		right := node.Value.Accept(i, env)
+		if i.isError(right) {
+			return right, false
+		}
+		return i.evalBinary(current, right, node.Operator), true
+	})
+}
+
+func (i *Interpreter) VisitUpdateExpression(node *UpdateExpression, env *Environment) Object {
+	var old Object
+
+	result := i.evalUpdate(node.Target, env, func(current Object) (Object, bool) {
+		number, ok := current.(*FloatObject)
+		if !ok {
+			return i.newError("%s: %s", typeMissMatchError, fmt.Sprintf("operand of %s must be a float, got=%s", node.Operator, current.Type())), false
+		}
+
+		old = number
+		if node.Operator == "++" {
+			return &FloatObject{Value: number.Value + 1}, true
+		}
+		return &FloatObject{Value: number.Value - 1}, true
+	})
+
+	if i.isError(result) || node.Prefix {
+		return result
+	}
+	return old
+}
+
+// evalUpdate reads the current value of an assignment target, passes it to
+// update and writes the result back when update asks for it. The target's
+// object and index are evaluated exactly once.
+func (i *Interpreter) evalUpdate(target Expression, env *Environment, update func(current Object) (Object, bool)) Object {
+	switch target := target.(type) {
+	case *Identifier:
+		current, ok := i.lookup(target, target.Value, env)
+		if !ok {
+			return i.newError("%s: %s", identifierNotFoundError, target.Value)
+		}
+		if i.isError(current) {
+			return current
+		}
+
+		value, store := update(current)
+		if i.isError(value) {
+			return value
+		}
+		if store {
+			if result := i.assign(target, target.Value, value, env); i.isError(result) {
+				return result
+			}
+		}
+		return value
+	case *GetExpression:
+		object := target.Object.Accept(i, env)
+		if i.isError(object) {
+			return object
+		}
+
+		instance, ok := object.(*InstanceObject)
+		if !ok {
+			return i.newError("%s: %s %s", invalidSyntax, "Only instances have fields.", object.Type())
+		}
+
+		current := i.evalGetProperty(instance, target.Property.Value)
+		if i.isError(current) {
+			return current
+		}
+
+		value, store := update(current)
+		if i.isError(value) {
+			return value
+		}
+		if store {
+			instance.SetField(target.Property.Value, value)
+		}
+		return value
+	case *IndexExpression:
+		left := target.Left.Accept(i, env)
+		if i.isError(left) {
+			return left
+		}
+
+		index := target.Index.Accept(i, env)
+		if i.isError(index) {
+			return index
+		}
+
+		current := i.evalIndexExpression(left, index)
+		if i.isError(current) {
+			return current
+		}
+
+		value, store := update(current)
+		if i.isError(value) {
+			return value
+		}
+		if store {
+			if result := i.evalIndexAssignment(left, index, value); i.isError(result) {
+				return result
+			}
+		}
+		return value
+	default:
+		return i.newError("%s: %s", invalidSyntax, "Invalid assignment target.")
+	}
 }
 
 func (i *Interpreter) VisitCallExpression(node *CallExpression, env *Environment) Object {
@@ -634,23 +1472,81 @@ func (i *Interpreter) VisitCallExpression(node *CallExpression, env *Environment
 	}
 
 	for _, argument := range node.Arguments {
+		spread, isSpread := argument.(*SpreadExpression)
+		if isSpread {
+			argument = spread.Value
+		}
+
 		result := argument.Accept(i, env)
 		if i.isError(result) {
-			return nil
+			return result
+		}
+
+		if !isSpread {
+			arguments = append(arguments, result)
+			continue
+		}
+
+		array, ok := result.(*Array)
+		if !ok {
+			return i.newError("%s: cannot spread %s, expected an array", typeMissMatchError, result.Type())
+		}
+		arguments = append(arguments, array.Elements...)
+	}
+
+	var names []string
+	for _, named := range node.Named {
+		result := named.Value.Accept(i, env)
+		if i.isError(result) {
+			return result
 		}
 		arguments = append(arguments, result)
+		names = append(names, named.Name.Value)
 	}
 
 	switch callee.Type() {
 	case ClassObj:
-		return i.instantiateClass(callee, arguments)
+		return i.instantiateClass(callee, arguments, names)
 	case BoundObj:
-		return i.applyBoundMethod(callee, arguments)
+		return i.unwrapReturnValue(i.applyBoundMethod(callee, arguments, names))
 	default:
-		return i.applyFunction(callee, arguments)
+		return i.applyFunction(callee, arguments, names)
 	}
 }
 
+func (i *Interpreter) VisitSpreadExpression(node *SpreadExpression, env *Environment) Object {
+	return i.newError("%s: %s", invalidSyntax, "spread is only allowed in call arguments")
+}
+
+// bindParameters defines fn's parameters in env from the arguments of a call,
+// the last len(names) of them named. A parameter that is left out or passed
+// nil takes its default, which can refer to the parameters before it.
+func (i *Interpreter) bindParameters(fn *Function, env *Environment, args []Object, names []string) Object {
+	required := requiredParameters(len(fn.Parameters), fn.Defaults)
+
+	values, err := bindArguments(parameterNames(fn.Parameters), required, fn.Rest != nil, args, names)
+	if err != nil {
+		return i.newError("%s", err.Error())
+	}
+
+	for idx, param := range fn.Parameters {
+		value := values[idx]
+		if value.Type() == NillObj && idx < len(fn.Defaults) && fn.Defaults[idx] != nil {
+			value = fn.Defaults[idx].Accept(i, env)
+			if i.isError(value) {
+				return value
+			}
+		}
+		env.Define(param.Value, value)
+	}
+
+	if fn.Rest != nil {
+		env.Define(fn.Rest.Value, values[len(fn.Parameters)])
+	}
+
+	return nil
+}
+
 func (i *Interpreter) unwrapReturnValue(obj Object) Object {
 	if returnValue, ok := obj.(*ReturnValueObject); ok {
 		return returnValue.Value
@@ -659,44 +1555,46 @@ func (i *Interpreter) unwrapReturnValue(obj Object) Object {
 	return obj
 }
 
-func (i *Interpreter) applyBoundMethod(class Object, args []Object) Object {
+func (i *Interpreter) applyBoundMethod(class Object, args []Object, names []string) Object {
 	bm, ok := class.(*BoundMethod)
 	if !ok {
 		return i.newError("%s: %s", invalidSyntax, class.Type())
 	}
 
+	i.pushFrame(bm.Receiver.Class.Name.Value + "." + bm.Method.Name.Value)
+	defer i.popFrame()
+
 	// Check if the method is the initializer
 	if bm.Method.Name.Value == "init" {
 		i.pushContext(InitializerContext)
 		defer i.popContext()
 
 		extendedEnv := NewEnclosingEnvironment(bm.Method.Env)
-		extendedEnv.Set("this", bm.Receiver)
-		if bm.Receiver.Class.SuperClass != nil {
-			extendedEnv.Set("super", bm.Receiver.Class.SuperClass)
-		}
+		extendedEnv.Define("this", bm.Receiver)
 
 		// Set parameters for the initializer
-		for idx, param := range bm.Method.Parameters {
-			extendedEnv.Set(param.Value, args[idx])
+		if err := i.bindParameters(bm.Method, extendedEnv, args, names); err != nil {
+			return err
 		}
 
 		// Execute the initializer
-		i.executeBlock(bm.Method.Body.Statements, extendedEnv)
+		if result := i.executeBlock(bm.Method.Body.Statements, extendedEnv); i.isError(result) {
+			return result
+		}
 		return bm.Receiver
 	}
 
 	extendedEnv := NewEnclosingEnvironment(bm.Method.Env)
-	extendedEnv.Set("this", bm.Receiver)
-	if bm.Receiver.Class.SuperClass != nil {
-		extendedEnv.Set("super", bm.Receiver.Class.SuperClass)
-	}
+	extendedEnv.Define("this", bm.Receiver)
 
 	i.pushContext(ClassMethodContext)
 	defer func() { i.popContext() }()
 
-	for idx, param := range bm.Method.Parameters {
-		extendedEnv.Set(param.Value, args[idx])
+	if err := i.bindParameters(bm.Method, extendedEnv, args, names); err != nil {
+		return err
+	}
+	if bm.Method.IsGenerator {
+		return i.newGenerator(bm.Method, extendedEnv, true)
 	}
 
 	result := i.executeBlock(bm.Method.Body.Statements, extendedEnv)
@@ -709,7 +1607,7 @@ func (i *Interpreter) applyBoundMethod(class Object, args []Object) Object {
 	return result
 }
 
-func (i *Interpreter) instantiateClass(class Object, args []Object) Object {
+func (i *Interpreter) instantiateClass(class Object, args []Object, names []string) Object {
 	cl, ok := class.(*ClassObject)
 	if !ok {
 		return i.newError("%s: %s", invalidSyntax, class.Type())
@@ -720,20 +1618,15 @@ func (i *Interpreter) instantiateClass(class Object, args []Object) Object {
 		Fields: make(map[string]Object),
 	}
 
-	if initMethod, ok := cl.Methods["init"]; ok {
+	if initMethod, ok := cl.GetMethod("init"); ok {
 		i.pushContext(InitializerContext)
 		defer i.popContext()
-		if len(initMethod.Parameters) != len(args) {
-			return i.newError("constructor for class %s expected %d arguments, got %d", cl.Name.Value, len(initMethod.Parameters), len(args))
-		}
-
+		i.pushFrame(cl.Name.Value + ".init")
+		defer i.popFrame()
 		newEnv := NewEnclosingEnvironment(initMethod.Env)
-		newEnv.Set("this", instance)
-		if instance.Class.SuperClass != nil {
-			newEnv.Set("super", instance.Class.SuperClass)
-		}
-		for idx, param := range initMethod.Parameters {
-			newEnv.Set(param.Value, args[idx])
+		newEnv.Define("this", instance)
+		if err := i.bindParameters(initMethod, newEnv, args, names); err != nil {
+			return err
 		}
 
 		result := i.executeBlock(initMethod.Body.Statements, newEnv)
@@ -745,20 +1638,31 @@ func (i *Interpreter) instantiateClass(class Object, args []Object) Object {
 	return instance
 }
 
-func (i *Interpreter) applyFunction(fn Object, args []Object) Object {
+func (i *Interpreter) applyFunction(fn Object, args []Object, names []string) Object {
 
 	switch fn := fn.(type) {
 	case *Function:
-		if len(fn.Parameters) != len(args) {
-			return i.newError("%s: Expected %d arguments, but got %d .", invalidSyntax, len(fn.Parameters), len(args))
+		extendedEnv := NewEnclosingEnvironment(fn.Env)
+		if err := i.bindParameters(fn, extendedEnv, args, names); err != nil {
+			return err
+		}
+		if fn.IsGenerator {
+			return i.newGenerator(fn, extendedEnv, false)
+		}
+		if fn.IsAsync {
+			return i.newError("%s: async %s", vmOnlyError, functionName(fn))
 		}
-		extendedEnv := i.extendedFunctionEnv(fn, args)
 		i.pushContext(FunctionContext)
 		defer func() { i.popContext() }()
+		i.pushFrame(functionName(fn))
+		defer i.popFrame()
 		evaluated := fn.Body.Accept(i, extendedEnv)
 
 		return i.unwrapReturnValue(evaluated)
 	case *Builtin:
+		if len(names) > 0 {
+			return i.newError("%s: %s", invalidSyntax, "builtins take no named arguments")
+		}
 		if result := fn.Fn(args...); result != nil {
 			return result
 		} else {
@@ -769,16 +1673,6 @@ func (i *Interpreter) applyFunction(fn Object, args []Object) Object {
 	}
 }
 
-func (i *Interpreter) extendedFunctionEnv(fn *Function, args []Object) *Environment {
-	env := NewEnclosingEnvironment(fn.Env)
-
-	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
-	}
-
-	return env
-}
-
 func (i *Interpreter) VisitTernaryExpression(node *TernaryExpression, env *Environment) Object {
 	condition := node.Condition.Accept(i, env)
 	if i.isError(condition) {
@@ -794,10 +1688,239 @@ func (i *Interpreter) VisitTernaryExpression(node *TernaryExpression, env *Envir
 	}
 }
 
+// newGenerator sets up a generator over the body of fn, whose parameters
+// are already bound in env. The body of a method runs in env itself, next
+// to this and its parameters, as the resolver expects. Nothing runs until
+// the first next().
+func (i *Interpreter) newGenerator(fn *Function, env *Environment, method bool) *Generator {
+	state := &generatorState{resume: make(chan Object), yields: make(chan Object), done: make(chan struct{})}
+	generator := &Generator{Name: functionName(fn), state: state}
+
+	generator.start = func() {
+		// the body gets an interpreter of its own, so the contexts and frames
+		// it leaves behind while suspended don't leak into the caller's
+		body := NewInterpreter()
+		body.locals = i.locals
+		body.globals = i.globals
+		if method {
+			body.pushContext(ClassMethodContext)
+		} else {
+			body.pushContext(FunctionContext)
+		}
+		body.pushFrame(generator.Name)
+		body.generator = state
+		run := func() Object {
+			if method {
+				return body.executeBlock(fn.Body.Statements, env)
+			}
+			return fn.Body.Accept(body, env)
+		}
+
+		go func() {
+			defer close(state.yields)
+			select {
+			case <-state.resume:
+			case <-state.done:
+				return
+			}
+			if result := run(); i.isError(result) && result != errGeneratorClosed {
+				select {
+				case state.yields <- result:
+				case <-state.done:
+				}
+			}
+		}()
+		// a dropped generator can't be resumed, so its body is unwound
+		runtime.SetFinalizer(generator, func(generator *Generator) {
+			generator.state.close(true)
+		})
+	}
+
+	return generator
+}
+
+// closeGenerator ends a generator that hasn't finished: the body unwinds
+// from the yield it is suspended at, running its finally blocks, before
+// closeGenerator returns.
+func (i *Interpreter) closeGenerator(generator *Generator) {
+	if generator.Done || generator.Running {
+		return
+	}
+	generator.Done = true
+	if generator.start != nil {
+		generator.start = nil
+		return
+	}
+
+	generator.state.close(false)
+	for range generator.state.yields {
+	}
+}
+
+// resumeGenerator runs the generator up to its next yield, returning the
+// yielded value, or nil once the body has finished.
+func (i *Interpreter) resumeGenerator(generator *Generator, value Object) Object {
+	if generator.Done {
+		return Null
+	}
+	if generator.Running {
+		return i.newError("%s: generator %s is already running", invalidSyntax, generator.Name)
+	}
+
+	if generator.start != nil {
+		generator.start()
+		generator.start = nil
+	}
+
+	generator.Running = true
+	generator.state.resume <- value
+	result, ok := <-generator.state.yields
+	generator.Running = false
+
+	if !ok {
+		generator.Done = true
+		return Null
+	}
+	if i.isError(result) {
+		generator.Done = true
+	}
+	return result
+}
+
+func (i *Interpreter) VisitYieldExpression(node *YieldExpression, env *Environment) Object {
+	if i.generator == nil {
+		return i.newError("%s: %s", invalidSyntax, "Can't use 'yield' outside a generator function")
+	}
+
+	var value Object = Null
+	if node.Value != nil {
+		value = node.Value.Accept(i, env)
+		if i.isError(value) {
+			return value
+		}
+	}
+
+	select {
+	case i.generator.yields <- value:
+	case <-i.generator.done:
+		return errGeneratorClosed
+	}
+	select {
+	case sent := <-i.generator.resume:
+		return sent
+	case <-i.generator.done:
+		return errGeneratorClosed
+	}
+}
+
+// The event loop behind async functions lives in the VM.
+func (i *Interpreter) VisitAwaitExpression(node *AwaitExpression, env *Environment) Object {
+	return i.newError("%s: %s", vmOnlyError, node.TokenLiteral())
+}
+
+// Threads and channels need the VM, which gives every thread its own copy
+// of the values it can see.
+func (i *Interpreter) VisitSpawnExpression(node *SpawnExpression, env *Environment) Object {
+	return i.newError("%s: %s", vmOnlyError, node.TokenLiteral())
+}
+
+func (i *Interpreter) VisitSelectStatement(node *SelectStatement, env *Environment) Object {
+	return i.newError("%s: %s", vmOnlyError, node.TokenLiteral())
+}
+
+func (i *Interpreter) VisitImportStatement(node *ImportStatement, env *Environment) Object {
+	return i.newError("%s: %s", vmOnlyError, node.TokenLiteral())
+}
+
+// VisitExportStatement declares as usual; a single file has no one to
+// export to.
+func (i *Interpreter) VisitExportStatement(node *ExportStatement, env *Environment) Object {
+	return node.Declaration.Accept(i, env)
+}
+
+// VisitTestStatement does nothing; tests are only run by "jlox test".
+func (i *Interpreter) VisitTestStatement(node *TestStatement, env *Environment) Object {
+	return nil
+}
+
+func (i *Interpreter) VisitThrowStatement(node *ThrowStatement, env *Environment) Object {
+	value := node.Value.Accept(i, env)
+	if i.isError(value) {
+		return value
+	}
+
+	stack := i.stackTrace()
+	if instance, ok := value.(*InstanceObject); ok && isErrorClass(instance.Class) {
+		fillStack(instance.Fields, stack)
+	}
+
+	return &ErrorObject{Message: "uncaught " + thrownMessage(value), Value: value, Stack: stack}
+}
+
+// VisitTryStatement catches errors from the body only; a signal from the
+// finally block (return, break, error) replaces whatever came before it.
+func (i *Interpreter) VisitTryStatement(node *TryStatement, env *Environment) Object {
+	result := node.Body.Accept(i, env)
+
+	if result == errGeneratorClosed {
+		// a generator dropped while suspended runs none of its code
+		if i.generator.abandoned {
+			return result
+		}
+	} else if err, ok := result.(*ErrorObject); ok && node.Catch != nil {
+		catchEnv := NewEnclosingEnvironment(env)
+		if node.CatchName != nil {
+			catchEnv.Define(node.CatchName.Value, i.exceptionValue(err, env))
+		}
+		result = i.executeBlock(node.Catch.Statements, catchEnv)
+	}
+
+	if node.Finally != nil {
+		if signal := node.Finally.Accept(i, env); i.isBreak(signal) || i.isContinue(signal) || i.isReturn(signal) || i.isError(signal) {
+			return signal
+		}
+	}
+
+	return result
+}
+
+// exceptionValue is what a catch clause binds: the thrown value, or an
+// Error instance describing a built-in runtime error.
+func (i *Interpreter) exceptionValue(err *ErrorObject, env *Environment) Object {
+	if err.Value != nil {
+		return err.Value
+	}
+
+	obj, _ := env.Get(ErrorClassName)
+	class, ok := obj.(*ClassObject)
+	if !ok {
+		return &StringObject{Value: err.Message}
+	}
+
+	return &InstanceObject{Class: class, Fields: errorFields(err.Message, err.Stack)}
+}
+
+func isErrorClass(class *ClassObject) bool {
+	for ; class != nil; class = class.SuperClass {
+		if class.Name.Value == ErrorClassName {
+			return true
+		}
+	}
+	return false
+}
+
+func functionName(fn *Function) string {
+	if fn.Name == nil {
+		return "<anonymous>"
+	}
+	return fn.Name.Value
+}
+
 func (i *Interpreter) VisitReturnStatement(node *ReturnStatement, env *Environment) Object {
 	var value Object
 
-	if i.currentContext().Type != FunctionContext && i.currentContext().Type != InitializerContext && i.currentContext().Type != ClassMethodContext {
+	context := i.functionContext()
+	if context.Type != FunctionContext && context.Type != InitializerContext && context.Type != ClassMethodContext {
 		return i.newError("%s: %s", invalidSyntax, "Cannot use 'return' outside of function")
 	}
 
@@ -805,7 +1928,7 @@ func (i *Interpreter) VisitReturnStatement(node *ReturnStatement, env *Environme
 		value = Null
 	} else {
 		value = node.ReturnValue.Accept(i, env)
-		if i.currentContext().Type == InitializerContext {
+		if context.Type == InitializerContext {
 			return i.newError("%s: %s", invalidSyntax, "Cannot use 'return' inside init method")
 		}
 	}
@@ -862,6 +1985,10 @@ func (i *Interpreter) evalIndexExpression(left, index Object) Object {
 	switch {
 	case left.Type() == ArrayObj && index.Type() == FloatObj:
 		return i.evalArrayIndexExpression(left, index)
+	case left.Type() == StringObj && index.Type() == FloatObj:
+		return i.evalStringIndexExpression(left, index)
+	case left.Type() == RangeObj && index.Type() == FloatObj:
+		return i.evalRangeIndexExpression(left, index)
 	case left.Type() == HashObj:
 		return i.evalHashExpression(left, index)
 	default:
@@ -888,20 +2015,203 @@ func (i *Interpreter) evalHashExpression(hash, index Object) Object {
 
 func (i *Interpreter) evalArrayIndexExpression(array, index Object) Object {
 	arrayObj := array.(*Array)
-	idx := int(index.(*FloatObject).Value)
-	max := len(arrayObj.Elements) - 1
+	number := index.(*FloatObject)
 
-	if idx < 0 || idx > max {
-		return Null
+	idx, ok := resolveIndex(number, len(arrayObj.Elements))
+	if !ok {
+		return i.newError("%s: %s", indexOutOfRange, fmt.Sprintf("index %s, length %d", number.Inspect(), len(arrayObj.Elements)))
 	}
 
 	return arrayObj.Elements[idx]
 }
 
+func (i *Interpreter) evalStringIndexExpression(str, index Object) Object {
+	runes := []rune(str.(*StringObject).Value)
+	number := index.(*FloatObject)
+
+	idx, ok := resolveIndex(number, len(runes))
+	if !ok {
+		return i.newError("%s: %s", indexOutOfRange, fmt.Sprintf("index %s, length %d", number.Inspect(), len(runes)))
+	}
+
+	return &StringObject{Value: string(runes[idx])}
+}
+
+func (i *Interpreter) evalRangeIndexExpression(rng, index Object) Object {
+	rangeObj := rng.(*Range)
+	number := index.(*FloatObject)
+
+	idx, ok := resolveIndex(number, rangeObj.Len())
+	if !ok {
+		return i.newError("%s: %s", indexOutOfRange, fmt.Sprintf("index %s, length %d", number.Inspect(), rangeObj.Len()))
+	}
+
+	return &FloatObject{Value: rangeObj.At(idx)}
+}
+
+// evalSliceBounds evaluates the optional bounds of a slice; an omitted bound
+// is returned as nil.
+func (i *Interpreter) evalSliceBounds(startNode, endNode Expression, env *Environment) (Object, Object) {
+	var start, end Object
+
+	if startNode != nil {
+		start = startNode.Accept(i, env)
+		if i.isError(start) {
+			return start, nil
+		}
+	}
+	if endNode != nil {
+		end = endNode.Accept(i, env)
+	}
+
+	return start, end
+}
+
+func (i *Interpreter) evalSliceExpression(left, start, end Object) Object {
+	switch left := left.(type) {
+	case *Array:
+		low, high, ok := resolveSlice(start, end, len(left.Elements))
+		if !ok {
+			return i.newError("%s: %s", typeMissMatchError, "slice bounds must be numbers")
+		}
+
+		elements := make([]Object, high-low)
+		copy(elements, left.Elements[low:high])
+		return &Array{Elements: elements}
+	case *StringObject:
+		runes := []rune(left.Value)
+		low, high, ok := resolveSlice(start, end, len(runes))
+		if !ok {
+			return i.newError("%s: %s", typeMissMatchError, "slice bounds must be numbers")
+		}
+
+		return &StringObject{Value: string(runes[low:high])}
+	default:
+		return i.newError("%s: %s", invalidSyntax, "slice operator not supported")
+	}
+}
+
+func (i *Interpreter) evalIndexAssignment(left, index, value Object) Object {
+	switch {
+	case left.Type() == ArrayObj && index.Type() == FloatObj:
+		arrayObj := left.(*Array)
+		number := index.(*FloatObject)
+
+		idx, ok := resolveIndex(number, len(arrayObj.Elements))
+		if !ok {
+			return i.newError("%s: %s", indexOutOfRange, fmt.Sprintf("index %s, length %d", number.Inspect(), len(arrayObj.Elements)))
+		}
+
+		arrayObj.Elements[idx] = value
+		return value
+	case left.Type() == HashObj:
+		hashObject := left.(*Hash)
+
+		key, ok := index.(Hashable)
+		if !ok {
+			return i.newError("%s: %s", invalidSyntax, fmt.Sprintf("unusable hash key %s", index.Type()))
+		}
+
+		hashObject.Pairs[key.HashKey()] = HashPair{Key: index, Value: value}
+		return value
+	default:
+		return i.newError("%s: %s", invalidSyntax, "index assignment not supported")
+	}
+}
+
+// evalSliceAssignment replaces left[start:end] with the elements of value,
+// growing or shrinking the array as needed.
+func (i *Interpreter) evalSliceAssignment(left, start, end, value Object) Object {
+	arrayObj, ok := left.(*Array)
+	if !ok {
+		return i.newError("%s: %s", invalidSyntax, "slice assignment not supported")
+	}
+
+	replacement, ok := value.(*Array)
+	if !ok {
+		return i.newError("%s: %s", typeMissMatchError, "can only assign an array to a slice")
+	}
+
+	low, high, ok := resolveSlice(start, end, len(arrayObj.Elements))
+	if !ok {
+		return i.newError("%s: %s", typeMissMatchError, "slice bounds must be numbers")
+	}
+
+	arrayObj.Elements = spliceElements(arrayObj.Elements, low, high, replacement.Elements)
+	return value
+}
+
 func (i *Interpreter) VisitExpressionStatement(node *ExpressionStatement, env *Environment) Object {
 	return node.Expression.Accept(i, env)
 }
 
 func (i *Interpreter) Interpret(node Node, env *Environment) Object {
+	i.globals = env
+
+	if _, ok := env.Get(ErrorClassName); !ok {
+		prelude := Prelude()
+		if err := i.resolve(prelude, env); err != nil {
+			return err
+		}
+		if result := prelude.Accept(i, env); i.isError(result) {
+			return result
+		}
+	}
+
+	if program, ok := node.(*Program); ok {
+		if err := i.resolve(program, env); err != nil {
+			return err
+		}
+	}
+
 	return node.Accept(i, env)
 }
+
+// resolve runs the resolver over program and keeps the locations of its
+// locals, reporting the first error it finds.
+func (i *Interpreter) resolve(program *Program, env *Environment) Object {
+	resolver := NewResolver(env)
+	resolver.Resolve(program)
+	if resolver.Errors().HasErrors() {
+		return i.newError("%s: %s", resolveError, resolver.Errors().Errors[0].Message)
+	}
+
+	for expr, location := range resolver.Locals() {
+		i.locals[expr] = location
+	}
+	return nil
+}
+
+// lookup reads the variable name used by node, from the slot the resolver
+// gave it or else from the globals. A slot that doesn't hold name reads as
+// an internal error.
+func (i *Interpreter) lookup(node Expression, name string, env *Environment) (Object, bool) {
+	if location, ok := i.locals[node]; ok {
+		if obj, ok := env.GetAt(location.Depth, location.Slot, name); ok {
+			return obj, true
+		}
+		return i.misplaced(name, location), true
+	}
+	if i.globals != nil {
+		return i.globals.Get(name)
+	}
+	return env.Get(name)
+}
+
+// assign is the counterpart of lookup for storing to a variable.
+func (i *Interpreter) assign(node Expression, name string, value Object, env *Environment) Object {
+	if location, ok := i.locals[node]; ok {
+		if !env.SetAt(location.Depth, location.Slot, name, value) {
+			return i.misplaced(name, location)
+		}
+		return value
+	}
+	if i.globals != nil {
+		return i.globals.Set(name, value)
+	}
+	return env.Set(name, value)
+}
+
+func (i *Interpreter) misplaced(name string, location Location) Object {
+	return i.newError("%s: %s is not in slot %d of the scope %d out", internalError, name, location.Slot, location.Depth)
+}
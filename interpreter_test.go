@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func runInterpreter(input []byte) Object {
@@ -12,16 +15,16 @@ func runInterpreter(input []byte) Object {
 	scanner.scanTokens()
 	env := NewEnvironment()
 
-	if scanner.Errors.HasErrors() {
-		scanner.Errors.PrintErrors()
+	if scanner.Errors().HasErrors() {
+		scanner.Errors().PrintErrors()
 		return nil
 	}
 
-	parser := NewParser(scanner.Tokens)
+	parser := NewParser(scanner.Tokens())
 	program := parser.parse()
 
-	if parser.Errors.HasErrors() {
-		parser.Errors.PrintErrors()
+	if parser.Errors().HasErrors() {
+		parser.Errors().PrintErrors()
 		return nil
 	}
 	interpreter := NewInterpreter()
@@ -675,3 +678,804 @@ func testNullObject(t *testing.T, obj Object) bool {
 	}
 	return true
 }
+
+func TestCompoundAssignment(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{"var a = 5; a += 2; a;", float64(7)},
+		{"var a = 5; a -= 2; a;", float64(3)},
+		{"var a = 5; a *= 2; a;", float64(10)},
+		{"var a = 5; a /= 2; a;", float64(2.5)},
+		{"var a = 5; a %= 2; a;", float64(1)},
+		{`var a = "foo"; a += "bar"; a;`, "foobar"},
+		{"var a = 1; var b = a += 2; b;", float64(3)},
+		{"var a = nil; a ??= 4; a;", float64(4)},
+		{"var a = 1; a ??= 4; a;", float64(1)},
+		{
+			`var a = 1;
+			function add(n) {
+				a += n;
+			}
+			add(2);
+			add(3);
+			a;`,
+			float64(6),
+		},
+		{
+			`class Counter {}
+			var c = Counter();
+			c.count = 1;
+			c.count += 10;
+			c.count;`,
+			float64(11),
+		},
+		{"var arr = [1, 2, 3]; arr[1] *= 10; arr[1];", float64(20)},
+		{`var h = {"a": 1}; h["a"] += 1; h["a"];`, float64(2)},
+		{`var h = {"a": 1}; h["b"] ??= 3; h["b"];`, float64(3)},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			return
+		}
+	}
+}
+
+func TestCompoundAssignmentEvaluatesTargetOnce(t *testing.T) {
+	code := `
+		var calls = 0;
+		var arr = [1, 2, 3];
+		function index() {
+			calls += 1;
+			return 0;
+		}
+		arr[index()] += 5;
+		arr[index()]++;
+		calls;
+	`
+
+	result := runInterpreter([]byte(code))
+
+	testLiteralObject(t, result, float64(2))
+}
+
+func TestUpdateExpression(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{"var a = 1; ++a;", float64(2)},
+		{"var a = 1; a++;", float64(1)},
+		{"var a = 1; a++; a;", float64(2)},
+		{"var a = 1; --a;", float64(0)},
+		{"var a = 1; a--;", float64(1)},
+		{"var a = 1; a--; a;", float64(0)},
+		{"var arr = [1, 2]; arr[0]++; arr[0];", float64(2)},
+		{
+			`class Point {}
+			var p = Point();
+			p.x = 1;
+			var old = p.x++;
+			old + p.x * 10;`,
+			float64(21),
+		},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			return
+		}
+	}
+}
+
+func TestUpdateExpressionTypeMismatch(t *testing.T) {
+	result := runInterpreter([]byte(`var a = "hello"; a++;`))
+
+	if result.Type() != ErrorObj {
+		t.Fatalf("Expected %s, got=%s", ErrorObj, result.Type())
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`var count = 2; "you have ${count + 1} items";`, "you have 3 items"},
+		{`var x = 2.5; "${x}";`, "2.5"},
+		{`"${"nested ${"quotes"}"}";`, "nested quotes"},
+		{`var h = {"k": "v"}; "value: ${h["k"]}";`, "value: v"},
+		{`"${true} ${[1, 2]}";`, "true [1, 2]"},
+		{
+			`class User {
+				init(name) {
+					this.name = name;
+				}
+			}
+			var user = User("Ada");
+			"Hello ${user.name}";`,
+			"Hello Ada",
+		},
+		{
+			`class Point {
+				init(x, y) {
+					this.x = x;
+					this.y = y;
+				}
+				toString() {
+					return "(" + this.x + ", " + this.y + ")";
+				}
+			}
+			"p = ${Point(1, 2)}";`,
+			"p = (1, 2)",
+		},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			return
+		}
+	}
+}
+
+func TestIndexAndSlice(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`var a = [1, 2, 3]; a[-1];`, float64(3)},
+		{`var a = [1, 2, 3]; a[-3];`, float64(1)},
+		{`var a = [1, 2, 3]; a[0] = 10; a[0];`, float64(10)},
+		{`var a = [1, 2, 3]; a[-1] = 30; a[2];`, float64(30)},
+		{`var h = {"a": 1}; h["b"] = 2; h["b"];`, float64(2)},
+		{`var a = [[1, 2], [3, 4]]; a[1][0];`, float64(3)},
+		{`var a = [1, 2, 3, 4]; "${a[1:3]}";`, "[2, 3]"},
+		{`var a = [1, 2, 3, 4]; "${a[:-1]}";`, "[1, 2, 3]"},
+		{`var a = [1, 2, 3, 4]; "${a[10:]}";`, "[]"},
+		{`var a = [1, 2, 3, 4]; a[1:3] = [9]; "${a}";`, "[1, 9, 4]"},
+		{`var a = [1, 2]; var b = a[:]; b[0] = 5; a[0];`, float64(1)},
+		{`"hello"[1];`, "e"},
+		{`"hello"[-1];`, "o"},
+		{`"hello"[2:];`, "llo"},
+		{`"hello"[1:3];`, "el"},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestIndexOutOfRange(t *testing.T) {
+	tests := []string{
+		`var a = [1, 2, 3]; a[3];`,
+		`var a = [1, 2, 3]; a[-4];`,
+		`var a = [1, 2, 3]; a[5] = 1;`,
+		`"abc"[3];`,
+		`var s = "abc"; s[0:1] = ["x"];`,
+	}
+
+	for _, code := range tests {
+		result := runInterpreter([]byte(code))
+
+		if result.Type() != ErrorObj {
+			t.Errorf("Expected %s for %q, got=%s", ErrorObj, code, result.Type())
+		}
+	}
+}
+
+func TestForIn(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`var s = 0; for (var x in [1, 2, 3]) { s += x; } s;`, float64(6)},
+		{`var s = 0; for (var i, x in [10, 20]) { s += i * x; } s;`, float64(20)},
+		{`var s = ""; for (var k in {"b": 2, "a": 1}) { s += k; } s;`, "ab"},
+		{`var s = ""; for (var k, v in {"b": 2, "a": 1}) { s += "${k}=${v};"; } s;`, "a=1;b=2;"},
+		{`var s = ""; for (var c in "héllo") { s = c + s; } s;`, "olléh"},
+		{`var s = 0; for (var x in [1, 2, 3, 4]) { if (x == 2) { continue; } if (x == 4) { break; } s += x; } s;`, float64(4)},
+		{`var s = 0; for (var x in [1, 2, 3]) { for (var y in [1, 2]) { break; } s += x; } s;`, float64(6)},
+		{`var s = 0; for (var x in [1, 2, 3]) { for (var y in [1, 2]) { if (y == 2) { break; } s += 1; } } s;`, float64(3)},
+		{
+			`class Countdown {
+				init(n) {
+					this.n = n;
+				}
+				next() {
+					if (this.n == 0) {
+						return nil;
+					}
+					this.n -= 1;
+					return this.n + 1;
+				}
+			}
+			var s = "";
+			for (var x in Countdown(3)) { s += "${x}"; }
+			s;`,
+			"321",
+		},
+		{
+			`class Cursor {
+				init(items) {
+					this.items = items;
+					this.i = 0;
+				}
+				next() {
+					if (this.i == 2) {
+						return nil;
+					}
+					this.i += 1;
+					return this.items[this.i - 1];
+				}
+			}
+			class Pair {
+				iterator() {
+					return Cursor(["a", "b"]);
+				}
+			}
+			var s = "";
+			for (var i, x in Pair()) { s += "${i}${x}"; }
+			s;`,
+			"0a1b",
+		},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestForInNotIterable(t *testing.T) {
+	result := runInterpreter([]byte(`for (var x in 12) {}`))
+
+	if result.Type() != ErrorObj {
+		t.Fatalf("Expected %s, got=%s", ErrorObj, result.Type())
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`var s = 0; for (var i in 0..4) { s += i; } s;`, float64(10)},
+		{`var s = 0; for (var i in 0..<4) { s += i; } s;`, float64(6)},
+		{`var s = ""; for (var i in 10..0 step -5) { s += "${i},"; } s;`, "10,5,0,"},
+		{`var s = ""; for (var i in 3..1) { s += "${i}"; } s;`, "321"},
+		{`var s = ""; for (var i, x in 5..<7) { s += "${i}:${x} "; } s;`, "0:5 1:6 "},
+		{`len(0..10);`, float64(11)},
+		{`len(0..<10 step 3);`, float64(4)},
+		{`len(0..<0);`, float64(0)},
+		{`len(0..1 step 0.25);`, float64(5)},
+		{`(0..10 step 2)[2];`, float64(4)},
+		{`(0..10 step 2)[-1];`, float64(10)},
+		{`4 in 0..10 step 2;`, true},
+		{`5 in 0..10 step 2;`, false},
+		{`10 in 0..<10;`, false},
+		{`"${1..<4 step 2}";`, "1..<4 step 2"},
+		{`2 in [1, 2, 3];`, true},
+		{`"ell" in "hello";`, true},
+		{`"b" in {"a": 1};`, false},
+		{`len("héllo");`, float64(5)},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestRangeErrors(t *testing.T) {
+	tests := []string{
+		`0..10 step 0;`,
+		`"a"..3;`,
+		`(0..3)[4];`,
+		`1 in 2;`,
+	}
+
+	for _, code := range tests {
+		result := runInterpreter([]byte(code))
+
+		if result.Type() != ErrorObj {
+			t.Errorf("Expected %s for %q, got=%s", ErrorObj, code, result.Type())
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`match (2) { 1, 2 => "small", _ => "big" };`, "small"},
+		{`match (7) { 1, 2 => "small", _ => "big" };`, "big"},
+		{`match ("a") { "a" => 1, "b" => 2, _ => 3 };`, float64(1)},
+		{`match (-1) { -1 => "neg", _ => "other" };`, "neg"},
+		{`match (nil) { nil => "nil", _ => "other" };`, "nil"},
+		{`match ([1, 2]) { [x] => x, [x, y] => x + y, _ => 0 };`, float64(3)},
+		{`match ([1, [2, 3]]) { [_, [a, b]] => a * b, _ => 0 };`, float64(6)},
+		{`match ({"k": 5, "j": 1}) { {"k": v} => v, _ => 0 };`, float64(5)},
+		{`match ({"j": 1}) { {"k": v} => v, {} => "hash", _ => 0 };`, "hash"},
+		{`match (3) { x if x > 5 => "big", x if x > 1 => "medium", _ => "small" };`, "medium"},
+		{`match (3) { 1, x if x == 3 => "three", _ => "other" };`, "three"},
+		{`var r = 0; match (4) { 4 => { r = 40; } _ => { r = -1; } } r;`, float64(40)},
+		{`match ("a") { 1 => "one", _ => "other" };`, "other"},
+		{`var s = ""; for (var i in 0..5) { s += match (i) { 0 => "a", 1 => "b", 2 => "c", 3 => "d", _ => "?" }; } s;`, "abcd??"},
+		{`var s = ""; for (var i in -1..3) { s += match (i) { -1 => "m", 0, 1 => "z", 2 => "t", 3 => "h" }; } s;`, "mzzth"},
+		{
+			`class Point {
+				init(x, y) {
+					this.x = x;
+					this.y = y;
+				}
+			}
+			class Other {}
+			function describe(p) {
+				return match (p) {
+					Point(0, 0) => "origin",
+					Point(x, y) if x > 0 => "right ${y}",
+					Point() => "point",
+					Other() => "other",
+					_ => "nothing"
+				};
+			}
+			describe(Point(0, 0)) + "," + describe(Point(2, 7)) + "," + describe(Point(-1, 0)) + "," + describe(Other()) + "," + describe(1);`,
+			"origin,right 7,point,other,nothing",
+		},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestMatchNoArm(t *testing.T) {
+	result := runInterpreter([]byte(`match (3) { 1 => "one" };`))
+
+	if result.Type() != ErrorObj {
+		t.Fatalf("Expected %s, got=%s", ErrorObj, result.Type())
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`var [a, b] = [1, 2]; a + b;`, float64(3)},
+		{`var [a, ...rest] = [1, 2, 3]; rest[0] * 10 + rest[1];`, float64(23)},
+		{`var [a, ...rest] = [1]; len(rest);`, float64(0)},
+		{`var [a, b = 5] = [1]; a + b;`, float64(6)},
+		{`var [a, [b, c]] = [1, [2, 3]]; a + b + c;`, float64(6)},
+		{`var {name, age = 30} = {"name": "bob"}; name + " ${age}";`, "bob 30"},
+		{`var {name: n, missing: m = "none"} = {"name": "bob"}; n + m;`, "bobnone"},
+		{`var a = 1; var b = 2; [a, b] = [b, a]; a - b;`, float64(1)},
+		{`var a = 0; var b = 0; ({a, b} = {"a": 3, "b": 4}); a * b;`, float64(12)},
+		{`var arr = [0, 0]; [arr[0], arr[1]] = [5, 6]; arr[0] + arr[1];`, float64(11)},
+		{`var a = 0; var r = ([a] = [7]); len(r) + a;`, float64(8)},
+		{`function f([x, y], {z}) { return x + y + z; } f([1, 2], {"z": 3});`, float64(6)},
+		{
+			`class Point {
+				init(x, y) {
+					this.x = x;
+					this.y = y;
+				}
+			}
+			function swap(p) {
+				var {x, y} = p;
+				[p.x, p.y] = [y, x];
+				return p;
+			}
+			var p = swap(Point(1, 2));
+			"${p.x},${p.y}";`,
+			"2,1",
+		},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestDestructuringShapeMismatch(t *testing.T) {
+	tests := []string{
+		`var [a, b] = [1];`,
+		`var [a] = [1, 2];`,
+		`var [a, b] = 5;`,
+		`var {a} = {"b": 1};`,
+		`var {a} = [1];`,
+		`function f([a, b]) { return a; } f([1, 2, 3]);`,
+	}
+
+	for _, code := range tests {
+		result := runInterpreter([]byte(code))
+
+		if result.Type() != ErrorObj {
+			t.Errorf("Expected %s for %q, got=%s", ErrorObj, code, result.Type())
+		}
+	}
+}
+
+func TestDefaultVariadicAndNamedArguments(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`function f(a, b = 2) { return a + b; } f(1);`, float64(3)},
+		{`function f(a, b = 2) { return a + b; } f(1, 5);`, float64(6)},
+		{`function f(a, b = a * 10) { return a + b; } f(2);`, float64(22)},
+		{`function f(a, ...rest) { return len(rest); } f(1, 2, 3, 4);`, float64(3)},
+		{`function f(a, ...rest) { return len(rest); } f(1);`, float64(0)},
+		{`function f(a, b, c) { return a + b + c; } f(...[1, 2, 3]);`, float64(6)},
+		{`function f(a, b, c) { return "${a}${b}${c}"; } f(1, ...[2], ...[3]);`, "123"},
+		{`function f(a, b) { return a - b; } f(b: 1, a: 5);`, float64(4)},
+		{`function f(a, b = 2, c = 3) { return "${a}${b}${c}"; } f(1, c: 9);`, "129"},
+		{`function f(a, ...rest) { return rest[1]; } f(...[1, 2, 3]);`, float64(3)},
+		{`len(...["abc"]);`, float64(3)},
+		{
+			`class Point {
+				init(x, y = 0) {
+					this.x = x;
+					this.y = y;
+				}
+				sum(extra = 0) {
+					return this.x + this.y + extra;
+				}
+			}
+			var p = Point(y: 2, x: 1);
+			p.sum() + p.sum(extra: 10);`,
+			float64(16),
+		},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestArgumentErrors(t *testing.T) {
+	tests := []string{
+		`function f(a, b = 1) { return a; } f();`,
+		`function f(a) { return a; } f(1, 2);`,
+		`function f(a) { return a; } f(c: 1);`,
+		`function f(a) { return a; } f(1, a: 2);`,
+		`function f(a, b) { return a; } f(b: 2);`,
+		`function f(a) { return a; } f(...5);`,
+		`function f(a, ...rest) { return a; } f();`,
+		`len(x: "abc");`,
+	}
+
+	for _, code := range tests {
+		result := runInterpreter([]byte(code))
+
+		if result.Type() != ErrorObj {
+			t.Errorf("Expected %s for %q, got=%s", ErrorObj, code, result.Type())
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`var r = ""; try { throw "boom"; } catch (e) { r = e; } r;`, "boom"},
+		{`var r = ""; try { throw Error("boom"); } catch (e) { r = e.toString(); } r;`, "Error: boom"},
+		{`var r = ""; try { throw "a"; } catch (e) { r = e; } finally { r = r + "b"; } r;`, "ab"},
+		{`var r = ""; try { r = "a"; } catch (e) { r = "caught"; } finally { r = r + "b"; } r;`, "ab"},
+		{`var r = ""; try { throw "x"; } catch { r = "caught"; } r;`, "caught"},
+		{`var r; try { throw Error("outer", Error("inner")); } catch (e) { r = e.cause.message; } r;`, "inner"},
+		// built-in runtime errors are caught as Error instances
+		{`var r; try { 1 / 0; } catch (e) { r = e.toString(); } r;`, "Error: divide by zero: 1 / 0"},
+		{`var r; try { 1 - "a"; } catch (e) { r = e.message; } r;`, "type mismatch: Float - String"},
+		{`class A {} var r; try { A().missing; } catch (e) { r = "caught"; } r;`, "caught"},
+		// unwinding through calls, with the stack at the throw
+		{`function f() { throw Error("deep"); } function g() { f(); } var r; try { g(); } catch (e) { r = "${e.stack}"; } r;`, "[f, g, main]"},
+		{`function f() { [1][5]; } var r; try { f(); } catch (e) { r = "${e.stack}"; } r;`, "[f, main]"},
+		{`var r = ""; try { try { throw "a"; } finally { r = r + "1"; } } catch (e) { r = r + e; } r;`, "1a"},
+		{`var r = ""; try { try { throw "a"; } catch (e) { throw e + "b"; } finally { r = r + "1"; } } catch (e) { r = r + e; } r;`, "1ab"},
+		{`var log = ""; function f() { try { return "r"; } finally { log = "f"; } } var r = f(); log + r;`, "fr"},
+		{`function f() { try { return 1; } finally { return 2; } } f();`, float64(2)},
+		{`var sum = 0; for (var i in [1, 2, 3]) { try { if (i == 2) { throw i; } sum = sum + i; } catch (e) { sum = sum + 100; } } sum;`, float64(104)},
+		{
+			`class NotFound extends Error {
+				init(name) {
+					super.init("no ${name}");
+					this.name = name;
+				}
+			}
+			var r;
+			try { throw NotFound("x"); } catch (e) { r = "${e.message} ${e.name} ${e.stack}"; }
+			r;`,
+			"no x x [main]",
+		},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestUncaughtExceptions(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`throw "boom";`, "uncaught boom"},
+		{`throw Error("boom");`, "uncaught Error: boom"},
+		{`function f() { throw Error("x"); } try { f(); } finally { }`, "uncaught Error: x"},
+		{`try { throw "a"; } catch (e) { throw "b"; }`, "uncaught b"},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		err, ok := result.(*ErrorObject)
+		if !ok {
+			t.Errorf("Expected %s for %q, got=%s", ErrorObj, test.code, result.Type())
+			continue
+		}
+		if err.Message != test.expected {
+			t.Errorf("Expected message %q, got=%q", test.expected, err.Message)
+		}
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`function* count(n) { var i = 0; while (i < n) { yield i; i = i + 1; } } var s = 0; for (var x in count(5)) { s = s + x; } s;`, float64(10)},
+		{`function* nat() { var i = 0; while (true) { yield i; i = i + 1; } } var g = nat(); g.next(); g.next(); g.next();`, float64(2)},
+		{`function* echo() { var got = yield "first"; yield "got ${got}"; } var g = echo(); g.next(); g.next("x");`, "got x"},
+		{`function* two() { yield 1; yield 2; } var g = two(); g.next(); g.next(); "${g.next()}";`, "nill"},
+		{`function* g(a, b = 5) { yield a + b; } g(1).next();`, float64(6)},
+		{`function* g() { yield 1; yield 2; } var s = ""; for (var k, v in g()) { s = s + "${k}:${v} "; } s;`, "0:1 1:2 "},
+		{`function* inner() { yield 1; yield 2; } function* outer() { for (var x in inner()) { yield x * 10; } yield 99; } var s = 0; for (var v in outer()) { s = s + v; } s;`, float64(129)},
+		{
+			`function* each(xs) { for (var x in xs) { yield x * 2; } }
+			class Bag {
+				init() { this.items = [1, 2, 3]; }
+				iterator() { return each(this.items); }
+			}
+			var s = 0;
+			for (var v in Bag()) { s = s + v; }
+			s;`,
+			float64(12),
+		},
+		{`function* g() { try { yield 1; throw "x"; } catch (e) { yield "caught ${e}"; } } var gen = g(); gen.next(); gen.next();`, "caught x"},
+		{`function* bad() { yield 1; throw Error("inside"); } var g = bad(); g.next(); var r; try { g.next(); } catch (e) { r = e.message; } r;`, "inside"},
+		{`class Bag { init(items) { this.items = items; } *each(scale) { for (var x in this.items) { yield x * scale; } } } var s = 0; for (var v in Bag([1, 2, 3]).each(10)) { s = s + v; } s;`, float64(60)},
+		{`class Bag { init() { this.items = [4, 5]; } *iterator() { for (var x in this.items) { yield x; } } } var s = ""; for (var v in Bag()) { s = s + "${v}"; } s;`, "45"},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestGeneratorClose(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`var closed = false; function* nat() { try { var i = 0; while (true) { yield i; i = i + 1; } } finally { closed = true; } } var s = 0; for (var x in nat()) { if (x == 3) { break; } s = s + x; } "${s} ${closed}";`, "3 true"},
+		{`var caught = false; function* g() { try { yield 1; yield 2; } catch (e) { caught = true; } } for (var x in g()) { break; } caught;`, false},
+		{`var closed = false; function* g() { try { yield 1; } finally { closed = true; } } for (var x in g()) { } closed;`, true},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestGeneratorGoroutines(t *testing.T) {
+	// settle waits for the goroutines of closed generators to end
+	settle := func(baseline int) int {
+		for attempt := 0; attempt < 100 && runtime.NumGoroutine() > baseline; attempt++ {
+			runtime.GC()
+			time.Sleep(10 * time.Millisecond)
+		}
+		return runtime.NumGoroutine()
+	}
+	baseline := settle(0)
+
+	runInterpreter([]byte(`function* nat() { var i = 0; while (true) { yield i; i = i + 1; } }
+		for (var n in 0..<50) { for (var x in nat()) { if (x == 2) { break; } } }`))
+	if count := settle(baseline); count > baseline {
+		t.Errorf("Expected generators left by break to end, got=%d goroutines, want %d", count, baseline)
+	}
+
+	runInterpreter([]byte(`function* nat() { var i = 0; while (true) { yield i; i = i + 1; } }
+		for (var n in 0..<50) { nat().next(); }`))
+	if count := settle(baseline); count > baseline {
+		t.Errorf("Expected dropped generators to end, got=%d goroutines, want %d", count, baseline)
+	}
+}
+
+func TestVMOnlyFeatures(t *testing.T) {
+	tests := []string{
+		`async function f() { return 1; } f();`,
+		`await 1;`,
+		`function f() { return 1; } spawn f();`,
+		`import "math" as m;`,
+	}
+
+	for _, input := range tests {
+		result := runInterpreter([]byte(input))
+		err, ok := result.(*ErrorObject)
+		if !ok {
+			t.Errorf("Expected an error for %q, got=%v", input, result)
+			continue
+		}
+		if !strings.HasPrefix(err.Message, vmOnlyError) {
+			t.Errorf("Expected %q to be %s, got=%q", input, vmOnlyError, err.Message)
+		}
+	}
+}
+
+func TestLetAndConst(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		{`const a = 2; a * 3;`, float64(6)},
+		{`let x = 1; var r; if (true) { let x = 2; r = x; } r + x;`, float64(3)},
+		{`var s = 0; for (let i = 0; i < 3; i = i + 1) { s = s + i; } for (let i = 0; i < 2; i = i + 1) { s = s + i; } s;`, float64(4)},
+		{`var s = 0; for (const v in [1, 2, 3]) { s = s + v; } s;`, float64(6)},
+		{`const [a, b] = [1, 2]; a + b;`, float64(3)},
+		{`x = 5; x;`, float64(5)},
+		{`"use strict"; var x; function f() { x = 5; } f(); x;`, float64(5)},
+		{`"use strict"; function f() { y = 5; } var y; f(); y;`, float64(5)},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestResolvedScopes(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected interface{}
+	}{
+		// a closure keeps the variable it saw, not one declared after it
+		{`var a = "global"; var r = ""; if (true) { function show() { r = r + a; } show(); var a = "block"; show(); } r;`, "globalglobal"},
+		{`var a = 1; if (true) { function set() { a = 2; } var a = 3; set(); } a;`, float64(2)},
+		{`function counter() { var n = 0; function inc() { n = n + 1; return n; } return inc; } var c = counter(); c(); c(); c();`, float64(3)},
+		{`function f(a, b = a + 1) { var c = a + b; if (true) { var d = c * 2; return d; } } f(1);`, float64(6)},
+		{`var s = 0; for (var i = 0; i < 3; i++) { var j = i; s += j; } s;`, float64(3)},
+		{`class A { init(x) { this.x = x; } get() { var y = this.x; return y; } } A(4).get();`, float64(4)},
+		{`var r = 0; try { throw 5; } catch (e) { var f = e + 1; r = f; } r;`, float64(6)},
+		{`var r; match ([1, 2]) { [a, b] => { var c = a + b; r = c; } } r;`, float64(3)},
+		// an alternative binds its names in an order of its own, or none at
+		// all, and they still land in the slots the resolver gave them
+		{`match ([2, 3]) { [1, a], [b, a] => "${a}${b}" };`, "32"},
+		{`var r; match ([2, 3]) { [1, a], [b, a] => { var c = a + b; c += 1; r = c; } } r;`, float64(6)},
+		{`var a = 9; match (5) { [a], b => "${b}" };`, "5"},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestResolvedSlotMismatch(t *testing.T) {
+	// the slots are checked by name rather than trusted, so a scope the
+	// resolver got wrong fails loudly instead of reading the wrong variable
+	env := NewEnvironment()
+	env.Define("a", &FloatObject{Value: 1})
+	inner := NewEnclosingEnvironment(env)
+	inner.Define("b", &FloatObject{Value: 2})
+
+	if obj, ok := inner.GetAt(1, 0, "a"); !ok || obj.Inspect() != "1" {
+		t.Errorf("Expected a in its slot, got=%v %v", obj, ok)
+	}
+	if _, ok := inner.GetAt(0, 0, "a"); ok {
+		t.Errorf("Expected a not to be found in the slot of b")
+	}
+	if inner.SetAt(0, 0, "a", True) {
+		t.Errorf("Expected a not to be stored over b")
+	}
+
+	interpreter := NewInterpreter()
+	node := &Identifier{Value: "a"}
+	interpreter.locals[node] = Location{Depth: 0, Slot: 0}
+	result := interpreter.VisitIdentifier(node, inner)
+	err, ok := result.(*ErrorObject)
+	if !ok || !strings.HasPrefix(err.Message, internalError) {
+		t.Errorf("Expected an internal error, got=%v", result)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`const a = 1; a = 2;`, "Can't assign to constant 'a'."},
+		{`const a = 1; a += 2;`, "Can't assign to constant 'a'."},
+		{`const a = 1; a++;`, "Can't assign to constant 'a'."},
+		{`const a = 1; function f() { a = 2; }`, "Can't assign to constant 'a'."},
+		{`const a = 1; var b; [a, b] = [1, 2];`, "Can't assign to constant 'a'."},
+		{`for (const i = 0; i < 3; i++) { }`, "Can't assign to constant 'i'."},
+		{`for (const v in [1]) { v = 2; }`, "Can't assign to constant 'v'."},
+		{`"use strict"; x = 5;`, "Can't assign to undeclared variable 'x'."},
+		{`"use strict"; function f() { x += 1; }`, "Can't assign to undeclared variable 'x'."},
+		{`"use strict"; if (true) { var x; } x = 1;`, "Can't assign to undeclared variable 'x'."},
+		{`var a = 1; if (true) { var a = a + 1; }`, "Can't read local variable 'a' in its own initializer."},
+		{`function f() { let b = [b]; }`, "Can't read local variable 'b' in its own initializer."},
+		{`return 1;`, "Can't return from top-level code."},
+		{`if (true) { return; }`, "Can't return from top-level code."},
+		{`var t = this;`, "Can't use 'this' outside of a class."},
+		{`function f() { return this; }`, "Can't use 'this' outside of a class."},
+		{`class A { f() { return super.f(); } }`, "Can't use 'super' in a class with no superclass."},
+		{`function f() { super.f(); }`, "Can't use 'super' outside of a class."},
+	}
+
+	for _, test := range tests {
+		result := runInterpreter([]byte(test.code))
+
+		err, ok := result.(*ErrorObject)
+		if !ok {
+			t.Errorf("Expected %s for %q, got=%v", ErrorObj, test.code, result)
+			continue
+		}
+		expected := resolveError + ": " + test.expected
+		if err.Message != expected {
+			t.Errorf("Expected message %q, got=%q", expected, err.Message)
+		}
+	}
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LintConfigFile holds the settings of lox lint. It is looked for in the
+// current directory and then in each directory above it.
+const LintConfigFile = ".loxlint"
+
+// SyntaxRule names the findings for files that don't parse.
+const SyntaxRule = "syntax"
+
+// LintConfig is the JSON content of a .loxlint file, such as
+//
+//	{"rules": {"empty-block": false}, "maxFunctionLength": 30}
+type LintConfig struct {
+	// Rules turns rules on or off by name, those left out are on
+	Rules map[string]bool `json:"rules"`
+	// MaxFunctionLength is the most lines a function may span
+	MaxFunctionLength int `json:"maxFunctionLength"`
+}
+
+func DefaultLintConfig() *LintConfig {
+	return &LintConfig{Rules: make(map[string]bool), MaxFunctionLength: 50}
+}
+
+// LoadLintConfig reads the config file at path over the defaults.
+func LoadLintConfig(path string) (*LintConfig, error) {
+	config := DefaultLintConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name := range config.Rules {
+		if findLintRule(name) == nil {
+			return nil, fmt.Errorf("%s: unknown rule %q", path, name)
+		}
+	}
+	return config, nil
+}
+
+// findLintConfig returns the nearest .loxlint in dir or above, or "".
+func findLintConfig(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+
+	for {
+		path := filepath.Join(dir, LintConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func (c *LintConfig) enabled(rule string) bool {
+	on, ok := c.Rules[rule]
+	return !ok || on
+}
+
+// LintFix replaces Old, found at Line and Column, with New.
+type LintFix struct {
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+type LintFinding struct {
+	File    string   `json:"file"`
+	Line    int      `json:"line"`
+	Column  int      `json:"column"`
+	Rule    string   `json:"rule"`
+	Message string   `json:"message"`
+	Fix     *LintFix `json:"fix,omitempty"`
+}
+
+func (f *LintFinding) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s (%s)", f.File, f.Line, f.Column, f.Message, f.Rule)
+}
+
+// LintRule checks programs for one kind of problem, reporting what it
+// finds to the linter.
+type LintRule struct {
+	Name        string
+	Description string
+	Check       func(l *Linter, program *Program)
+}
+
+var lintRules = []*LintRule{
+	{Name: "naming", Description: "classes are UpperCamelCase, everything else lowerCamelCase, constants may be UPPER_CASE", Check: checkNaming},
+	{Name: "function-length", Description: "functions are no longer than maxFunctionLength lines", Check: checkFunctionLength},
+	{Name: "nil-comparison", Description: "conditions test truthiness rather than compare with nil", Check: checkNilComparison},
+	{Name: "empty-block", Description: "if, loop and try blocks aren't empty", Check: checkEmptyBlocks},
+	{Name: "assignment-in-condition", Description: "conditions don't assign, unless in parentheses", Check: checkAssignmentInCondition},
+	{Name: "prefer-const", Description: "let variables that are never reassigned are const", Check: checkPreferConst},
+}
+
+func findLintRule(name string) *LintRule {
+	for _, rule := range lintRules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Linter runs the enabled rules over one file at a time. A finding can be
+// turned off with a "// lox:ignore <rule>" comment on its line.
+type Linter struct {
+	Config       *LintConfig
+	file         string
+	suppressions Suppressions
+	findings     []*LintFinding
+}
+
+func NewLinter(config *LintConfig) *Linter {
+	return &Linter{Config: config}
+}
+
+// Lint returns what the rules find in source, in source order. A file
+// that doesn't parse has its first syntax error as its only finding.
+func (l *Linter) Lint(file string, source []byte) []*LintFinding {
+	l.file = file
+	l.findings = nil
+
+	scanner := NewScanner(source)
+	scanner.scanTokens()
+	if scanner.Errors().HasErrors() {
+		err := scanner.Errors().Errors[0]
+		return []*LintFinding{{File: file, Line: err.Line, Column: 1, Rule: SyntaxRule, Message: err.Message}}
+	}
+
+	parser := NewParser(scanner.Tokens())
+	program := parser.parse()
+	if parser.Errors().HasErrors() {
+		err := parser.Errors().Errors[0]
+		return []*LintFinding{{File: file, Line: err.Line, Column: 1, Rule: SyntaxRule, Message: err.Message}}
+	}
+
+	l.suppressions = NewSuppressions(program.Comments)
+	for _, rule := range lintRules {
+		if l.Config.enabled(rule.Name) {
+			rule.Check(l, program)
+		}
+	}
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		if l.findings[i].Line != l.findings[j].Line {
+			return l.findings[i].Line < l.findings[j].Line
+		}
+		return l.findings[i].Column < l.findings[j].Column
+	})
+	return l.findings
+}
+
+func (l *Linter) report(rule string, token Token, format string, args ...interface{}) *LintFinding {
+	finding := &LintFinding{File: l.file, Line: token.Line, Column: token.Column, Rule: rule, Message: fmt.Sprintf(format, args...)}
+	if !l.suppressions.Ignores(token.Line, rule) {
+		l.findings = append(l.findings, finding)
+	}
+	return finding
+}
+
+var (
+	upperCamelCase = regexp.MustCompile(`^_*[A-Z][A-Za-z0-9]*$`)
+	lowerCamelCase = regexp.MustCompile(`^_*([a-z][A-Za-z0-9]*)?$`)
+	upperSnakeCase = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+)
+
+func checkNaming(l *Linter, program *Program) {
+	lower := func(kind string, name *Identifier) {
+		if name != nil && !lowerCamelCase.MatchString(name.Value) {
+			l.report("naming", name.Token, "%s name %s should be lowerCamelCase", kind, name.Value)
+		}
+	}
+	params := func(fun *FunctionCommon) {
+		for _, param := range fun.Params {
+			lower("parameter", param)
+		}
+		lower("parameter", fun.Rest)
+	}
+
+	Walk(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *ClassStatement:
+			if !upperCamelCase.MatchString(node.Name.Value) {
+				l.report("naming", node.Name.Token, "class name %s should be UpperCamelCase", node.Name.Value)
+			}
+		case *FunctionDeclaration:
+			lower("function", node.Name)
+			params(&node.FunctionCommon)
+		case *FunctionLiteral:
+			lower("function", node.Name)
+			params(&node.FunctionCommon)
+		case *MethodDeclaration:
+			lower("method", node.Name)
+			params(&node.FunctionCommon)
+		case *VarStatement:
+			if node.Token.Type == CONST && upperSnakeCase.MatchString(node.Identifier.Value) {
+				break
+			}
+			lower("variable", node.Identifier)
+		case *ForInStatement:
+			lower("variable", node.Key)
+			lower("variable", node.Value)
+		case *TryStatement:
+			lower("variable", node.CatchName)
+		}
+		return true
+	})
+}
+
+func checkFunctionLength(l *Linter, program *Program) {
+	check := func(fun *FunctionCommon, kind string) {
+		if fun.Body == nil {
+			return
+		}
+
+		last := fun.Token.Line
+		Walk(fun.Body, func(node Node) bool {
+			if line := nodeToken(node).Line; line > last {
+				last = line
+			}
+			return true
+		})
+
+		if lines := last - fun.Token.Line + 1; lines > l.Config.MaxFunctionLength {
+			name := "anonymous function"
+			if fun.Name != nil {
+				name = kind + " " + fun.Name.Value
+			}
+			l.report("function-length", fun.Token, "%s is %d lines long, more than %d", name, lines, l.Config.MaxFunctionLength)
+		}
+	}
+
+	Walk(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *FunctionDeclaration:
+			check(&node.FunctionCommon, "function")
+		case *FunctionLiteral:
+			check(&node.FunctionCommon, "function")
+		case *MethodDeclaration:
+			check(&node.FunctionCommon, "method")
+		}
+		return true
+	})
+}
+
+// conditions calls check with the condition of every if, loop and ternary.
+func conditions(program *Program, check func(condition Expression)) {
+	Walk(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *IfStatement:
+			check(node.Condition)
+		case *While:
+			check(node.Condition)
+		case *For:
+			if node.Condition != nil {
+				check(node.Condition)
+			}
+		case *TernaryExpression:
+			check(node.Condition)
+		}
+		return true
+	})
+}
+
+func checkNilComparison(l *Linter, program *Program) {
+	var check func(condition Expression)
+	check = func(condition Expression) {
+		switch condition := condition.(type) {
+		case *Logical:
+			check(condition.Left)
+			check(condition.Right)
+		case *Unary:
+			check(condition.Right)
+		case *GroupedExpression:
+			check(condition.Expression)
+		case *Binary:
+			if condition.Operator != EQUAL_EQUAL && condition.Operator != BANG_EQUAL {
+				return
+			}
+			_, left := condition.Left.(*NilLiteral)
+			_, right := condition.Right.(*NilLiteral)
+			if left || right {
+				l.report("nil-comparison", condition.Token, "condition compares with nil, test the value itself unless it can be false")
+			}
+		}
+	}
+
+	conditions(program, check)
+}
+
+func checkEmptyBlocks(l *Linter, program *Program) {
+	check := func(block *BlockStatement, kind string) {
+		if block != nil && len(block.Statements) == 0 {
+			l.report("empty-block", block.Token, "empty %s block", kind)
+		}
+	}
+
+	Walk(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *IfStatement:
+			check(node.ThenBranch, "if")
+			check(node.ElseBranch, "else")
+		case *While:
+			check(node.Body, "while")
+		case *For:
+			check(node.Body, "for")
+		case *ForInStatement:
+			check(node.Body, "for")
+		case *TryStatement:
+			check(node.Body, "try")
+			check(node.Catch, "catch")
+			check(node.Finally, "finally")
+		}
+		return true
+	})
+}
+
+func checkAssignmentInCondition(l *Linter, program *Program) {
+	conditions(program, func(condition Expression) {
+		switch condition := condition.(type) {
+		case *Assignment:
+			l.report("assignment-in-condition", condition.Identifier.Token, "condition assigns to %s, wrap it in parentheses if that is meant", condition.Identifier.Value)
+		case *CompoundAssignment, *DestructureAssignment:
+			l.report("assignment-in-condition", nodeToken(condition), "condition assigns, wrap it in parentheses if that is meant")
+		}
+	})
+}
+
+// checkPreferConst offers to turn let into const when nothing in the
+// scope of the variables assigns to them, nested functions included. A let
+// without an initializer stays, as a const needs one.
+func checkPreferConst(l *Linter, program *Program) {
+	checkStatements := func(statements []Statement) {
+		for _, stmt := range statements {
+			var token Token
+			var names []string
+			switch stmt := stmt.(type) {
+			case *VarStatement:
+				if !initialized(stmt) {
+					continue
+				}
+				token = stmt.Token
+				names = []string{stmt.Identifier.Value}
+			case *DestructureStatement:
+				token = stmt.Token
+				names = patternNames(stmt.Pattern)
+			case *For:
+				// the loop's let is only seen by the loop
+				if init, ok := stmt.Initializer.(*VarStatement); ok && init.Token.Type == LET && initialized(init) {
+					if !assignsTo(stmt, init.Identifier.Value) {
+						l.preferConst(init.Token, init.Identifier.Value)
+					}
+				}
+				continue
+			default:
+				continue
+			}
+			if token.Type != LET {
+				continue
+			}
+
+			assigned := false
+			for _, other := range statements {
+				for _, name := range names {
+					assigned = assigned || assignsTo(other, name)
+				}
+			}
+			if !assigned {
+				l.preferConst(token, strings.Join(names, ", "))
+			}
+		}
+	}
+
+	Walk(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *Program:
+			checkStatements(node.Statements)
+		case *BlockStatement:
+			checkStatements(node.Statements)
+		}
+		return true
+	})
+}
+
+// initialized reports whether the declaration has an initializer; the
+// parser gives one without it a nil of its own, which has no token.
+func initialized(stmt *VarStatement) bool {
+	if literal, ok := stmt.Expression.(*NilLiteral); ok {
+		return literal.Token.Lexeme != ""
+	}
+	return stmt.Expression != nil
+}
+
+func (l *Linter) preferConst(token Token, names string) {
+	finding := l.report("prefer-const", token, "%s is never reassigned, declare it with const", names)
+	finding.Fix = &LintFix{Line: token.Line, Column: token.Column, Old: token.Lexeme, New: "const"}
+}
+
+// assignsTo reports whether anything in node assigns to a variable called
+// name, whichever variable that is.
+func assignsTo(node Node, name string) bool {
+	found := false
+	target := func(expr Expression) {
+		if identifier, ok := expr.(*Identifier); ok && identifier.Value == name {
+			found = true
+		}
+	}
+
+	Walk(node, func(node Node) bool {
+		switch node := node.(type) {
+		case *Assignment:
+			target(&node.Identifier)
+		case *CompoundAssignment:
+			target(node.Target)
+		case *UpdateExpression:
+			target(node.Target)
+		case *DestructureAssignment:
+			for _, assigned := range patternNames(node.Pattern) {
+				found = found || assigned == name
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// patternNames returns the variables a destructuring pattern binds.
+func patternNames(pattern Destructure) []string {
+	var names []string
+	var elements []*DestructureElement
+	var rest Expression
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		elements = pattern.Elements
+		rest = pattern.Rest
+	case *HashDestructure:
+		elements = pattern.Entries
+	}
+
+	for _, element := range elements {
+		if element.Pattern != nil {
+			names = append(names, patternNames(element.Pattern)...)
+		} else if name, ok := element.Target.(*Identifier); ok {
+			names = append(names, name.Value)
+		}
+	}
+	if name, ok := rest.(*Identifier); ok {
+		names = append(names, name.Value)
+	}
+	return names
+}
+
+// ApplyFixes makes the fixes to source and returns the result, along with
+// the fixes it skipped because their text wasn't where they said.
+func ApplyFixes(source []byte, fixes []*LintFix) ([]byte, []*LintFix) {
+	lineStarts := []int{0}
+	for idx, c := range source {
+		if c == '\n' {
+			lineStarts = append(lineStarts, idx+1)
+		}
+	}
+
+	// from the end, so earlier offsets stay put
+	sorted := append([]*LintFix(nil), fixes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Line != sorted[j].Line {
+			return sorted[i].Line > sorted[j].Line
+		}
+		return sorted[i].Column > sorted[j].Column
+	})
+
+	result := append([]byte(nil), source...)
+	var skipped []*LintFix
+	for _, fix := range sorted {
+		if fix.Line < 1 || fix.Line > len(lineStarts) {
+			skipped = append(skipped, fix)
+			continue
+		}
+		start := lineStarts[fix.Line-1] + fix.Column - 1
+		end := start + len(fix.Old)
+		if start < 0 || end > len(result) || string(result[start:end]) != fix.Old {
+			skipped = append(skipped, fix)
+			continue
+		}
+
+		result = append(result[:start], append([]byte(fix.New), result[end:]...)...)
+	}
+	return result, skipped
+}
+
+// loxFiles expands the paths given to lox lint and lox fmt: a file
+// stands for itself, a directory for the .lox files in it and "dir/..."
+// for those anywhere below dir.
+func loxFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if dir, ok := strings.CutSuffix(path, "..."); ok {
+			if dir == "" {
+				dir = "."
+			}
+			err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !entry.IsDir() && filepath.Ext(path) == ModuleExtension {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && filepath.Ext(entry.Name()) == ModuleExtension {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// runLint is lox lint. It exits with 1 when there are findings left and
+// with 2 when it can't do its job.
+func runLint(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "text", "output format, text or json")
+	fix := flags.Bool("fix", false, "apply the safe fixes to the files")
+	configPath := flags.String("config", "", "config file, instead of the nearest "+LintConfigFile)
+	rules := flags.Bool("rules", false, "list the rules and exit")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: jlox lint [flags] [path ...]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *rules {
+		for _, rule := range lintRules {
+			fmt.Fprintf(stdout, "%-24s %s\n", rule.Name, rule.Description)
+		}
+		return 0
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	config := DefaultLintConfig()
+	if *configPath == "" {
+		*configPath = findLintConfig(".")
+	}
+	if *configPath != "" {
+		loaded, err := LoadLintConfig(*configPath)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		config = loaded
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"./..."}
+	}
+	files, err := loxFiles(paths)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	linter := NewLinter(config)
+	findings := []*LintFinding{}
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+
+		found := linter.Lint(file, source)
+		if *fix {
+			found, err = fixFile(file, source, found)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return 2
+			}
+		}
+		findings = append(findings, found...)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(findings)
+	} else {
+		for _, finding := range findings {
+			fmt.Fprintln(stdout, finding)
+		}
+	}
+
+	if len(findings) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// fixFile writes the fixes of findings to file, returning the findings
+// that are left, those a fix couldn't be made for included.
+func fixFile(file string, source []byte, findings []*LintFinding) ([]*LintFinding, error) {
+	var fixes []*LintFix
+	for _, finding := range findings {
+		if finding.Fix != nil {
+			fixes = append(fixes, finding.Fix)
+		}
+	}
+	if len(fixes) == 0 {
+		return findings, nil
+	}
+
+	fixed, skipped := ApplyFixes(source, fixes)
+	left := []*LintFinding{}
+	for _, finding := range findings {
+		if finding.Fix == nil || containsFix(skipped, finding.Fix) {
+			left = append(left, finding)
+		}
+	}
+	return left, os.WriteFile(file, fixed, 0644)
+}
+
+func containsFix(fixes []*LintFix, fix *LintFix) bool {
+	for _, f := range fixes {
+		if f == fix {
+			return true
+		}
+	}
+	return false
+}
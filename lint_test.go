@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintRules(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected []string
+	}{
+		{`class point { }`, []string{"1:7 naming"}},
+		{`function Bad_name(X, ...Rest) { return X + Rest; }`, []string{"1:10 naming", "1:19 naming", "1:25 naming"}},
+		{`const MAX_SIZE = 1; const maxSize = 2; var _tmp = 3;`, nil},
+		{`var x; if (x == nil) { x = 1; }`, []string{"1:14 nil-comparison"}},
+		{`var x; while (!(nil != x) and true) { x = 1; }`, []string{"1:21 nil-comparison"}},
+		{`var x; if (x) { }`, []string{"1:15 empty-block"}},
+		{`try { } catch (e) { print(e); }`, []string{"1:5 empty-block"}},
+		{`var x; if (x = 1) { print(x); }`, []string{"1:12 assignment-in-condition"}},
+		{`var x; if ((x = 1)) { print(x); }`, nil},
+		{`let a = 1; let b = 2; b = 3; print(a + b);`, []string{"1:1 prefer-const"}},
+		{`let [a, b] = [1, 2]; a += 1;`, nil},
+		{`for (let i = 0; i < 3; i++) { print(i); }`, nil},
+		{`let x; print(x);`, nil},
+		{`let x = nil; print(x);`, []string{"1:1 prefer-const"}},
+		{`function f() { let n = 1; function g() { n = 2; } g(); return n; }`, nil},
+		{`class point { } // lox:ignore naming`, nil},
+		{`var x; if (x == nil) { } // lox:ignore`, nil},
+	}
+
+	for _, test := range tests {
+		linter := NewLinter(DefaultLintConfig())
+		findings := linter.Lint("test.lox", []byte(test.code))
+
+		var got []string
+		for _, finding := range findings {
+			got = append(got, fmt.Sprintf("%d:%d %s", finding.Line, finding.Column, finding.Rule))
+		}
+		if strings.Join(got, ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("Expected findings %v for %q, got=%v", test.expected, test.code, findings)
+		}
+	}
+}
+
+func TestLintFunctionLength(t *testing.T) {
+	code := "function long() {\n  var a = 1;\n  var b = 2;\n  return a + b;\n}\nfunction short() { return 1; }"
+
+	config := DefaultLintConfig()
+	config.MaxFunctionLength = 3
+	findings := NewLinter(config).Lint("test.lox", []byte(code))
+
+	if len(findings) != 1 {
+		t.Fatalf("Expected 1 finding, got=%v", findings)
+	}
+	expected := "function long is 4 lines long, more than 3"
+	if findings[0].Rule != "function-length" || findings[0].Message != expected {
+		t.Errorf("Expected %q, got=%v", expected, findings[0])
+	}
+}
+
+func TestLintSyntaxError(t *testing.T) {
+	findings := NewLinter(DefaultLintConfig()).Lint("test.lox", []byte("var = ;"))
+
+	if len(findings) != 1 || findings[0].Rule != SyntaxRule {
+		t.Errorf("Expected a single %s finding, got=%v", SyntaxRule, findings)
+	}
+}
+
+func TestApplyFixes(t *testing.T) {
+	source := "let a = 1;\n  let b = a; let c = b;\n"
+	fixes := []*LintFix{
+		{Line: 2, Column: 3, Old: "let", New: "const"},
+		{Line: 2, Column: 14, Old: "let", New: "const"},
+		{Line: 1, Column: 1, Old: "var", New: "const"},
+	}
+
+	fixed, skipped := ApplyFixes([]byte(source), fixes)
+
+	expected := "let a = 1;\n  const b = a; const c = b;\n"
+	if string(fixed) != expected {
+		t.Errorf("Expected %q, got=%q", expected, fixed)
+	}
+	if len(skipped) != 1 || skipped[0] != fixes[2] {
+		t.Errorf("Expected the fix of line 1 to be skipped, got=%v", skipped)
+	}
+}
+
+func TestLintFixUninitialized(t *testing.T) {
+	source := "let x; x = 1;\nlet y;\nlet z = y;\nprint(x, z);\n"
+
+	var fixes []*LintFix
+	for _, finding := range NewLinter(DefaultLintConfig()).Lint("test.lox", []byte(source)) {
+		if finding.Fix != nil {
+			fixes = append(fixes, finding.Fix)
+		}
+	}
+	fixed, _ := ApplyFixes([]byte(source), fixes)
+
+	expected := "let x; x = 1;\nlet y;\nconst z = y;\nprint(x, z);\n"
+	if string(fixed) != expected {
+		t.Errorf("Expected %q, got=%q", expected, fixed)
+	}
+}
+
+func TestRunLint(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.lox":     "let a = 1;\nprint(a);\n",
+		"lib/util.lox": "class thing { }\n",
+		"lib/skip.txt": "class thing { }\n",
+		".loxlint":     `{"rules": {"prefer-const": false}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := runLint([]string{"-config", filepath.Join(dir, ".loxlint"), "-format", "json", dir + "/..."}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("Expected exit code 1, got=%d (%s)", code, stderr.String())
+	}
+
+	var findings []*LintFinding
+	if err := json.Unmarshal(stdout.Bytes(), &findings); err != nil {
+		t.Fatalf("invalid json %q: %s", stdout.String(), err)
+	}
+	if len(findings) != 1 || findings[0].Rule != "naming" || filepath.Base(findings[0].File) != "util.lox" {
+		t.Errorf("Expected one naming finding in util.lox, got=%v", findings)
+	}
+
+	stdout.Reset()
+	code = runLint([]string{"-fix", filepath.Join(dir, "main.lox")}, &stdout, &stderr)
+	if code != 0 {
+		t.Errorf("Expected exit code 0 once fixed, got=%d (%s)", code, stdout.String())
+	}
+	fixed, _ := os.ReadFile(filepath.Join(dir, "main.lox"))
+	if string(fixed) != "const a = 1;\nprint(a);\n" {
+		t.Errorf("Expected main.lox to be fixed, got=%q", fixed)
+	}
+
+	if code := runLint([]string{"-format", "xml", dir}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected exit code 2 for an unknown format, got=%d", code)
+	}
+}
@@ -0,0 +1,709 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// JSON-RPC error codes the language server answers with
+const (
+	lspParseError     = -32700
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+	lspInternalError  = -32603
+)
+
+// LSP kinds of completion items and document symbols
+const (
+	completionFunction = 3
+	completionVariable = 6
+	completionClass    = 7
+	completionMethod   = 2
+	completionKeyword  = 14
+
+	symbolClass    = 5
+	symbolMethod   = 6
+	symbolFunction = 12
+	symbolVariable = 13
+	symbolConstant = 14
+)
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type lspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Detail         string              `json:"detail,omitempty"`
+	Kind           int                 `json:"kind"`
+	Range          lspRange            `json:"range"`
+	SelectionRange lspRange            `json:"selectionRange"`
+	Children       []lspDocumentSymbol `json:"children,omitempty"`
+}
+
+type lspTextDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+// document is a file the client has open, as last sent.
+type document struct {
+	uri   string
+	lines []string
+	// index is from the last version of the text that parsed
+	index *SymbolIndex
+}
+
+// character converts a line and byte column, counting from 1, to the
+// UTF-16 offset LSP positions use.
+func (d *document) character(line, column int) int {
+	if line < 1 || line > len(d.lines) {
+		return 0
+	}
+	text := d.lines[line-1]
+	if column-1 < len(text) {
+		text = text[:max(column-1, 0)]
+	}
+	return len(utf16.Encode([]rune(text)))
+}
+
+// column converts an LSP line and UTF-16 offset to a byte column
+// counting from 1.
+func (d *document) column(line, character int) int {
+	if line < 0 || line >= len(d.lines) {
+		return 1
+	}
+	text := d.lines[line]
+	units, offset := 0, 0
+	for offset < len(text) && units < character {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset + 1
+}
+
+func (d *document) tokenRange(token Token) lspRange {
+	return lspRange{
+		Start: lspPosition{token.Line - 1, d.character(token.Line, token.Column)},
+		End:   lspPosition{token.Line - 1, d.character(token.Line, token.Column+len(token.Lexeme))},
+	}
+}
+
+// lineRange spans line, counting from 1, for the errors that only know
+// their line.
+func (d *document) lineRange(line int) lspRange {
+	line = max(line, 1)
+	end := 0
+	if line <= len(d.lines) {
+		end = d.character(line, len(d.lines[line-1])+1)
+	}
+	return lspRange{Start: lspPosition{line - 1, 0}, End: lspPosition{line - 1, end}}
+}
+
+func (d *document) errorRange(err *Error) lspRange {
+	if err.Token.Line > 0 && err.Token.Column > 0 {
+		return d.tokenRange(err.Token)
+	}
+	return d.lineRange(err.Line)
+}
+
+// LanguageServer answers the requests of an editor about lox files over
+// the Language Server Protocol.
+type LanguageServer struct {
+	documents map[string]*document
+	out       io.Writer
+	shutdown  bool
+}
+
+func NewLanguageServer() *LanguageServer {
+	return &LanguageServer{documents: make(map[string]*document)}
+}
+
+// Serve answers the messages read from in on out until the client says
+// to exit, which is an error unless it asked to shut down first.
+func (s *LanguageServer) Serve(in io.Reader, out io.Writer) error {
+	s.out = out
+	reader := bufio.NewReader(in)
+	for {
+		content, err := readMessage(reader)
+		if err != nil {
+			return err
+		}
+
+		var message lspMessage
+		if err := json.Unmarshal(content, &message); err != nil {
+			s.send(lspMessage{ID: rawNull(), Error: &lspError{lspParseError, err.Error()}})
+			continue
+		}
+		if message.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit without shutdown")
+			}
+			return nil
+		}
+		s.handle(message)
+	}
+}
+
+func readMessage(reader *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("bad Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without a Content-Length")
+	}
+
+	content := make([]byte, length)
+	_, err := io.ReadFull(reader, content)
+	return content, err
+}
+
+func (s *LanguageServer) send(message lspMessage) {
+	message.JSONRPC = "2.0"
+	content, _ := json.Marshal(message)
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func (s *LanguageServer) notify(method string, params interface{}) {
+	content, _ := json.Marshal(params)
+	s.send(lspMessage{Method: method, Params: content})
+}
+
+func rawNull() *json.RawMessage {
+	null := json.RawMessage("null")
+	return &null
+}
+
+// handle answers a request; notifications, which have no ID, get no
+// answer.
+func (s *LanguageServer) handle(message lspMessage) {
+	handler, ok := lspHandlers[message.Method]
+	if !ok {
+		if message.ID != nil {
+			s.send(lspMessage{ID: message.ID, Error: &lspError{lspMethodNotFound, "unknown method " + message.Method}})
+		}
+		return
+	}
+
+	result, err := handler(s, message.Params)
+	if message.ID == nil {
+		return
+	}
+	if err != nil {
+		var lspErr *lspError
+		if !errors.As(err, &lspErr) {
+			lspErr = &lspError{lspInternalError, err.Error()}
+		}
+		s.send(lspMessage{ID: message.ID, Error: lspErr})
+		return
+	}
+
+	content, _ := json.Marshal(result)
+	s.send(lspMessage{ID: message.ID, Result: content})
+}
+
+// lspHandlers answer the methods the server knows.
+var lspHandlers = map[string]func(s *LanguageServer, params json.RawMessage) (interface{}, error){
+	"initialize":                  (*LanguageServer).initialize,
+	"initialized":                 ignoreParams,
+	"shutdown":                    (*LanguageServer).shutdownServer,
+	"textDocument/didOpen":        (*LanguageServer).didOpen,
+	"textDocument/didChange":      (*LanguageServer).didChange,
+	"textDocument/didClose":       (*LanguageServer).didClose,
+	"textDocument/didSave":        ignoreParams,
+	"textDocument/definition":     (*LanguageServer).definition,
+	"textDocument/references":     (*LanguageServer).references,
+	"textDocument/hover":          (*LanguageServer).hover,
+	"textDocument/completion":     (*LanguageServer).completion,
+	"textDocument/documentSymbol": (*LanguageServer).documentSymbol,
+	"textDocument/rename":         (*LanguageServer).rename,
+}
+
+func ignoreParams(s *LanguageServer, params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &lspError{lspInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+func (s *LanguageServer) initialize(params json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":       1, // the whole text on every change
+			"definitionProvider":     true,
+			"referencesProvider":     true,
+			"hoverProvider":          true,
+			"documentSymbolProvider": true,
+			"renameProvider":         true,
+			"completionProvider": map[string]interface{}{
+				"triggerCharacters": []string{"."},
+			},
+		},
+		"serverInfo": map[string]string{"name": "lox"},
+	}, nil
+}
+
+func (s *LanguageServer) shutdownServer(params json.RawMessage) (interface{}, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func (s *LanguageServer) didOpen(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	s.update(p.TextDocument.URI, p.TextDocument.Text)
+	return nil, nil
+}
+
+func (s *LanguageServer) didChange(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.ContentChanges) > 0 {
+		s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	}
+	return nil, nil
+}
+
+func (s *LanguageServer) didClose(params json.RawMessage) (interface{}, error) {
+	var p lspTextDocumentPosition
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	delete(s.documents, p.TextDocument.URI)
+	s.publish(p.TextDocument.URI, []lspDiagnostic{})
+	return nil, nil
+}
+
+func (s *LanguageServer) publish(uri string, diagnostics []lspDiagnostic) {
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diagnostics})
+}
+
+// update checks the new text of a document, publishing what is wrong
+// with it, and indexes it if it parses far enough.
+func (s *LanguageServer) update(uri, text string) {
+	doc := s.documents[uri]
+	if doc == nil {
+		doc = &document{uri: uri}
+		s.documents[uri] = doc
+	}
+	doc.lines = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	diagnostics := []lspDiagnostic{}
+	errorDiagnostic := func(err *Error) {
+		diagnostics = append(diagnostics, lspDiagnostic{Range: doc.errorRange(err), Severity: 1, Source: "lox", Message: err.Message})
+	}
+
+	scanner := NewScanner([]byte(text))
+	scanner.scanTokens()
+	for _, err := range scanner.Errors().Errors {
+		errorDiagnostic(err)
+	}
+
+	program, parseErrors := parseDocument(scanner.Tokens())
+	if program != nil {
+		if index := indexDocument(program); index != nil {
+			doc.index = index
+		}
+	}
+	for _, err := range parseErrors {
+		errorDiagnostic(err)
+	}
+
+	if len(diagnostics) == 0 && program != nil {
+		compiler := NewCompiler()
+		compiler.Path = uriPath(uri)
+		if err := compiler.Compile(program); err != nil {
+			diagnostic := lspDiagnostic{Range: doc.lineRange(1), Severity: 1, Source: "lox", Message: err.Error()}
+			var compileErr *CompileError
+			if errors.As(err, &compileErr) {
+				diagnostic.Range = doc.tokenRange(compileErr.Token)
+			}
+			diagnostics = append(diagnostics, diagnostic)
+		}
+		for _, warning := range compiler.Warnings().Errors {
+			diagnostics = append(diagnostics, lspDiagnostic{Range: doc.errorRange(warning), Severity: 2, Code: warning.Code, Source: "lox", Message: warning.Message})
+		}
+	}
+
+	s.publish(uri, diagnostics)
+}
+
+// parseDocument parses tokens, which may be any text at all, and the
+// parser may panic on some.
+func parseDocument(tokens []*Token) (program *Program, errs []*Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			program, errs = nil, []*Error{{Message: fmt.Sprint(r), Line: tokens[len(tokens)-1].Line}}
+		}
+	}()
+
+	parser := NewParser(tokens)
+	program = parser.parse()
+	return program, parser.Errors().Errors
+}
+
+// indexDocument indexes program, or returns nil if what parsed of it
+// is too broken to index.
+func indexDocument(program *Program) (index *SymbolIndex) {
+	defer func() {
+		if recover() != nil {
+			index = nil
+		}
+	}()
+
+	return NewSymbolIndex(program)
+}
+
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return u.Path
+}
+
+// at returns the document of a request and the declaration of the name
+// at its position, if there is one.
+func (s *LanguageServer) at(params json.RawMessage, p interface{}, position *lspTextDocumentPosition) (*document, *Declaration, Token, error) {
+	if err := decodeParams(params, p); err != nil {
+		return nil, nil, Token{}, err
+	}
+	doc := s.documents[position.TextDocument.URI]
+	if doc == nil || doc.index == nil {
+		return doc, nil, Token{}, nil
+	}
+
+	line := position.Position.Line + 1
+	d, token, _ := doc.index.At(line, doc.column(position.Position.Line, position.Position.Character))
+	return doc, d, token, nil
+}
+
+func (s *LanguageServer) definition(params json.RawMessage) (interface{}, error) {
+	var p lspTextDocumentPosition
+	doc, d, _, err := s.at(params, &p, &p)
+	if err != nil || d == nil || d.Token.Line == 0 {
+		return nil, err
+	}
+	return lspLocation{URI: doc.uri, Range: doc.tokenRange(d.Token)}, nil
+}
+
+// occurrences returns the names that refer to d, or to a method of the
+// same name, in the order they appear.
+func occurrences(index *SymbolIndex, d *Declaration, declarations bool) []Token {
+	seen := make(map[[2]int]bool)
+	var tokens []Token
+	add := func(token Token) {
+		key := [2]int{token.Line, token.Column}
+		if token.Line > 0 && !seen[key] {
+			seen[key] = true
+			tokens = append(tokens, token)
+		}
+	}
+
+	for _, related := range index.Related(d) {
+		if declarations {
+			add(related.Token)
+		}
+		for _, token := range related.References {
+			add(token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return before(tokens[i].Line, tokens[i].Column, tokens[j].Line, tokens[j].Column)
+	})
+	return tokens
+}
+
+func (s *LanguageServer) references(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		lspTextDocumentPosition
+		Context struct {
+			IncludeDeclaration bool `json:"includeDeclaration"`
+		} `json:"context"`
+	}
+	doc, d, _, err := s.at(params, &p, &p.lspTextDocumentPosition)
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	locations := []lspLocation{}
+	for _, token := range occurrences(doc.index, d, p.Context.IncludeDeclaration) {
+		locations = append(locations, lspLocation{URI: doc.uri, Range: doc.tokenRange(token)})
+	}
+	return locations, nil
+}
+
+func (s *LanguageServer) hover(params json.RawMessage) (interface{}, error) {
+	var p lspTextDocumentPosition
+	doc, d, token, err := s.at(params, &p, &p)
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	contents := "```lox\n" + d.Signature() + "\n```"
+	if d.Doc != "" {
+		contents += "\n\n" + d.Doc
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": contents},
+		"range":    doc.tokenRange(token),
+	}, nil
+}
+
+func (s *LanguageServer) completion(params json.RawMessage) (interface{}, error) {
+	var p lspTextDocumentPosition
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	doc := s.documents[p.TextDocument.URI]
+	if doc == nil {
+		return []lspCompletionItem{}, nil
+	}
+
+	// a name right after a dot is a property
+	column := doc.column(p.Position.Line, p.Position.Character)
+	text := ""
+	if p.Position.Line < len(doc.lines) {
+		text = doc.lines[p.Position.Line][:column-1]
+	}
+	var scanner Scanner
+	text = strings.TrimRightFunc(text, func(r rune) bool { return r < utf8.RuneSelf && scanner.isAlphaNumeric(byte(r)) })
+	property := strings.HasSuffix(strings.TrimRight(text, " \t"), ".")
+
+	items := []lspCompletionItem{}
+	seen := make(map[string]bool)
+	if doc.index != nil && property {
+		for _, method := range doc.index.Methods() {
+			if !seen[method.Name] {
+				seen[method.Name] = true
+				items = append(items, lspCompletionItem{Label: method.Name, Kind: completionMethod, Detail: method.Signature()})
+			}
+		}
+		return items, nil
+	}
+	if property {
+		return items, nil
+	}
+
+	if doc.index != nil {
+		visible := doc.index.Visible(p.Position.Line+1, column)
+		// the innermost of the names declared more than once is last
+		for i := len(visible) - 1; i >= 0; i-- {
+			d := visible[i]
+			if seen[d.Name] {
+				continue
+			}
+			seen[d.Name] = true
+			kind := completionVariable
+			switch d.Kind {
+			case FunctionKind, BuiltinKind:
+				kind = completionFunction
+			case ClassKind:
+				kind = completionClass
+			}
+			items = append(items, lspCompletionItem{Label: d.Name, Kind: kind, Detail: d.Signature()})
+		}
+	}
+	for keyword := range reserved {
+		if !seen[keyword] {
+			items = append(items, lspCompletionItem{Label: keyword, Kind: completionKeyword})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items, nil
+}
+
+func (s *LanguageServer) documentSymbol(params json.RawMessage) (interface{}, error) {
+	var p lspTextDocumentPosition
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	doc := s.documents[p.TextDocument.URI]
+	symbols := []lspDocumentSymbol{}
+	if doc == nil || doc.index == nil {
+		return symbols, nil
+	}
+
+	symbol := func(d *Declaration, kind int) lspDocumentSymbol {
+		name := doc.tokenRange(d.Token)
+		whole := name
+		if d.Function != nil {
+			whole.End = doc.tokenRange(d.Function.Body.End).End
+		} else if d.Method != nil {
+			whole.End = doc.tokenRange(d.Method.Body.End).End
+		}
+		return lspDocumentSymbol{Name: d.Name, Detail: d.Signature(), Kind: kind, Range: whole, SelectionRange: name}
+	}
+
+	classes := make(map[*Declaration]int)
+	for _, d := range doc.index.Declarations {
+		if d.Token.Line == 0 || d.ScopeEnd.Line > 0 {
+			continue
+		}
+		switch d.Kind {
+		case ClassKind:
+			classes[d] = len(symbols)
+			symbols = append(symbols, symbol(d, symbolClass))
+		case MethodKind:
+			idx, ok := classes[d.Owner]
+			if !ok {
+				continue
+			}
+			method := symbol(d, symbolMethod)
+			symbols[idx].Children = append(symbols[idx].Children, method)
+			symbols[idx].Range.End = method.Range.End
+		case FunctionKind:
+			symbols = append(symbols, symbol(d, symbolFunction))
+		case VariableKind:
+			kind := symbolVariable
+			if d.Keyword == "const" {
+				kind = symbolConstant
+			}
+			symbols = append(symbols, symbol(d, kind))
+		}
+	}
+	return symbols, nil
+}
+
+func (s *LanguageServer) rename(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		lspTextDocumentPosition
+		NewName string `json:"newName"`
+	}
+	doc, d, _, err := s.at(params, &p, &p.lspTextDocumentPosition)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	if d.Token.Line == 0 {
+		return nil, &lspError{lspInvalidParams, "cannot rename builtin " + d.Name}
+	}
+	if !isIdentifier(p.NewName) {
+		return nil, &lspError{lspInvalidParams, fmt.Sprintf("%q is not a valid name", p.NewName)}
+	}
+
+	edits := []lspTextEdit{}
+	for _, token := range occurrences(doc.index, d, true) {
+		edits = append(edits, lspTextEdit{Range: doc.tokenRange(token), NewText: p.NewName})
+	}
+	return map[string]interface{}{"changes": map[string][]lspTextEdit{doc.uri: edits}}, nil
+}
+
+// isIdentifier reports whether name scans as a single name that isn't a
+// keyword.
+func isIdentifier(name string) bool {
+	scanner := NewScanner([]byte(name))
+	scanner.scanTokens()
+	tokens := scanner.Tokens()
+	return !scanner.Errors().HasErrors() && len(tokens) == 2 && tokens[0].Type == IDENTIFIER && tokens[0].Lexeme == name
+}
+
+func runLsp(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: jlox lsp\n\nServes the Language Server Protocol over stdin and stdout.\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := NewLanguageServer().Serve(os.Stdin, stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
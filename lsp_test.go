@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lspClient drives a LanguageServer the way an editor would, over pipes.
+type lspClient struct {
+	t           *testing.T
+	in          *io.PipeWriter
+	out         *bufio.Reader
+	id          int
+	diagnostics map[string][]lspDiagnostic
+	done        chan error
+}
+
+func newLspClient(t *testing.T) *lspClient {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	c := &lspClient{t: t, in: clientOut, out: bufio.NewReader(clientIn), diagnostics: map[string][]lspDiagnostic{}, done: make(chan error, 1)}
+	go func() {
+		c.done <- NewLanguageServer().Serve(serverIn, serverOut)
+		serverOut.Close()
+	}()
+	return c
+}
+
+func (c *lspClient) write(message map[string]interface{}) {
+	message["jsonrpc"] = "2.0"
+	content, _ := json.Marshal(message)
+	if _, err := io.WriteString(c.in, "Content-Length: "+strconv.Itoa(len(content))+"\r\n\r\n"+string(content)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read reads the next message, keeping the diagnostics it publishes.
+func (c *lspClient) read() lspMessage {
+	content, err := readMessage(c.out)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var message lspMessage
+	if err := json.Unmarshal(content, &message); err != nil {
+		c.t.Fatal(err)
+	}
+	if message.Method == "textDocument/publishDiagnostics" {
+		var params struct {
+			URI         string          `json:"uri"`
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		}
+		json.Unmarshal(message.Params, &params)
+		c.diagnostics[params.URI] = params.Diagnostics
+	}
+	return message
+}
+
+// request sends a request, decoding its answer into result, and returns
+// the error it is answered with.
+func (c *lspClient) request(method string, params interface{}, result interface{}) *lspError {
+	c.id++
+	c.write(map[string]interface{}{"id": c.id, "method": method, "params": params})
+	for {
+		message := c.read()
+		if message.ID == nil || string(*message.ID) != strconv.Itoa(c.id) {
+			continue
+		}
+		if message.Error != nil {
+			return message.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(message.Result, result); err != nil {
+				c.t.Fatalf("%s answered %s: %s", method, message.Result, err)
+			}
+		}
+		return nil
+	}
+}
+
+// notify sends a notification, then reads what it publishes.
+func (c *lspClient) notify(method string, params interface{}) {
+	c.write(map[string]interface{}{"method": method, "params": params})
+	c.read()
+}
+
+func position(uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     lspPosition{line, character},
+	}
+}
+
+const lspSource = `// adds two numbers
+// together
+function add(a, b = 1) {
+	var sum = a + b;
+	return sum;
+}
+
+class Counter {
+	init() {
+		this.count = 0;
+	}
+	increment() {
+		this.count = this.count + 1;
+		return this.count;
+	}
+}
+
+var counter = Counter();
+counter.increment();
+print(add(counter.count, 2));
+`
+
+func TestLanguageServer(t *testing.T) {
+	c := newLspClient(t)
+	uri := "file:///tmp/counter.lox"
+
+	var initialized struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	if err := c.request("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, &initialized); err != nil {
+		t.Fatal(err)
+	}
+	for _, capability := range []string{"definitionProvider", "referencesProvider", "hoverProvider", "completionProvider", "documentSymbolProvider", "renameProvider"} {
+		if initialized.Capabilities[capability] == nil {
+			t.Errorf("Expected the %s capability", capability)
+		}
+	}
+	c.write(map[string]interface{}{"method": "initialized", "params": map[string]interface{}{}})
+
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "lox", "version": 1, "text": lspSource},
+	})
+	if diagnostics, ok := c.diagnostics[uri]; !ok || len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics, got=%+v", diagnostics)
+	}
+
+	t.Run("definition", func(t *testing.T) {
+		var location lspLocation
+		c.request("textDocument/definition", position(uri, 19, 7), &location)
+		expected := lspLocation{URI: uri, Range: lspRange{lspPosition{2, 9}, lspPosition{2, 12}}}
+		if location != expected {
+			t.Errorf("Expected %+v, got=%+v", expected, location)
+		}
+
+		var method lspLocation
+		c.request("textDocument/definition", position(uri, 18, 10), &method)
+		if method.Range.Start != (lspPosition{11, 1}) {
+			t.Errorf("Expected increment to be defined at 11:1, got=%+v", method.Range.Start)
+		}
+	})
+
+	t.Run("references", func(t *testing.T) {
+		params := position(uri, 4, 9)
+		params["context"] = map[string]bool{"includeDeclaration": true}
+		var locations []lspLocation
+		c.request("textDocument/references", params, &locations)
+		if len(locations) != 2 || locations[0].Range.Start != (lspPosition{3, 5}) || locations[1].Range.Start != (lspPosition{4, 8}) {
+			t.Errorf("Expected sum at 3:5 and 4:8, got=%+v", locations)
+		}
+
+		// count is a field, not a method, so it refers to nothing
+		var none json.RawMessage
+		c.request("textDocument/references", position(uri, 19, 20), &none)
+		if string(none) != "null" {
+			t.Errorf("Expected no references to a field, got=%s", none)
+		}
+	})
+
+	t.Run("hover", func(t *testing.T) {
+		var hover struct {
+			Contents struct {
+				Value string `json:"value"`
+			} `json:"contents"`
+		}
+		c.request("textDocument/hover", position(uri, 19, 7), &hover)
+		expected := "```lox\nfunction add(a, b = 1)\n```\n\nadds two numbers\ntogether"
+		if hover.Contents.Value != expected {
+			t.Errorf("Expected %q, got=%q", expected, hover.Contents.Value)
+		}
+
+		c.request("textDocument/hover", position(uri, 18, 9), &hover)
+		if !strings.Contains(hover.Contents.Value, "Counter.increment()") {
+			t.Errorf("Expected the signature of increment, got=%q", hover.Contents.Value)
+		}
+	})
+
+	t.Run("completion", func(t *testing.T) {
+		labels := func(line, character int) map[string]int {
+			var items []lspCompletionItem
+			c.request("textDocument/completion", position(uri, line, character), &items)
+			found := map[string]int{}
+			for _, item := range items {
+				found[item.Label] = item.Kind
+			}
+			return found
+		}
+
+		inAdd := labels(4, 8)
+		for label, kind := range map[string]int{"sum": completionVariable, "a": completionVariable, "add": completionFunction, "print": completionFunction, "return": completionKeyword} {
+			if inAdd[label] != kind {
+				t.Errorf("Expected %s of kind %d in add, got=%d", label, kind, inAdd[label])
+			}
+		}
+		if _, ok := inAdd["counter"]; ok {
+			t.Errorf("Expected counter, declared later, not to be offered")
+		}
+
+		properties := labels(18, 8)
+		if len(properties) != 2 || properties["init"] != completionMethod || properties["increment"] != completionMethod {
+			t.Errorf("Expected the methods after a dot, got=%v", properties)
+		}
+	})
+
+	t.Run("documentSymbol", func(t *testing.T) {
+		var symbols []lspDocumentSymbol
+		c.request("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": uri}}, &symbols)
+		var names []string
+		for _, symbol := range symbols {
+			names = append(names, symbol.Name)
+		}
+		if strings.Join(names, " ") != "add Counter counter" {
+			t.Fatalf("Expected add, Counter and counter, got=%v", names)
+		}
+		if symbols[1].Kind != symbolClass || len(symbols[1].Children) != 2 || symbols[1].Children[1].Name != "increment" {
+			t.Errorf("Expected Counter to hold its methods, got=%+v", symbols[1])
+		}
+		if symbols[0].Range.End != (lspPosition{5, 1}) {
+			t.Errorf("Expected add to end with its body, got=%+v", symbols[0].Range)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		params := position(uri, 2, 13)
+		params["newName"] = "first"
+		var edit struct {
+			Changes map[string][]lspTextEdit `json:"changes"`
+		}
+		if err := c.request("textDocument/rename", params, &edit); err != nil {
+			t.Fatal(err)
+		}
+		edits := edit.Changes[uri]
+		if len(edits) != 2 || edits[0].Range.Start != (lspPosition{2, 13}) || edits[1].Range.Start != (lspPosition{3, 11}) || edits[1].NewText != "first" {
+			t.Errorf("Expected a renamed at 2:13 and 3:11, got=%+v", edits)
+		}
+
+		params["newName"] = "class"
+		if err := c.request("textDocument/rename", params, nil); err == nil || err.Code != lspInvalidParams {
+			t.Errorf("Expected renaming to a keyword to fail, got=%v", err)
+		}
+		builtin := position(uri, 19, 1)
+		builtin["newName"] = "show"
+		if err := c.request("textDocument/rename", builtin, nil); err == nil {
+			t.Errorf("Expected renaming a builtin to fail")
+		}
+	})
+
+	t.Run("diagnostics", func(t *testing.T) {
+		change := func(text string) []lspDiagnostic {
+			c.notify("textDocument/didChange", map[string]interface{}{
+				"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+				"contentChanges": []map[string]string{{"text": text}},
+			})
+			return c.diagnostics[uri]
+		}
+
+		syntax := change("var x = 1;\nvar = 2;\n")
+		if len(syntax) == 0 || syntax[0].Severity != 1 || syntax[0].Range.Start.Line != 1 {
+			t.Errorf("Expected a syntax error on the second line, got=%+v", syntax)
+		}
+
+		compile := change("const x = 1;\nx = 2;\n")
+		if len(compile) != 1 || compile[0].Severity != 1 || compile[0].Range.Start.Line != 1 {
+			t.Errorf("Expected an error where the constant is assigned, got=%+v", compile)
+		}
+
+		warnings := change("function f() {\n\tvar unused = 1;\n}\n")
+		if len(warnings) != 1 || warnings[0].Severity != 2 || warnings[0].Code == "" || warnings[0].Range.Start != (lspPosition{1, 5}) {
+			t.Errorf("Expected a warning for the unused variable, got=%+v", warnings)
+		}
+	})
+
+	if err := c.request("textDocument/formatting", map[string]interface{}{}, nil); err == nil || err.Code != lspMethodNotFound {
+		t.Errorf("Expected an unknown method to fail, got=%v", err)
+	}
+
+	c.request("shutdown", nil, nil)
+	c.write(map[string]interface{}{"method": "exit"})
+	if err := <-c.done; err != nil {
+		t.Errorf("Expected a clean exit, got=%s", err)
+	}
+}
+
+func TestLanguageServerQuiet(t *testing.T) {
+	// the messages go to the server's writer, and checking a document
+	// prints nothing of its own in between
+	original := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	output := make(chan []byte)
+	go func() {
+		content, _ := io.ReadAll(r)
+		output <- content
+	}()
+	var out bytes.Buffer
+	server := NewLanguageServer()
+	server.out = &out
+	server.update("file:///tmp/counter.lox", lspSource)
+	w.Close()
+	os.Stdout = original
+
+	if leaked := <-output; len(leaked) > 0 {
+		t.Errorf("Expected nothing on stdout, got=\n%s", leaked)
+	}
+	if !strings.Contains(out.String(), "publishDiagnostics") {
+		t.Errorf("Expected the diagnostics to be published, got=%q", out.String())
+	}
+}
+
+func TestSymbolIndexScopes(t *testing.T) {
+	program, ok := parseSource([]byte("var x = 1;\nfunction f(x) {\n\tvar g = function() { return x; };\n\treturn x;\n}\nif (x) {\n\tlet x = 2;\n\tprint(x);\n}\nprint(x);\n"))
+	if !ok {
+		t.Fatal("the program doesn't parse")
+	}
+	index := NewSymbolIndex(program)
+
+	tests := []struct {
+		line, column int
+		declared     int
+	}{
+		{3, 30, 2}, // captured parameter
+		{4, 9, 2},  // parameter
+		{6, 5, 1},  // global
+		{8, 8, 7},  // shadowing let
+		{10, 7, 1}, // global after the block
+	}
+	for _, test := range tests {
+		d, _, ok := index.At(test.line, test.column)
+		if !ok {
+			t.Errorf("Expected a name at %d:%d", test.line, test.column)
+			continue
+		}
+		if d.Token.Line != test.declared {
+			t.Errorf("Expected the x at %d:%d to be declared on line %d, got=%d", test.line, test.column, test.declared, d.Token.Line)
+		}
+	}
+}
@@ -7,14 +7,31 @@ import (
 	"os"
 )
 
+// commands are run as "jlox <command> [args]" and return the exit code.
+var commands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"dap":   runDap,
+	"debug": runDebug,
+	"fmt":   runFmt,
+	"lint":  runLint,
+	"lsp":   runLsp,
+	"run":   runRun,
+	"test":  runTest,
+}
+
 func main() {
 	var err error
 
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	if len(os.Args) > 2 {
 		fmt.Fprint(os.Stderr, "Usage: jlox [script]\n")
 		os.Exit(64)
 	} else if len(os.Args) == 2 {
-		err = runFile(os.Args[0])
+		err = runFile(os.Args[1])
 	} else {
 		err = runPrompt()
 	}
@@ -36,7 +53,7 @@ func runFile(file string) error {
 	}
 
 	env := NewEnvironment()
-	run(input[:], env)
+	run(input[:], file, env)
 	return nil
 }
 
@@ -50,7 +67,7 @@ func runPrompt() error {
 		if text == "" {
 			break
 		}
-		run([]byte(text), env)
+		run([]byte(text), "", env)
 		fmt.Fprint(os.Stdin, ">>> ")
 	}
 
@@ -61,14 +78,38 @@ func runPrompt() error {
 	return nil
 }
 
-func run(source []byte, env *Environment) {
+// run compiles and runs source; path is the file it came from, which
+// imports are resolved against, or "" for the prompt.
+func run(source []byte, path string, env *Environment) {
+	compiler := compile(source, path)
+	if compiler == nil {
+		return
+	}
+
+	vm := NewVM(compiler.ByteCode())
+	vmError := vm.run()
+	if vmError != nil {
+		vm.prinStackTrace(vmError)
+		return
+	}
+
+	// interpreter := NewInterpreter()
+	// result := interpreter.Interpret(program, env)
+	// if result != nil {
+	// 	fmt.Println(result.Inspect())
+	// }
+}
+
+// compile compiles source, printing its errors; it returns nil if there
+// are any.
+func compile(source []byte, path string) *Compiler {
 	scanner := NewScanner(source)
 	scanner.scanTokens()
 	scanErr := scanner.Errors()
 
 	if scanErr.HasErrors() {
 		scanErr.PrintErrors()
-		return
+		return nil
 	}
 
 	tokens := scanner.Tokens()
@@ -78,30 +119,49 @@ func run(source []byte, env *Environment) {
 
 	if parserErr.HasErrors() {
 		parserErr.PrintErrors()
-		return
+		return nil
 	}
 
 	compiler := NewCompiler()
+	compiler.Path = path
+	// debugging
+	compiler.Disassembly = os.Stdout
 	compilationErr := compiler.Compile(program)
 
 	if compilationErr != nil {
 		fmt.Println(compilationErr)
-		return
+		return nil
 	}
-	// debugging
-	fmt.Printf("Bytecode for `%s`\n", "main")
-	compiler.DisassembleChunks()
+	compiler.Warnings().PrintErrors()
+	compiler.disassemble("main")
+	return compiler
+}
 
-	vm := NewVM(compiler.ByteCode())
-	vmError := vm.run()
-	if vmError != nil {
-		vm.prinStackTrace(vmError)
-		return
+// compileSource compiles source, the file at path, returning the first of
+// its errors.
+func compileSource(source []byte, path string) (*Compiler, error) {
+	return compileTest(source, path, "")
+}
+
+// compileTest is compileSource keeping the test block named test, if any.
+func compileTest(source []byte, path string, test string) (*Compiler, error) {
+	scanner := NewScanner(source)
+	scanner.scanTokens()
+	if scanner.Errors().HasErrors() {
+		return nil, scanner.Errors().Errors[0]
 	}
 
-	// interpreter := NewInterpreter()
-	// result := interpreter.Interpret(program, env)
-	// if result != nil {
-	// 	fmt.Println(result.Inspect())
-	// }
+	parser := NewParser(scanner.Tokens())
+	program := parser.parse()
+	if parser.Errors().HasErrors() {
+		return nil, parser.Errors().Errors[0]
+	}
+
+	compiler := NewCompiler()
+	compiler.Path = path
+	compiler.Test = test
+	if err := compiler.Compile(program); err != nil {
+		return nil, err
+	}
+	return compiler, nil
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ModuleExtension is added to import paths that don't name one.
+const ModuleExtension = ".lox"
+
+// ModulePathEnv lists, like PATH, the directories searched for modules
+// that aren't found next to the importing file.
+const ModulePathEnv = "LOX_PATH"
+
+// ModuleLoader caches the modules compiled so far by absolute path. The
+// compiler of the program and those of all the modules it imports share
+// one, so each module is compiled once and gets one namespace.
+type ModuleLoader struct {
+	Modules map[string]*ModuleObject
+	// count is the number of namespaces handed out, the program's aside
+	count int
+}
+
+func NewModuleLoader() *ModuleLoader {
+	return &ModuleLoader{Modules: make(map[string]*ModuleObject)}
+}
+
+// Files maps each namespace of the program to the file its code comes
+// from.
+func (c *Compiler) Files() map[int]string {
+	files := map[int]string{0: c.Path}
+	if c.loader != nil {
+		for path, module := range c.loader.Modules {
+			files[module.Namespace] = path
+		}
+	}
+	return files
+}
+
+// resolveModule finds the file an import of path refers to, looking
+// first in the importing file's directory and then in the LOX_PATH ones.
+func (c *Compiler) resolveModule(path string) (string, error) {
+	if filepath.Ext(path) == "" {
+		path += ModuleExtension
+	}
+
+	if filepath.IsAbs(path) {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("module %q not found", path)
+		}
+		return path, nil
+	}
+
+	dirs := []string{"."}
+	if c.Path != "" {
+		dirs[0] = filepath.Dir(c.Path)
+	}
+	dirs = append(dirs, filepath.SplitList(os.Getenv(ModulePathEnv))...)
+
+	for _, dir := range dirs {
+		candidate, err := filepath.Abs(filepath.Join(dir, path))
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("module %q not found", path)
+}
+
+// importModule returns the module at path, compiling it the first time.
+func (c *Compiler) importModule(path string) (*ModuleObject, error) {
+	resolved, err := c.resolveModule(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkCycle(resolved); err != nil {
+		return nil, err
+	}
+
+	if c.loader == nil {
+		c.loader = NewModuleLoader()
+	}
+	if module, ok := c.loader.Modules[resolved]; ok {
+		return module, nil
+	}
+
+	source, err := os.ReadFile(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := NewScanner(source)
+	scanner.scanTokens()
+	if scanner.Errors().HasErrors() {
+		return nil, fmt.Errorf("in module %s: %s", path, strings.TrimSpace(scanner.Errors().Errors[0].Error()))
+	}
+
+	parser := NewParser(scanner.Tokens())
+	program := parser.parse()
+	if parser.Errors().HasErrors() {
+		return nil, fmt.Errorf("in module %s: %s", path, strings.TrimSpace(parser.Errors().Errors[0].Error()))
+	}
+
+	// the module's globals start with the same builtins and Error as the
+	// program's, so Error is found in the same slot of every namespace
+	child := NewCompiler()
+	child.SymbolTable.Define(ErrorClassName)
+	child.Constants = c.Constants
+	child.Path = resolved
+	child.importer = c
+	child.loader = c.loader
+	child.Disassembly = c.Disassembly
+	c.loader.count++
+	child.namespace = c.loader.count
+
+	if err := child.Compile(program); err != nil {
+		return nil, fmt.Errorf("in module %s: %w", path, err)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(resolved), filepath.Ext(resolved))
+	module := &ModuleObject{
+		Name:      name,
+		Path:      resolved,
+		Namespace: child.namespace,
+		Exports:   child.exports,
+	}
+	if module.Exports == nil {
+		module.Exports = make(map[string]int)
+	}
+
+	// running the body leaves the module itself as the result of the import
+	child.WriteChunk(OP_CONSTANT, 0, child.MakeConstant(module))
+	child.WriteChunk(OP_RETURN, 0)
+
+	module.Body = &CompiledFunction{Instructions: child.currentInstructions(), Lines: child.Scopes[0].Lines, Name: name, Namespace: child.namespace, NumLocals: len(child.SymbolTable.Locals()), LocalNames: child.SymbolTable.Locals()}
+	module.Size = child.SymbolTable.numDefinitions
+	c.Constants = child.Constants
+	c.warnings.Errors = append(c.warnings.Errors, child.warnings.Errors...)
+
+	c.loader.Modules[resolved] = module
+	return module, nil
+}
+
+// checkCycle reports an import of path by a module that path itself
+// imports, directly or not.
+func (c *Compiler) checkCycle(path string) error {
+	var chain []string
+	for importer := c; importer != nil; importer = importer.importer {
+		current := importer.Path
+		if current != "" {
+			current, _ = filepath.Abs(current)
+		}
+		chain = append(chain, current)
+
+		if current != path {
+			continue
+		}
+
+		root := c
+		for root.importer != nil {
+			root = root.importer
+		}
+		dir := "."
+		if root.Path != "" {
+			dir = filepath.Dir(root.Path)
+		}
+
+		var names []string
+		for i := len(chain) - 1; i >= 0; i-- {
+			names = append(names, displayPath(dir, chain[i]))
+		}
+		names = append(names, displayPath(dir, path))
+		return fmt.Errorf("import cycle: %s", strings.Join(names, " -> "))
+	}
+
+	return nil
+}
+
+// displayPath shortens path to one relative to dir when it can.
+func displayPath(dir, path string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(dir, path); err == nil {
+		return rel
+	}
+	return path
+}
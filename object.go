@@ -3,7 +3,11 @@ package main
 import (
 	"fmt"
 	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type ObjectType string
@@ -26,6 +30,30 @@ const (
 	ClassObj            = "Class"
 	InstanceObj         = "Instance"
 	BoundObj            = "BoundObj"
+	IteratorObj         = "Iterator"
+	RangeObj            = "Range"
+	JumpTableObj        = "JumpTable"
+	CallShapeObj        = "CallShape"
+	SelectShapeObj      = "SelectShape"
+	ChannelObj          = "Channel"
+	WaitGroupObj        = "WaitGroup"
+	ThreadObj           = "Thread"
+	PromiseObj          = "Promise"
+	GeneratorObj        = "Generator"
+	FiberObj            = "Fiber"
+	ModuleObj           = "Module"
+)
+
+// ToStringMethod is the method an instance can define to control how it is
+// turned into a string, e.g. inside an interpolated string.
+const ToStringMethod = "toString"
+
+// An instance is iterable in a for-in loop when it defines NextMethod, or
+// IteratorMethod returning an instance or generator that does. Iteration
+// stops when next() returns nil. Generators answer next() themselves.
+const (
+	IteratorMethod = "iterator"
+	NextMethod     = "next"
 )
 
 type BuiltinFunction func(args ...Object) Object
@@ -111,13 +139,55 @@ func (c *Closure) Inspect() string {
 }
 
 type CompiledFunction struct {
-	Name          string
-	Instructions  Instructions
-	NumLocals     int
-	NumParameters int
+	Name         string
+	Instructions Instructions
+	// Lines is the line table of Instructions
+	Lines []LineInfo
+	// LocalNames and UpvalueNames name the local slots and the upvalues
+	LocalNames     []string
+	UpvalueNames   []string
+	NumLocals      int
+	NumParameters  int
+	ParameterNames []string
+	// NumRequired leading parameters have no default.
+	NumRequired int
+	// Variadic functions take their extra positional arguments as an array
+	// in the local slot after the parameters.
+	Variadic bool
+	// calling a generator function returns a CompiledGenerator instead of
+	// running the body
+	IsGenerator bool
+	// calling an async function returns a Promise and leaves running the
+	// body to the event loop
+	IsAsync bool
+	// Namespace is the module whose globals the function reads and
+	// writes, 0 for the main program
+	Namespace int
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return CompiledFunctionObj }
+
+// Line returns the line of the instruction at offset, or -1 if it has
+// none.
+func (cf *CompiledFunction) Line(offset int) int {
+	return lineAt(cf.Lines, offset)
+}
+
+// LineTable returns the line of every byte of Instructions, -1 for those
+// with none.
+func (cf *CompiledFunction) LineTable() []int {
+	table := make([]int, 0, len(cf.Instructions))
+	for _, info := range cf.Lines {
+		for idx := 0; idx < info.Count; idx++ {
+			table = append(table, info.Line)
+		}
+	}
+	for len(table) < len(cf.Instructions) {
+		table = append(table, -1)
+	}
+	return table
+}
+
 func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
@@ -131,11 +201,292 @@ func (io *CompiledInstanceObject) Type() ObjectType { return InstanceObj }
 func (io *CompiledInstanceObject) Inspect() string {
 	fields := []string{}
 	for key, value := range io.Fields {
-		fields = append(fields, fmt.Sprintf("%s: %v", key, value))
+		fields = append(fields, fmt.Sprintf("%s: %s", key, value.Inspect()))
 	}
 	return fmt.Sprintf("<instance of %s> {%s}", io.Class.Name, strings.Join(fields, ", "))
 }
 
+// IsSubclassOf reports whether c is class or inherits from it.
+func (c *CompiledClassObject) IsSubclassOf(class *CompiledClassObject) bool {
+	for current := c; current != nil; current = current.SuperClass {
+		if current == class {
+			return true
+		}
+	}
+	return false
+}
+
+// CallShape describes the arguments of a call that spreads arrays or names
+// its arguments: which positional arguments are spread, and the names of the
+// arguments that follow them.
+type CallShape struct {
+	Spread []bool
+	Names  []string
+}
+
+func (cs *CallShape) Type() ObjectType { return CallShapeObj }
+func (cs *CallShape) Inspect() string {
+	return fmt.Sprintf("CallShape[%d, %s]", len(cs.Spread), strings.Join(cs.Names, ", "))
+}
+
+// SelectShape describes the cases of a select: whether each one sends, and
+// whether there is a default case.
+type SelectShape struct {
+	Sends   []bool
+	Default bool
+}
+
+func (ss *SelectShape) Type() ObjectType { return SelectShapeObj }
+func (ss *SelectShape) Inspect() string {
+	return fmt.Sprintf("SelectShape[%d, %t]", len(ss.Sends), ss.Default)
+}
+
+// ModuleObject is a compiled module. The first import runs Body with a
+// fresh set of Size globals, which becomes namespace Namespace; every
+// import after that only looks up the cached globals. Exports maps each
+// exported name to its global slot.
+type ModuleObject struct {
+	Name      string
+	Path      string
+	Namespace int
+	Exports   map[string]int
+	Body      *CompiledFunction
+	Size      int
+}
+
+func (m *ModuleObject) Type() ObjectType { return ModuleObj }
+func (m *ModuleObject) Inspect() string {
+	return fmt.Sprintf("<module %s>", m.Name)
+}
+
+// JumpTable maps the integers Min..Min+len(Targets)-1 to instruction
+// offsets for a dense match; a negative target falls through.
+type JumpTable struct {
+	Min     int
+	Targets []int
+}
+
+func (jt *JumpTable) Type() ObjectType { return JumpTableObj }
+func (jt *JumpTable) Inspect() string {
+	return fmt.Sprintf("JumpTable[%d..%d]", jt.Min, jt.Min+len(jt.Targets)-1)
+}
+
+// CompiledGenerator is a suspended call of a generator function. Between
+// calls to next() it keeps the frame's ip, its stack slots above the base
+// pointer and the exception handlers it set up, with Sp relative to the base.
+type CompiledGenerator struct {
+	Closure  *Closure
+	Ip       int
+	Stack    []Object
+	Loops    []int
+	Handlers []ExceptionHandler
+	Started  bool
+	Running  bool
+	Done     bool
+}
+
+func (g *CompiledGenerator) Type() ObjectType { return GeneratorObj }
+func (g *CompiledGenerator) Inspect() string {
+	return fmt.Sprintf("<generator %s>", g.Closure.Function.Name)
+}
+
+// GeneratorNext is a generator's next method, resuming it when called.
+type GeneratorNext struct {
+	Generator *CompiledGenerator
+}
+
+func (gn *GeneratorNext) Type() ObjectType { return BoundObj }
+func (gn *GeneratorNext) Inspect() string {
+	return fmt.Sprintf("<bound method %s of %s>", NextMethod, gn.Generator.Inspect())
+}
+
+// Methods and properties of fibers. Yield is called on Fiber itself and
+// suspends whichever fiber is running.
+const (
+	FiberResumeMethod = "resume"
+	FiberYieldMethod  = "yield"
+	FiberIsDone       = "isDone"
+	FiberError        = "error"
+)
+
+type FiberState int
+
+const (
+	FiberNew FiberState = iota
+	FiberSuspended
+	FiberRunning
+	FiberDone
+)
+
+// CompiledFiber is a coroutine running a closure on a stack and call frames
+// of its own. While it runs they are the VM's; otherwise they are parked
+// here, so switching fibers only swaps a few slices. Caller is the fiber
+// that resumed it and gets control back when it yields or finishes.
+type CompiledFiber struct {
+	Closure    *Closure
+	Stack      []Object
+	Sp         int
+	Frames     []*CallFrame
+	FrameCount int
+	Handlers   []ExceptionHandler
+	Caller     *CompiledFiber
+	State      FiberState
+	// Error is the exception that ended the fiber, if any
+	Error Object
+	// Promise is settled with the result of the async call the fiber runs
+	Promise *Promise
+}
+
+func NewFiber(closure *Closure) *CompiledFiber {
+	return &CompiledFiber{
+		Closure: closure,
+		Stack:   make([]Object, STACK_MAX),
+		Frames:  make([]*CallFrame, FRAMES_MAX),
+		State:   FiberNew,
+	}
+}
+
+func (f *CompiledFiber) Type() ObjectType { return FiberObj }
+func (f *CompiledFiber) Inspect() string {
+	return fmt.Sprintf("<fiber %s>", f.Closure.Function.Name)
+}
+
+// FiberMethod is resume bound to a fiber, or Fiber.yield with no fiber.
+type FiberMethod struct {
+	Fiber *CompiledFiber
+	Name  string
+}
+
+func (fm *FiberMethod) Type() ObjectType { return BoundObj }
+func (fm *FiberMethod) Inspect() string {
+	if fm.Fiber == nil {
+		return fmt.Sprintf("<builtin %s.%s>", BuiltinFuncNameFiber, fm.Name)
+	}
+	return fmt.Sprintf("<bound method %s of %s>", fm.Name, fm.Fiber.Inspect())
+}
+
+type PromiseState int
+
+const (
+	PromisePending PromiseState = iota
+	PromiseFulfilled
+	PromiseRejected
+)
+
+// Methods of promises made with Promise().
+const (
+	PromiseResolveMethod = "resolve"
+	PromiseRejectMethod  = "reject"
+)
+
+// Promise is the eventual result of an async call or a job. Job, when
+// set, is work the event loop runs off the VM's goroutine, such as
+// waiting out a timer; the promise is settled with what it returns.
+type Promise struct {
+	State PromiseState
+	Value Object
+	Job   func() (Object, error)
+	// Handled is set once anything awaits p or reacts to it
+	Handled   bool
+	reactions []func(*Promise)
+}
+
+func (p *Promise) Type() ObjectType { return PromiseObj }
+func (p *Promise) Inspect() string {
+	switch p.State {
+	case PromiseFulfilled:
+		return fmt.Sprintf("<promise %s>", p.Value.Inspect())
+	case PromiseRejected:
+		return fmt.Sprintf("<promise rejected %s>", p.Value.Inspect())
+	default:
+		return "<promise pending>"
+	}
+}
+
+// Then runs reaction once p is settled, straight away if it already is.
+func (p *Promise) Then(reaction func(*Promise)) {
+	p.Handled = true
+	if p.State == PromisePending {
+		p.reactions = append(p.reactions, reaction)
+		return
+	}
+	reaction(p)
+}
+
+// Settle fulfills or rejects p, unless it is settled already. Fulfilling
+// it with another promise settles it the way that one is.
+func (p *Promise) Settle(value Object, rejected bool) {
+	if p.State != PromisePending {
+		return
+	}
+
+	if inner, ok := value.(*Promise); ok && !rejected {
+		inner.Then(func(inner *Promise) { p.Settle(inner.Value, inner.State == PromiseRejected) })
+		return
+	}
+
+	p.State, p.Value = PromiseFulfilled, value
+	if rejected {
+		p.State = PromiseRejected
+	}
+
+	reactions := p.reactions
+	p.reactions = nil
+	for _, reaction := range reactions {
+		reaction(p)
+	}
+}
+
+// Methods of channels, wait groups and threads.
+const (
+	ChannelSendMethod   = "send"
+	ChannelRecvMethod   = "recv"
+	ChannelCloseMethod  = "close"
+	WaitGroupAddMethod  = "add"
+	WaitGroupDoneMethod = "done"
+	WaitGroupWaitMethod = "wait"
+	ThreadJoinMethod    = "join"
+)
+
+// Channel passes values between threads. Values are copied on the way in,
+// so the receiving thread never shares anything mutable with the sender.
+type Channel struct {
+	Values chan Object
+}
+
+func (c *Channel) Type() ObjectType { return ChannelObj }
+func (c *Channel) Inspect() string  { return fmt.Sprintf("<channel %d>", cap(c.Values)) }
+
+type WaitGroup struct {
+	Group sync.WaitGroup
+}
+
+func (wg *WaitGroup) Type() ObjectType { return WaitGroupObj }
+func (wg *WaitGroup) Inspect() string  { return "<wait group>" }
+
+// Thread is what spawn evaluates to. Done is closed when the spawned call
+// finishes, leaving either its Result or the Err that ended it.
+type Thread struct {
+	Name   string
+	Done   chan struct{}
+	Result Object
+	Err    error
+}
+
+func (t *Thread) Type() ObjectType { return ThreadObj }
+func (t *Thread) Inspect() string  { return fmt.Sprintf("<thread %s>", t.Name) }
+
+// SyncMethod is a method of a channel, wait group or thread bound to it.
+type SyncMethod struct {
+	Receiver Object
+	Name     string
+}
+
+func (sm *SyncMethod) Type() ObjectType { return BoundObj }
+func (sm *SyncMethod) Inspect() string {
+	return fmt.Sprintf("<bound method %s of %s>", sm.Name, sm.Receiver.Inspect())
+}
+
 type CompiledBoundMethod struct {
 	Receiver *CompiledInstanceObject
 	Method   *Closure
@@ -146,6 +497,50 @@ func (bm *CompiledBoundMethod) Inspect() string {
 	return fmt.Sprintf("<bound method %s of %s>", bm.Method.Function.Name, bm.Receiver.Class.Name)
 }
 
+// IteratorState tracks where OP_ITER_NEXT is when it has to call back into
+// a user iterator.
+type IteratorState int
+
+const (
+	IteratorReady IteratorState = iota
+	IteratorPending
+	IteratorAwaitingIterator
+	IteratorAwaitingNext
+)
+
+// Iterator is the VM's cursor over the iterable of a for-in loop. Arrays,
+// strings and hashes are snapshotted into Keys and Values; instances and
+// generators are driven through their next() method.
+type Iterator struct {
+	Keys      []Object
+	Values    []Object
+	Range     *Range
+	Index     int
+	Keyed     bool
+	Instance  *CompiledInstanceObject
+	Generator *CompiledGenerator
+	State     IteratorState
+}
+
+func (it *Iterator) Type() ObjectType { return IteratorObj }
+func (it *Iterator) Inspect() string  { return "<iterator>" }
+
+// Len is the number of values in a snapshotted or range iterator.
+func (it *Iterator) Len() int {
+	if it.Range != nil {
+		return it.Range.Len()
+	}
+	return len(it.Values)
+}
+
+// At returns the value at idx of a snapshotted or range iterator.
+func (it *Iterator) At(idx int) Object {
+	if it.Range != nil {
+		return &FloatObject{Value: it.Range.At(idx)}
+	}
+	return it.Values[idx]
+}
+
 type ContinueSignal struct{}
 
 func (b *ContinueSignal) Type() ObjectType { return ContinueObj }
@@ -156,19 +551,73 @@ type BreakSignal struct{}
 func (b *BreakSignal) Type() ObjectType { return BreakObj }
 func (b *BreakSignal) Inspect() string  { return "BREAK" }
 
+// ErrorObject is a runtime failure unwinding the tree-walker. Value holds
+// what a throw statement threw and is nil for built-in errors; Stack lists
+// the functions that were active, innermost first.
 type ErrorObject struct {
 	Message string
+	Value   Object
+	Stack   []string
 }
 
 func (e *ErrorObject) Type() ObjectType { return ErrorObj }
 func (e *ErrorObject) Inspect() string  { return "ERROR: " + e.Message }
 
+// thrownMessage describes a value that escaped every handler. Instances
+// with a message field, such as Error, read as "Class: message".
+func thrownMessage(value Object) string {
+	var class string
+	var fields map[string]Object
+
+	switch value := value.(type) {
+	case *InstanceObject:
+		class, fields = value.Class.Name.Value, value.Fields
+	case *CompiledInstanceObject:
+		class, fields = value.Class.Name.Value, value.Fields
+	default:
+		return value.Inspect()
+	}
+
+	if message, ok := fields["message"]; ok {
+		return class + ": " + message.Inspect()
+	}
+	return value.Inspect()
+}
+
+// stackArray turns a stack trace into the array stored in an Error's stack
+// field.
+func stackArray(stack []string) *Array {
+	elements := make([]Object, len(stack))
+	for idx, name := range stack {
+		elements[idx] = &StringObject{Value: name}
+	}
+	return &Array{Elements: elements}
+}
+
+// fillStack records the stack on an error instance that was constructed but
+// never thrown before, leaving rethrown errors with their original trace.
+func fillStack(fields map[string]Object, stack []string) {
+	if trace, ok := fields["stack"].(*Array); ok && len(trace.Elements) == 0 {
+		fields["stack"] = stackArray(stack)
+	}
+}
+
+// errorFields are the fields of an Error instance standing in for a
+// built-in runtime error.
+func errorFields(message string, stack []string) map[string]Object {
+	return map[string]Object{
+		"message": &StringObject{Value: message},
+		"cause":   Null,
+		"stack":   stackArray(stack),
+	}
+}
+
 type FloatObject struct {
 	Value float64
 }
 
 func (f *FloatObject) Type() ObjectType { return FloatObj }
-func (f *FloatObject) Inspect() string  { return fmt.Sprintf("%.0f", f.Value) }
+func (f *FloatObject) Inspect() string  { return strconv.FormatFloat(f.Value, 'f', -1, 64) }
 func (f *FloatObject) HashKey() HashKey {
 	return HashKey{Type: f.Type(), Value: int64(f.Value)}
 }
@@ -279,6 +728,16 @@ func (c *ClassObject) GetMethod(name string) (*Function, bool) {
 	return method, ok
 }
 
+// IsSubclassOf reports whether c is class or inherits from it.
+func (c *ClassObject) IsSubclassOf(class *ClassObject) bool {
+	for current := c; current != nil; current = current.SuperClass {
+		if current == class {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *ClassObject) GetStaticMethod(name string) (*Function, bool) {
 	method, ok := c.StaticMethods[name]
 	return method, ok
@@ -293,7 +752,7 @@ func (io *InstanceObject) Type() ObjectType { return InstanceObj }
 func (io *InstanceObject) Inspect() string {
 	fields := []string{}
 	for key, value := range io.Fields {
-		fields = append(fields, fmt.Sprintf("%s: %v", key, value))
+		fields = append(fields, fmt.Sprintf("%s: %s", key, value.Inspect()))
 	}
 	return fmt.Sprintf("<instance of %s> {%s}", io.Class.Name, strings.Join(fields, ", "))
 }
@@ -322,12 +781,16 @@ func (bm *BoundMethod) Inspect() string {
 }
 
 type Function struct {
-	Name       *Identifier
-	Parameters []*Identifier
-	Body       *BlockStatement
-	Env        *Environment // capture current environment for closures
-	IsStatic   bool
-	IsGetter   bool
+	Name        *Identifier
+	Parameters  []*Identifier
+	Defaults    []Expression
+	Rest        *Identifier
+	Body        *BlockStatement
+	Env         *Environment // capture current environment for closures
+	IsStatic    bool
+	IsGetter    bool
+	IsGenerator bool
+	IsAsync     bool
 }
 
 func (f *Function) Type() ObjectType { return FunctionObj }
@@ -361,6 +824,44 @@ func (f *Function) Inspect() string {
 	return str.String()
 }
 
+// Generator is the tree-walker's suspended generator call. The body runs on
+// a goroutine of its own, taking turns with the caller: next() sends a value
+// on resume and waits on yields, which carries each yielded value and is
+// closed when the body finishes. A generator left before it finishes is
+// closed, by the for-in loop running it or, once dropped, by the garbage
+// collector, which ends its goroutine.
+type Generator struct {
+	Name    string
+	start   func()
+	state   *generatorState
+	Running bool
+	Done    bool
+}
+
+// generatorState is the part of a Generator its goroutine shares. The
+// goroutine doesn't hold on to the Generator itself, so a dropped one can be
+// collected.
+type generatorState struct {
+	resume chan Object
+	yields chan Object
+	// done is closed to make the body unwind from its yield
+	done chan struct{}
+	once sync.Once
+	// abandoned is set when the generator was dropped, in which case its
+	// finally blocks are skipped rather than run alongside the caller
+	abandoned bool
+}
+
+func (s *generatorState) close(abandoned bool) {
+	s.once.Do(func() {
+		s.abandoned = abandoned
+		close(s.done)
+	})
+}
+
+func (g *Generator) Type() ObjectType { return GeneratorObj }
+func (g *Generator) Inspect() string  { return fmt.Sprintf("<generator %s>", g.Name) }
+
 type Array struct {
 	Elements []Object
 }
@@ -381,6 +882,69 @@ func (ao *Array) Inspect() string {
 	return str.String()
 }
 
+// resolveIndex maps an index onto a sequence of the given length, counting
+// negative indices back from the end. It reports false when out of range.
+func resolveIndex(index *FloatObject, length int) (int, bool) {
+	idx := int(index.Value)
+	if idx < 0 {
+		idx += length
+	}
+	if idx < 0 || idx >= length {
+		return 0, false
+	}
+	return idx, true
+}
+
+// resolveSlice turns the bounds of a slice into a [low, high) range clamped
+// to the sequence length. A nil or Null bound is omitted; any other
+// non-number bound reports false.
+func resolveSlice(start, end Object, length int) (int, int, bool) {
+	bound := func(obj Object, omitted int) (int, bool) {
+		if obj == nil || obj == Null {
+			return omitted, true
+		}
+		number, ok := obj.(*FloatObject)
+		if !ok {
+			return 0, false
+		}
+
+		idx := int(number.Value)
+		if idx < 0 {
+			idx += length
+		}
+		if idx < 0 {
+			return 0, true
+		}
+		if idx > length {
+			return length, true
+		}
+		return idx, true
+	}
+
+	low, ok := bound(start, 0)
+	if !ok {
+		return 0, 0, false
+	}
+	high, ok := bound(end, length)
+	if !ok {
+		return 0, 0, false
+	}
+	if high < low {
+		high = low
+	}
+
+	return low, high, true
+}
+
+// spliceElements returns elements with [low, high) replaced by replacement.
+func spliceElements(elements []Object, low, high int, replacement []Object) []Object {
+	result := make([]Object, 0, len(elements)-(high-low)+len(replacement))
+	result = append(result, elements[:low]...)
+	result = append(result, replacement...)
+	result = append(result, elements[high:]...)
+	return result
+}
+
 type HashKey struct {
 	Type  ObjectType
 	Value int64
@@ -400,6 +964,36 @@ type Hash struct {
 }
 
 func (h *Hash) Type() ObjectType { return HashObj }
+
+// SortedPairs returns the pairs ordered by key, so iteration is
+// deterministic: booleans first, then numbers, then strings.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+
+	rank := map[ObjectType]int{BooleanObj: 0, FloatObj: 1, StringObj: 2}
+	sort.Slice(pairs, func(a, b int) bool {
+		left, right := pairs[a].Key, pairs[b].Key
+		if left.Type() != right.Type() {
+			return rank[left.Type()] < rank[right.Type()]
+		}
+
+		switch left := left.(type) {
+		case *FloatObject:
+			return left.Value < right.(*FloatObject).Value
+		case *StringObject:
+			return left.Value < right.(*StringObject).Value
+		case *BooleanObject:
+			return !left.Value && right.(*BooleanObject).Value
+		default:
+			return false
+		}
+	})
+
+	return pairs
+}
 func (h *Hash) Inspect() string {
 	var str strings.Builder
 
@@ -414,3 +1008,304 @@ func (h *Hash) Inspect() string {
 
 	return str.String()
 }
+
+// Range is a lazy arithmetic sequence from Start towards End by Step; End
+// itself is included only when Inclusive. Values are computed on demand.
+type Range struct {
+	Start     float64
+	End       float64
+	Step      float64
+	Inclusive bool
+}
+
+// NewRange builds a range from evaluated bounds. A nil or Null step defaults
+// to 1, or -1 when counting down.
+func NewRange(start, end, step Object, inclusive bool) (*Range, error) {
+	from, ok := start.(*FloatObject)
+	if !ok {
+		return nil, fmt.Errorf("range bounds must be numbers, got %s", start.Type())
+	}
+	to, ok := end.(*FloatObject)
+	if !ok {
+		return nil, fmt.Errorf("range bounds must be numbers, got %s", end.Type())
+	}
+
+	rng := &Range{Start: from.Value, End: to.Value, Step: 1, Inclusive: inclusive}
+	if rng.End < rng.Start {
+		rng.Step = -1
+	}
+
+	if step != nil && step != Null {
+		by, ok := step.(*FloatObject)
+		if !ok {
+			return nil, fmt.Errorf("range step must be a number, got %s", step.Type())
+		}
+		if by.Value == 0 {
+			return nil, fmt.Errorf("range step must not be zero")
+		}
+		rng.Step = by.Value
+	}
+
+	return rng, nil
+}
+
+func (r *Range) Type() ObjectType { return RangeObj }
+func (r *Range) Inspect() string {
+	var str strings.Builder
+
+	str.WriteString(strconv.FormatFloat(r.Start, 'f', -1, 64))
+	if r.Inclusive {
+		str.WriteString(DOT_DOT)
+	} else {
+		str.WriteString(DOT_DOT_LESS)
+	}
+	str.WriteString(strconv.FormatFloat(r.End, 'f', -1, 64))
+	if r.Step != 1 {
+		str.WriteString(" step ")
+		str.WriteString(strconv.FormatFloat(r.Step, 'f', -1, 64))
+	}
+
+	return str.String()
+}
+
+// steps is how many steps End is from Start, rounded to absorb float error.
+func (r *Range) steps() float64 {
+	return math.Round((r.End-r.Start)/r.Step*1e9) / 1e9
+}
+
+func (r *Range) Len() int {
+	steps := r.steps()
+	if steps < 0 {
+		return 0
+	}
+	if r.Inclusive {
+		return int(math.Floor(steps)) + 1
+	}
+	return int(math.Ceil(steps))
+}
+
+func (r *Range) At(idx int) float64 {
+	return r.Start + float64(idx)*r.Step
+}
+
+func (r *Range) Contains(value float64) bool {
+	idx := math.Round((value-r.Start)/r.Step*1e9) / 1e9
+	return idx >= 0 && idx == math.Trunc(idx) && int(idx) < r.Len()
+}
+
+// isTruthy tells whether value counts as true in a condition.
+func isTruthy(value Object) bool {
+	switch v := value.(type) {
+	case *StringObject:
+		return len(v.Value) > 0
+	case *FloatObject:
+		return v.Value > 0
+	case *BooleanObject:
+		return v.Value
+	case *NilObject:
+		return false
+	default:
+		return true
+	}
+}
+
+// objectsEqual compares numbers, strings and booleans by value and
+// everything else by identity.
+func objectsEqual(left, right Object) bool {
+	switch left := left.(type) {
+	case *FloatObject:
+		r, ok := right.(*FloatObject)
+		return ok && left.Value == r.Value
+	case *StringObject:
+		r, ok := right.(*StringObject)
+		return ok && left.Value == r.Value
+	case *BooleanObject:
+		r, ok := right.(*BooleanObject)
+		return ok && left.Value == r.Value
+	default:
+		return left == right
+	}
+}
+
+// deepEqual is objectsEqual, but compares arrays and hashes by their
+// elements.
+func deepEqual(left, right Object) bool {
+	switch left := left.(type) {
+	case *NilObject:
+		_, ok := right.(*NilObject)
+		return ok
+	case *Array:
+		r, ok := right.(*Array)
+		if !ok || len(left.Elements) != len(r.Elements) {
+			return false
+		}
+		for idx, element := range left.Elements {
+			if !deepEqual(element, r.Elements[idx]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		r, ok := right.(*Hash)
+		if !ok || len(left.Pairs) != len(r.Pairs) {
+			return false
+		}
+		for key, pair := range left.Pairs {
+			other, ok := r.Pairs[key]
+			if !ok || !deepEqual(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return objectsEqual(left, right)
+	}
+}
+
+// containsObject implements `item in container`: array elements, substrings,
+// hash keys and range members. It reports false in the second result when the
+// container doesn't support membership tests.
+func containsObject(container, item Object) (bool, bool) {
+	switch container := container.(type) {
+	case *Array:
+		for _, element := range container.Elements {
+			if objectsEqual(element, item) {
+				return true, true
+			}
+		}
+		return false, true
+	case *StringObject:
+		str, ok := item.(*StringObject)
+		return ok && strings.Contains(container.Value, str.Value), true
+	case *Hash:
+		key, ok := item.(Hashable)
+		if !ok {
+			return false, true
+		}
+		_, ok = container.Pairs[key.HashKey()]
+		return ok, true
+	case *Range:
+		number, ok := item.(*FloatObject)
+		return ok && container.Contains(number.Value), true
+	default:
+		return false, false
+	}
+}
+
+// arrayShapeError describes why an array of length elements doesn't fit a
+// destructuring pattern that needs at least required elements and, without
+// a rest element, at most max. It is empty when the array fits.
+func arrayShapeError(length, required, max int, rest bool) string {
+	switch {
+	case length < required:
+		return fmt.Sprintf("expected at least %d elements, got %d", required, length)
+	case !rest && length > max:
+		return fmt.Sprintf("expected at most %d elements, got %d", max, length)
+	default:
+		return ""
+	}
+}
+
+// destructureKey looks key up in a hash or in the fields of an instance, and
+// reports whether it was found. It reports false in the third result when
+// value is neither.
+func destructureKey(value Object, key string) (Object, bool, bool) {
+	switch value := value.(type) {
+	case *Hash:
+		pair, ok := value.Pairs[(&StringObject{Value: key}).HashKey()]
+		return pair.Value, ok, true
+	case *InstanceObject:
+		field, ok := value.Fields[key]
+		return field, ok, true
+	case *CompiledInstanceObject:
+		field, ok := value.Fields[key]
+		return field, ok, true
+	default:
+		return nil, false, false
+	}
+}
+
+// spreadArguments expands the arguments marked in spread, which have to be
+// arrays, into separate arguments.
+func spreadArguments(args []Object, spread []bool) ([]Object, error) {
+	var expanded []Object
+	for idx, arg := range args {
+		if idx >= len(spread) || !spread[idx] {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		array, ok := arg.(*Array)
+		if !ok {
+			return nil, fmt.Errorf("cannot spread %s, expected an array", arg.Type())
+		}
+		expanded = append(expanded, array.Elements...)
+	}
+
+	return expanded, nil
+}
+
+// bindArguments lays out the arguments of a call as the parameters of a
+// function. args holds the positional arguments followed by the named ones,
+// whose names are given in names. A parameter past the required ones that
+// is left out gets Null, so that its default applies; a variadic function
+// gets its extra positional arguments as an array after the parameters.
+func bindArguments(params []string, required int, variadic bool, args []Object, names []string) ([]Object, error) {
+	positional := args[:len(args)-len(names)]
+
+	arity := fmt.Sprintf("%d", len(params))
+	switch {
+	case variadic:
+		arity = fmt.Sprintf("at least %d", required)
+	case required < len(params):
+		arity = fmt.Sprintf("%d to %d", required, len(params))
+	}
+
+	if len(positional) > len(params) && !variadic {
+		return nil, fmt.Errorf("wrong number of arguments: want=%s, got=%d", arity, len(args))
+	}
+
+	bound := make([]Object, len(params))
+	copy(bound, positional)
+
+	for idx, name := range names {
+		slot := -1
+		for paramIdx, param := range params {
+			if param == name {
+				slot = paramIdx
+				break
+			}
+		}
+
+		if slot < 0 {
+			return nil, fmt.Errorf("unknown argument name: %s", name)
+		}
+		if bound[slot] != nil {
+			return nil, fmt.Errorf("argument %s given more than once", name)
+		}
+		bound[slot] = args[len(positional)+idx]
+	}
+
+	for idx := range bound {
+		if bound[idx] != nil {
+			continue
+		}
+		if idx < required {
+			if len(names) == 0 {
+				return nil, fmt.Errorf("wrong number of arguments: want=%s, got=%d", arity, len(args))
+			}
+			return nil, fmt.Errorf("missing argument: %s", params[idx])
+		}
+		bound[idx] = Null
+	}
+
+	if variadic {
+		rest := []Object{}
+		if len(positional) > len(params) {
+			rest = append(rest, positional[len(params):]...)
+		}
+		bound = append(bound, &Array{Elements: rest})
+	}
+
+	return bound, nil
+}
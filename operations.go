@@ -39,6 +39,48 @@ const (
 	OP_SET_PROPERTY
 	OP_GET_PROPERTY
 	OP_METHOD
+	OP_MODULO
+	OP_SET_UPVALUE
+	OP_DUP
+	OP_BURY
+	OP_JUMP_IF_NOT_NIL
+	OP_ARRAY
+	OP_HASH
+	OP_INDEX
+	OP_SET_INDEX
+	OP_TO_STRING
+	OP_INTERPOLATE
+	OP_SLICE
+	OP_SET_SLICE
+	OP_ITERATOR
+	OP_ITER_NEXT
+	OP_RANGE
+	OP_IN
+	OP_MATCH_EQUAL
+	OP_MATCH_ARRAY
+	OP_MATCH_KEY
+	OP_MATCH_CLASS
+	OP_MATCH_FIELD
+	OP_NO_MATCH
+	OP_JUMP_TABLE
+	OP_DESTRUCTURE_ARRAY
+	OP_DESTRUCTURE_HASH
+	OP_DESTRUCTURE_INDEX
+	OP_DESTRUCTURE_KEY
+	OP_DESTRUCTURE_MISSING
+	OP_CALL_EXTENDED
+	OP_TRY
+	OP_END_TRY
+	OP_THROW
+	OP_YIELD
+	OP_SPAWN
+	OP_SELECT
+	OP_AWAIT
+	OP_IMPORT
+	OP_INHERIT
+	OP_GET_SUPER
+	OP_LOOP_ENTER
+	OP_UNWIND
 )
 
 type Definition struct {
@@ -47,39 +89,81 @@ type Definition struct {
 }
 
 var definitions = map[OpCode]*Definition{
-	OP_CONSTANT:      {"OP_CONSTANT", []int{2}},
-	OP_NEGATE:        {"OP_NEGATE", []int{}},
-	OP_RETURN:        {"OP_RETURN", []int{}},
-	OP_ADD:           {"OP_ADD", []int{}},
-	OP_SUBTRACT:      {"OP_SUBTRACT", []int{}},
-	OP_MULTIPLY:      {"OP_MULTIPLY", []int{}},
-	OP_DIVIDE:        {"OP_DIVIDE", []int{}},
-	OP_TRUE:          {"OP_TRUE", []int{}},
-	OP_FALSE:         {"OP_FALSE", []int{}},
-	OP_NIL:           {"OP_NIL", []int{}},
-	OP_LESS:          {"OP_LESS", []int{}},
-	OP_GREATER:       {"OP_GREATER", []int{}},
-	OP_EQUAL:         {"OP_EQUAL", []int{}},
-	OP_NOT:           {"OP_NOT", []int{}},
-	OP_POP:           {"OP_POP", []int{}},
-	OP_DEFINE_GLOBAL: {"OP_DEFINE_GLOBAL", []int{2}},
-	OP_DEFINE_LOCAL:  {"OP_DEFINE_LOCAL", []int{1}},
-	OP_GET_GLOBAL:    {"OP_GET_GLOBAL", []int{2}},
-	OP_GET_LOCAL:     {"OP_GET_LOCAL", []int{1}},
-	OP_GET_BUILTIN:   {"OP_GET_BUILTIN", []int{1}},
-	OP_SET_GLOBAL:    {"OP_SET_GLOBAL", []int{2}},
-	OP_SET_LOCAL:     {"OP_SET_LOCAL", []int{1}},
-	OP_JUMP_IF_FALSE: {"OP_JUMP_IF_FALSE", []int{2}},
-	OP_JUMP:          {"OP_JUMP", []int{2}},
-	OP_LOOP:          {"OP_LOOP", []int{2}},
-	OP_CALL:          {"OP_CALL", []int{1}},
-	OP_FUNCTION:      {"OP_FUNCTION", []int{2}},
-	OP_CLOSURE:       {"OP_CLOSURE", []int{2, 1}},
-	OP_GET_UPVALUE:   {"OP_GET_UPVALUE", []int{1}},
-	OP_CLASS:         {"OP_CLASS", []int{2}},
-	OP_SET_PROPERTY:  {"OP_SET_PROPERTY", []int{1}},
-	OP_GET_PROPERTY:  {"OP_GET_PROPERTY", []int{1}},
-	OP_METHOD:        {"OP_METHOD", []int{2}},
+	OP_CONSTANT:            {"OP_CONSTANT", []int{2}},
+	OP_NEGATE:              {"OP_NEGATE", []int{}},
+	OP_RETURN:              {"OP_RETURN", []int{}},
+	OP_ADD:                 {"OP_ADD", []int{}},
+	OP_SUBTRACT:            {"OP_SUBTRACT", []int{}},
+	OP_MULTIPLY:            {"OP_MULTIPLY", []int{}},
+	OP_DIVIDE:              {"OP_DIVIDE", []int{}},
+	OP_TRUE:                {"OP_TRUE", []int{}},
+	OP_FALSE:               {"OP_FALSE", []int{}},
+	OP_NIL:                 {"OP_NIL", []int{}},
+	OP_LESS:                {"OP_LESS", []int{}},
+	OP_GREATER:             {"OP_GREATER", []int{}},
+	OP_EQUAL:               {"OP_EQUAL", []int{}},
+	OP_NOT:                 {"OP_NOT", []int{}},
+	OP_POP:                 {"OP_POP", []int{}},
+	OP_DEFINE_GLOBAL:       {"OP_DEFINE_GLOBAL", []int{2}},
+	OP_DEFINE_LOCAL:        {"OP_DEFINE_LOCAL", []int{1}},
+	OP_GET_GLOBAL:          {"OP_GET_GLOBAL", []int{2}},
+	OP_GET_LOCAL:           {"OP_GET_LOCAL", []int{1}},
+	OP_GET_BUILTIN:         {"OP_GET_BUILTIN", []int{1}},
+	OP_SET_GLOBAL:          {"OP_SET_GLOBAL", []int{2}},
+	OP_SET_LOCAL:           {"OP_SET_LOCAL", []int{1}},
+	OP_JUMP_IF_FALSE:       {"OP_JUMP_IF_FALSE", []int{2}},
+	OP_JUMP:                {"OP_JUMP", []int{2}},
+	OP_LOOP:                {"OP_LOOP", []int{2}},
+	OP_CALL:                {"OP_CALL", []int{1}},
+	OP_FUNCTION:            {"OP_FUNCTION", []int{2}},
+	OP_CLOSURE:             {"OP_CLOSURE", []int{2, 1}},
+	OP_GET_UPVALUE:         {"OP_GET_UPVALUE", []int{1}},
+	OP_CLASS:               {"OP_CLASS", []int{2}},
+	OP_SET_PROPERTY:        {"OP_SET_PROPERTY", []int{1}},
+	OP_GET_PROPERTY:        {"OP_GET_PROPERTY", []int{1}},
+	OP_METHOD:              {"OP_METHOD", []int{2}},
+	OP_MODULO:              {"OP_MODULO", []int{}},
+	OP_SET_UPVALUE:         {"OP_SET_UPVALUE", []int{1}},
+	OP_DUP:                 {"OP_DUP", []int{1}},
+	OP_BURY:                {"OP_BURY", []int{1}},
+	OP_JUMP_IF_NOT_NIL:     {"OP_JUMP_IF_NOT_NIL", []int{2}},
+	OP_ARRAY:               {"OP_ARRAY", []int{2}},
+	OP_HASH:                {"OP_HASH", []int{2}},
+	OP_INDEX:               {"OP_INDEX", []int{}},
+	OP_SET_INDEX:           {"OP_SET_INDEX", []int{}},
+	OP_TO_STRING:           {"OP_TO_STRING", []int{}},
+	OP_INTERPOLATE:         {"OP_INTERPOLATE", []int{2}},
+	OP_SLICE:               {"OP_SLICE", []int{}},
+	OP_SET_SLICE:           {"OP_SET_SLICE", []int{}},
+	OP_ITERATOR:            {"OP_ITERATOR", []int{1}},
+	OP_ITER_NEXT:           {"OP_ITER_NEXT", []int{2}},
+	OP_RANGE:               {"OP_RANGE", []int{1}},
+	OP_IN:                  {"OP_IN", []int{}},
+	OP_MATCH_EQUAL:         {"OP_MATCH_EQUAL", []int{}},
+	OP_MATCH_ARRAY:         {"OP_MATCH_ARRAY", []int{2}},
+	OP_MATCH_KEY:           {"OP_MATCH_KEY", []int{}},
+	OP_MATCH_CLASS:         {"OP_MATCH_CLASS", []int{1}},
+	OP_MATCH_FIELD:         {"OP_MATCH_FIELD", []int{1}},
+	OP_NO_MATCH:            {"OP_NO_MATCH", []int{}},
+	OP_JUMP_TABLE:          {"OP_JUMP_TABLE", []int{2}},
+	OP_DESTRUCTURE_ARRAY:   {"OP_DESTRUCTURE_ARRAY", []int{2, 2, 1}},
+	OP_DESTRUCTURE_HASH:    {"OP_DESTRUCTURE_HASH", []int{}},
+	OP_DESTRUCTURE_INDEX:   {"OP_DESTRUCTURE_INDEX", []int{2, 2}},
+	OP_DESTRUCTURE_KEY:     {"OP_DESTRUCTURE_KEY", []int{2, 2}},
+	OP_DESTRUCTURE_MISSING: {"OP_DESTRUCTURE_MISSING", []int{2}},
+	OP_CALL_EXTENDED:       {"OP_CALL_EXTENDED", []int{2}},
+	OP_TRY:                 {"OP_TRY", []int{2}},
+	OP_END_TRY:             {"OP_END_TRY", []int{}},
+	OP_THROW:               {"OP_THROW", []int{}},
+	OP_YIELD:               {"OP_YIELD", []int{}},
+	OP_SPAWN:               {"OP_SPAWN", []int{1}},
+	OP_SELECT:              {"OP_SELECT", []int{2}},
+	OP_AWAIT:               {"OP_AWAIT", []int{}},
+	OP_IMPORT:              {"OP_IMPORT", []int{2}},
+	OP_INHERIT:             {"OP_INHERIT", []int{}},
+	OP_GET_SUPER:           {"OP_GET_SUPER", []int{2}},
+	OP_LOOP_ENTER:          {"OP_LOOP_ENTER", []int{1}},
+	OP_UNWIND:              {"OP_UNWIND", []int{1}},
 }
 
 func Lookup(opcode byte) (*Definition, error) {
@@ -9,15 +9,37 @@ type Parser struct {
 	errors  *ErrorHandler
 	tokens  []*Token
 	current int
+	// comments were taken out of tokens, for the program to keep
+	comments []*Token
+	// generator is set while parsing the body of a function*, where yield
+	// is allowed
+	generator bool
+	// async is set in the body of an async function; function is set in
+	// any function body. await is allowed in async functions and at the
+	// top level.
+	async    bool
+	function bool
 }
 
 func NewParser(tokens []*Token) *Parser {
 	errors := NewErrorHandler()
 
+	// comments aren't part of the grammar, the program keeps them aside
+	code := make([]*Token, 0, len(tokens))
+	var comments []*Token
+	for _, token := range tokens {
+		if token.Type == COMMENT {
+			comments = append(comments, token)
+		} else {
+			code = append(code, token)
+		}
+	}
+
 	return &Parser{
-		tokens:  tokens,
-		current: 0,
-		errors:  errors,
+		tokens:   code,
+		comments: comments,
+		current:  0,
+		errors:   errors,
 	}
 }
 
@@ -59,6 +81,14 @@ func (p *Parser) check(tokenType TokenType) bool {
 	return p.peek().Type == tokenType
 }
 
+// checkNext looks one token past the current one.
+func (p *Parser) checkNext(tokenType TokenType) bool {
+	if p.current+1 >= len(p.tokens) {
+		return false
+	}
+	return p.tokens[p.current+1].Type == tokenType
+}
+
 func (p *Parser) advance() Token {
 	if !p.isAtEnd() {
 		p.current++
@@ -67,10 +97,26 @@ func (p *Parser) advance() Token {
 }
 
 func (p *Parser) parse() *Program {
-	program := &Program{}
+	program := &Program{Comments: p.comments}
 
 	for !p.isAtEnd() {
-		stmt := p.declaration()
+		var stmt Statement
+		switch p.peek().Type {
+		case IMPORT:
+			stmt = p.importStatement()
+		case EXPORT:
+			stmt = p.exportStatement()
+		case IDENTIFIER:
+			// test is only a keyword before the name of a test, so it is
+			// still free to name a variable
+			if p.peek().Lexeme == "test" && p.checkNext(STRING) {
+				stmt = p.testStatement()
+			} else {
+				stmt = p.declaration()
+			}
+		default:
+			stmt = p.declaration()
+		}
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		} else {
@@ -88,13 +134,141 @@ func (p *Parser) declaration() Statement {
 		return p.classDeclaration()
 	case FUNCTION:
 		return p.functionDeclaration()
-	case VAR:
+	case ASYNC:
+		if p.checkNext(FUNCTION) {
+			return p.functionDeclaration()
+		}
+		return p.statement()
+	case VAR, LET, CONST:
 		return p.varDeclaration()
+	case IMPORT, EXPORT:
+		token := p.advance()
+		p.addError(&Error{Token: token, Message: fmt.Sprintf("'%s' is only allowed at the top level of a file.", token.Lexeme), Line: token.Line})
+		return nil
+	case IDENTIFIER:
+		if p.peek().Lexeme == "test" && p.checkNext(STRING) {
+			token := p.advance()
+			p.addError(&Error{Token: token, Message: fmt.Sprintf("'%s' is only allowed at the top level of a file.", token.Lexeme), Line: token.Line})
+			return nil
+		}
+		return p.statement()
 	default:
 		return p.statement()
 	}
 }
 
+// importStatement parses `import "path" as name;` or
+// `import { a, b } from "path";`.
+func (p *Parser) importStatement() Statement {
+	stmt := &ImportStatement{Token: p.advance()}
+
+	if p.match(STRING) {
+		stmt.Path = p.previous().Lexeme
+		if !p.expectContextual("as") || !p.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		stmt.Alias = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
+		if !p.expectPeek(SEMICOLON) {
+			return nil
+		}
+		return stmt
+	}
+
+	if !p.expectPeek(LEFT_BRACKET) {
+		return nil
+	}
+	for !p.check(RIGHT_BRACKET) {
+		if !p.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		stmt.Names = append(stmt.Names, &Identifier{Token: p.previous(), Value: p.previous().Lexeme})
+		if !p.match(COMMA) {
+			break
+		}
+	}
+	if !p.expectPeek(RIGHT_BRACKET) {
+		return nil
+	}
+	if len(stmt.Names) == 0 {
+		p.addError(&Error{Token: stmt.Token, Message: "Expect at least one name to import.", Line: stmt.Token.Line})
+		return nil
+	}
+
+	if !p.expectContextual("from") || !p.expectPeek(STRING) {
+		return nil
+	}
+	stmt.Path = p.previous().Lexeme
+	if !p.expectPeek(SEMICOLON) {
+		return nil
+	}
+
+	return stmt
+}
+
+// exportStatement parses `export` followed by a function, class or single
+// variable declaration.
+func (p *Parser) exportStatement() Statement {
+	stmt := &ExportStatement{Token: p.advance()}
+
+	switch {
+	case p.check(FUNCTION), p.check(ASYNC) && p.checkNext(FUNCTION):
+		if fun := p.functionDeclaration(); fun != nil {
+			stmt.Declaration = fun
+		}
+	case p.check(CLASS):
+		if class := p.classDeclaration(); class != nil {
+			stmt.Declaration = class
+		}
+	case p.check(VAR), p.check(LET), p.check(CONST):
+		decl := p.varDeclaration()
+		if _, ok := decl.(*VarStatement); !ok && decl != nil {
+			p.addError(&Error{Token: stmt.Token, Message: "Can only export a single variable.", Line: stmt.Token.Line})
+			return nil
+		}
+		stmt.Declaration = decl
+	default:
+		p.addError(&Error{Token: stmt.Token, Message: "Expect a function, class or var declaration after 'export'.", Line: stmt.Token.Line})
+	}
+
+	if stmt.Declaration == nil {
+		return nil
+	}
+	return stmt
+}
+
+// testStatement parses `test "name" { ... }`.
+func (p *Parser) testStatement() Statement {
+	stmt := &TestStatement{Token: p.advance()}
+	stmt.Name = p.advance().Lexeme
+	if stmt.Name == "" {
+		p.addError(&Error{Token: stmt.Token, Message: "Expect a name for the test.", Line: stmt.Token.Line})
+		return nil
+	}
+
+	if !p.check(LEFT_BRACKET) {
+		p.addError(&Error{Message: fmt.Sprintf("Expect '{' before the body of the test, got=%s", p.peek().Lexeme), Line: p.peek().Line})
+		return nil
+	}
+	stmt.Body = p.block()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+// expectContextual consumes an identifier used as a keyword in one place
+// only, like the "as" and "from" of an import.
+func (p *Parser) expectContextual(word string) bool {
+	if !p.check(IDENTIFIER) || p.peek().Lexeme != word {
+		p.addError(&Error{Message: fmt.Sprintf("Expect '%s', got=%s", word, p.peek().Lexeme), Line: p.peek().Line})
+		return false
+	}
+
+	p.advance()
+	return true
+}
+
 func (p *Parser) statement() Statement {
 	switch p.peek().Type {
 	case CONTINUE:
@@ -103,12 +277,20 @@ func (p *Parser) statement() Statement {
 		return p.breakStatement()
 	case FOR:
 		return p.forStatement()
+	case MATCH:
+		return p.matchStatement()
 	case WHILE:
 		return p.whileStatement()
 	case IF:
 		return p.ifStatement()
 	case RETURN:
 		return p.returnStatement()
+	case THROW:
+		return p.throwStatement()
+	case TRY:
+		return p.tryStatement()
+	case SELECT:
+		return p.selectStatement()
 	default:
 		return p.expressionStatement()
 	}
@@ -158,7 +340,12 @@ func (p *Parser) classDeclaration() *ClassStatement {
 
 func (p *Parser) functionDeclaration() *FunctionDeclaration {
 	fun := &FunctionDeclaration{}
+	fun.IsAsync = p.match(ASYNC)
 	fun.Token = p.advance()
+	fun.IsGenerator = p.match(STAR)
+	if !p.checkAsyncGenerator(&fun.FunctionCommon) {
+		return nil
+	}
 
 	if !p.expectPeek(IDENTIFIER) {
 		return nil
@@ -170,12 +357,20 @@ func (p *Parser) functionDeclaration() *FunctionDeclaration {
 		return nil
 	}
 
-	fun.Params = p.parseFunctionParams()
-	fun.Body = p.block()
+	prologue := p.parseFunctionParams(&fun.FunctionCommon)
+	fun.Body = p.parseFunctionBody(prologue, &fun.FunctionCommon)
 
 	return fun
 }
 
+func (p *Parser) checkAsyncGenerator(fun *FunctionCommon) bool {
+	if fun.IsAsync && fun.IsGenerator {
+		p.addError(&Error{Token: fun.Token, Message: "An async function can't be a generator.", Line: fun.Token.Line})
+		return false
+	}
+	return true
+}
+
 func (p *Parser) breakStatement() *BreakStatement {
 	stmt := &BreakStatement{Token: p.advance()}
 
@@ -196,18 +391,24 @@ func (p *Parser) continueStatement() *ContinueStatement {
 	return stmt
 }
 
-func (p *Parser) forStatement() *For {
-	stmt := &For{Token: p.advance()}
+func (p *Parser) forStatement() Statement {
+	token := p.advance()
 
 	if !p.expectPeek(LEFT_PAREN) {
 		return nil
 	}
 
+	if p.isForIn() {
+		return p.forInStatement(token)
+	}
+
+	stmt := &For{Token: token}
+
 	switch p.peek().Type {
 	case SEMICOLON:
 		stmt.Initializer = nil
 		p.advance()
-	case VAR:
+	case VAR, LET, CONST:
 		stmt.Initializer = p.varDeclaration()
 	default:
 		stmt.Initializer = p.expressionStatement()
@@ -231,6 +432,61 @@ func (p *Parser) forStatement() *For {
 	return stmt
 }
 
+// isForIn looks past the opening parenthesis for `var name in` or
+// `var key, value in`.
+func (p *Parser) isForIn() bool {
+	lookahead := func(n int) TokenType {
+		if p.current+n >= len(p.tokens) {
+			return EOF
+		}
+		return p.tokens[p.current+n].Type
+	}
+
+	switch lookahead(0) {
+	case VAR, LET, CONST:
+	default:
+		return false
+	}
+	if lookahead(1) != IDENTIFIER {
+		return false
+	}
+
+	switch lookahead(2) {
+	case IN:
+		return true
+	case COMMA:
+		return lookahead(3) == IDENTIFIER && lookahead(4) == IN
+	default:
+		return false
+	}
+}
+
+func (p *Parser) forInStatement(token Token) *ForInStatement {
+	stmt := &ForInStatement{Token: token}
+
+	stmt.Declaration = p.advance()
+	p.advance()
+	stmt.Value = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
+
+	if p.match(COMMA) {
+		p.advance()
+		stmt.Key = stmt.Value
+		stmt.Value = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
+	}
+
+	if !p.expectPeek(IN) {
+		return nil
+	}
+	stmt.Iterable = p.expression()
+
+	if !p.expectPeek(RIGHT_PAREN) {
+		return nil
+	}
+	stmt.Body = p.block()
+
+	return stmt
+}
+
 func (p *Parser) whileStatement() *While {
 	stmt := &While{Token: p.advance()}
 	if !p.expectPeek(LEFT_PAREN) {
@@ -271,18 +527,27 @@ func (p *Parser) block() *BlockStatement {
 		stmt := p.declaration()
 		if stmt != nil {
 			blockStmt.Statements = append(blockStmt.Statements, stmt)
+		} else {
+			p.synchronize()
 		}
 	}
 
 	if !p.expectPeek(RIGHT_BRACKET) {
 		return nil
 	}
+	blockStmt.End = p.previous()
 
 	return blockStmt
 }
 
-func (p *Parser) varDeclaration() *VarStatement {
-	stmt := &VarStatement{Token: p.advance()}
+func (p *Parser) varDeclaration() Statement {
+	token := p.advance()
+
+	if p.match(LEFT_BRACE, LEFT_BRACKET) {
+		return p.destructureDeclaration(token)
+	}
+
+	stmt := &VarStatement{Token: token}
 
 	if !p.expectPeek(IDENTIFIER) {
 		return nil
@@ -291,6 +556,10 @@ func (p *Parser) varDeclaration() *VarStatement {
 	stmt.Identifier = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
 
 	if !p.check(EQUAL) {
+		if token.Type == CONST {
+			p.addError(&Error{Token: p.peek(), Message: "Missing initializer in const declaration.", Line: p.peek().Line})
+			return nil
+		}
 		// nil
 		if p.expectPeek(SEMICOLON) {
 			stmt.Expression = &NilLiteral{}
@@ -308,6 +577,30 @@ func (p *Parser) varDeclaration() *VarStatement {
 	return stmt
 }
 
+// destructureDeclaration parses `var [a, b] = value;` or `var {a, b} = value;`,
+// the opening bracket already consumed.
+func (p *Parser) destructureDeclaration(token Token) Statement {
+	stmt := &DestructureStatement{Token: token}
+
+	stmt.Pattern = p.parseDestructure(false)
+	if stmt.Pattern == nil {
+		return nil
+	}
+
+	if !p.check(EQUAL) {
+		p.addError(&Error{Token: p.peek(), Message: "Destructuring declaration needs a value.", Line: p.peek().Line})
+		return nil
+	}
+	p.advance()
+
+	stmt.Value = p.expression()
+
+	if !p.expectPeek(SEMICOLON) {
+		return nil
+	}
+	return stmt
+}
+
 func (p *Parser) returnStatement() *ReturnStatement {
 	stmt := &ReturnStatement{Token: p.advance()}
 
@@ -325,119 +618,343 @@ func (p *Parser) returnStatement() *ReturnStatement {
 	return stmt
 }
 
-func (p *Parser) expressionStatement() Statement {
-	stmt := &ExpressionStatement{Token: p.peek()}
+func (p *Parser) parseYield() Expression {
+	yield := &YieldExpression{Token: p.previous()}
 
-	stmt.Expression = p.expression()
+	if !p.generator {
+		p.addError(&Error{Message: "Can't use 'yield' outside a generator function.", Line: yield.Token.Line})
+		return nil
+	}
 
-	if !p.expectPeek(SEMICOLON) {
+	switch p.peek().Type {
+	case SEMICOLON, RIGHT_PAREN, RIGHT_BRACE, RIGHT_BRACKET, COMMA, COLON:
+		return yield
+	}
+
+	if yield.Value = p.assignment(); yield.Value == nil {
 		return nil
 	}
-	return stmt
+
+	return yield
 }
 
-func (p *Parser) expectPeek(tokenType TokenType) bool {
-	if !p.check(tokenType) {
-		err := &Error{Message: fmt.Sprintf("Expect peek to be %s, got=%s", tokenType, p.peek().Type), Line: p.peek().Line}
-		p.addError(err)
-		return false
+func (p *Parser) throwStatement() Statement {
+	stmt := &ThrowStatement{Token: p.advance()}
+
+	stmt.Value = p.expression()
+	if stmt.Value == nil || !p.expectPeek(SEMICOLON) {
+		return nil
 	}
 
-	p.advance()
-	return true
+	return stmt
 }
 
-// Discard tokens until we're right at the beginning of the next statement
-// After catching parse error we'll call this and then we are hopefully back in sync.
-func (p *Parser) synchronize() {
-	p.advance()
-
-	for !p.isAtEnd() {
-		if p.previous().Type == SEMICOLON {
-			return
-		}
+func (p *Parser) parseAwait() Expression {
+	await := &AwaitExpression{Token: p.previous()}
 
-		switch p.peek().Type {
-		case CLASS, FUNCTION, VAR, FOR, IF, WHILE, RETURN:
-			return
-		default:
-			// do nothing
-		}
+	if p.function && !p.async {
+		p.addError(&Error{Token: await.Token, Message: "Can't use 'await' outside an async function.", Line: await.Token.Line})
+		return nil
+	}
 
-		p.advance()
+	if await.Value = p.unary(); await.Value == nil {
+		return nil
 	}
+
+	return await
 }
 
-func (p *Parser) primary() Expression {
-	if p.match(FALSE) {
-		return &BooleanLiteral{Token: p.previous(), Value: false}
-	}
-	if p.match(TRUE) {
-		return &BooleanLiteral{Token: p.previous(), Value: true}
-	}
-	if p.match(NIL) {
-		return &NilLiteral{Token: p.previous()}
-	}
-	if p.match(STRING) {
-		return &StringLiteral{Token: p.previous(), Value: p.previous().Lexeme}
-	}
-	if p.match(NUMBER) {
-		num, err := strconv.ParseFloat(p.previous().Lexeme, 64)
-		if err != nil {
-			p.addError(&Error{Message: err.Error(), Line: p.previous().Line})
-			return nil
-		}
-		return &NumberLiteral{Token: p.previous(), Value: num}
-	}
-	if p.match(LEFT_PAREN) {
-		expr := p.expression()
-		if p.expectPeek(RIGHT_PAREN) {
-			return &GroupedExpression{Token: p.previous(), Expression: expr}
-		}
+func (p *Parser) parseSpawn() Expression {
+	spawn := &SpawnExpression{Token: p.previous()}
+
+	call, ok := p.call().(*CallExpression)
+	if !ok {
+		p.addError(&Error{Token: spawn.Token, Message: "Expect a call after 'spawn'.", Line: spawn.Token.Line})
+		return nil
 	}
-	if p.match(LEFT_BRACKET) {
-		hash := &HashLiteral{Token: p.previous()}
-		hash.Pairs = make(map[Expression]Expression)
+	spawn.Call = call
 
-		for !p.isAtEnd() && !p.check(RIGHT_BRACKET) {
-			key := p.expression()
+	return spawn
+}
 
-			if !p.expectPeek(COLON) {
-				return nil
-			}
+func (p *Parser) selectStatement() Statement {
+	stmt := &SelectStatement{Token: p.advance()}
 
-			value := p.expression()
-			hash.Pairs[key] = value
+	if !p.expectPeek(LEFT_BRACKET) {
+		return nil
+	}
 
-			if !p.check(RIGHT_BRACKET) && !p.check(COMMA) {
+	for !p.check(RIGHT_BRACKET) && !p.isAtEnd() {
+		if p.check(IDENTIFIER) && p.peek().Lexeme == "_" && p.checkNext(FAT_ARROW) {
+			token := p.advance()
+			if stmt.Default != nil {
+				p.addError(&Error{Token: token, Message: "Select can only have one default case.", Line: token.Line})
 				return nil
 			}
-			if p.check(COMMA) {
-				p.advance()
+			if stmt.Default = p.selectBody(); stmt.Default == nil {
+				return nil
 			}
+			continue
 		}
 
-		if !p.expectPeek(RIGHT_BRACKET) {
+		selectCase := p.selectCase()
+		if selectCase == nil {
 			return nil
 		}
-
-		return hash
-	}
-	if p.match(LEFT_BRACE) {
-		array := &ArrayLiteral{Token: p.previous()}
-		array.Elements = p.parseExpressionList(RIGHT_BRACE)
-		return array
+		stmt.Cases = append(stmt.Cases, selectCase)
 	}
-	if p.match(IDENTIFIER) {
-		return &Identifier{
-			Token: p.previous(),
-			Value: p.previous().Lexeme,
-		}
+
+	if !p.expectPeek(RIGHT_BRACKET) {
+		return nil
 	}
-	if p.match(FUNCTION) {
-		return p.parseFunctionLiteral()
+
+	if len(stmt.Cases) == 0 {
+		p.addError(&Error{Token: stmt.Token, Message: "Expect at least one select case.", Line: stmt.Token.Line})
+		return nil
 	}
-	if p.match(THIS) {
+
+	return stmt
+}
+
+// selectCase parses `var name = channel.recv() => { ... }`, with the
+// binding optional, or `channel.send(value) => { ... }`.
+func (p *Parser) selectCase() *SelectCase {
+	selectCase := &SelectCase{Token: p.peek()}
+
+	if p.match(VAR) {
+		if !p.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		selectCase.Name = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
+		if !p.expectPeek(EQUAL) {
+			return nil
+		}
+	}
+
+	var property *GetExpression
+	call, ok := p.call().(*CallExpression)
+	if ok {
+		property, _ = call.Callee.(*GetExpression)
+	}
+	if property == nil || len(call.Named) > 0 {
+		p.addError(&Error{Token: selectCase.Token, Message: "Expect a channel send or recv in select case.", Line: selectCase.Token.Line})
+		return nil
+	}
+
+	selectCase.Channel = property.Object
+
+	switch {
+	case property.Property.Value == ChannelRecvMethod && len(call.Arguments) == 0:
+	case property.Property.Value == ChannelSendMethod && len(call.Arguments) == 1 && selectCase.Name == nil:
+		selectCase.Value = call.Arguments[0]
+	default:
+		p.addError(&Error{Token: selectCase.Token, Message: "Expect a channel send or recv in select case.", Line: selectCase.Token.Line})
+		return nil
+	}
+
+	if selectCase.Body = p.selectBody(); selectCase.Body == nil {
+		return nil
+	}
+
+	return selectCase
+}
+
+func (p *Parser) selectBody() *BlockStatement {
+	if !p.expectPeek(FAT_ARROW) {
+		return nil
+	}
+	if !p.check(LEFT_BRACKET) {
+		p.addError(&Error{Message: "Expect '{' after '=>' in select case.", Line: p.peek().Line})
+		return nil
+	}
+	return p.block()
+}
+
+func (p *Parser) tryStatement() Statement {
+	stmt := &TryStatement{Token: p.advance()}
+
+	if !p.check(LEFT_BRACKET) {
+		p.addError(&Error{Message: "Expect '{' after 'try'.", Line: p.peek().Line})
+		return nil
+	}
+	if stmt.Body = p.block(); stmt.Body == nil {
+		return nil
+	}
+
+	if p.match(CATCH) {
+		if p.match(LEFT_PAREN) {
+			if !p.expectPeek(IDENTIFIER) {
+				return nil
+			}
+			stmt.CatchName = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
+			if !p.expectPeek(RIGHT_PAREN) {
+				return nil
+			}
+		}
+		if !p.check(LEFT_BRACKET) {
+			p.addError(&Error{Message: "Expect '{' after 'catch'.", Line: p.peek().Line})
+			return nil
+		}
+		if stmt.Catch = p.block(); stmt.Catch == nil {
+			return nil
+		}
+	}
+
+	if p.match(FINALLY) {
+		if !p.check(LEFT_BRACKET) {
+			p.addError(&Error{Message: "Expect '{' after 'finally'.", Line: p.peek().Line})
+			return nil
+		}
+		if stmt.Finally = p.block(); stmt.Finally == nil {
+			return nil
+		}
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.addError(&Error{Message: "Expect 'catch' or 'finally' after try block.", Line: p.peek().Line})
+		return nil
+	}
+
+	return stmt
+}
+
+// matchStatement is a match used as a statement, where the closing brace
+// ends it and the semicolon is optional.
+func (p *Parser) matchStatement() Statement {
+	stmt := &ExpressionStatement{Token: p.peek()}
+
+	stmt.Expression = p.expression()
+	p.match(SEMICOLON)
+
+	return stmt
+}
+
+func (p *Parser) expressionStatement() Statement {
+	stmt := &ExpressionStatement{Token: p.peek()}
+
+	stmt.Expression = p.expression()
+
+	if !p.expectPeek(SEMICOLON) {
+		return nil
+	}
+	return stmt
+}
+
+func (p *Parser) expectPeek(tokenType TokenType) bool {
+	if !p.check(tokenType) {
+		err := &Error{Message: fmt.Sprintf("Expect peek to be %s, got=%s", tokenType, p.peek().Type), Line: p.peek().Line}
+		p.addError(err)
+		return false
+	}
+
+	p.advance()
+	return true
+}
+
+// Discard tokens until we're right at the beginning of the next statement
+// After catching parse error we'll call this and then we are hopefully back in sync.
+func (p *Parser) synchronize() {
+	p.advance()
+
+	for !p.isAtEnd() {
+		if p.previous().Type == SEMICOLON {
+			return
+		}
+
+		switch p.peek().Type {
+		case CLASS, FUNCTION, ASYNC, VAR, LET, CONST, FOR, IF, WHILE, RETURN, THROW, TRY, SELECT, IMPORT, EXPORT:
+			return
+		default:
+			// do nothing
+		}
+
+		p.advance()
+	}
+}
+
+func (p *Parser) primary() Expression {
+	if p.match(FALSE) {
+		return &BooleanLiteral{Token: p.previous(), Value: false}
+	}
+	if p.match(TRUE) {
+		return &BooleanLiteral{Token: p.previous(), Value: true}
+	}
+	if p.match(NIL) {
+		return &NilLiteral{Token: p.previous()}
+	}
+	if p.match(STRING) {
+		return &StringLiteral{Token: p.previous(), Value: p.previous().Lexeme}
+	}
+	if p.match(INTERPOLATION) {
+		return p.parseInterpolation()
+	}
+	if p.match(NUMBER) {
+		num, err := strconv.ParseFloat(p.previous().Lexeme, 64)
+		if err != nil {
+			p.addError(&Error{Message: err.Error(), Line: p.previous().Line})
+			return nil
+		}
+		return &NumberLiteral{Token: p.previous(), Value: num}
+	}
+	if p.match(LEFT_PAREN) {
+		expr := p.expression()
+		if p.expectPeek(RIGHT_PAREN) {
+			return &GroupedExpression{Token: p.previous(), Expression: expr}
+		}
+	}
+	if p.match(LEFT_BRACKET) {
+		hash := &HashLiteral{Token: p.previous()}
+		hash.Pairs = make(map[Expression]Expression)
+
+		for !p.isAtEnd() && !p.check(RIGHT_BRACKET) {
+			key := p.expression()
+
+			if !p.expectPeek(COLON) {
+				return nil
+			}
+
+			value := p.expression()
+			hash.Pairs[key] = value
+			hash.Keys = append(hash.Keys, key)
+
+			if !p.check(RIGHT_BRACKET) && !p.check(COMMA) {
+				return nil
+			}
+			if p.check(COMMA) {
+				p.advance()
+			}
+		}
+
+		if !p.expectPeek(RIGHT_BRACKET) {
+			return nil
+		}
+
+		return hash
+	}
+	if p.match(LEFT_BRACE) {
+		array := &ArrayLiteral{Token: p.previous()}
+		array.Elements = p.parseExpressionList(RIGHT_BRACE)
+		return array
+	}
+	if p.match(IDENTIFIER) {
+		return &Identifier{
+			Token: p.previous(),
+			Value: p.previous().Lexeme,
+		}
+	}
+	if p.match(FUNCTION) {
+		return p.parseFunctionLiteral(false)
+	}
+	if p.match(ASYNC) {
+		if !p.expectPeek(FUNCTION) {
+			return nil
+		}
+		return p.parseFunctionLiteral(true)
+	}
+	if p.match(MATCH) {
+		return p.parseMatch()
+	}
+	if p.match(THIS) {
 		return &This{Token: p.previous()}
 	}
 	if p.match(SUPER) {
@@ -456,6 +973,184 @@ func (p *Parser) primary() Expression {
 	return nil
 }
 
+func (p *Parser) parseMatch() Expression {
+	match := &MatchExpression{Token: p.previous()}
+
+	if !p.expectPeek(LEFT_PAREN) {
+		return nil
+	}
+	match.Subject = p.expression()
+	if !p.expectPeek(RIGHT_PAREN) {
+		return nil
+	}
+	if !p.expectPeek(LEFT_BRACKET) {
+		return nil
+	}
+
+	for !p.check(RIGHT_BRACKET) && !p.isAtEnd() {
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		match.Arms = append(match.Arms, arm)
+
+		// arms are separated by optional commas
+		p.match(COMMA)
+	}
+
+	if !p.expectPeek(RIGHT_BRACKET) {
+		return nil
+	}
+
+	if len(match.Arms) == 0 {
+		p.addError(&Error{Token: match.Token, Message: "Expect at least one match arm.", Line: match.Token.Line})
+		return nil
+	}
+
+	return match
+}
+
+func (p *Parser) parseMatchArm() *MatchArm {
+	arm := &MatchArm{Token: p.peek()}
+
+	for {
+		pattern := p.parsePattern()
+		if pattern == nil {
+			return nil
+		}
+		arm.Patterns = append(arm.Patterns, pattern)
+
+		if !p.match(COMMA) {
+			break
+		}
+	}
+
+	if p.match(IF) {
+		arm.Guard = p.expression()
+	}
+
+	if !p.expectPeek(FAT_ARROW) {
+		return nil
+	}
+
+	if p.isBlockArm() {
+		arm.Block = p.block()
+		if arm.Block == nil {
+			return nil
+		}
+	} else {
+		arm.Body = p.expression()
+	}
+
+	return arm
+}
+
+// isBlockArm tells a block arm body from a hash literal one: `{}` and
+// `{ key: ...` are hashes, any other brace opens a block.
+func (p *Parser) isBlockArm() bool {
+	if !p.check(LEFT_BRACKET) || p.current+2 >= len(p.tokens) {
+		return false
+	}
+
+	next := p.tokens[p.current+1].Type
+	return next != RIGHT_BRACKET && p.tokens[p.current+2].Type != COLON
+}
+
+func (p *Parser) parsePattern() Pattern {
+	switch {
+	case p.check(NUMBER), p.check(STRING), p.check(TRUE), p.check(FALSE), p.check(NIL):
+		return &LiteralPattern{Token: p.peek(), Value: p.primary()}
+	case p.check(MINUS):
+		token := p.advance()
+		if !p.check(NUMBER) {
+			p.addError(&Error{Token: token, Message: "Expect number after '-' in pattern.", Line: token.Line})
+			return nil
+		}
+		value := &Unary{Token: token, Operator: token.Lexeme, Right: p.primary()}
+		return &LiteralPattern{Token: token, Value: value}
+	case p.match(LEFT_BRACE):
+		pattern := &ArrayPattern{Token: p.previous()}
+
+		for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+			element := p.parsePattern()
+			if element == nil {
+				return nil
+			}
+			pattern.Elements = append(pattern.Elements, element)
+
+			if !p.match(COMMA) {
+				break
+			}
+		}
+
+		if !p.expectPeek(RIGHT_BRACE) {
+			return nil
+		}
+		return pattern
+	case p.match(LEFT_BRACKET):
+		pattern := &HashPattern{Token: p.previous()}
+
+		for !p.check(RIGHT_BRACKET) && !p.isAtEnd() {
+			if !p.check(NUMBER) && !p.check(STRING) && !p.check(TRUE) && !p.check(FALSE) {
+				p.addError(&Error{Token: p.peek(), Message: "Expect literal key in hash pattern.", Line: p.peek().Line})
+				return nil
+			}
+			key := p.primary()
+
+			if !p.expectPeek(COLON) {
+				return nil
+			}
+
+			value := p.parsePattern()
+			if value == nil {
+				return nil
+			}
+			pattern.Keys = append(pattern.Keys, key)
+			pattern.Values = append(pattern.Values, value)
+
+			if !p.match(COMMA) {
+				break
+			}
+		}
+
+		if !p.expectPeek(RIGHT_BRACKET) {
+			return nil
+		}
+		return pattern
+	case p.match(IDENTIFIER):
+		name := &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
+
+		if name.Value == "_" {
+			return &WildcardPattern{Token: name.Token}
+		}
+
+		if !p.match(LEFT_PAREN) {
+			return &BindingPattern{Name: name}
+		}
+
+		pattern := &ClassPattern{Token: name.Token, Class: name}
+		for !p.check(RIGHT_PAREN) && !p.isAtEnd() {
+			field := p.parsePattern()
+			if field == nil {
+				return nil
+			}
+			pattern.Fields = append(pattern.Fields, field)
+
+			if !p.match(COMMA) {
+				break
+			}
+		}
+
+		if !p.expectPeek(RIGHT_PAREN) {
+			return nil
+		}
+		return pattern
+	default:
+		p.addError(&Error{Token: p.peek(), Message: "Expect pattern.", Line: p.peek().Line})
+		return nil
+	}
+}
+
 func (p *Parser) parseMethodDeclaration() *MethodDeclaration {
 	method := &MethodDeclaration{IsStatic: false, IsGetter: false}
 
@@ -463,6 +1158,7 @@ func (p *Parser) parseMethodDeclaration() *MethodDeclaration {
 		p.advance()
 		method.IsStatic = true
 	}
+	method.IsGenerator = p.match(STAR)
 
 	if !p.expectPeek(IDENTIFIER) {
 		return nil
@@ -471,14 +1167,19 @@ func (p *Parser) parseMethodDeclaration() *MethodDeclaration {
 	method.Token = p.previous()
 	method.Name = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
 
+	if method.IsGenerator && (method.Name.Value == "init" || p.check(LEFT_BRACKET)) {
+		p.addError(&Error{Token: method.Token, Message: "An initializer or getter can't be a generator.", Line: method.Token.Line})
+		return nil
+	}
+
 	switch p.peek().Type {
 	case LEFT_BRACKET:
 		method.IsGetter = true
 		method.Body = p.block()
 	case LEFT_PAREN:
 		p.advance()
-		method.Params = p.parseFunctionParams()
-		method.Body = p.block()
+		prologue := p.parseFunctionParams(&method.FunctionCommon)
+		method.Body = p.parseFunctionBody(prologue, &method.FunctionCommon)
 	default:
 		err := &Error{Token: p.peek(), Message: "Invalid method declaration", Line: p.peek().Line}
 		p.addError(err)
@@ -488,9 +1189,14 @@ func (p *Parser) parseMethodDeclaration() *MethodDeclaration {
 	return method
 }
 
-func (p *Parser) parseFunctionLiteral() *FunctionLiteral {
+func (p *Parser) parseFunctionLiteral(async bool) *FunctionLiteral {
 	fun := &FunctionLiteral{}
 	fun.Token = p.previous()
+	fun.IsAsync = async
+	fun.IsGenerator = p.match(STAR)
+	if !p.checkAsyncGenerator(&fun.FunctionCommon) {
+		return nil
+	}
 
 	if p.check(IDENTIFIER) {
 		fun.Name = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
@@ -500,12 +1206,40 @@ func (p *Parser) parseFunctionLiteral() *FunctionLiteral {
 		return nil
 	}
 
-	fun.Params = p.parseFunctionParams()
-	fun.Body = p.block()
+	prologue := p.parseFunctionParams(&fun.FunctionCommon)
+	fun.Body = p.parseFunctionBody(prologue, &fun.FunctionCommon)
 
 	return fun
 }
 
+// parseInterpolation parses the tokens the scanner emits for an interpolated
+// string: INTERPOLATION (expression INTERPOLATION)* expression STRING.
+func (p *Parser) parseInterpolation() *InterpolationExpression {
+	interpolation := &InterpolationExpression{Token: p.previous()}
+
+	for {
+		segment := p.previous()
+		if segment.Lexeme != "" {
+			interpolation.Parts = append(interpolation.Parts, &StringLiteral{Token: segment, Value: segment.Lexeme})
+		}
+
+		if segment.Type == STRING {
+			return interpolation
+		}
+
+		expr := p.expression()
+		if expr == nil {
+			return nil
+		}
+		interpolation.Parts = append(interpolation.Parts, expr)
+
+		if !p.match(INTERPOLATION, STRING) {
+			p.addError(&Error{Token: p.peek(), Message: "Expect end of string interpolation.", Line: p.peek().Line})
+			return nil
+		}
+	}
+}
+
 func (p *Parser) parseExpressionList(end TokenType) []Expression {
 	var list []Expression
 
@@ -532,38 +1266,274 @@ func (p *Parser) parseExpressionList(end TokenType) []Expression {
 	return list
 }
 
-func (p *Parser) parseFunctionParams() []*Identifier {
-	var identifiers []*Identifier
+// parseFunctionParams parses a parameter list into fun. A destructured
+// parameter is replaced by a hidden one named after its pattern, and the
+// declaration that unpacks it is returned in the prologue for the start of
+// the body. Parameters with a default have to come after the ones without,
+// and a `...rest` parameter comes last.
+// parseArguments parses a call's arguments into call: positional ones, which
+// may spread an array with `...`, followed by named ones written `name: value`.
+func (p *Parser) parseArguments(call *CallExpression) bool {
+	if p.match(RIGHT_PAREN) {
+		return true
+	}
+
+	for {
+		if len(call.Arguments)+len(call.Named) >= 255 {
+			p.addError(&Error{Token: p.peek(), Message: "Can't have more than 255 arguments", Line: p.peek().Line})
+			return false
+		}
+
+		switch {
+		case p.check(IDENTIFIER) && p.checkNext(COLON):
+			name := p.advance()
+			named := &NamedArgument{Token: p.advance(), Name: &Identifier{Token: name, Value: name.Lexeme}}
+			named.Value = p.expression()
+			call.Named = append(call.Named, named)
+		case len(call.Named) > 0:
+			p.addError(&Error{Token: p.peek(), Message: "Positional argument can't follow a named one.", Line: p.peek().Line})
+			return false
+		case p.match(ELLIPSIS):
+			call.Arguments = append(call.Arguments, &SpreadExpression{Token: p.previous(), Value: p.expression()})
+		default:
+			call.Arguments = append(call.Arguments, p.expression())
+		}
+
+		if !p.match(COMMA) {
+			break
+		}
+	}
+
+	return p.expectPeek(RIGHT_PAREN)
+}
+
+func (p *Parser) parseFunctionParams(fun *FunctionCommon) []Statement {
+	var prologue []Statement
 
 	if p.check(RIGHT_PAREN) {
 		p.advance()
-		return identifiers
+		return prologue
 	}
 
-	if !p.expectPeek(IDENTIFIER) {
+	for {
+		if len(fun.Params) >= 255 {
+			p.addError(&Error{Message: "Can't have more than 255 parameters", Line: p.peek().Line})
+			return nil
+		}
+
+		if p.match(ELLIPSIS) {
+			if !p.expectPeek(IDENTIFIER) {
+				return nil
+			}
+			fun.Rest = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
+			break
+		}
+
+		var identifier *Identifier
+		if p.match(LEFT_BRACE, LEFT_BRACKET) {
+			token := p.previous()
+			pattern := p.parseDestructure(false)
+			if pattern == nil {
+				return nil
+			}
+
+			identifier = &Identifier{Token: token, Value: pattern.String()}
+			prologue = append(prologue, &DestructureStatement{
+				Token:   Token{Type: VAR, Lexeme: "var", Line: token.Line},
+				Pattern: pattern,
+				Value:   identifier,
+			})
+		} else {
+			if !p.expectPeek(IDENTIFIER) {
+				return nil
+			}
+			identifier = &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
+		}
+
+		var value Expression
+		if p.match(EQUAL) {
+			value = p.or()
+		} else if fun.Required() < len(fun.Params) {
+			p.addError(&Error{Token: identifier.Token, Message: "Parameter without a default can't follow one with a default.", Line: identifier.Token.Line})
+			return nil
+		}
+		fun.Params = append(fun.Params, identifier)
+		fun.Defaults = append(fun.Defaults, value)
+
+		if !p.match(COMMA) {
+			break
+		}
+	}
+
+	if !p.expectPeek(RIGHT_PAREN) {
 		return nil
 	}
 
-	identifier := &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
-	identifiers = append(identifiers, identifier)
+	return prologue
+}
 
-	for p.match(COMMA) {
-		if len(identifiers) >= 255 {
-			p.addError(&Error{Message: "Can't have more than 255 parameters", Line: p.peek().Line})
+// parseFunctionBody parses a function's block with prologue in front of its
+// statements.
+func (p *Parser) parseFunctionBody(prologue []Statement, fun *FunctionCommon) *BlockStatement {
+	generator, async, function := p.generator, p.async, p.function
+	p.generator, p.async, p.function = fun.IsGenerator, fun.IsAsync, true
+	body := p.block()
+	p.generator, p.async, p.function = generator, async, function
+
+	if body != nil && len(prologue) > 0 {
+		body.Statements = append(prologue, body.Statements...)
+	}
+
+	return body
+}
+
+// parseDestructure parses an array or hash destructuring pattern, the opening
+// bracket already consumed. In an assignment the targets may be any
+// assignable expression, elsewhere they have to be identifiers.
+func (p *Parser) parseDestructure(assign bool) Destructure {
+	token := p.previous()
+
+	if token.Type == LEFT_BRACE {
+		if pattern := p.parseArrayDestructure(token, assign); pattern != nil {
+			return pattern
+		}
+		return nil
+	}
+
+	if pattern := p.parseHashDestructure(token, assign); pattern != nil {
+		return pattern
+	}
+	return nil
+}
+
+func (p *Parser) parseArrayDestructure(token Token, assign bool) *ArrayDestructure {
+	pattern := &ArrayDestructure{Token: token}
+
+	for !p.check(RIGHT_BRACE) {
+		if p.match(ELLIPSIS) {
+			pattern.Rest = p.parseDestructureTarget(assign)
+			if pattern.Rest == nil {
+				return nil
+			}
+			break
+		}
+
+		element := p.parseDestructureElement(assign)
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+
+		if !p.match(COMMA) {
+			break
+		}
+	}
+
+	if !p.expectPeek(RIGHT_BRACE) {
+		return nil
+	}
+
+	return pattern
+}
+
+func (p *Parser) parseHashDestructure(token Token, assign bool) *HashDestructure {
+	pattern := &HashDestructure{Token: token}
+
+	for !p.check(RIGHT_BRACKET) {
+		if !p.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		key := p.previous()
+
+		var entry *DestructureElement
+		if p.match(COLON) {
+			// {key: target}
+			entry = p.parseDestructureElement(assign)
+			if entry == nil {
+				return nil
+			}
+		} else {
+			// {key} binds the entry to a variable of the same name
+			entry = &DestructureElement{Target: &Identifier{Token: key, Value: key.Lexeme}}
+			if p.match(EQUAL) {
+				entry.Default = p.or()
+			}
+		}
+		entry.Key = key.Lexeme
+		pattern.Entries = append(pattern.Entries, entry)
+
+		if !p.match(COMMA) {
+			break
+		}
+	}
+
+	if !p.expectPeek(RIGHT_BRACKET) {
+		return nil
+	}
+
+	return pattern
+}
+
+// parseDestructureElement parses a target or a nested pattern, followed by
+// an optional `= default`.
+func (p *Parser) parseDestructureElement(assign bool) *DestructureElement {
+	element := &DestructureElement{}
+
+	if p.match(LEFT_BRACE, LEFT_BRACKET) {
+		element.Pattern = p.parseDestructure(assign)
+		if element.Pattern == nil {
 			return nil
 		}
+	} else {
+		element.Target = p.parseDestructureTarget(assign)
+		if element.Target == nil {
+			return nil
+		}
+	}
+
+	if p.match(EQUAL) {
+		element.Default = p.or()
+	}
+
+	return element
+}
+
+func (p *Parser) parseDestructureTarget(assign bool) Expression {
+	if !assign {
 		if !p.expectPeek(IDENTIFIER) {
 			return nil
 		}
-		identifier := &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
-		identifiers = append(identifiers, identifier)
+		return &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
 	}
 
-	if !p.expectPeek(RIGHT_PAREN) {
+	token := p.peek()
+	target := p.call()
+	if target == nil || !p.isAssignable(target) {
+		p.addError(&Error{Token: token, Message: "Invalid destructuring target.", Line: token.Line})
 		return nil
 	}
 
-	return identifiers
+	return target
+}
+
+// destructureAssignment parses `[a, b] = value` or `{a, b} = value` when the
+// tokens ahead form one. Otherwise it rewinds, dropping any errors it
+// reported, so that they can be parsed as a literal instead.
+func (p *Parser) destructureAssignment() Expression {
+	start, errors := p.current, len(p.errors.Errors)
+
+	p.advance()
+	pattern := p.parseDestructure(true)
+	if pattern == nil || !p.check(EQUAL) {
+		p.current = start
+		p.errors.Errors = p.errors.Errors[:errors]
+		return nil
+	}
+
+	assignment := &DestructureAssignment{Token: p.advance(), Pattern: pattern}
+	assignment.Value = p.assignment()
+
+	return assignment
 }
 
 func (p *Parser) call() Expression {
@@ -571,21 +1541,21 @@ func (p *Parser) call() Expression {
 
 	for {
 		if p.match(LEFT_BRACE) {
-			index := &IndexExpression{Left: expr, Token: p.previous()}
-			index.Index = p.expression()
-			if !p.expectPeek(RIGHT_BRACE) {
+			expr = p.parseIndex(expr)
+			if expr == nil {
 				return nil
 			}
-			return index
-		}
-		if p.match(LEFT_PAREN) {
+		} else if p.match(LEFT_PAREN) {
 			operator := p.previous()
 			exp := &CallExpression{Token: operator, Callee: expr}
-			exp.Arguments = p.parseExpressionList(RIGHT_PAREN)
+			if !p.parseArguments(exp) {
+				return nil
+			}
 			expr = exp
 		} else if p.match(DOT) {
 			operator := p.previous()
-			if !p.expectPeek(IDENTIFIER) {
+			// yield is a keyword but also names Fiber.yield
+			if !p.match(YIELD) && !p.expectPeek(IDENTIFIER) {
 				return nil
 			}
 			identifier := &Identifier{Token: p.previous(), Value: p.previous().Lexeme}
@@ -599,6 +1569,34 @@ func (p *Parser) call() Expression {
 	return expr
 }
 
+// parseIndex parses the remainder of left[index] or left[start:end] once
+// the opening bracket has been consumed.
+func (p *Parser) parseIndex(left Expression) Expression {
+	token := p.previous()
+
+	var start Expression
+	if !p.check(COLON) {
+		start = p.expression()
+	}
+
+	if !p.match(COLON) {
+		if !p.expectPeek(RIGHT_BRACE) {
+			return nil
+		}
+		return &IndexExpression{Token: token, Left: left, Index: start}
+	}
+
+	slice := &SliceExpression{Token: token, Left: left, Start: start}
+	if !p.check(RIGHT_BRACE) {
+		slice.End = p.expression()
+	}
+	if !p.expectPeek(RIGHT_BRACE) {
+		return nil
+	}
+
+	return slice
+}
+
 func (p *Parser) unary() Expression {
 	for p.match(BANG, MINUS) {
 		operator := p.previous()
@@ -610,13 +1608,67 @@ func (p *Parser) unary() Expression {
 		}
 	}
 
-	return p.call()
+	if p.match(SPAWN) {
+		return p.parseSpawn()
+	}
+
+	if p.match(AWAIT) {
+		return p.parseAwait()
+	}
+
+	if p.match(PLUS_PLUS, MINUS_MINUS) {
+		operator := p.previous()
+		target := p.unary()
+		if !p.isAssignable(target) {
+			p.addError(&Error{Token: operator, Message: "Invalid increment/decrement target.", Line: operator.Line})
+			return nil
+		}
+		return &UpdateExpression{
+			Token:    operator,
+			Operator: operator.Lexeme,
+			Target:   target,
+			Prefix:   true,
+		}
+	}
+
+	return p.postfix()
+}
+
+func (p *Parser) postfix() Expression {
+	expr := p.call()
+
+	if p.match(PLUS_PLUS, MINUS_MINUS) {
+		operator := p.previous()
+		if !p.isAssignable(expr) {
+			p.addError(&Error{Token: operator, Message: "Invalid increment/decrement target.", Line: operator.Line})
+			return nil
+		}
+		return &UpdateExpression{
+			Token:    operator,
+			Operator: operator.Lexeme,
+			Target:   expr,
+			Prefix:   false,
+		}
+	}
+
+	return expr
+}
+
+// isAssignable reports whether expr can be the target of a compound
+// assignment or an increment/decrement.
+func (p *Parser) isAssignable(expr Expression) bool {
+	switch expr.(type) {
+	case *Identifier, *GetExpression, *IndexExpression:
+		return true
+	default:
+		return false
+	}
 }
 
 func (p *Parser) factor() Expression {
 	expr := p.unary()
 
-	for p.match(SLASH, STAR) {
+	for p.match(SLASH, STAR, PERCENT) {
 		operator := p.previous()
 		right := p.unary()
 
@@ -649,11 +1701,11 @@ func (p *Parser) term() Expression {
 }
 
 func (p *Parser) comparison() Expression {
-	expr := p.term()
+	expr := p.rangeExpression()
 
-	for p.match(GREATER, GREATER_EQUAL, LESS, LESS_EQUAL) {
+	for p.match(GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, IN) {
 		operator := p.previous()
-		right := p.term()
+		right := p.rangeExpression()
 		expr = &Binary{
 			Token:    operator,
 			Left:     expr,
@@ -665,6 +1717,32 @@ func (p *Parser) comparison() Expression {
 	return expr
 }
 
+// rangeExpression parses start..end or start..<end, optionally followed by
+// `step` and the step expression. Ranges don't chain.
+func (p *Parser) rangeExpression() Expression {
+	expr := p.term()
+
+	if !p.match(DOT_DOT, DOT_DOT_LESS) {
+		return expr
+	}
+
+	operator := p.previous()
+	rng := &RangeExpression{
+		Token:     operator,
+		Start:     expr,
+		End:       p.term(),
+		Inclusive: operator.Type == DOT_DOT,
+	}
+
+	// step is only a keyword in this position
+	if p.check(IDENTIFIER) && p.peek().Lexeme == "step" {
+		p.advance()
+		rng.Step = p.term()
+	}
+
+	return rng
+}
+
 func (p *Parser) equality() Expression {
 	// matches equality or anything of higher precedence
 	expr := p.comparison()
@@ -739,6 +1817,16 @@ func (p *Parser) or() Expression {
 }
 
 func (p *Parser) assignment() Expression {
+	if p.match(YIELD) {
+		return p.parseYield()
+	}
+
+	if p.check(LEFT_BRACE) || p.check(LEFT_BRACKET) {
+		if assignment := p.destructureAssignment(); assignment != nil {
+			return assignment
+		}
+	}
+
 	expr := p.or()
 
 	for p.match(EQUAL) {
@@ -760,12 +1848,44 @@ func (p *Parser) assignment() Expression {
 				Property: target.Property,
 				Value:    right,
 			}
+		case *IndexExpression:
+			return &SetIndexExpression{
+				Token: equals,
+				Left:  target.Left,
+				Index: target.Index,
+				Value: right,
+			}
+		case *SliceExpression:
+			return &SetSliceExpression{
+				Token: equals,
+				Left:  target.Left,
+				Start: target.Start,
+				End:   target.End,
+				Value: right,
+			}
 		default:
 			p.addError(&Error{Message: "Invalid assignment target.", Line: p.previous().Line})
 			return nil
 		}
 	}
 
+	if p.match(PLUS_EQUAL, MINUS_EQUAL, STAR_EQUAL, SLASH_EQUAL, PERCENT_EQUAL, QUESTION_QUESTION_EQUAL) {
+		operator := p.previous()
+		right := p.assignment()
+
+		if !p.isAssignable(expr) {
+			p.addError(&Error{Token: operator, Message: "Invalid assignment target.", Line: operator.Line})
+			return nil
+		}
+
+		return &CompoundAssignment{
+			Token:    operator,
+			Target:   expr,
+			Operator: operator.Lexeme[:len(operator.Lexeme)-1],
+			Value:    right,
+		}
+	}
+
 	return expr
 }
 
@@ -9,8 +9,8 @@ func createParseProgram(input string) *Program {
 	scanner := NewScanner([]byte(input))
 	scanner.scanTokens()
 
-	fmt.Println(scanner.Tokens)
-	parser := NewParser(scanner.Tokens)
+	fmt.Println(scanner.Tokens())
+	parser := NewParser(scanner.Tokens())
 	program := parser.parse()
 
 	return program
@@ -1191,3 +1191,739 @@ func testString(t *testing.T, expr Expression, expected string) bool {
 
 	return true
 }
+
+func TestParsingCompoundAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		operator string
+		target   string
+	}{
+		{"a += 1;", "+", "a"},
+		{"a -= 1;", "-", "a"},
+		{"a *= 1;", "*", "a"},
+		{"a /= 1;", "/", "a"},
+		{"a %= 1;", "%", "a"},
+		{"a ??= 1;", "??", "a"},
+		{"a.b += 1;", "+", "a.b"},
+		{"a[0] -= 1;", "-", "(a{0})"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ExpressionStatement)
+		if !ok {
+			t.Fatalf("Expected=%T, got=%T", &ExpressionStatement{}, program.Statements[0])
+		}
+
+		assignment, ok := stmt.Expression.(*CompoundAssignment)
+		if !ok {
+			t.Fatalf("Expected=%T, got=%T", &CompoundAssignment{}, stmt.Expression)
+		}
+
+		if assignment.Operator != test.operator {
+			t.Errorf("Expected operator to be %s, got=%s", test.operator, assignment.Operator)
+		}
+
+		if assignment.Target.String() != test.target {
+			t.Errorf("Expected target to be %s, got=%s", test.target, assignment.Target.String())
+		}
+
+		if !testLiteral(t, assignment.Value, float64(1)) {
+			return
+		}
+	}
+}
+
+func TestParsingUpdateExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"++a;", "(++a)"},
+		{"--a;", "(--a)"},
+		{"a++;", "(a++)"},
+		{"a--;", "(a--)"},
+		{"-a++;", "(-(a++))"},
+		{"a.b++;", "(a.b++)"},
+		{"a + b++;", "(a + (b++))"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		actual := program.String()
+		if actual != test.expected {
+			t.Errorf("expected=%q, got=%q", test.expected, actual)
+		}
+	}
+}
+
+func TestInvalidUpdateTarget(t *testing.T) {
+	tests := []string{
+		"1++;",
+		"++(a + b);",
+		"1 += 2;",
+	}
+
+	for _, input := range tests {
+		scanner := NewScanner([]byte(input))
+		scanner.scanTokens()
+
+		parser := NewParser(scanner.Tokens())
+		parser.parse()
+
+		if !parser.Errors().HasErrors() {
+			t.Errorf("Expected parse error for %q", input)
+		}
+	}
+}
+
+func TestParsingInterpolation(t *testing.T) {
+	input := `"Hello ${user.name}, you have ${count + 1} items";`
+
+	program := createParseProgram(input)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ExpressionStatement)
+	if !ok {
+		t.Fatalf("Expected=%T, got=%T", &ExpressionStatement{}, program.Statements[0])
+	}
+
+	interpolation, ok := stmt.Expression.(*InterpolationExpression)
+	if !ok {
+		t.Fatalf("Expected=%T, got=%T", &InterpolationExpression{}, stmt.Expression)
+	}
+
+	expected := []string{`"Hello "`, "user.name", `", you have "`, "(count + 1)", `" items"`}
+	if len(interpolation.Parts) != len(expected) {
+		t.Fatalf("Expected %d parts, got=%d", len(expected), len(interpolation.Parts))
+	}
+
+	for idx, part := range interpolation.Parts {
+		if part.String() != expected[idx] {
+			t.Errorf("Expected part %d to be %s, got=%s", idx, expected[idx], part.String())
+		}
+	}
+}
+
+func TestUnterminatedInterpolation(t *testing.T) {
+	scanner := NewScanner([]byte(`"abc ${x`))
+	scanner.scanTokens()
+
+	if !scanner.Errors().HasErrors() {
+		t.Errorf("Expected scan error for unterminated interpolation")
+	}
+}
+
+func TestParsingIndexAndSlice(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[0][1];", "((a{0}){1})"},
+		{"a[0].x;", "(a{0}).x"},
+		{"a[1:3];", "(a{1:3})"},
+		{"a[2:];", "(a{2:})"},
+		{"a[:-1];", "(a{:(-1)})"},
+		{"a[:];", "(a{:})"},
+		{"a[0] = 1;", "a{0}=1"},
+		{"a[1:2] = b;", "a{1:2}=b"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ExpressionStatement)
+		if !ok {
+			t.Fatalf("Expected=%T, got=%T", &ExpressionStatement{}, program.Statements[0])
+		}
+
+		if stmt.Expression.String() != test.expected {
+			t.Errorf("Expected %s, got=%s", test.expected, stmt.Expression.String())
+		}
+	}
+}
+
+func TestParsingForIn(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedKey   string
+		expectedValue string
+		iterable      string
+	}{
+		{"for (var x in items) { print x; }", "", "x", "items"},
+		{"for (var k, v in hash) { print k; }", "k", "v", "hash"},
+		{"for (var c in \"abc\") {}", "", "c", `"abc"`},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ForInStatement)
+		if !ok {
+			t.Fatalf("Expected=%T, got=%T", &ForInStatement{}, program.Statements[0])
+		}
+
+		if test.expectedKey == "" && stmt.Key != nil {
+			t.Errorf("Expected no key, got=%s", stmt.Key.Value)
+		}
+		if test.expectedKey != "" && (stmt.Key == nil || stmt.Key.Value != test.expectedKey) {
+			t.Errorf("Expected key %s, got=%v", test.expectedKey, stmt.Key)
+		}
+		if stmt.Value.Value != test.expectedValue {
+			t.Errorf("Expected value %s, got=%s", test.expectedValue, stmt.Value.Value)
+		}
+		if stmt.Iterable.String() != test.iterable {
+			t.Errorf("Expected iterable %s, got=%s", test.iterable, stmt.Iterable.String())
+		}
+	}
+}
+
+func TestParsingRange(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0..10;", "(0..10)"},
+		{"0..<n - 1;", "(0..<(n - 1))"},
+		{"10..0 step -2;", "(10..0 step (-2))"},
+		{"x in 0..10;", "(x in (0..10))"},
+		{"0..3 == r;", "((0..3) == r)"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		if program.Statements[0].String() != test.expected {
+			t.Errorf("Expected %s, got=%s", test.expected, program.Statements[0].String())
+		}
+	}
+}
+
+func TestParsingMatch(t *testing.T) {
+	input := `var r = match (v) {
+		1, 2 => "small",
+		-1 => "negative",
+		[x, _] => x,
+		{"k": v} => v,
+		Point(x, 0) if x > 0 => x,
+		{} => "hash",
+		_ => { print(v); }
+	};`
+
+	program := createParseProgram(input)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*VarStatement)
+	if !ok {
+		t.Fatalf("Expected=%T, got=%T", &VarStatement{}, program.Statements[0])
+	}
+
+	match, ok := stmt.Expression.(*MatchExpression)
+	if !ok {
+		t.Fatalf("Expected=%T, got=%T", &MatchExpression{}, stmt.Expression)
+	}
+
+	expected := []string{
+		`1, 2 => "small"`,
+		`(-1) => "negative"`,
+		`[x, _] => x`,
+		`{"k": v} => v`,
+		`Point(x, 0) if (x > 0) => x`,
+		`{} => "hash"`,
+		"_ => {\tprint(v)}",
+	}
+
+	if len(match.Arms) != len(expected) {
+		t.Fatalf("Expected %d arms, got=%d", len(expected), len(match.Arms))
+	}
+
+	for idx, arm := range match.Arms {
+		if arm.String() != expected[idx] {
+			t.Errorf("Expected arm %d to be %q, got=%q", idx, expected[idx], arm.String())
+		}
+	}
+
+	if !match.IsExhaustive() {
+		t.Errorf("Expected match to be exhaustive")
+	}
+}
+
+func TestMatchExhaustiveness(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`match (v) { 1 => 1 }`, false},
+		{`match (v) { x if x > 1 => 1 }`, false},
+		{`match (v) { 1 => 1, other => 2 }`, true},
+		{`match (v) { true => 1, false => 2 }`, true},
+		{`match (v) { true => 1, false if v => 2 }`, false},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		stmt, ok := program.Statements[0].(*ExpressionStatement)
+		if !ok {
+			t.Fatalf("Expected=%T, got=%T", &ExpressionStatement{}, program.Statements[0])
+		}
+
+		match := stmt.Expression.(*MatchExpression)
+		if match.IsExhaustive() != test.expected {
+			t.Errorf("Expected exhaustive=%t for %q", test.expected, test.input)
+		}
+	}
+}
+
+func TestParsingDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`var [a, b, ...rest] = arr;`, "var [a, b, ...rest] = arr"},
+		{`var {name, age = 30, city: c} = h;`, "var {name, age = 30, city: c} = h"},
+		{`var [a, [b, c = 1]] = arr;`, "var [a, [b, c = 1]] = arr"},
+		{`[a, b] = [b, a];`, "[a, b] = {b, a}"},
+		{`[p.x, arr[0], ...rest] = v;`, "[p.x, (arr{0}), ...rest] = v"},
+		{`({name, age} = h);`, "( {name, age} = h )"},
+		{`[1, a = 2];`, "{1, a = 2}"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		if program.Statements[0].String() != test.expected {
+			t.Errorf("Expected %q, got=%q", test.expected, program.Statements[0].String())
+		}
+	}
+}
+
+func TestParsingDestructuredParams(t *testing.T) {
+	program := createParseProgram(`function f([x, y], {z}) { return x + y + z; }`)
+
+	fun, ok := program.Statements[0].(*FunctionDeclaration)
+	if !ok {
+		t.Fatalf("Expected=%T, got=%T", &FunctionDeclaration{}, program.Statements[0])
+	}
+
+	if len(fun.Params) != 2 {
+		t.Fatalf("Expected %d params, got=%d", 2, len(fun.Params))
+	}
+
+	// each destructured parameter is unpacked at the start of the body
+	for idx, expected := range []string{"var [x, y] = [x, y]", "var {z} = {z}"} {
+		if fun.Body.Statements[idx].String() != expected {
+			t.Errorf("Expected %q, got=%q", expected, fun.Body.Statements[idx].String())
+		}
+	}
+}
+
+func TestParsingParametersAndArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`function f(a, b = 2, ...rest) { return a; }`, "functionf(a, b = 2, ...rest)\treturn a"},
+		{`f(1, ...args, b: 3);`, "f(1, ...args, b: 3)"},
+		{`f(a ? b : c);`, "f(a ? b : c)"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		if program.Statements[0].String() != test.expected {
+			t.Errorf("Expected %q, got=%q", test.expected, program.Statements[0].String())
+		}
+	}
+}
+
+func TestParsingParameterErrors(t *testing.T) {
+	tests := []string{
+		`function f(a = 1, b) { return a; }`,
+		`function f(...rest, a) { return a; }`,
+		`f(a: 1, 2);`,
+	}
+
+	for _, input := range tests {
+		scanner := NewScanner([]byte(input))
+		scanner.scanTokens()
+		parser := NewParser(scanner.Tokens())
+		parser.parse()
+
+		if !parser.Errors().HasErrors() {
+			t.Errorf("Expected parse errors for %q", input)
+		}
+	}
+}
+
+func TestParsingTryAndThrow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`throw Error("boom");`, `throw Error("boom")`},
+		{`try { f(); } catch (e) { g(e); }`, "try\tf()catch(e)\tg(e)"},
+		{`try { f(); } catch { g(); } finally { h(); }`, "try\tf()catch\tg()finally\th()"},
+		{`try { f(); } finally { h(); }`, "try\tf()finally\th()"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		if program.Statements[0].String() != test.expected {
+			t.Errorf("Expected %q, got=%q", test.expected, program.Statements[0].String())
+		}
+	}
+}
+
+func TestParsingTryErrors(t *testing.T) {
+	tests := []string{
+		`try { f(); }`,
+		`try f(); catch (e) { g(); }`,
+		`try { f(); } catch (1) { g(); }`,
+		`throw;`,
+	}
+
+	for _, input := range tests {
+		scanner := NewScanner([]byte(input))
+		scanner.scanTokens()
+		parser := NewParser(scanner.Tokens())
+		parser.parse()
+
+		if !parser.Errors().HasErrors() {
+			t.Errorf("Expected parse errors for %q", input)
+		}
+	}
+}
+
+func TestParsingGenerators(t *testing.T) {
+	program := createParseProgram(`function* count(n) { var got = yield n; yield; }`)
+
+	fun, ok := program.Statements[0].(*FunctionDeclaration)
+	if !ok {
+		t.Fatalf("Expected=%T, got=%T", &FunctionDeclaration{}, program.Statements[0])
+	}
+
+	if !fun.IsGenerator {
+		t.Errorf("Expected %s to be a generator", fun.Name.Value)
+	}
+
+	yields := []string{"yield n", "yield"}
+	for idx, stmt := range fun.Body.Statements {
+		var expr Expression
+		switch stmt := stmt.(type) {
+		case *VarStatement:
+			expr = stmt.Expression
+		case *ExpressionStatement:
+			expr = stmt.Expression
+		}
+
+		yield, ok := expr.(*YieldExpression)
+		if !ok {
+			t.Fatalf("Expected=%T, got=%T", &YieldExpression{}, expr)
+		}
+		if yield.String() != yields[idx] {
+			t.Errorf("Expected %q, got=%q", yields[idx], yield.String())
+		}
+	}
+
+	class, ok := createParseProgram(`class Bag { *each() { yield 1; } static *all() { yield 2; } }`).Statements[0].(*ClassStatement)
+	if !ok || len(class.Methods) != 2 {
+		t.Fatalf("Expected a class with two methods")
+	}
+	for _, method := range class.Methods {
+		if !method.IsGenerator {
+			t.Errorf("Expected %s to be a generator", method.Name.Value)
+		}
+	}
+	if class.Methods[0].String() != "*each()\tyield 1" {
+		t.Errorf("Expected the method to read as a generator, got=%q", class.Methods[0].String())
+	}
+}
+
+func TestParsingYieldOutsideGenerator(t *testing.T) {
+	tests := []string{
+		`yield 1;`,
+		`function f() { yield 1; }`,
+		`function* g() { function f() { yield 1; } }`,
+		`class A { m() { yield 1; } }`,
+		`class A { *init() { yield 1; } }`,
+		`class A { *size { yield 1; } }`,
+	}
+
+	for _, input := range tests {
+		scanner := NewScanner([]byte(input))
+		scanner.scanTokens()
+		parser := NewParser(scanner.Tokens())
+		parser.parse()
+
+		if !parser.Errors().HasErrors() {
+			t.Errorf("Expected parse errors for %q", input)
+		}
+	}
+}
+
+func TestParsingSpawnAndSelect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`spawn worker(ch, 1);`, "spawn worker(ch, 1)"},
+		{`select { var v = a.recv() => { f(v); } b.send(1) => { g(); } _ => { h(); } }`, "select{var v = a.recv() => \tf(v) b.send(1) => \tg() _ => \th()}"},
+		{`select { a.recv() => { } }`, "select{a.recv() => }"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		if program.Statements[0].String() != test.expected {
+			t.Errorf("Expected %q, got=%q", test.expected, program.Statements[0].String())
+		}
+	}
+}
+
+func TestParsingSpawnAndSelectErrors(t *testing.T) {
+	tests := []string{
+		`spawn worker;`,
+		`select { }`,
+		`select { f() => { } }`,
+		`select { var v = a.send(1) => { } }`,
+		`select { a.recv() { } }`,
+		`select { _ => { } _ => { } }`,
+	}
+
+	for _, input := range tests {
+		scanner := NewScanner([]byte(input))
+		scanner.scanTokens()
+		parser := NewParser(scanner.Tokens())
+		parser.parse()
+
+		if !parser.Errors().HasErrors() {
+			t.Errorf("Expected parse errors for %q", input)
+		}
+	}
+}
+
+func TestParsingAsyncAndAwait(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`async function f(x) { return await g(x); }`, "async functionf(x)\treturn await g(x)"},
+		{`var r = await sleep(10);`, "var r = await sleep(10)"},
+		{`var f = async function() { await 1; };`, "var f = async function()\tawait 1"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		if program.Statements[0].String() != test.expected {
+			t.Errorf("Expected %q, got=%q", test.expected, program.Statements[0].String())
+		}
+	}
+
+	fun, ok := createParseProgram(`async function f() { }`).Statements[0].(*FunctionDeclaration)
+	if !ok || !fun.IsAsync {
+		t.Errorf("Expected an async function declaration")
+	}
+}
+
+func TestParsingAwaitOutsideAsync(t *testing.T) {
+	tests := []string{
+		`function f() { await g(); }`,
+		`async function f() { function g() { await h(); } }`,
+		`async function* f() { }`,
+		`class A { m() { await f(); } }`,
+	}
+
+	for _, input := range tests {
+		scanner := NewScanner([]byte(input))
+		scanner.scanTokens()
+		parser := NewParser(scanner.Tokens())
+		parser.parse()
+
+		if !parser.Errors().HasErrors() {
+			t.Errorf("Expected parse errors for %q", input)
+		}
+	}
+}
+
+func TestParsingImportAndExport(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib/math.lox" as m;`, `import "lib/math.lox" as m`},
+		{`import { sqrt, PI } from "math";`, `import {sqrt, PI} from "math"`},
+		{`export var PI = 3;`, "export var PI = 3"},
+		{`export function f(x) { return x; }`, "export functionf(x)\treturn x"},
+		{`export async function f() { }`, "export async functionf()"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		if program.Statements[0].String() != test.expected {
+			t.Errorf("Expected %q, got=%q", test.expected, program.Statements[0].String())
+		}
+	}
+
+	export, ok := createParseProgram(`export class A { }`).Statements[0].(*ExportStatement)
+	if !ok {
+		t.Fatalf("Expected an export statement")
+	}
+	if _, ok := export.Declaration.(*ClassStatement); !ok {
+		t.Errorf("Expected an exported class, got=%T", export.Declaration)
+	}
+}
+
+func TestParsingImportAndExportErrors(t *testing.T) {
+	tests := []string{
+		`import "math";`,
+		`import "math" from m;`,
+		`import {} from "math";`,
+		`import { a } "math";`,
+		`export 1;`,
+		`export var [a, b] = [1, 2];`,
+		`function f() { import "math" as m; }`,
+		`if (true) { export var a = 1; }`,
+	}
+
+	for _, input := range tests {
+		scanner := NewScanner([]byte(input))
+		scanner.scanTokens()
+		parser := NewParser(scanner.Tokens())
+		parser.parse()
+
+		if !parser.Errors().HasErrors() {
+			t.Errorf("Expected parse errors for %q", input)
+		}
+	}
+}
+
+func TestParsingTestBlocks(t *testing.T) {
+	program := createParseProgram(`test "adds" { assertEqual(1 + 1, 2); }`)
+	if len(program.Statements) != 1 {
+		t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+	}
+	block, ok := program.Statements[0].(*TestStatement)
+	if !ok {
+		t.Fatalf("Expected a test statement, got=%T", program.Statements[0])
+	}
+	if block.Name != "adds" || len(block.Body.Statements) != 1 {
+		t.Errorf("Expected the test \"adds\" with one statement, got=%q with %d", block.Name, len(block.Body.Statements))
+	}
+
+	// test is only a keyword in front of a name
+	program = createParseProgram(`var test = 1; test = test + 1;`)
+	if len(program.Statements) != 2 {
+		t.Errorf("Expected test to be a variable, got=%v", program.Statements)
+	}
+
+	for _, input := range []string{
+		`test "" { }`,
+		`test "name";`,
+		`function f() { test "name" { } }`,
+	} {
+		scanner := NewScanner([]byte(input))
+		scanner.scanTokens()
+		parser := NewParser(scanner.Tokens())
+		parser.parse()
+
+		if !parser.Errors().HasErrors() {
+			t.Errorf("Expected parse errors for %q", input)
+		}
+	}
+}
+
+func TestParsingLetAndConst(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x = 1;`, "let x = 1"},
+		{`const y = "a";`, `const y = "a"`},
+		{`let z;`, "let z = nil"},
+		{`const [a, b] = pair;`, "const [a, b] = pair"},
+	}
+
+	for _, test := range tests {
+		program := createParseProgram(test.input)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected length of statements to be %d, got=%d", 1, len(program.Statements))
+		}
+
+		if program.Statements[0].String() != test.expected {
+			t.Errorf("Expected %q, got=%q", test.expected, program.Statements[0].String())
+		}
+	}
+
+	loop, ok := createParseProgram(`for (const v in xs) { }`).Statements[0].(*ForInStatement)
+	if !ok || loop.Declaration.Type != CONST {
+		t.Errorf("Expected a for-in loop over a const")
+	}
+
+	scanner := NewScanner([]byte(`const x;`))
+	scanner.scanTokens()
+	parser := NewParser(scanner.Tokens())
+	parser.parse()
+	if !parser.Errors().HasErrors() {
+		t.Errorf("Expected a parse error for a const without initializer")
+	}
+
+	if !createParseProgram(`"use strict"; var x;`).Strict() {
+		t.Errorf("Expected the program to be strict")
+	}
+	if createParseProgram(`var x; "use strict";`).Strict() {
+		t.Errorf("Expected a directive after the first statement to be ignored")
+	}
+}
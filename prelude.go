@@ -0,0 +1,47 @@
+package main
+
+// ErrorClassName is the base class for errors. Built-in runtime errors
+// reach catch blocks as instances of it.
+const ErrorClassName = "Error"
+
+// preludeSource is loaded before every program, by both the interpreter and
+// the compiler.
+const preludeSource = `
+class Error {
+	init(message = "", ...cause) {
+		this.message = message;
+		this.cause = nil;
+		if (len(cause) > 0) {
+			this.cause = cause[0];
+		}
+		this.stack = [];
+	}
+
+	toString() {
+		return "Error: ${this.message}";
+	}
+}
+
+function assertThrows(fn, message = "no error was thrown") {
+	try {
+		fn();
+	} catch (error) {
+		return error;
+	}
+	assert(false, message);
+}
+`
+
+func Prelude() *Program {
+	scanner := NewScanner([]byte(preludeSource))
+	scanner.scanTokens()
+
+	// the prelude has no lines of its own in the program it is loaded
+	// into, so a debugger never stops in it
+	for _, token := range scanner.Tokens() {
+		token.Line = 0
+	}
+
+	parser := NewParser(scanner.Tokens())
+	return parser.parse()
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultProfilePeriod is how often a Profiler samples the stack unless
+// told otherwise, which is what the Go profiler does.
+const DefaultProfilePeriod = 10 * time.Millisecond
+
+// Profiler records where a program spends its time. The VM tells it of
+// each instruction before it runs: it counts the calls of each function
+// and the times each line is got to, and once every Period it samples
+// the stack, which the time since the last sample is put down to. It
+// looks at the clock every profileClockEvery instructions, rather than
+// being woken by a timer, which a busy VM on one CPU would starve.
+type Profiler struct {
+	Period time.Duration
+	// Files are the files of the namespaces of the program
+	Files map[int]string
+
+	functions map[*CompiledFunction]*FunctionProfile
+	lines     map[profileLine]*LineProfile
+	// tables hold the line of each instruction of the functions
+	tables  map[*CompiledFunction][]int
+	samples map[string]*profileSample
+	// order is the order the samples were first taken in
+	order   []*profileSample
+	current lineTracker
+
+	ticks    int
+	last     time.Time
+	start    time.Time
+	duration time.Duration
+}
+
+const profileClockEvery = 16
+
+// FunctionProfile is what a Profiler recorded of a function. Self is the
+// time spent in the function itself, Cumulative that in it and in what it
+// called.
+type FunctionProfile struct {
+	Name       string
+	File       string
+	Line       int
+	Calls      int
+	Self       time.Duration
+	Cumulative time.Duration
+	id         int
+}
+
+// LineProfile is what a Profiler recorded of a line of a file.
+type LineProfile struct {
+	File string
+	Line int
+	Hits int
+	Self time.Duration
+}
+
+type profileLine struct {
+	namespace int
+	line      int
+}
+
+// lineTracker tells when the VM gets to a line. It keeps the line each
+// call of each fiber was last on: a call gets to a line when it runs an
+// instruction of it after one of another line, which a call it made
+// returning to it is not.
+type lineTracker map[*CompiledFiber][]int
+
+// enter tells the tracker the VM is at an instruction of line, reporting
+// whether that gets it to the line.
+func (t *lineTracker) enter(vm *VM, line int) bool {
+	if *t == nil {
+		*t = make(lineTracker)
+	}
+	lines := (*t)[vm.Fiber]
+	depth := vm.FrameCount - 1
+	for len(lines) <= depth {
+		lines = append(lines, 0)
+	}
+	(*t)[vm.Fiber] = lines
+
+	frame := vm.currentFrame()
+	if frame.Ip == 0 {
+		lines[depth] = 0
+	}
+	// the jumps that end a block are on the line of the statement the
+	// block is in, which they don't get back to
+	if opcode := OpCode(frame.Instructions()[frame.Ip]); opcode == OP_JUMP || opcode == OP_LOOP {
+		return false
+	}
+	if line <= 0 || line == lines[depth] {
+		return false
+	}
+	lines[depth] = line
+	return true
+}
+
+// profileFrame is a call in a sample, at line of function.
+type profileFrame struct {
+	function *CompiledFunction
+	line     int
+}
+
+// profileSample counts the samples of one stack, innermost call first.
+type profileSample struct {
+	stack []profileFrame
+	count int64
+	time  time.Duration
+}
+
+func NewProfiler(period time.Duration) *Profiler {
+	return &Profiler{
+		Period:    period,
+		Files:     map[int]string{},
+		functions: make(map[*CompiledFunction]*FunctionProfile),
+		lines:     make(map[profileLine]*LineProfile),
+		tables:    make(map[*CompiledFunction][]int),
+		samples:   make(map[string]*profileSample),
+	}
+}
+
+// Start starts the clock, as the program starts.
+func (p *Profiler) Start() {
+	p.start = time.Now()
+	p.last = p.start
+}
+
+// Stop stops the clock, once the program is done.
+func (p *Profiler) Stop() {
+	p.duration = time.Since(p.start)
+}
+
+// instruction runs before each instruction of the VM.
+func (p *Profiler) instruction(vm *VM) {
+	frame := vm.currentFrame()
+	function := frame.Closure.Function
+	if frame.Ip == 0 {
+		p.function(function).Calls++
+	}
+
+	if line := p.table(function)[frame.Ip]; p.current.enter(vm, line) {
+		p.line(function, line).Hits++
+	}
+
+	p.ticks++
+	if p.ticks%profileClockEvery == 0 {
+		if now := time.Now(); now.Sub(p.last) >= p.Period {
+			p.sample(vm, now)
+		}
+	}
+}
+
+func (p *Profiler) function(function *CompiledFunction) *FunctionProfile {
+	profile := p.functions[function]
+	if profile == nil {
+		profile = &FunctionProfile{Name: function.Name, File: "prelude", id: len(p.functions) + 1}
+		// the functions of the prelude have no lines, and the program
+		// starts with the code of the prelude
+		for _, info := range function.Lines {
+			if info.Line > 0 {
+				profile.File, profile.Line = p.Files[function.Namespace], info.Line
+				break
+			}
+		}
+		p.functions[function] = profile
+	}
+	return profile
+}
+
+func (p *Profiler) line(function *CompiledFunction, line int) *LineProfile {
+	key := profileLine{function.Namespace, line}
+	profile := p.lines[key]
+	if profile == nil {
+		profile = &LineProfile{File: p.Files[function.Namespace], Line: line}
+		p.lines[key] = profile
+	}
+	return profile
+}
+
+func (p *Profiler) table(function *CompiledFunction) []int {
+	table := p.tables[function]
+	if table == nil {
+		table = function.LineTable()
+		p.tables[function] = table
+	}
+	return table
+}
+
+// sample records the stack the VM is in, putting the time since the last
+// sample down to it.
+func (p *Profiler) sample(vm *VM, now time.Time) {
+	elapsed := now.Sub(p.last)
+	p.last = now
+
+	var key strings.Builder
+	stack := make([]profileFrame, 0, vm.FrameCount)
+	for idx := vm.FrameCount - 1; idx >= 0; idx-- {
+		frame := vm.Frames[idx]
+		// the frames that made a call are past it
+		ip := frame.Ip
+		if idx < vm.FrameCount-1 {
+			ip--
+		}
+		function := frame.Closure.Function
+		stack = append(stack, profileFrame{function, p.table(function)[ip]})
+		fmt.Fprintf(&key, "%p:%d;", function, stack[len(stack)-1].line)
+	}
+
+	sample := p.samples[key.String()]
+	if sample == nil {
+		sample = &profileSample{stack: stack}
+		p.samples[key.String()] = sample
+		p.order = append(p.order, sample)
+	}
+	sample.count++
+	sample.time += elapsed
+}
+
+// Functions returns the functions that ran, by self time and then by
+// calls.
+func (p *Profiler) Functions() []*FunctionProfile {
+	for _, profile := range p.functions {
+		profile.Self, profile.Cumulative = 0, 0
+	}
+	for _, sample := range p.order {
+		seen := map[*CompiledFunction]bool{}
+		for idx, frame := range sample.stack {
+			profile := p.function(frame.function)
+			if idx == 0 {
+				profile.Self += sample.time
+			}
+			// a recursive call counts once
+			if !seen[frame.function] {
+				seen[frame.function] = true
+				profile.Cumulative += sample.time
+			}
+		}
+	}
+
+	functions := make([]*FunctionProfile, 0, len(p.functions))
+	for _, profile := range p.functions {
+		functions = append(functions, profile)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		a, b := functions[i], functions[j]
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+		if a.Calls != b.Calls {
+			return a.Calls > b.Calls
+		}
+		return a.id < b.id
+	})
+	return functions
+}
+
+// Lines returns the lines that ran, by self time and then by hits.
+func (p *Profiler) Lines() []*LineProfile {
+	for _, profile := range p.lines {
+		profile.Self = 0
+	}
+	for _, sample := range p.order {
+		if frame := sample.stack[0]; frame.line > 0 {
+			p.line(frame.function, frame.line).Self += sample.time
+		}
+	}
+
+	lines := make([]*LineProfile, 0, len(p.lines))
+	for _, profile := range p.lines {
+		lines = append(lines, profile)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+		if a.Hits != b.Hits {
+			return a.Hits > b.Hits
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return lines
+}
+
+// WriteReport writes the flat report: the functions and then the lines,
+// by self time.
+func (p *Profiler) WriteReport(w io.Writer) {
+	var samples int64
+	for _, sample := range p.order {
+		samples += sample.count
+	}
+	fmt.Fprintf(w, "Duration: %s, %d samples every %s\n\n", p.duration.Round(time.Microsecond), samples, p.Period)
+
+	percent := func(d time.Duration) float64 {
+		if p.duration == 0 {
+			return 0
+		}
+		return 100 * float64(d) / float64(p.duration)
+	}
+	fmt.Fprintf(w, "%10s %7s %10s %7s %8s  %s\n", "flat", "flat%", "cum", "cum%", "calls", "function")
+	for _, function := range p.Functions() {
+		fmt.Fprintf(w, "%10s %6.2f%% %10s %6.2f%% %8d  %s (%s)\n",
+			function.Self.Round(time.Microsecond), percent(function.Self),
+			function.Cumulative.Round(time.Microsecond), percent(function.Cumulative),
+			function.Calls, function.Name, profileLocation(function.File, function.Line))
+	}
+
+	fmt.Fprintf(w, "\n%10s %7s %8s  %s\n", "flat", "flat%", "hits", "line")
+	for _, line := range p.Lines() {
+		fmt.Fprintf(w, "%10s %6.2f%% %8d  %s\n", line.Self.Round(time.Microsecond), percent(line.Self), line.Hits, profileLocation(line.File, line.Line))
+	}
+}
+
+func profileLocation(file string, line int) string {
+	if line <= 0 {
+		return file
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+}
+
+// WriteFolded writes the samples as folded stacks, the input of flame
+// graph tools: a line per stack, outermost call first, with the
+// microseconds spent in it.
+func (p *Profiler) WriteFolded(w io.Writer) {
+	var lines []string
+	for _, sample := range p.order {
+		names := make([]string, len(sample.stack))
+		for idx, frame := range sample.stack {
+			names[len(names)-1-idx] = frame.function.Name
+		}
+		lines = append(lines, fmt.Sprintf("%s %d", strings.Join(names, ";"), sample.time.Microseconds()))
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// WritePprof writes the samples as a gzipped profile.proto, which
+// "go tool pprof" reads. Each line of a function is a location of its own.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	indexes := map[string]int{"": 0}
+	table := []string{""}
+	str := func(s string) int {
+		if idx, ok := indexes[s]; ok {
+			return idx
+		}
+		indexes[s] = len(table)
+		table = append(table, s)
+		return len(table) - 1
+	}
+	valueType := func(kind, unit string) []byte {
+		var message protoWriter
+		message.varint(1, uint64(str(kind)))
+		message.varint(2, uint64(str(unit)))
+		return message.Bytes()
+	}
+
+	var profile protoWriter
+	profile.bytes(1, valueType("samples", "count"))
+	profile.bytes(1, valueType("cpu", "nanoseconds"))
+
+	locations := map[profileFrame]int{}
+	var locationMessages [][]byte
+	for _, sample := range p.order {
+		var ids []uint64
+		for _, frame := range sample.stack {
+			id, ok := locations[frame]
+			if !ok {
+				id = len(locations) + 1
+				locations[frame] = id
+				var line protoWriter
+				line.varint(1, uint64(p.function(frame.function).id))
+				line.varint(2, uint64(max(frame.line, 0)))
+				var location protoWriter
+				location.varint(1, uint64(id))
+				location.bytes(4, line.Bytes())
+				locationMessages = append(locationMessages, location.Bytes())
+			}
+			ids = append(ids, uint64(id))
+		}
+
+		var message protoWriter
+		message.packed(1, ids)
+		message.packed(2, []uint64{uint64(sample.count), uint64(sample.time.Nanoseconds())})
+		profile.bytes(2, message.Bytes())
+	}
+	for _, location := range locationMessages {
+		profile.bytes(4, location)
+	}
+
+	functions := make([]*FunctionProfile, 0, len(p.functions))
+	for _, function := range p.functions {
+		functions = append(functions, function)
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].id < functions[j].id })
+	for _, function := range functions {
+		var message protoWriter
+		message.varint(1, uint64(function.id))
+		message.varint(2, uint64(str(function.Name)))
+		message.varint(3, uint64(str(function.Name)))
+		message.varint(4, uint64(str(function.File)))
+		message.varint(5, uint64(function.Line))
+		profile.bytes(5, message.Bytes())
+	}
+
+	// the string table has to come after everything that adds to it
+	periodType := valueType("cpu", "nanoseconds")
+	for _, s := range table {
+		profile.bytes(6, []byte(s))
+	}
+	profile.varint(9, uint64(p.start.UnixNano()))
+	profile.varint(10, uint64(p.duration.Nanoseconds()))
+	profile.bytes(11, periodType)
+	profile.varint(12, uint64(p.Period.Nanoseconds()))
+
+	zipped := gzip.NewWriter(w)
+	if _, err := zipped.Write(profile.Bytes()); err != nil {
+		return err
+	}
+	return zipped.Close()
+}
+
+// protoWriter encodes the fields of a protocol buffer message.
+type protoWriter struct {
+	bytes.Buffer
+}
+
+func (pw *protoWriter) key(field, wireType int) {
+	pw.Write(binary.AppendUvarint(nil, uint64(field<<3|wireType)))
+}
+
+func (pw *protoWriter) varint(field int, value uint64) {
+	pw.key(field, 0)
+	pw.Write(binary.AppendUvarint(nil, value))
+}
+
+func (pw *protoWriter) bytes(field int, value []byte) {
+	pw.key(field, 2)
+	pw.Write(binary.AppendUvarint(nil, uint64(len(value))))
+	pw.Write(value)
+}
+
+func (pw *protoWriter) packed(field int, values []uint64) {
+	var buffer []byte
+	for _, value := range values {
+		buffer = binary.AppendUvarint(buffer, value)
+	}
+	pw.bytes(field, buffer)
+}
+
+// runRun runs a script like "jlox script" does, profiling it if asked.
+func runRun(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	profilePath := flags.String("profile", "", "write a pprof profile of the script to `file` and a report to stderr")
+	foldedPath := flags.String("folded", "", "write the folded stacks of the profile to `file`")
+	period := flags.Duration("profile-period", DefaultProfilePeriod, "how often the profiler samples")
+	trace := flags.Bool("trace", false, "print each instruction and the stack as the script runs")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage: jlox run [flags] script\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *period <= 0 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	compiler := compile(source, path)
+	if compiler == nil {
+		return 1
+	}
+
+	vm := NewVM(compiler.ByteCode())
+	vm.Trace = *trace
+	var profiler *Profiler
+	if *profilePath != "" || *foldedPath != "" {
+		profiler = NewProfiler(*period)
+		profiler.Files = compiler.Files()
+		vm.Profiler = profiler
+		profiler.Start()
+	}
+	err = vm.run()
+	if profiler != nil {
+		profiler.Stop()
+	}
+	code := 0
+	if err != nil {
+		vm.prinStackTrace(err)
+		code = 1
+	}
+	if profiler == nil {
+		return code
+	}
+
+	profiler.WriteReport(stderr)
+	write := func(path string, write func(io.Writer) error) {
+		file, err := os.Create(path)
+		if err == nil {
+			err = write(file)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			code = 1
+		}
+	}
+	if *profilePath != "" {
+		write(*profilePath, profiler.WritePprof)
+	}
+	if *foldedPath != "" {
+		write(*foldedPath, func(w io.Writer) error {
+			profiler.WriteFolded(w)
+			return nil
+		})
+	}
+	return code
+}
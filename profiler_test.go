@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const profilerSource = `function fib(n) {
+	if (n < 2) {
+		return n;
+	}
+	return fib(n - 1) + fib(n - 2);
+}
+function work() {
+	var total = 0;
+	for (var i = 0; i < 10; i = i + 1) {
+		total = total + i;
+	}
+	return total;
+}
+fib(5);
+work();
+`
+
+// profile runs source with a profiler that samples every time it looks
+// at the clock.
+func profile(t *testing.T, source string) *Profiler {
+	compiler := compile([]byte(source), "test.lox")
+	if compiler == nil {
+		t.Fatal("the program doesn't compile")
+	}
+	profiler := NewProfiler(time.Nanosecond)
+	profiler.Files = compiler.Files()
+	vm := NewVM(compiler.ByteCode())
+	vm.Profiler = profiler
+	profiler.Start()
+	if err := vm.run(); err != nil {
+		t.Fatal(err)
+	}
+	profiler.Stop()
+	return profiler
+}
+
+func TestProfilerCounts(t *testing.T) {
+	profiler := profile(t, profilerSource)
+
+	functions := map[string]*FunctionProfile{}
+	var self time.Duration
+	for _, function := range profiler.Functions() {
+		functions[function.Name] = function
+		self += function.Self
+	}
+	if functions["fib"].Calls != 15 || functions["work"].Calls != 1 || functions["main"].Calls != 1 {
+		t.Errorf("Expected fib called 15 times and the others once, got=%d %d %d",
+			functions["fib"].Calls, functions["work"].Calls, functions["main"].Calls)
+	}
+	if functions["fib"].Line != 2 || functions["work"].File != "test.lox" {
+		t.Errorf("Expected the functions where they are, got=%+v %+v", functions["fib"], functions["work"])
+	}
+	if self == 0 || functions["main"].Cumulative != self || functions["fib"].Cumulative < functions["fib"].Self {
+		t.Errorf("Expected main to take all the time, got=%s of %s", functions["main"].Cumulative, self)
+	}
+
+	hits := map[int]int{}
+	for _, line := range profiler.Lines() {
+		hits[line.Line] = line.Hits
+	}
+	// the condition of the loop is got to once more than its body
+	if hits[2] != 15 || hits[3] != 8 || hits[5] != 7 || hits[9] != 11 || hits[10] != 10 || hits[14] != 1 {
+		t.Errorf("Expected the hits of each line, got=%v", hits)
+	}
+
+	var report bytes.Buffer
+	profiler.WriteReport(&report)
+	for _, expected := range []string{"calls  function\n", " fib (test.lox:2)\n", "hits  line\n", " test.lox:10\n"} {
+		if !strings.Contains(report.String(), expected) {
+			t.Errorf("Expected %q in the report, got=\n%s", expected, report.String())
+		}
+	}
+}
+
+func TestProfilerFolded(t *testing.T) {
+	profiler := profile(t, profilerSource)
+
+	var folded bytes.Buffer
+	profiler.WriteFolded(&folded)
+	stacks := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(folded.String()), "\n") {
+		stack, _, ok := strings.Cut(line, " ")
+		if !ok || !strings.HasPrefix(stack, "main") {
+			t.Fatalf("Expected a stack from main and a count, got=%q", line)
+		}
+		stacks[stack] = true
+	}
+	if !stacks["main;fib;fib;fib"] || !stacks["main;work"] {
+		t.Errorf("Expected the stacks of fib and work, got=%v", stacks)
+	}
+}
+
+// protoFields decodes the fields of a protocol buffer message that are
+// varints or bytes, by field number.
+func protoFields(t *testing.T, message []byte) map[int][][]byte {
+	fields := map[int][][]byte{}
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		message = message[n:]
+		switch key & 7 {
+		case 0:
+			_, n = binary.Uvarint(message)
+			fields[int(key>>3)] = append(fields[int(key>>3)], message[:n])
+			message = message[n:]
+		case 2:
+			length, n := binary.Uvarint(message)
+			fields[int(key>>3)] = append(fields[int(key>>3)], message[n:n+int(length)])
+			message = message[n+int(length):]
+		default:
+			t.Fatalf("Unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func TestProfilerPprof(t *testing.T) {
+	profiler := profile(t, profilerSource)
+
+	var out bytes.Buffer
+	if err := profiler.WritePprof(&out); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(reader)
+	fields := protoFields(t, content)
+
+	var table []string
+	for _, s := range fields[6] {
+		table = append(table, string(s))
+	}
+	if len(table) == 0 || table[0] != "" {
+		t.Fatalf("Expected the string table to start with an empty string, got=%q", table)
+	}
+	for _, expected := range []string{"samples", "cpu", "nanoseconds", "fib", "work", "main", "test.lox"} {
+		if !strings.Contains(strings.Join(table, "|")+"|", "|"+expected+"|") {
+			t.Errorf("Expected %q in the string table, got=%q", expected, table)
+		}
+	}
+	if len(fields[1]) != 2 || len(fields[2]) != len(profiler.order) || len(fields[5]) != 3 {
+		t.Errorf("Expected 2 sample types, %d samples and 3 functions, got=%d, %d and %d",
+			len(profiler.order), len(fields[1]), len(fields[2]), len(fields[5]))
+	}
+
+	// every location of a sample is there
+	locations := map[uint64]bool{}
+	for _, location := range fields[4] {
+		id, _ := binary.Uvarint(protoFields(t, location)[1][0])
+		locations[id] = true
+	}
+	for _, sample := range fields[2] {
+		for ids := protoFields(t, sample)[1][0]; len(ids) > 0; {
+			id, n := binary.Uvarint(ids)
+			if !locations[id] {
+				t.Errorf("Expected location %d to be defined", id)
+			}
+			ids = ids[n:]
+		}
+	}
+}
+
+func TestProfilerNoTrace(t *testing.T) {
+	compiler := compile([]byte(profilerSource), "test.lox")
+	if compiler == nil {
+		t.Fatal("the program doesn't compile")
+	}
+	vm := NewVM(compiler.ByteCode())
+	vm.Profiler = NewProfiler(time.Nanosecond)
+	vm.Trace = true
+
+	// the instruction trace would make the samples measure its printing, so
+	// profiling leaves it out even when asked for
+	original := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	output := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		output <- buf.String()
+	}()
+	vm.Profiler.Start()
+	err := vm.run()
+	vm.Profiler.Stop()
+	w.Close()
+	os.Stdout = original
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := <-output; out != "" {
+		t.Errorf("Expected no output while profiling, got=\n%s", out)
+	}
+}
+
+func TestRunProfile(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "fib.lox")
+	os.WriteFile(script, []byte(profilerSource), 0644)
+	profilePath, foldedPath := filepath.Join(dir, "cpu.out"), filepath.Join(dir, "stacks.txt")
+
+	var stdout, stderr bytes.Buffer
+	if code := runRun([]string{"-profile=" + profilePath, "-folded", foldedPath, "-profile-period=1ns", script}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected the run to succeed, got=%d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), " fib (fib.lox:2)\n") {
+		t.Errorf("Expected the report on stderr, got=\n%s", stderr.String())
+	}
+	for _, path := range []string{profilePath, foldedPath} {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("Expected %s to be written", path)
+		}
+	}
+
+	if code := runRun([]string{filepath.Join(dir, "missing.lox")}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected a missing script to fail, got=%d", code)
+	}
+	if code := runRun(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected no script to be a usage error, got=%d", code)
+	}
+}
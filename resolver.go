@@ -0,0 +1,572 @@
+package main
+
+import "fmt"
+
+// StrictDirective as the first statement of a program makes assigning a
+// variable that was never declared an error. The compiler always rejects
+// such assignments; the tree-walker otherwise defines the variable.
+const StrictDirective = "use strict"
+
+// Location is where the resolver placed a local variable: in slot of the
+// environment Depth scopes out from the one it is used in.
+type Location struct {
+	Depth int
+	Slot  int
+}
+
+type FunctionType int
+
+const (
+	NoFunction FunctionType = iota
+	InFunction
+	InMethod
+	InInitializer
+)
+
+type ClassType int
+
+const (
+	NoClass ClassType = iota
+	InClass
+	InSubclass
+)
+
+// variable is a name declared in one scope. defined is false while its
+// own initializer is being resolved.
+type variable struct {
+	slot     int
+	defined  bool
+	constant bool
+}
+
+// Resolver checks a program before the tree-walker runs it and works out
+// where each local variable lives. Its scopes mirror the interpreter's
+// environments: the globals, each block, function call, method call and
+// loop. Globals are left to be looked up by name, so a program can use
+// one it only defines further down.
+type Resolver struct {
+	errors *ErrorHandler
+	// scopes holds the variables of each scope, innermost last; scopes[0]
+	// has the globals
+	scopes []map[string]*variable
+	locals map[Expression]Location
+	// globals holds what was defined before the program ran, such as the
+	// prelude
+	globals  *Environment
+	strict   bool
+	function FunctionType
+	class    ClassType
+}
+
+func NewResolver(globals *Environment) *Resolver {
+	return &Resolver{errors: NewErrorHandler(), locals: make(map[Expression]Location), globals: globals}
+}
+
+func (r *Resolver) Errors() *ErrorHandler {
+	return r.errors
+}
+
+// Locals maps each use of a local variable, an identifier or this, to
+// its location.
+func (r *Resolver) Locals() map[Expression]Location {
+	return r.locals
+}
+
+func (r *Resolver) Resolve(program *Program) {
+	r.strict = program.Strict()
+
+	r.beginScope()
+	// globals can be used before their declaration, from a function
+	for _, stmt := range program.Statements {
+		r.hoist(stmt)
+	}
+	for _, stmt := range program.Statements {
+		r.resolveStatement(stmt)
+	}
+	r.endScope()
+}
+
+func (r *Resolver) addError(token Token, format string, args ...interface{}) {
+	r.errors.AddError(&Error{Token: token, Message: fmt.Sprintf(format, args...), Line: token.Line})
+}
+
+func (r *Resolver) beginScope() {
+	r.scopes = append(r.scopes, make(map[string]*variable))
+}
+
+func (r *Resolver) endScope() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *Resolver) isGlobalScope() bool {
+	return len(r.scopes) == 1
+}
+
+// declare adds name to the innermost scope in the next slot, as
+// Environment.Define does, and reuses the slot of a name declared again.
+func (r *Resolver) declare(name string, constant bool) *variable {
+	scope := r.scopes[len(r.scopes)-1]
+	if v, ok := scope[name]; ok {
+		v.constant = constant
+		return v
+	}
+
+	v := &variable{slot: len(scope), defined: true, constant: constant}
+	scope[name] = v
+	return v
+}
+
+// resolveLocal records the location of the variable name used by expr
+// and returns it, or the global of that name. It returns nil for a name
+// declared nowhere in the program.
+func (r *Resolver) resolveLocal(expr Expression, name string) *variable {
+	for i := len(r.scopes) - 1; i > 0; i-- {
+		if v, ok := r.scopes[i][name]; ok {
+			r.locals[expr] = Location{Depth: len(r.scopes) - 1 - i, Slot: v.slot}
+			return v
+		}
+	}
+	return r.scopes[0][name]
+}
+
+// assign checks an assignment to name against the innermost declaration
+// of it.
+func (r *Resolver) assign(name *Identifier) {
+	if v := r.resolveLocal(name, name.Value); v != nil {
+		if v.constant {
+			r.addError(name.Token, "Can't assign to constant '%s'.", name.Value)
+		}
+		return
+	}
+
+	if _, ok := r.globals.Get(name.Value); ok {
+		return
+	}
+	if r.strict {
+		r.addError(name.Token, "Can't assign to undeclared variable '%s'.", name.Value)
+	}
+}
+
+// hoist declares a global ahead of the code that runs before it.
+func (r *Resolver) hoist(stmt Statement) {
+	switch stmt := stmt.(type) {
+	case *VarStatement:
+		r.declare(stmt.Identifier.Value, stmt.Token.Type == CONST)
+	case *DestructureStatement:
+		r.declarePattern(stmt.Pattern, stmt.Token.Type == CONST)
+	case *FunctionDeclaration:
+		if stmt.Name != nil {
+			r.declare(stmt.Name.Value, false)
+		}
+	case *ClassStatement:
+		r.declare(stmt.Name.Value, false)
+	case *ExportStatement:
+		r.hoist(stmt.Declaration)
+	case *ImportStatement:
+		if stmt.Alias != nil {
+			r.declare(stmt.Alias.Value, false)
+		}
+		for _, name := range stmt.Names {
+			r.declare(name.Value, false)
+		}
+	case *For:
+		if init, ok := stmt.Initializer.(*VarStatement); ok && init.Token.Type == VAR {
+			r.hoist(init)
+		}
+	}
+}
+
+// declarePattern declares the variables of a destructuring declaration in
+// the order they are bound.
+func (r *Resolver) declarePattern(pattern Destructure, constant bool) {
+	var elements []*DestructureElement
+	var rest Expression
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		elements = pattern.Elements
+		rest = pattern.Rest
+	case *HashDestructure:
+		elements = pattern.Entries
+	}
+
+	for _, element := range elements {
+		if element.Pattern != nil {
+			r.declarePattern(element.Pattern, constant)
+		} else if name, ok := element.Target.(*Identifier); ok {
+			r.declare(name.Value, constant)
+		}
+	}
+	if name, ok := rest.(*Identifier); ok {
+		r.declare(name.Value, constant)
+	}
+}
+
+// resolveStatements resolves statements that run in the innermost scope.
+func (r *Resolver) resolveStatements(statements []Statement) {
+	for _, stmt := range statements {
+		r.resolveStatement(stmt)
+	}
+}
+
+func (r *Resolver) resolveBlock(block *BlockStatement) {
+	if block == nil {
+		return
+	}
+
+	r.beginScope()
+	r.resolveStatements(block.Statements)
+	r.endScope()
+}
+
+func (r *Resolver) resolveStatement(stmt Statement) {
+	switch stmt := stmt.(type) {
+	case *ExpressionStatement:
+		r.resolveExpression(stmt.Expression)
+	case *VarStatement:
+		if r.isGlobalScope() {
+			r.resolveExpression(stmt.Expression)
+			return
+		}
+		v := r.declare(stmt.Identifier.Value, stmt.Token.Type == CONST)
+		v.defined = false
+		r.resolveExpression(stmt.Expression)
+		v.defined = true
+	case *DestructureStatement:
+		r.resolveExpression(stmt.Value)
+		r.resolveDestructureDefaults(stmt.Pattern)
+		if !r.isGlobalScope() {
+			r.declarePattern(stmt.Pattern, stmt.Token.Type == CONST)
+		}
+	case *BlockStatement:
+		r.resolveBlock(stmt)
+	case *FunctionDeclaration:
+		if stmt.Name != nil && !r.isGlobalScope() {
+			r.declare(stmt.Name.Value, false)
+		}
+		r.resolveFunction(&stmt.FunctionCommon, InFunction)
+	case *ClassStatement:
+		r.resolveClass(stmt)
+	case *ExportStatement:
+		r.resolveStatement(stmt.Declaration)
+	case *TestStatement:
+		r.resolveBlock(stmt.Body)
+	case *IfStatement:
+		r.resolveExpression(stmt.Condition)
+		r.resolveBlock(stmt.ThenBranch)
+		r.resolveBlock(stmt.ElseBranch)
+	case *While:
+		r.resolveExpression(stmt.Condition)
+		r.resolveBlock(stmt.Body)
+	case *For:
+		// let and const in the initializer get a scope of their own, var
+		// goes in the enclosing one
+		init, ok := stmt.Initializer.(*VarStatement)
+		scoped := ok && init.Token.Type != VAR
+		if scoped {
+			r.beginScope()
+		}
+		if stmt.Initializer != nil {
+			r.resolveStatement(stmt.Initializer)
+		}
+		r.resolveExpression(stmt.Condition)
+		r.resolveExpression(stmt.Increment)
+		r.resolveBlock(stmt.Body)
+		if scoped {
+			r.endScope()
+		}
+	case *ForInStatement:
+		r.resolveExpression(stmt.Iterable)
+		r.beginScope()
+		constant := stmt.Declaration.Type == CONST
+		if stmt.Key != nil {
+			r.declare(stmt.Key.Value, constant)
+		}
+		r.declare(stmt.Value.Value, constant)
+		r.resolveBlock(stmt.Body)
+		r.endScope()
+	case *ReturnStatement:
+		if r.function == NoFunction {
+			r.addError(stmt.Token, "Can't return from top-level code.")
+		}
+		r.resolveExpression(stmt.ReturnValue)
+	case *ThrowStatement:
+		r.resolveExpression(stmt.Value)
+	case *TryStatement:
+		r.resolveBlock(stmt.Body)
+		if stmt.Catch != nil {
+			// the caught value shares a scope with the catch block
+			r.beginScope()
+			if stmt.CatchName != nil {
+				r.declare(stmt.CatchName.Value, false)
+			}
+			r.resolveStatements(stmt.Catch.Statements)
+			r.endScope()
+		}
+		r.resolveBlock(stmt.Finally)
+	case *SelectStatement:
+		for _, c := range stmt.Cases {
+			r.resolveExpression(c.Channel)
+			r.resolveExpression(c.Value)
+			r.beginScope()
+			if c.Name != nil {
+				r.declare(c.Name.Value, false)
+			}
+			r.resolveBlock(c.Body)
+			r.endScope()
+		}
+		r.resolveBlock(stmt.Default)
+	}
+}
+
+// resolveFunction resolves a call of fun: its parameters get a scope, in
+// which each default can see the parameters before it, and its body
+// another.
+func (r *Resolver) resolveFunction(fun *FunctionCommon, kind FunctionType) {
+	enclosing := r.function
+	r.function = kind
+	defer func() { r.function = enclosing }()
+
+	r.beginScope()
+	r.resolveParameters(fun)
+	r.resolveBlock(fun.Body)
+	r.endScope()
+}
+
+func (r *Resolver) resolveParameters(fun *FunctionCommon) {
+	for idx, param := range fun.Params {
+		if idx < len(fun.Defaults) {
+			r.resolveExpression(fun.Defaults[idx])
+		}
+		r.declare(param.Value, false)
+	}
+	if fun.Rest != nil {
+		r.declare(fun.Rest.Value, false)
+	}
+}
+
+func (r *Resolver) resolveClass(class *ClassStatement) {
+	enclosing := r.class
+	r.class = InClass
+	defer func() { r.class = enclosing }()
+
+	if !r.isGlobalScope() {
+		r.declare(class.Name.Value, false)
+	}
+
+	if class.SuperClass != nil {
+		r.resolveExpression(class.SuperClass)
+
+		// methods close over a scope holding the superclass
+		r.class = InSubclass
+		r.beginScope()
+		r.declare("super", false)
+		defer r.endScope()
+	}
+
+	for _, method := range class.Methods {
+		if method.IsStatic {
+			r.resolveFunction(&method.FunctionCommon, InFunction)
+			continue
+		}
+
+		kind := InMethod
+		if method.Name.Value == "init" {
+			kind = InInitializer
+		}
+		r.resolveMethod(method, kind)
+	}
+}
+
+// resolveMethod resolves a call of a method, which binds this, its
+// parameters and the locals of its body in a single scope.
+func (r *Resolver) resolveMethod(method *MethodDeclaration, kind FunctionType) {
+	enclosing := r.function
+	r.function = kind
+	defer func() { r.function = enclosing }()
+
+	r.beginScope()
+	r.declare("this", false)
+	r.resolveParameters(&method.FunctionCommon)
+	if method.Body != nil {
+		r.resolveStatements(method.Body.Statements)
+	}
+	r.endScope()
+}
+
+func (r *Resolver) resolveDestructureDefaults(pattern Destructure) {
+	var elements []*DestructureElement
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		elements = pattern.Elements
+	case *HashDestructure:
+		elements = pattern.Entries
+	}
+
+	for _, element := range elements {
+		r.resolveExpression(element.Default)
+		if element.Pattern != nil {
+			r.resolveDestructureDefaults(element.Pattern)
+		}
+	}
+}
+
+// resolveTarget checks an expression being assigned to.
+func (r *Resolver) resolveTarget(target Expression) {
+	if name, ok := target.(*Identifier); ok {
+		r.assign(name)
+		return
+	}
+	r.resolveExpression(target)
+}
+
+func (r *Resolver) resolveAssignedPattern(pattern Destructure) {
+	var elements []*DestructureElement
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		elements = pattern.Elements
+		if pattern.Rest != nil {
+			r.resolveTarget(pattern.Rest)
+		}
+	case *HashDestructure:
+		elements = pattern.Entries
+	}
+
+	for _, element := range elements {
+		r.resolveExpression(element.Default)
+		if element.Pattern != nil {
+			r.resolveAssignedPattern(element.Pattern)
+		} else {
+			r.resolveTarget(element.Target)
+		}
+	}
+}
+
+func (r *Resolver) resolveExpression(expr Expression) {
+	switch expr := expr.(type) {
+	case *Identifier:
+		if scope := r.scopes[len(r.scopes)-1]; !r.isGlobalScope() {
+			if v, ok := scope[expr.Value]; ok && !v.defined {
+				r.addError(expr.Token, "Can't read local variable '%s' in its own initializer.", expr.Value)
+			}
+		}
+		r.resolveLocal(expr, expr.Value)
+	case *This:
+		if r.class == NoClass {
+			r.addError(expr.Token, "Can't use 'this' outside of a class.")
+			return
+		}
+		r.resolveLocal(expr, "this")
+	case *Super:
+		if r.class == NoClass {
+			r.addError(expr.Token, "Can't use 'super' outside of a class.")
+		} else if r.class != InSubclass {
+			r.addError(expr.Token, "Can't use 'super' in a class with no superclass.")
+		}
+	case *Assignment:
+		r.resolveExpression(expr.Expression)
+		r.assign(&expr.Identifier)
+	case *CompoundAssignment:
+		r.resolveExpression(expr.Value)
+		r.resolveTarget(expr.Target)
+	case *UpdateExpression:
+		r.resolveTarget(expr.Target)
+	case *DestructureAssignment:
+		r.resolveExpression(expr.Value)
+		r.resolveAssignedPattern(expr.Pattern)
+	case *FunctionLiteral:
+		if expr.Name != nil && !r.isGlobalScope() {
+			r.declare(expr.Name.Value, false)
+		}
+		r.resolveFunction(&expr.FunctionCommon, InFunction)
+	case *Binary:
+		r.resolveExpression(expr.Left)
+		r.resolveExpression(expr.Right)
+	case *Logical:
+		r.resolveExpression(expr.Left)
+		r.resolveExpression(expr.Right)
+	case *Unary:
+		r.resolveExpression(expr.Right)
+	case *GroupedExpression:
+		r.resolveExpression(expr.Expression)
+	case *TernaryExpression:
+		r.resolveExpression(expr.Condition)
+		r.resolveExpression(expr.ThenBranch)
+		r.resolveExpression(expr.ElseBranch)
+	case *CallExpression:
+		r.resolveExpression(expr.Callee)
+		for _, arg := range expr.Arguments {
+			r.resolveExpression(arg)
+		}
+		for _, named := range expr.Named {
+			r.resolveExpression(named.Value)
+		}
+	case *SpreadExpression:
+		r.resolveExpression(expr.Value)
+	case *SpawnExpression:
+		r.resolveExpression(expr.Call)
+	case *AwaitExpression:
+		r.resolveExpression(expr.Value)
+	case *YieldExpression:
+		r.resolveExpression(expr.Value)
+	case *GetExpression:
+		r.resolveExpression(expr.Object)
+	case *SetExpression:
+		r.resolveExpression(expr.Object)
+		r.resolveExpression(expr.Value)
+	case *IndexExpression:
+		r.resolveExpression(expr.Left)
+		r.resolveExpression(expr.Index)
+	case *SliceExpression:
+		r.resolveExpression(expr.Left)
+		r.resolveExpression(expr.Start)
+		r.resolveExpression(expr.End)
+	case *SetIndexExpression:
+		r.resolveExpression(expr.Left)
+		r.resolveExpression(expr.Index)
+		r.resolveExpression(expr.Value)
+	case *SetSliceExpression:
+		r.resolveExpression(expr.Left)
+		r.resolveExpression(expr.Start)
+		r.resolveExpression(expr.End)
+		r.resolveExpression(expr.Value)
+	case *RangeExpression:
+		r.resolveExpression(expr.Start)
+		r.resolveExpression(expr.End)
+		r.resolveExpression(expr.Step)
+	case *ArrayLiteral:
+		for _, element := range expr.Elements {
+			r.resolveExpression(element)
+		}
+	case *HashLiteral:
+		for key, value := range expr.Pairs {
+			r.resolveExpression(key)
+			r.resolveExpression(value)
+		}
+	case *InterpolationExpression:
+		for _, part := range expr.Parts {
+			r.resolveExpression(part)
+		}
+	case *MatchExpression:
+		r.resolveExpression(expr.Subject)
+		for _, arm := range expr.Arms {
+			r.beginScope()
+			for _, pattern := range arm.Patterns {
+				r.declareBindings(pattern)
+			}
+			r.resolveExpression(arm.Guard)
+			r.resolveExpression(arm.Body)
+			if arm.Block != nil {
+				r.resolveStatements(arm.Block.Statements)
+			}
+			r.endScope()
+		}
+	}
+}
+
+// declareBindings declares the names a match pattern binds, in the order
+// the interpreter defines them.
+func (r *Resolver) declareBindings(pattern Pattern) {
+	for _, name := range patternBindings(pattern) {
+		r.declare(name, false)
+	}
+}
@@ -9,14 +9,20 @@ type Scanner struct {
 	start   int // start of the new scanned token
 	current int // current char
 	line    int // line identifying token
-	tokens  []*Token
-	errors  *ErrorHandler
+	// lineStart is where the current line starts and column where the
+	// token being scanned does
+	lineStart int
+	column    int
+	tokens    []*Token
+	errors    *ErrorHandler
+	// open "${" interpolations, each entry counts the unclosed braces inside it
+	interpolations []int
 }
 
 func NewScanner(source []byte) *Scanner {
 	tokens := make([]*Token, 0)
 	errors := NewErrorHandler()
-	return &Scanner{source, 0, 0, 1, tokens, errors}
+	return &Scanner{source: source, line: 1, tokens: tokens, errors: errors}
 }
 
 func (s *Scanner) Tokens() []*Token {
@@ -56,6 +62,7 @@ func (s *Scanner) addError(err *Error) {
 
 func (s *Scanner) addToken(tokenType TokenType, lexeme string) {
 	token := NewToken(tokenType, lexeme, s.line)
+	token.Column = s.column
 	s.tokens = append(s.tokens, token)
 }
 
@@ -81,9 +88,15 @@ func (s *Scanner) scanTokens() []*Error {
 	for !s.isAtEnd() {
 		// we are at the beginning of next lexeme
 		s.start = s.current
+		s.column = s.start - s.lineStart + 1
 		s.scanToken()
 	}
 
+	if len(s.interpolations) > 0 {
+		s.addError(&Error{Message: "Unterminated string interpolation.", Line: s.line})
+	}
+
+	s.column = s.current - s.lineStart + 1
 	s.addToken(EOF, "0")
 	return nil
 }
@@ -127,23 +140,37 @@ func (s *Scanner) peekNext() byte {
 }
 
 func (s *Scanner) str() {
+	s.stringSegment(s.start + 1)
+}
+
+// stringSegment scans string contents starting at begin. A "${" ends the
+// segment with an INTERPOLATION token, the closing quote with a STRING token.
+func (s *Scanner) stringSegment(begin int) {
 	for s.peek() != '"' && !s.isAtEnd() {
+		if s.peek() == '$' && s.peekNext() == '{' {
+			text := string(s.source[begin:s.current])
+			s.current += 2
+			s.addToken(INTERPOLATION, text)
+			s.interpolations = append(s.interpolations, 1)
+			return
+		}
 		if s.peek() == '\n' {
 			s.line++
+			s.lineStart = s.current + 1
 		}
 		s.advance()
 	}
 
 	if s.isAtEnd() {
-		msg := fmt.Sprintf("Unexpected character: %q", s.peek())
-		err := &Error{Message: msg, Line: s.line}
+		err := &Error{Message: "Unterminated string.", Line: s.line}
 		s.addError(err)
+		return
 	}
 
 	// The closing  ".
 	s.advance()
 
-	text := string(s.source[s.start+1 : s.current-1])
+	text := string(s.source[begin : s.current-1])
 	s.addToken(STRING, text)
 }
 
@@ -159,25 +186,74 @@ func (s *Scanner) scanToken() {
 	case ']':
 		s.addToken(RIGHT_BRACE, "]")
 	case '{':
+		if n := len(s.interpolations); n > 0 {
+			s.interpolations[n-1]++
+		}
 		s.addToken(LEFT_BRACKET, "{")
 	case '}':
+		if n := len(s.interpolations); n > 0 {
+			s.interpolations[n-1]--
+			if s.interpolations[n-1] == 0 {
+				// end of "${ ... }", carry on with the rest of the string
+				s.interpolations = s.interpolations[:n-1]
+				s.stringSegment(s.current)
+				break
+			}
+		}
 		s.addToken(RIGHT_BRACKET, "}")
 	case ',':
 		s.addToken(COMMA, ",")
 	case '.':
-		s.addToken(DOT, ".")
+		if s.match('.') {
+			if s.match('<') {
+				s.addToken(DOT_DOT_LESS, "..<")
+			} else if s.match('.') {
+				s.addToken(ELLIPSIS, "...")
+			} else {
+				s.addToken(DOT_DOT, "..")
+			}
+		} else {
+			s.addToken(DOT, ".")
+		}
 	case '-':
-		s.addToken(MINUS, "-")
+		if s.match('-') {
+			s.addToken(MINUS_MINUS, "--")
+		} else if s.match('=') {
+			s.addToken(MINUS_EQUAL, "-=")
+		} else {
+			s.addToken(MINUS, "-")
+		}
 	case '+':
-		s.addToken(PLUS, "+")
+		if s.match('+') {
+			s.addToken(PLUS_PLUS, "++")
+		} else if s.match('=') {
+			s.addToken(PLUS_EQUAL, "+=")
+		} else {
+			s.addToken(PLUS, "+")
+		}
 	case ';':
 		s.addToken(SEMICOLON, ";")
 	case ':':
 		s.addToken(COLON, ":")
 	case '?':
-		s.addToken(QUESTION, "?")
+		if s.peek() == '?' && s.peekNext() == '=' {
+			s.current += 2
+			s.addToken(QUESTION_QUESTION_EQUAL, "??=")
+		} else {
+			s.addToken(QUESTION, "?")
+		}
 	case '*':
-		s.addToken(STAR, "*")
+		if s.match('=') {
+			s.addToken(STAR_EQUAL, "*=")
+		} else {
+			s.addToken(STAR, "*")
+		}
+	case '%':
+		if s.match('=') {
+			s.addToken(PERCENT_EQUAL, "%=")
+		} else {
+			s.addToken(PERCENT, "%")
+		}
 	case '!':
 		if s.match('=') {
 			s.addToken(BANG_EQUAL, "!=")
@@ -187,6 +263,8 @@ func (s *Scanner) scanToken() {
 	case '=':
 		if s.match('=') {
 			s.addToken(EQUAL_EQUAL, "==")
+		} else if s.match('>') {
+			s.addToken(FAT_ARROW, "=>")
 		} else {
 			s.addToken(EQUAL, "=")
 		}
@@ -207,6 +285,9 @@ func (s *Scanner) scanToken() {
 			for s.peek() != '\n' && !s.isAtEnd() {
 				s.advance()
 			}
+			s.addToken(COMMENT, string(s.source[s.start:s.current]))
+		} else if s.match('=') {
+			s.addToken(SLASH_EQUAL, "/=")
 		} else {
 			s.addToken(SLASH, "/")
 		}
@@ -214,6 +295,7 @@ func (s *Scanner) scanToken() {
 		// Ignore whitespaces
 	case '\n':
 		s.line++
+		s.lineStart = s.current
 		break
 	case '"':
 		s.str()
@@ -28,7 +28,7 @@ func TestScanner(t *testing.T) {
 		},
 		{
 			// One or two characters tokens.
-			input: "!!====>>=<<=",
+			input: "!!==== >>=<<=",
 			expected: []*Token{
 				NewToken(BANG, "!", 1),
 				NewToken(BANG_EQUAL, "!=", 1),
@@ -69,6 +69,116 @@ func TestScanner(t *testing.T) {
 				NewToken(EOF, "0", 1),
 			},
 		},
+		{
+			input: `"a ${x} b"`,
+			expected: []*Token{
+				NewToken(INTERPOLATION, "a ", 1),
+				NewToken(IDENTIFIER, "x", 1),
+				NewToken(STRING, " b", 1),
+				NewToken(EOF, "0", 1),
+			},
+		},
+		{
+			// nested quotes and braces inside the interpolation
+			input: `"${ {"k": "${y}"}["k"] }!"`,
+			expected: []*Token{
+				NewToken(INTERPOLATION, "", 1),
+				NewToken(LEFT_BRACKET, "{", 1),
+				NewToken(STRING, "k", 1),
+				NewToken(COLON, ":", 1),
+				NewToken(INTERPOLATION, "", 1),
+				NewToken(IDENTIFIER, "y", 1),
+				NewToken(STRING, "", 1),
+				NewToken(RIGHT_BRACKET, "}", 1),
+				NewToken(LEFT_BRACE, "[", 1),
+				NewToken(STRING, "k", 1),
+				NewToken(RIGHT_BRACE, "]", 1),
+				NewToken(STRING, "!", 1),
+				NewToken(EOF, "0", 1),
+			},
+		},
+		{
+			input: "x = 1; // lox:ignore\n// note",
+			expected: []*Token{
+				NewToken(IDENTIFIER, "x", 1),
+				NewToken(EQUAL, "=", 1),
+				NewToken(NUMBER, "1", 1),
+				NewToken(SEMICOLON, ";", 1),
+				NewToken(COMMENT, "// lox:ignore", 1),
+				NewToken(COMMENT, "// note", 2),
+				NewToken(EOF, "0", 2),
+			},
+		},
+		{
+			input: "x => _",
+			expected: []*Token{
+				NewToken(IDENTIFIER, "x", 1),
+				NewToken(FAT_ARROW, "=>", 1),
+				NewToken(IDENTIFIER, "_", 1),
+				NewToken(EOF, "0", 1),
+			},
+		},
+		{
+			// ranges; the number stops before ".."
+			input: "0..10 1..<2.5",
+			expected: []*Token{
+				NewToken(NUMBER, "0", 1),
+				NewToken(DOT_DOT, "..", 1),
+				NewToken(NUMBER, "10", 1),
+				NewToken(NUMBER, "1", 1),
+				NewToken(DOT_DOT_LESS, "..<", 1),
+				NewToken(NUMBER, "2.5", 1),
+				NewToken(EOF, "0", 1),
+			},
+		},
+		{
+			input: "[a, ...rest]",
+			expected: []*Token{
+				NewToken(LEFT_BRACE, "[", 1),
+				NewToken(IDENTIFIER, "a", 1),
+				NewToken(COMMA, ",", 1),
+				NewToken(ELLIPSIS, "...", 1),
+				NewToken(IDENTIFIER, "rest", 1),
+				NewToken(RIGHT_BRACE, "]", 1),
+				NewToken(EOF, "0", 1),
+			},
+		},
+		{
+			input: "try catch finally throw",
+			expected: []*Token{
+				NewToken(TRY, "try", 1),
+				NewToken(CATCH, "catch", 1),
+				NewToken(FINALLY, "finally", 1),
+				NewToken(THROW, "throw", 1),
+				NewToken(EOF, "0", 1),
+			},
+		},
+		{
+			input: "spawn select async await",
+			expected: []*Token{
+				NewToken(SPAWN, "spawn", 1),
+				NewToken(SELECT, "select", 1),
+				NewToken(ASYNC, "async", 1),
+				NewToken(AWAIT, "await", 1),
+				NewToken(EOF, "0", 1),
+			},
+		},
+		{
+			input: "import export",
+			expected: []*Token{
+				NewToken(IMPORT, "import", 1),
+				NewToken(EXPORT, "export", 1),
+				NewToken(EOF, "0", 1),
+			},
+		},
+		{
+			input: "let const",
+			expected: []*Token{
+				NewToken(LET, "let", 1),
+				NewToken(CONST, "const", 1),
+				NewToken(EOF, "0", 1),
+			},
+		},
 		{
 			input: "this",
 			expected: []*Token{
@@ -82,11 +192,11 @@ func TestScanner(t *testing.T) {
 		scanner := NewScanner([]byte(test.input))
 		scanner.scanTokens()
 
-		if len(scanner.Tokens) != len(test.expected) {
-			t.Fatalf("Incorrect tokens length: expected=%d, got=%d", len(test.expected), len(scanner.Tokens))
+		if len(scanner.Tokens()) != len(test.expected) {
+			t.Fatalf("Incorrect tokens length: expected=%d, got=%d", len(test.expected), len(scanner.Tokens()))
 		}
 
-		for i, token := range scanner.Tokens {
+		for i, token := range scanner.Tokens() {
 			if token.Type != test.expected[i].Type {
 				t.Errorf("Token type mismatch: expected=%s, got=%s", test.expected[i].Type, token.Type)
 			}
@@ -102,3 +212,37 @@ func TestScanner(t *testing.T) {
 		}
 	}
 }
+
+func TestScannerColumns(t *testing.T) {
+	input := "var x = \"a\";\n  let y = x;"
+	expected := []struct {
+		lexeme string
+		line   int
+		column int
+	}{
+		{"var", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"a", 1, 9},
+		{";", 1, 12},
+		{"let", 2, 3},
+		{"y", 2, 7},
+		{"=", 2, 9},
+		{"x", 2, 11},
+		{";", 2, 12},
+		{"0", 2, 13},
+	}
+
+	scanner := NewScanner([]byte(input))
+	scanner.scanTokens()
+
+	if len(scanner.Tokens()) != len(expected) {
+		t.Fatalf("Incorrect tokens length: expected=%d, got=%d", len(expected), len(scanner.Tokens()))
+	}
+
+	for i, token := range scanner.Tokens() {
+		if token.Lexeme != expected[i].lexeme || token.Line != expected[i].line || token.Column != expected[i].column {
+			t.Errorf("Token %d: expected=%q at %d:%d, got=%q at %d:%d", i, expected[i].lexeme, expected[i].line, expected[i].column, token.Lexeme, token.Line, token.Column)
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// DeclarationKind tells what a declaration declares.
+type DeclarationKind int
+
+const (
+	VariableKind DeclarationKind = iota
+	ParameterKind
+	FunctionKind
+	ClassKind
+	MethodKind
+	BuiltinKind
+)
+
+// Declaration is a name a program declares, along with every place the
+// name is used to refer to it.
+type Declaration struct {
+	Name string
+	Kind DeclarationKind
+	// Token is the name where it is declared, the zero token for builtins
+	Token Token
+	// Keyword is the var, let or const of a variable
+	Keyword string
+	// Function is set for functions, Method for methods and Class for
+	// classes
+	Function *FunctionCommon
+	Method   *MethodDeclaration
+	Class    *ClassStatement
+	// Owner is the class of a method
+	Owner *Declaration
+	// Doc is the comment right above the declaration, without the slashes
+	Doc        string
+	References []Token
+	// ScopeEnd closes the block or function the declaration is visible
+	// in, the zero token for the whole file
+	ScopeEnd Token
+}
+
+// Signature describes the declaration the way it is written, such as
+// "function add(a, b = 1)".
+func (d *Declaration) Signature() string {
+	f := newFormatter(nil)
+	switch d.Kind {
+	case FunctionKind:
+		if d.Function.IsAsync {
+			f.write("async ")
+		}
+		f.write(d.Function.Token.Lexeme)
+		if d.Function.IsGenerator {
+			f.write(STAR)
+		}
+		f.write(" ", d.Name)
+		f.params(d.Function)
+	case MethodKind:
+		if d.Method.IsStatic {
+			f.write("static ")
+		}
+		f.write(d.Owner.Name, ".", d.Name)
+		if !d.Method.IsGetter {
+			f.params(&d.Method.FunctionCommon)
+		}
+	case ClassKind:
+		f.write("class ", d.Name)
+		if d.Class != nil && d.Class.SuperClass != nil {
+			f.write(" extends ", d.Class.SuperClass.Value)
+		}
+	case ParameterKind:
+		f.write("parameter ", d.Name)
+	case BuiltinKind:
+		f.write("builtin ", d.Name)
+	default:
+		f.write(d.Keyword, " ", d.Name)
+	}
+	return f.out.String()
+}
+
+// SymbolIndex links the names in a program to their declarations. It
+// scopes names the way the compiler does, with a SymbolTable, so a name
+// refers to what it would refer to when compiled. A property refers to
+// every method of that name, whatever the object is.
+type SymbolIndex struct {
+	Declarations []*Declaration
+	// names holds the name tokens of each line with their declarations,
+	// by column
+	names map[int][]indexedName
+}
+
+type indexedName struct {
+	token       Token
+	declaration *Declaration
+}
+
+// NewSymbolIndex indexes program, which may be one with syntax errors for
+// the statements that did parse.
+func NewSymbolIndex(program *Program) *SymbolIndex {
+	ix := &indexer{
+		index:        &SymbolIndex{names: make(map[int][]indexedName)},
+		table:        NewSymbolTable(),
+		declarations: make(map[*SymbolTable]map[int]*Declaration),
+		methods:      make(map[string][]*Declaration),
+		docs:         docComments(program),
+	}
+	ix.root = ix.table
+
+	for _, def := range Builtins {
+		ix.define(ix.table.DefineBuiltin(def.Name), &Declaration{Name: def.Name, Kind: BuiltinKind})
+	}
+	ix.define(ix.table.Define(ErrorClassName), &Declaration{Name: ErrorClassName, Kind: ClassKind})
+
+	for _, stmt := range program.Statements {
+		ix.statement(stmt)
+	}
+	for _, refs := range ix.properties {
+		ix.property(refs)
+	}
+	for _, names := range ix.index.names {
+		sort.Slice(names, func(i, j int) bool { return names[i].token.Column < names[j].token.Column })
+	}
+
+	return ix.index
+}
+
+// At returns the declaration of the name at line and column, and the
+// token of the name.
+func (s *SymbolIndex) At(line, column int) (*Declaration, Token, bool) {
+	for _, name := range s.names[line] {
+		if column >= name.token.Column && column <= name.token.Column+len(name.token.Lexeme) {
+			return name.declaration, name.token, true
+		}
+	}
+	return nil, Token{}, false
+}
+
+// Related returns the declarations renaming d has to rename too: the
+// methods of the same name, which a property can refer to all at once.
+func (s *SymbolIndex) Related(d *Declaration) []*Declaration {
+	if d.Kind != MethodKind {
+		return []*Declaration{d}
+	}
+
+	var related []*Declaration
+	for _, other := range s.Declarations {
+		if other.Kind == MethodKind && other.Name == d.Name {
+			related = append(related, other)
+		}
+	}
+	return related
+}
+
+// Visible returns the declarations that can be referred to by name at
+// line and column.
+func (s *SymbolIndex) Visible(line, column int) []*Declaration {
+	var visible []*Declaration
+	for _, d := range s.Declarations {
+		if d.Kind == MethodKind {
+			continue
+		}
+		if d.Kind != BuiltinKind && before(line, column, d.Token.Line, d.Token.Column) {
+			continue
+		}
+		if d.ScopeEnd.Line > 0 && !before(line, column, d.ScopeEnd.Line, d.ScopeEnd.Column) {
+			continue
+		}
+		visible = append(visible, d)
+	}
+	return visible
+}
+
+// Methods returns the methods of every class.
+func (s *SymbolIndex) Methods() []*Declaration {
+	var methods []*Declaration
+	for _, d := range s.Declarations {
+		if d.Kind == MethodKind {
+			methods = append(methods, d)
+		}
+	}
+	return methods
+}
+
+func before(line, column, otherLine, otherColumn int) bool {
+	return line < otherLine || line == otherLine && column < otherColumn
+}
+
+// docComments returns the text of the comments that are alone on their
+// lines, by line.
+func docComments(program *Program) map[int]string {
+	code := make(map[int]bool)
+	for _, stmt := range program.Statements {
+		Walk(stmt, func(node Node) bool {
+			code[nodeToken(node).Line] = true
+			return true
+		})
+	}
+
+	docs := make(map[int]string)
+	for _, comment := range program.Comments {
+		if !code[comment.Line] {
+			docs[comment.Line] = strings.TrimSpace(strings.TrimPrefix(comment.Lexeme, "//"))
+		}
+	}
+	return docs
+}
+
+type indexer struct {
+	index *SymbolIndex
+	table *SymbolTable
+	root  *SymbolTable
+	// declarations holds the declaration of each slot of each table
+	declarations map[*SymbolTable]map[int]*Declaration
+	methods      map[string][]*Declaration
+	// properties are the property names met, linked to the methods once
+	// every class is known
+	properties [][]Token
+	docs       map[int]string
+	// scope and blocks are the ends of the function and the blocks
+	// being indexed
+	scope  Token
+	blocks []Token
+}
+
+func (ix *indexer) define(symbol Symbol, d *Declaration) *Declaration {
+	if ix.declarations[ix.table] == nil {
+		ix.declarations[ix.table] = make(map[int]*Declaration)
+	}
+	ix.declarations[ix.table][symbol.Index] = d
+	ix.add(d)
+	return d
+}
+
+// add records the declaration d and where it is declared.
+func (ix *indexer) add(d *Declaration) {
+	ix.index.Declarations = append(ix.index.Declarations, d)
+	if d.Token.Line > 0 {
+		ix.index.names[d.Token.Line] = append(ix.index.names[d.Token.Line], indexedName{d.Token, d})
+		d.Doc = ix.doc(d.Token.Line)
+	}
+}
+
+// doc returns the comment lines right above line.
+func (ix *indexer) doc(line int) string {
+	var lines []string
+	for line--; ; line-- {
+		text, ok := ix.docs[line]
+		if !ok {
+			break
+		}
+		lines = append([]string{text}, lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ix *indexer) reference(token Token, d *Declaration) {
+	d.References = append(d.References, token)
+	ix.index.names[token.Line] = append(ix.index.names[token.Line], indexedName{token, d})
+}
+
+// declare defines name the way keyword does: var for the rest of the
+// function, let and const for the rest of the block.
+func (ix *indexer) declare(name *Identifier, keyword Token, kind DeclarationKind) {
+	d := &Declaration{Name: name.Value, Kind: kind, Token: name.Token, Keyword: keyword.Lexeme, ScopeEnd: ix.scope}
+	if keyword.Type == VAR || len(ix.blocks) == 0 {
+		ix.define(ix.table.Define(name.Value), d)
+		return
+	}
+	d.ScopeEnd = ix.blocks[len(ix.blocks)-1]
+	ix.define(ix.table.DefineBlock(name.Value, keyword.Type == CONST), d)
+}
+
+// bind declares name for a catch clause, a select case or a match
+// pattern, which reuse a variable of the function by that name.
+func (ix *indexer) bind(name *Identifier) {
+	if symbol, ok := ix.table.ResolveInner(name.Value); ok && symbol.Scope != BUILTIN_SCOPE {
+		if d := ix.lookup(ix.table, symbol); d != nil {
+			ix.reference(name.Token, d)
+		}
+		return
+	}
+	ix.define(ix.table.Define(name.Value), &Declaration{Name: name.Value, Kind: VariableKind, Token: name.Token, Keyword: "var", ScopeEnd: ix.scope})
+}
+
+func (ix *indexer) resolve(name *Identifier) {
+	if symbol, ok := ix.table.Resolve(name.Value); ok {
+		if d := ix.lookup(ix.table, symbol); d != nil {
+			ix.reference(name.Token, d)
+		}
+	}
+}
+
+// lookup finds the declaration of symbol, as resolved in table. An
+// upvalue is looked up as the symbol it captures, in the table around.
+func (ix *indexer) lookup(table *SymbolTable, symbol Symbol) *Declaration {
+	switch symbol.Scope {
+	case GLOBAL_SCOPE, BUILTIN_SCOPE:
+		return ix.declarations[ix.root][symbol.Index]
+	case UPVALUE_SCOPE:
+		return ix.lookup(table.Outer, table.upvalues[symbol.Index])
+	default:
+		return ix.declarations[table][symbol.Index]
+	}
+}
+
+// property links a property name to the methods it may call.
+func (ix *indexer) property(tokens []Token) {
+	for _, token := range tokens {
+		for _, method := range ix.methods[token.Lexeme] {
+			ix.reference(token, method)
+		}
+	}
+}
+
+func (ix *indexer) block(block *BlockStatement) {
+	if block == nil {
+		return
+	}
+	ix.table.EnterBlock()
+	ix.blocks = append(ix.blocks, block.End)
+	for _, stmt := range block.Statements {
+		ix.statement(stmt)
+	}
+	ix.blocks = ix.blocks[:len(ix.blocks)-1]
+	ix.table.LeaveBlock()
+}
+
+// function indexes fun in a table of its own, as the compiler compiles
+// it; methods have "this" in their first slot.
+func (ix *indexer) function(fun *FunctionCommon, method bool) {
+	table, scope, blocks := ix.table, ix.scope, ix.blocks
+	ix.table = NewEnclosedSymbolTable(table)
+	ix.scope, ix.blocks = fun.Body.End, nil
+	defer func() { ix.table, ix.scope, ix.blocks = table, scope, blocks }()
+
+	if method {
+		ix.table.Define("this")
+	}
+	for _, param := range fun.Params {
+		symbol := ix.table.Define(param.Value)
+		// a destructured parameter is unpacked by a declaration the
+		// parser adds to the body, which declares the names
+		if param.Token.Type == IDENTIFIER {
+			ix.define(symbol, &Declaration{Name: param.Value, Kind: ParameterKind, Token: param.Token, ScopeEnd: ix.scope})
+		}
+	}
+	if fun.Rest != nil {
+		ix.define(ix.table.Define(fun.Rest.Value), &Declaration{Name: fun.Rest.Value, Kind: ParameterKind, Token: fun.Rest.Token, ScopeEnd: ix.scope})
+	}
+	for _, value := range fun.Defaults {
+		ix.expr(value)
+	}
+	ix.block(fun.Body)
+}
+
+func (ix *indexer) statement(stmt Statement) {
+	switch stmt := stmt.(type) {
+	case *ExpressionStatement:
+		ix.expr(stmt.Expression)
+	case *VarStatement:
+		ix.declare(stmt.Identifier, stmt.Token, VariableKind)
+		ix.expr(stmt.Expression)
+	case *DestructureStatement:
+		ix.expr(stmt.Value)
+		kind := VariableKind
+		// the var the parser makes up to unpack a parameter has no column
+		if stmt.Token.Column == 0 {
+			kind = ParameterKind
+		}
+		ix.destructure(stmt.Pattern, func(target Expression) {
+			ix.declare(target.(*Identifier), stmt.Token, kind)
+		})
+	case *FunctionDeclaration:
+		d := &Declaration{Name: stmt.Name.Value, Kind: FunctionKind, Token: stmt.Name.Token, Function: &stmt.FunctionCommon, ScopeEnd: ix.scope}
+		ix.define(ix.table.Define(stmt.Name.Value), d)
+		ix.function(&stmt.FunctionCommon, false)
+	case *ClassStatement:
+		d := &Declaration{Name: stmt.Name.Value, Kind: ClassKind, Token: stmt.Name.Token, Class: stmt, ScopeEnd: ix.scope}
+		ix.define(ix.table.Define(stmt.Name.Value), d)
+		if stmt.SuperClass != nil {
+			ix.resolve(stmt.SuperClass)
+		}
+
+		for _, method := range stmt.Methods {
+			m := &Declaration{Name: method.Name.Value, Kind: MethodKind, Token: method.Name.Token, Method: method, Owner: d}
+			ix.add(m)
+			ix.methods[m.Name] = append(ix.methods[m.Name], m)
+			ix.function(&method.FunctionCommon, true)
+		}
+	case *IfStatement:
+		ix.expr(stmt.Condition)
+		ix.block(stmt.ThenBranch)
+		ix.block(stmt.ElseBranch)
+	case *While:
+		ix.expr(stmt.Condition)
+		ix.block(stmt.Body)
+	case *For:
+		ix.table.EnterBlock()
+		ix.blocks = append(ix.blocks, stmt.Body.End)
+		if stmt.Initializer != nil {
+			ix.statement(stmt.Initializer)
+		}
+		ix.expr(stmt.Condition)
+		ix.expr(stmt.Increment)
+		ix.block(stmt.Body)
+		ix.blocks = ix.blocks[:len(ix.blocks)-1]
+		ix.table.LeaveBlock()
+	case *ForInStatement:
+		ix.expr(stmt.Iterable)
+		ix.table.EnterBlock()
+		ix.blocks = append(ix.blocks, stmt.Body.End)
+		for _, name := range []*Identifier{stmt.Value, stmt.Key} {
+			if name != nil {
+				ix.declare(name, stmt.Declaration, VariableKind)
+			}
+		}
+		ix.block(stmt.Body)
+		ix.blocks = ix.blocks[:len(ix.blocks)-1]
+		ix.table.LeaveBlock()
+	case *ReturnStatement:
+		ix.expr(stmt.ReturnValue)
+	case *ThrowStatement:
+		ix.expr(stmt.Value)
+	case *TryStatement:
+		ix.block(stmt.Body)
+		if stmt.CatchName != nil {
+			ix.bind(stmt.CatchName)
+		}
+		ix.block(stmt.Catch)
+		ix.block(stmt.Finally)
+	case *SelectStatement:
+		for _, c := range stmt.Cases {
+			ix.expr(c.Channel)
+			ix.expr(c.Value)
+		}
+		for _, c := range stmt.Cases {
+			if c.Name != nil {
+				ix.bind(c.Name)
+			}
+			ix.block(c.Body)
+		}
+		ix.block(stmt.Default)
+	case *ImportStatement:
+		if stmt.Alias != nil {
+			ix.define(ix.table.Define(stmt.Alias.Value), &Declaration{Name: stmt.Alias.Value, Kind: VariableKind, Token: stmt.Alias.Token, Keyword: stmt.Token.Lexeme})
+		}
+		for _, name := range stmt.Names {
+			ix.define(ix.table.Define(name.Value), &Declaration{Name: name.Value, Kind: VariableKind, Token: name.Token, Keyword: stmt.Token.Lexeme})
+		}
+	case *ExportStatement:
+		ix.statement(stmt.Declaration)
+	case *TestStatement:
+		ix.block(stmt.Body)
+	}
+}
+
+// destructure indexes the defaults of pattern and hands each target to
+// target.
+func (ix *indexer) destructure(pattern Destructure, target func(Expression)) {
+	var elements []*DestructureElement
+	var rest Expression
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		elements, rest = pattern.Elements, pattern.Rest
+	case *HashDestructure:
+		elements = pattern.Entries
+	}
+
+	for _, element := range elements {
+		ix.expr(element.Default)
+		if element.Pattern != nil {
+			ix.destructure(element.Pattern, target)
+		} else {
+			target(element.Target)
+		}
+	}
+	if rest != nil {
+		target(rest)
+	}
+}
+
+func (ix *indexer) expr(expr Expression) {
+	if isNilNode(expr) {
+		return
+	}
+
+	switch expr := expr.(type) {
+	case *Identifier:
+		ix.resolve(expr)
+	case *Assignment:
+		ix.expr(expr.Expression)
+		ix.resolve(&expr.Identifier)
+	case *CompoundAssignment:
+		ix.expr(expr.Target)
+		ix.expr(expr.Value)
+	case *UpdateExpression:
+		ix.expr(expr.Target)
+	case *DestructureAssignment:
+		ix.expr(expr.Value)
+		ix.destructure(expr.Pattern, ix.expr)
+	case *GetExpression:
+		ix.expr(expr.Object)
+		ix.properties = append(ix.properties, []Token{expr.Property.Token})
+	case *SetExpression:
+		ix.expr(expr.Object)
+		ix.expr(expr.Value)
+	case *Super:
+		ix.properties = append(ix.properties, []Token{expr.Method.Token})
+	case *CallExpression:
+		ix.expr(expr.Callee)
+		for _, arg := range expr.Arguments {
+			ix.expr(arg)
+		}
+		for _, named := range expr.Named {
+			ix.expr(named.Value)
+		}
+	case *FunctionLiteral:
+		ix.function(&expr.FunctionCommon, false)
+	case *MatchExpression:
+		ix.expr(expr.Subject)
+		for _, arm := range expr.Arms {
+			for _, pattern := range arm.Patterns {
+				ix.pattern(pattern)
+			}
+			ix.expr(arm.Guard)
+			ix.expr(arm.Body)
+			ix.block(arm.Block)
+		}
+	default:
+		// the other expressions only hold expressions
+		Walk(expr, func(node Node) bool {
+			if node == Node(expr) {
+				return true
+			}
+			if child, ok := node.(Expression); ok {
+				ix.expr(child)
+			}
+			return false
+		})
+	}
+}
+
+func (ix *indexer) pattern(pattern Pattern) {
+	switch pattern := pattern.(type) {
+	case *BindingPattern:
+		ix.bind(pattern.Name)
+	case *ArrayPattern:
+		for _, element := range pattern.Elements {
+			ix.pattern(element)
+		}
+	case *HashPattern:
+		for _, value := range pattern.Values {
+			ix.pattern(value)
+		}
+	case *ClassPattern:
+		ix.resolve(pattern.Class)
+		for _, field := range pattern.Fields {
+			ix.pattern(field)
+		}
+	}
+}
@@ -11,6 +11,8 @@ type Symbol struct {
 	Name  string
 	Index int
 	Scope string
+	// Const symbols can't be assigned after they are defined
+	Const bool
 }
 
 type SymbolTable struct {
@@ -18,8 +20,25 @@ type SymbolTable struct {
 
 	store          map[string]Symbol
 	numDefinitions int
+	// locals holds the name in each stack slot the outermost table hands
+	// out for loop variables, which are not globals
+	locals []string
+	// names holds the name defined in each slot
+	names []string
 
 	upvalues []Symbol
+
+	// blocks holds a blockScope for each block being compiled, innermost
+	// last
+	blocks []*blockScope
+}
+
+// blockScope records the names declared in one block and, for those
+// declared with let or const, the symbols they hid, which come back into
+// scope when the block ends. A nil symbol means the name was undefined.
+type blockScope struct {
+	declared map[string]bool
+	shadowed map[string]*Symbol
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -37,6 +56,7 @@ func (s *SymbolTable) DefineBuiltin(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: BUILTIN_SCOPE}
 	s.store[name] = symbol
 	s.numDefinitions++
+	s.names = append(s.names, name)
 	return symbol
 }
 
@@ -50,9 +70,106 @@ func (s *SymbolTable) Define(name string) Symbol {
 
 	s.store[name] = symbol
 	s.numDefinitions++
+	s.names = append(s.names, name)
+	if len(s.blocks) > 0 {
+		s.blocks[len(s.blocks)-1].declared[name] = true
+	}
+	return symbol
+}
+
+// DefineBlock defines name for the rest of the current block only, in a
+// slot of its own.
+func (s *SymbolTable) DefineBlock(name string, constant bool) Symbol {
+	s.shadow(name)
+
+	symbol := s.Define(name)
+	symbol.Const = constant
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineLocal is DefineBlock, except that it gives name a stack slot even in
+// the outermost table, so closures capture it the way they capture any
+// other local.
+func (s *SymbolTable) DefineLocal(name string, constant bool) Symbol {
+	if s.Outer != nil {
+		return s.DefineBlock(name, constant)
+	}
+
+	s.shadow(name)
+
+	symbol := Symbol{Name: name, Index: len(s.locals), Scope: LOCAL_SCOPE, Const: constant}
+	s.store[name] = symbol
+	s.locals = append(s.locals, name)
+	if len(s.blocks) > 0 {
+		s.blocks[len(s.blocks)-1].declared[name] = true
+	}
 	return symbol
 }
 
+// shadow records the symbol name has now, for the current block to bring
+// back when it ends.
+func (s *SymbolTable) shadow(name string) {
+	if len(s.blocks) > 0 {
+		block := s.blocks[len(s.blocks)-1]
+		if _, ok := block.shadowed[name]; !ok {
+			if previous, ok := s.store[name]; ok {
+				block.shadowed[name] = &previous
+			} else {
+				block.shadowed[name] = nil
+			}
+		}
+	}
+}
+
+// DeclaredInBlock reports whether name was declared in the current block,
+// or anywhere in the table outside of blocks.
+func (s *SymbolTable) DeclaredInBlock(name string) bool {
+	if len(s.blocks) == 0 {
+		_, ok := s.store[name]
+		return ok
+	}
+	return s.blocks[len(s.blocks)-1].declared[name]
+}
+
+func (s *SymbolTable) EnterBlock() {
+	s.blocks = append(s.blocks, &blockScope{declared: make(map[string]bool), shadowed: make(map[string]*Symbol)})
+}
+
+func (s *SymbolTable) LeaveBlock() {
+	block := s.blocks[len(s.blocks)-1]
+	s.blocks = s.blocks[:len(s.blocks)-1]
+
+	for name, previous := range block.shadowed {
+		if previous == nil {
+			delete(s.store, name)
+		} else {
+			s.store[name] = *previous
+		}
+	}
+}
+
+// Names returns the name defined in each slot. Names declared in blocks
+// have slots of their own, so a name can be in more than one.
+func (s *SymbolTable) Names() []string {
+	return s.names
+}
+
+// Locals returns the name in each stack slot of the outermost table.
+func (s *SymbolTable) Locals() []string {
+	return s.locals
+}
+
+// Fork returns a table holding the symbols s has now, which defines new
+// ones in the slots after them without changing s.
+func (s *SymbolTable) Fork() *SymbolTable {
+	store := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		store[name] = symbol
+	}
+	return &SymbolTable{Outer: s.Outer, store: store, numDefinitions: s.numDefinitions, names: append([]string(nil), s.names...), locals: append([]string(nil), s.locals...)}
+}
+
 func (s *SymbolTable) ResolveInner(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 	return obj, ok
@@ -78,7 +195,7 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 
 func (s *SymbolTable) DefineUpvalue(original Symbol) Symbol {
 	s.upvalues = append(s.upvalues, original)
-	symbol := Symbol{Name: original.Name, Index: len(s.upvalues) - 1, Scope: UPVALUE_SCOPE}
+	symbol := Symbol{Name: original.Name, Index: len(s.upvalues) - 1, Scope: UPVALUE_SCOPE, Const: original.Const}
 	s.store[original.Name] = symbol
 	return symbol
 }
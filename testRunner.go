@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TestFileSuffix ends the names of the files "jlox test" runs.
+const TestFileSuffix = "_test" + ModuleExtension
+
+func isTestFile(path string) bool {
+	return strings.HasSuffix(path, TestFileSuffix)
+}
+
+// TestResult is the outcome of the test block Name of File, or of the whole
+// file when Name is empty.
+type TestResult struct {
+	File    string
+	Name    string
+	Err     error
+	Elapsed time.Duration
+}
+
+// Description names the test in reports.
+func (r *TestResult) Description() string {
+	if r.Name == "" {
+		return r.File
+	}
+	return r.File + ": " + r.Name
+}
+
+// fileTests returns the names of the test blocks of the file at path.
+func fileTests(path string) ([]string, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	compiler, err := compileSource(source, path)
+	if err != nil {
+		return nil, err
+	}
+	return compiler.Tests, nil
+}
+
+// runTestFile runs the top-level code of the test file at path and then its
+// test block named test, if not empty, on a VM of their own, which prints
+// to output. It records the coverage if coverage is set. The test fails if
+// the file doesn't compile or throws.
+func runTestFile(path, test string, coverage *Coverage, output io.Writer) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	compiler, err := compileTest(source, path, test)
+	if err != nil {
+		return err
+	}
+
+	vm := NewVM(compiler.ByteCode())
+	vm.Stdout = output
+	if coverage != nil {
+		coverage.Instrument(vm, compiler.Files())
+	}
+	if err := vm.run(); err != nil {
+		// the functions of the prelude, like assertThrows, have no lines
+		for idx := vm.FrameCount - 1; idx >= 0; idx-- {
+			frame := vm.Frames[idx]
+			if line := frame.Closure.Function.Line(frame.Ip - 1); line > 0 {
+				return fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// runTestRecovered is runTestFile with a panic of the VM turned into the
+// test's error, so it doesn't take the other tests down with it.
+func runTestRecovered(path, test string, coverage *Coverage, output io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: panic: %v", path, r)
+		}
+	}()
+	return runTestFile(path, test, coverage, output)
+}
+
+// runTests runs results, whose File and Name say what to run, on up to
+// parallel VMs at once. A test's coverage is merged into coverage, if set,
+// in the order of results. What the tests print goes to output.
+func runTests(results []*TestResult, parallel int, coverage *Coverage, output io.Writer) {
+	output = &lockedWriter{w: output}
+	coverages := make([]*Coverage, len(results))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < max(parallel, 1); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				result := results[idx]
+				if coverage != nil {
+					coverages[idx] = NewCoverage()
+					coverages[idx].Exclude = coverage.Exclude
+				}
+				start := time.Now()
+				result.Err = runTestRecovered(result.File, result.Name, coverages[idx], output)
+				result.Elapsed = time.Since(start)
+			}
+		}()
+	}
+	for idx, result := range results {
+		if result.Err == nil {
+			jobs <- idx
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if coverage != nil {
+		for _, other := range coverages {
+			if other != nil {
+				coverage.merge(other)
+			}
+		}
+	}
+}
+
+// lockedWriter lets the tests running at once print to the same writer.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// writeText reports the tests of each file like "go test": one line for the
+// file, then each of its tests that failed.
+func writeText(w io.Writer, results []*TestResult) {
+	for start := 0; start < len(results); {
+		end := start
+		failed := false
+		var elapsed time.Duration
+		for ; end < len(results) && results[end].File == results[start].File; end++ {
+			failed = failed || results[end].Err != nil
+			elapsed += results[end].Elapsed
+		}
+		file := results[start].File
+		elapsed = elapsed.Round(time.Millisecond)
+
+		if !failed {
+			fmt.Fprintf(w, "ok\t%s\t%s\n", file, elapsed)
+			start = end
+			continue
+		}
+		fmt.Fprintf(w, "FAIL\t%s\t%s\n", file, elapsed)
+		for _, result := range results[start:end] {
+			switch {
+			case result.Err == nil:
+			case result.Name == "":
+				fmt.Fprintf(w, "\t%s\n", result.Err)
+			default:
+				fmt.Fprintf(w, "\t--- FAIL: %s (%s)\n\t\t%s\n", result.Name, result.Elapsed.Round(time.Millisecond), result.Err)
+			}
+		}
+		start = end
+	}
+}
+
+// writeTAP reports the tests in version 13 of the Test Anything Protocol,
+// with the error of a failed test in its YAML block.
+func writeTAP(w io.Writer, results []*TestResult) {
+	fmt.Fprintf(w, "TAP version 13\n1..%d\n", len(results))
+	for idx, result := range results {
+		if result.Err == nil {
+			fmt.Fprintf(w, "ok %d - %s\n", idx+1, result.Description())
+			continue
+		}
+		fmt.Fprintf(w, "not ok %d - %s\n  ---\n  message: %s\n  duration_ms: %.3f\n  ...\n",
+			idx+1, result.Description(), strconv.Quote(result.Err.Error()), float64(result.Elapsed)/float64(time.Millisecond))
+	}
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+
+	elapsed time.Duration
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// writeJUnit reports the tests as JUnit XML, with a test suite for each
+// file. A file without test blocks is a single test case named after it.
+func writeJUnit(w io.Writer, results []*TestResult) error {
+	report := &junitTestSuites{Tests: len(results)}
+	var total time.Duration
+	for _, result := range results {
+		var suite *junitTestSuite
+		if n := len(report.Suites); n > 0 && report.Suites[n-1].Name == result.File {
+			suite = report.Suites[n-1]
+		} else {
+			suite = &junitTestSuite{Name: result.File}
+			report.Suites = append(report.Suites, suite)
+		}
+
+		name := result.Name
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(result.File), ModuleExtension)
+		}
+		testCase := &junitTestCase{Name: name, Classname: result.File, Time: junitSeconds(result.Elapsed)}
+		if result.Err != nil {
+			testCase.Failure = &junitFailure{Message: result.Err.Error(), Text: result.Err.Error()}
+			suite.Failures++
+			report.Failures++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+		suite.elapsed += result.Elapsed
+		total += result.Elapsed
+	}
+	for _, suite := range report.Suites {
+		suite.Time = junitSeconds(suite.elapsed)
+	}
+	report.Time = junitSeconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func runTest(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	cover := flags.Bool("cover", false, "record the lines and branches the tests run and summarise them")
+	coverProfile := flags.String("coverprofile", "", "write the coverage as LCOV to `file`; implies -cover")
+	run := flags.String("run", "", "run only the test blocks whose names match `regexp`, and no files without any")
+	parallel := flags.Int("parallel", runtime.GOMAXPROCS(0), "run up to `n` tests at once")
+	format := flags.String("format", "text", "report the tests as text, tap or junit")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: jlox test [flags] [path ...]\n\n"+
+			"Runs the test blocks of the *%s files, each after the top-level code of\n"+
+			"its file on a VM of its own. A file without test blocks is run as a whole.\n", TestFileSuffix)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "tap" && *format != "junit" {
+		fmt.Fprintf(stderr, "unknown format %q, want text, tap or junit\n", *format)
+		return 2
+	}
+	var filter *regexp.Regexp
+	if *run != "" {
+		var err error
+		if filter, err = regexp.Compile(*run); err != nil {
+			fmt.Fprintf(stderr, "invalid -run: %s\n", err)
+			return 2
+		}
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"./..."}
+	}
+	files, err := loxFiles(paths)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	var tests []string
+	for _, file := range files {
+		if isTestFile(file) {
+			tests = append(tests, file)
+		}
+	}
+	if len(tests) == 0 {
+		fmt.Fprintln(stdout, "no test files")
+		return 0
+	}
+
+	// a file that doesn't compile is a failed test of its own
+	var results []*TestResult
+	for _, file := range tests {
+		names, err := fileTests(file)
+		switch {
+		case err != nil:
+			results = append(results, &TestResult{File: file, Err: err})
+		case len(names) == 0 && filter == nil:
+			results = append(results, &TestResult{File: file})
+		}
+		for _, name := range names {
+			if filter == nil || filter.MatchString(name) {
+				results = append(results, &TestResult{File: file, Name: name})
+			}
+		}
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(stdout, "no tests to run")
+		return 0
+	}
+
+	var coverage *Coverage
+	if *cover || *coverProfile != "" {
+		coverage = NewCoverage()
+		coverage.Exclude = isTestFile
+	}
+	// stdout is for the report, so what the scripts print goes to stderr
+	runTests(results, *parallel, coverage, stderr)
+
+	code := 0
+	for _, result := range results {
+		if result.Err != nil {
+			code = 1
+		}
+	}
+	// the coverage summary goes to stderr when stdout is for a program
+	summary := stdout
+	switch *format {
+	case "tap":
+		writeTAP(stdout, results)
+		summary = stderr
+	case "junit":
+		if err := writeJUnit(stdout, results); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		summary = stderr
+	default:
+		writeText(stdout, results)
+	}
+
+	if coverage == nil {
+		return code
+	}
+	coverage.WriteSummary(summary)
+	if *coverProfile != "" {
+		if !coverage.Coverable() {
+			fmt.Fprintf(stderr, "no coverable code, %s has no records\n", *coverProfile)
+		}
+		file, err := os.Create(*coverProfile)
+		if err == nil {
+			coverage.WriteLCOV(file)
+			err = file.Close()
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testBlocksSource = `var count = 0;
+function next() {
+	count = count + 1;
+	return count;
+}
+function works() { }
+
+test "first" {
+	assertEqual(next(), 1);
+}
+test "isolated" {
+	assertEqual(next(), 1);
+}
+test "fails" {
+	assertEqual(next(), 2, "count");
+}
+test "throws" {
+	assertThrows(works);
+}
+`
+
+func TestRunTestBlocks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"count_test.lox": testBlocksSource})
+	path := filepath.Join(dir, "count_test.lox")
+
+	var stdout, stderr bytes.Buffer
+	if code := runTest([]string{"-parallel", "2", dir}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected the tests to fail, got=%d", code)
+	}
+	out := stdout.String()
+	for _, expected := range []string{
+		"FAIL\t" + path + "\t",
+		"\t--- FAIL: fails (",
+		"\t\t" + path + ":15: count: expected 2, got 1\n",
+		"\t--- FAIL: throws (",
+		// assertThrows is in the prelude, so the line is that of its call
+		"\t\t" + path + ":18: no error was thrown: assertion failed\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "first") || strings.Contains(out, "isolated") {
+		t.Errorf("Expected only the failed tests, got=\n%s", out)
+	}
+
+	stdout.Reset()
+	if code := runTest([]string{"-run", "^(first|isolated)$", dir}, &stdout, &stderr); code != 0 || !strings.HasPrefix(stdout.String(), "ok\t"+path+"\t") {
+		t.Errorf("Expected the tests that were run to pass, got=%d\n%s", code, stdout.String())
+	}
+	stdout.Reset()
+	if code := runTest([]string{"-run", "missing", dir}, &stdout, &stderr); code != 0 || stdout.String() != "no tests to run\n" {
+		t.Errorf("Expected no tests to run, got=%d %q", code, stdout.String())
+	}
+	if code := runTest([]string{"-run", "(", dir}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected an invalid -run to be a usage error, got=%d", code)
+	}
+	if code := runTest([]string{"-format", "xml", dir}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected an unknown format to be a usage error, got=%d", code)
+	}
+}
+
+func TestRunTestTAP(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"count_test.lox": testBlocksSource,
+		"whole_test.lox": `assert(true);`,
+	})
+	count, whole := filepath.Join(dir, "count_test.lox"), filepath.Join(dir, "whole_test.lox")
+
+	var stdout, stderr bytes.Buffer
+	runTest([]string{"-format", "tap", dir}, &stdout, &stderr)
+	lines := strings.Split(stdout.String(), "\n")
+	expected := []string{
+		"TAP version 13",
+		"1..5",
+		"ok 1 - " + count + ": first",
+		"ok 2 - " + count + ": isolated",
+		"not ok 3 - " + count + ": fails",
+		"  ---",
+		`  message: "` + count + `:15: count: expected 2, got 1"`,
+	}
+	if len(lines) < len(expected) {
+		t.Fatalf("Expected TAP, got=\n%s", stdout.String())
+	}
+	for idx, line := range expected {
+		if lines[idx] != line {
+			t.Errorf("Expected line %d to be %q, got=%q", idx+1, line, lines[idx])
+		}
+	}
+	if !strings.Contains(stdout.String(), "\n  ...\nnot ok 4 - "+count+": throws\n") ||
+		!strings.HasSuffix(stdout.String(), "\nok 5 - "+whole+"\n") {
+		t.Errorf("Expected the other tests, got=\n%s", stdout.String())
+	}
+}
+
+func TestRunTestJUnit(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"count_test.lox":  testBlocksSource,
+		"syntax_test.lox": `var = 1;`,
+	})
+
+	var stdout, stderr bytes.Buffer
+	if code := runTest([]string{"-format", "junit", dir}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected the tests to fail, got=%d", code)
+	}
+	if !strings.HasPrefix(stdout.String(), xml.Header) {
+		t.Errorf("Expected an XML header, got=\n%s", stdout.String())
+	}
+	var report junitTestSuites
+	if err := xml.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Tests != 5 || report.Failures != 3 || len(report.Suites) != 2 {
+		t.Fatalf("Expected 5 tests, 3 failures and 2 suites, got=%d %d %d", report.Tests, report.Failures, len(report.Suites))
+	}
+
+	suite := report.Suites[0]
+	if suite.Name != filepath.Join(dir, "count_test.lox") || suite.Tests != 4 || suite.Failures != 2 {
+		t.Errorf("Expected the suite of count_test.lox, got=%+v", suite)
+	}
+	if suite.Cases[0].Name != "first" || suite.Cases[0].Failure != nil ||
+		suite.Cases[2].Failure == nil || !strings.HasSuffix(suite.Cases[2].Failure.Message, "count: expected 2, got 1") {
+		t.Errorf("Expected the cases of count_test.lox, got=%+v %+v", suite.Cases[0], suite.Cases[2])
+	}
+	// a file that doesn't compile is a case named after it
+	if cases := report.Suites[1].Cases; len(cases) != 1 || cases[0].Name != "syntax_test" || cases[0].Failure == nil {
+		t.Errorf("Expected syntax_test to fail, got=%+v", report.Suites[1])
+	}
+}
+
+func TestRunTestJUnitStdout(t *testing.T) {
+	// what the scripts print goes to stderr, and nothing to the process's
+	// stdout
+	dir := writeFiles(t, map[string]string{"print_test.lox": `function twice(n) {
+	print("twice ${n}");
+	return n * 2;
+}
+test "prints" {
+	assertEqual(twice(2), 4);
+}
+test "fails" {
+	print("<testsuites>");
+	assertEqual(twice(1), 3);
+}
+`})
+
+	original := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	output := make(chan []byte)
+	go func() {
+		content, _ := io.ReadAll(r)
+		output <- content
+	}()
+	var stdout, stderr bytes.Buffer
+	code := runTest([]string{"-format", "junit", dir}, &stdout, &stderr)
+	w.Close()
+	os.Stdout = original
+	if leaked := <-output; len(leaked) > 0 {
+		t.Errorf("Expected nothing on the process's stdout, got=\n%s", leaked)
+	}
+	report := stdout.Bytes()
+
+	if code != 1 {
+		t.Errorf("Expected the tests to fail, got=%d", code)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(report, &suites); err != nil {
+		t.Fatalf("Expected only the report on stdout, got %s in\n%s", err, report)
+	}
+	if suites.Tests != 2 || suites.Failures != 1 || !bytes.HasPrefix(report, []byte(xml.Header)) {
+		t.Errorf("Expected 2 tests and 1 failure, got=\n%s", report)
+	}
+	if !strings.Contains(stderr.String(), "twice 2\n") {
+		t.Errorf("Expected what the tests print on stderr, got=\n%s", stderr.String())
+	}
+}
+
+func TestRunTestAssertThrows(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"throws_test.lox": `test "throws" {
+	assertThrows(function() { throw "x"; });
+}
+test "does not throw" {
+	assertThrows(function() { return 1; }, "expected a throw");
+}
+`})
+
+	var stdout, stderr bytes.Buffer
+	if code := runTest([]string{"-format", "tap", dir}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected the tests to fail, got=%d", code)
+	}
+	path := filepath.Join(dir, "throws_test.lox")
+	for _, expected := range []string{
+		"ok 1 - " + path + ": throws\n",
+		"not ok 2 - " + path + ": does not throw\n",
+		"expected a throw",
+	} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("Expected %q in\n%s", expected, stdout.String())
+		}
+	}
+	if strings.Contains(stdout.String(), "panic") {
+		t.Errorf("Expected no panic, got\n%s", stdout.String())
+	}
+}
+
+func TestRunTestParallelCover(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"lib.lox": coverageLibrary,
+		"lib_test.lox": `import { classify, both } from "lib";
+test "positive" {
+	assertEqual(classify(1), "positive");
+}
+test "negative" {
+	assertEqual(classify(-1), "negative");
+}
+test "both" {
+	assert(!both(true, false));
+}
+`,
+	})
+
+	// the coverage of tests run at once adds up as if run one at a time
+	var profiles []string
+	for _, parallel := range []string{"1", "3"} {
+		profile := filepath.Join(dir, "lcov"+parallel+".info")
+		var stdout, stderr bytes.Buffer
+		if code := runTest([]string{"-parallel", parallel, "-coverprofile", profile, dir}, &stdout, &stderr); code != 0 {
+			t.Fatalf("Expected the tests to pass, got=%d: %s%s", code, stdout.String(), stderr.String())
+		}
+		content, err := os.ReadFile(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		profiles = append(profiles, string(content))
+	}
+	if profiles[0] != profiles[1] {
+		t.Errorf("Expected the same coverage, got=\n%s\nand\n%s", profiles[0], profiles[1])
+	}
+	for _, expected := range []string{"FNDA:2,classify\n", "FNDA:1,both\n", "BRDA:2,0,0,1\nBRDA:2,0,1,1\n"} {
+		if !strings.Contains(profiles[1], expected) {
+			t.Errorf("Expected %q in\n%s", expected, profiles[1])
+		}
+	}
+}
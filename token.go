@@ -21,6 +21,7 @@ const (
 	SEMICOLON     = ";"
 	SLASH         = "/"
 	STAR          = "*"
+	PERCENT       = "%"
 	QUESTION      = "?"
 	COLON         = ":"
 
@@ -33,11 +34,28 @@ const (
 	GREATER_EQUAL = ">="
 	LESS          = "<"
 	LESS_EQUAL    = "<="
+	PLUS_PLUS     = "++"
+	MINUS_MINUS   = "--"
+	DOT_DOT       = ".."
+	DOT_DOT_LESS  = "..<"
+	ELLIPSIS      = "..."
+	FAT_ARROW     = "=>"
+
+	// Compound assignment tokens.
+	PLUS_EQUAL              = "+="
+	MINUS_EQUAL             = "-="
+	STAR_EQUAL              = "*="
+	SLASH_EQUAL             = "/="
+	PERCENT_EQUAL           = "%="
+	QUESTION_QUESTION_EQUAL = "??="
 
 	// Literals.
 	IDENTIFIER = "IDENTIFIER"
 	STRING     = "STRING"
 	NUMBER     = "NUMBER"
+	// A string segment that is followed by an interpolated "${" expression.
+	// The string continues with another INTERPOLATION or a final STRING.
+	INTERPOLATION = "INTERPOLATION"
 
 	// Keywords.
 	AND      = "AND"
@@ -54,11 +72,30 @@ const (
 	THIS     = "THIS"
 	TRUE     = "TRUE"
 	VAR      = "VAR"
+	LET      = "LET"
+	CONST    = "CONST"
 	WHILE    = "WHILE"
 	BREAK    = "BREAK"
 	CONTINUE = "CONTINUE"
 	STATIC   = "STATIC"
 	EXTEND   = "EXTEND"
+	IN       = "IN"
+	MATCH    = "MATCH"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	YIELD    = "YIELD"
+	SPAWN    = "SPAWN"
+	SELECT   = "SELECT"
+	ASYNC    = "ASYNC"
+	AWAIT    = "AWAIT"
+	IMPORT   = "IMPORT"
+	EXPORT   = "EXPORT"
+
+	// COMMENT holds a "//" comment. The parser sets comments aside, they
+	// only matter to tools such as warning suppression.
+	COMMENT = "COMMENT"
 
 	EOF = "EOF"
 )
@@ -78,17 +115,34 @@ var reserved = map[string]TokenType{
 	"this":     THIS,
 	"true":     TRUE,
 	"var":      VAR,
+	"let":      LET,
+	"const":    CONST,
 	"while":    WHILE,
 	"break":    BREAK,
 	"continue": CONTINUE,
 	"static":   STATIC,
 	"extends":  EXTEND,
+	"in":       IN,
+	"match":    MATCH,
+	"throw":    THROW,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"yield":    YIELD,
+	"spawn":    SPAWN,
+	"select":   SELECT,
+	"async":    ASYNC,
+	"await":    AWAIT,
+	"import":   IMPORT,
+	"export":   EXPORT,
 }
 
 type Token struct {
 	Type   TokenType
 	Lexeme string
 	Line   int
+	// Column is where the token starts on its line, counting bytes from 1
+	Column int
 }
 
 func NewToken(tokenType TokenType, lexeme string, line int) *Token {
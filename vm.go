@@ -2,30 +2,101 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"sort"
 	"strings"
 )
 
 const (
-	// 256
-	STACK_MAX   = 10
+	STACK_MAX   = 2048
 	MAX_GLOBALS = 1 << 16
 	FRAMES_MAX  = 64
 )
 
 type VM struct {
-	Constants  []Object
-	Stack      []Object
-	Sp         int
+	Constants []Object
+	Stack     []Object
+	Sp        int
+	// Globals is the namespace of the running function: the program's
+	// globals, Namespaces[0], or those of the module it was defined in.
+	// A module's namespace is nil until it is first imported.
 	Globals    []Object
+	Namespaces [][]Object
 	Frames     []*CallFrame
 	FrameCount int
-	LineInfo   []LineInfo
+	Handlers   []ExceptionHandler
+	ErrorClass int
+	// Fiber is the running fiber; its stack, frames and handlers are the
+	// ones above
+	Fiber *CompiledFiber
+	Main  *CompiledFiber
+	Loop  *EventLoop
+	// Debugger, when set, is told of each instruction before it runs and
+	// can stop the VM there
+	Debugger *Debugger
+	// Profiler, when set, is told of each instruction before it runs
+	Profiler *Profiler
+	// Coverage, when set, is told of each instruction before it runs
+	Coverage *Coverage
+	// Trace, when set, prints each instruction and the stack as they run
+	Trace bool
+	// Stdout is where print, the trace and stack traces are written
+	Stdout io.Writer
+}
+
+// EventLoop runs async calls. Ready holds the fibers to resume, in order;
+// Waiting maps each fiber parked on an await to its promise; Jobs counts
+// the jobs still running, whose results come in on Completions.
+type EventLoop struct {
+	Ready   []Task
+	Waiting map[*CompiledFiber]*Promise
+	// Rejected holds the promises of async calls that failed, to report
+	// the ones nothing handled once the loop runs out of work
+	Rejected    []*Promise
+	Jobs        int
+	Completions chan func()
+}
+
+// Task resumes Fiber with the result of Promise, or starts it when
+// Promise is nil.
+type Task struct {
+	Fiber   *CompiledFiber
+	Promise *Promise
+}
+
+func NewEventLoop() *EventLoop {
+	return &EventLoop{Waiting: make(map[*CompiledFiber]*Promise), Completions: make(chan func(), 64)}
+}
+
+// ExceptionHandler is pushed by OP_TRY. When an error escapes an instruction
+// the VM drops back to FrameCount frames and Sp stack slots, pushes the
+// exception and continues at Target in the frame that set it up.
+type ExceptionHandler struct {
+	FrameCount int
+	Sp         int
+	Target     int
+}
+
+// ThrownError carries a thrown value that no handler caught out of run.
+type ThrownError struct {
+	Value Object
+}
+
+func (e *ThrownError) Error() string {
+	return "uncaught " + thrownMessage(e.Value)
 }
 
 type CallFrame struct {
 	Closure     *Closure
 	Ip          int
 	BasePointer int // Points to the base of stack
+	Generator   *CompiledGenerator
+	// Loops holds the height of the stack, above BasePointer, at the start
+	// of each loop the function is in, outermost first
+	Loops []int
 }
 
 func (cf *CallFrame) Instructions() Instructions {
@@ -33,36 +104,36 @@ func (cf *CallFrame) Instructions() Instructions {
 }
 
 func NewVM(bytecode *ByteCode) *VM {
-	main := &CompiledFunction{Instructions: bytecode.Code, Name: "main"}
+	main := &CompiledFunction{Instructions: bytecode.Code, Lines: bytecode.Lines, Name: "main", NumLocals: len(bytecode.LocalNames), LocalNames: bytecode.LocalNames}
 	closure := &Closure{Function: main}
 	mainFrame := &CallFrame{Closure: closure, Ip: 0, BasePointer: 0}
 
-	vm := &VM{}
+	vm := &VM{Stdout: os.Stdout}
 	vm.Constants = bytecode.Constants
+	vm.ErrorClass = bytecode.ErrorClass
 	vm.Stack = make([]Object, STACK_MAX)
-	vm.Globals = make([]Object, MAX_GLOBALS)
+	vm.Namespaces = make([][]Object, max(bytecode.Namespaces, 1))
+	vm.Namespaces[0] = make([]Object, MAX_GLOBALS)
+	vm.Globals = vm.Namespaces[0]
 
+	// the program's loop variables take the bottom of the stack
+	vm.Sp = len(bytecode.LocalNames)
 	vm.Frames = make([]*CallFrame, FRAMES_MAX)
 	vm.pushFrame(mainFrame)
+	vm.Fiber = &CompiledFiber{Closure: closure, State: FiberRunning}
+	vm.Main = vm.Fiber
 	return vm
 }
 
+// GetLine returns the line of the instruction at insIndex in the running
+// function.
 func (vm *VM) GetLine(insIndex int) int {
-	accumulatedCount := 0
-
-	for _, info := range vm.LineInfo {
-		accumulatedCount += info.Count
-		if insIndex < accumulatedCount {
-			return info.Line
-		}
-	}
-
-	return -1 // In case of an invalid instruction index
+	return vm.currentFrame().Closure.Function.Line(insIndex)
 }
 
 func (vm *VM) prinStackTrace(err error) {
 
-	fmt.Println(err)
+	fmt.Fprintln(vm.Stdout, err)
 
 	for i := vm.FrameCount - 1; i >= 0; i-- {
 		frame := vm.Frames[i]
@@ -75,10 +146,10 @@ func (vm *VM) prinStackTrace(err error) {
 			return
 		}
 
-		line := vm.GetLine(frame.Ip - 1)
-		fmt.Printf("[Instruction %s], ", definition.Name)
-		fmt.Printf("[Line %d] in ", line)
-		fmt.Printf("%s()\n", function.Name)
+		line := function.Line(frame.Ip - 1)
+		fmt.Fprintf(vm.Stdout, "[Instruction %s], ", definition.Name)
+		fmt.Fprintf(vm.Stdout, "[Line %d] in ", line)
+		fmt.Fprintf(vm.Stdout, "%s()\n", function.Name)
 	}
 }
 
@@ -86,20 +157,137 @@ func (vm *VM) currentFrame() *CallFrame {
 	return vm.Frames[vm.FrameCount-1]
 }
 
+// run executes the program, handing errors to the innermost exception
+// handler until one escapes them all.
 func (vm *VM) run() error {
+	for {
+		err := vm.execute()
+		if err == nil || err == errTerminated {
+			return err
+		}
+		if err = vm.catch(err); err != nil {
+			return err
+		}
+	}
+}
+
+// catch unwinds to the innermost handler with the exception on the stack.
+// An error no handler of a fiber catches ends that fiber and is rethrown
+// where it was resumed. It returns the error when nothing catches it.
+func (vm *VM) catch(err error) error {
+	for len(vm.Handlers) == 0 {
+		fiber := vm.Fiber
+
+		// an async call that fails rejects its promise
+		if fiber.Promise != nil {
+			fiber.Error = vm.exceptionValue(err)
+			fiber.Promise.Settle(fiber.Error, true)
+			vm.loop().Rejected = append(vm.loop().Rejected, fiber.Promise)
+			if err = vm.finishAsync(); err == nil {
+				return nil
+			}
+			continue
+		}
+
+		if fiber.Caller == nil {
+			return err
+		}
+
+		thrown := &ThrownError{Value: vm.exceptionValue(err)}
+		fiber.Error = thrown.Value
+		vm.finishFiber(Null)
+		err = thrown
+	}
+
+	exception := vm.exceptionValue(err)
+
+	handler := vm.Handlers[len(vm.Handlers)-1]
+	vm.Handlers = vm.Handlers[:len(vm.Handlers)-1]
+
+	// a generator whose frame is unwound can't be resumed
+	for idx := handler.FrameCount; idx < vm.FrameCount; idx++ {
+		if generator := vm.Frames[idx].Generator; generator != nil {
+			generator.Running = false
+			generator.Done = true
+		}
+	}
+
+	vm.FrameCount = handler.FrameCount
+	vm.Sp = handler.Sp
+	vm.currentFrame().Ip = handler.Target
+	vm.push(exception)
+
+	return nil
+}
+
+// exceptionValue is the value a handler receives: what was thrown, or an
+// Error instance describing a built-in runtime error.
+func (vm *VM) exceptionValue(err error) Object {
+	if thrown, ok := err.(*ThrownError); ok {
+		return thrown.Value
+	}
+
+	class, ok := vm.errorClass()
+	if !ok {
+		return &StringObject{Value: err.Error()}
+	}
+
+	return &CompiledInstanceObject{Class: class, Fields: errorFields(err.Error(), vm.stackTrace())}
+}
+
+func (vm *VM) errorClass() (*CompiledClassObject, bool) {
+	if vm.ErrorClass < 0 {
+		return nil, false
+	}
+	class, ok := vm.Namespaces[0][vm.ErrorClass].(*CompiledClassObject)
+	return class, ok
+}
+
+// stackTrace lists the functions of the active frames, innermost first.
+func (vm *VM) stackTrace() []string {
+	stack := make([]string, 0, vm.FrameCount)
+	for i := vm.FrameCount - 1; i >= 0; i-- {
+		stack = append(stack, vm.Frames[i].Closure.Function.Name)
+	}
+	return stack
+}
+
+func (vm *VM) execute() error {
 	for {
 		frame := vm.currentFrame()
 		ip := &frame.Ip
 		instructions := frame.Instructions()
+		vm.Globals = vm.Namespaces[frame.Closure.Function.Namespace]
 
 		if *ip > len(instructions)-1 {
-			return nil
+			// the main code is done, but the event loop may still have
+			// async calls to run
+			vm.Fiber.State = FiberDone
+			if err := vm.schedule(); err != nil {
+				return err
+			}
+			if vm.Fiber == vm.Main {
+				return nil
+			}
+			continue
+		}
+
+		if vm.Debugger != nil {
+			if err := vm.Debugger.instruction(vm); err != nil {
+				return err
+			}
+		}
+		if vm.Profiler != nil {
+			vm.Profiler.instruction(vm)
+		}
+		if vm.Coverage != nil {
+			vm.Coverage.instruction(vm)
 		}
 
 		opcode := OpCode(instructions[*ip])
 		definition := definitions[opcode]
 		line := vm.GetLine(*ip)
-		fmt.Printf("Instruction: %s, Sp: %d, Ip: %d, Line: %d", definition.Name, vm.Sp, *ip, line)
+		vm.trace("Instruction: %s, Sp: %d, Ip: %d, Line: %d", definition.Name, vm.Sp, *ip, line)
 		*ip += 1
 
 		switch opcode {
@@ -112,7 +300,92 @@ func (vm *VM) run() error {
 			}
 			frame := vm.popFrame()
 			vm.Sp = frame.BasePointer - 1
+
+			// a finished generator answers next() with nil
+			if frame.Generator != nil {
+				frame.Generator.Running = false
+				frame.Generator.Done = true
+				returnValue = Null
+			}
+
+			// the function a fiber was created from returned
+			if vm.FrameCount == 0 && vm.Fiber.Promise != nil {
+				vm.Fiber.Promise.Settle(returnValue, false)
+				if err := vm.finishAsync(); err != nil {
+					return err
+				}
+				continue
+			}
+			if vm.FrameCount == 0 {
+				vm.finishFiber(returnValue)
+				continue
+			}
 			vm.push(returnValue)
+
+			// handlers set up by the returning frame no longer apply
+			for len(vm.Handlers) > 0 && vm.Handlers[len(vm.Handlers)-1].FrameCount > vm.FrameCount {
+				vm.Handlers = vm.Handlers[:len(vm.Handlers)-1]
+			}
+		case OP_TRY:
+			offset := ReadUint16(instructions[*ip:])
+			*ip += 2
+			vm.Handlers = append(vm.Handlers, ExceptionHandler{FrameCount: vm.FrameCount, Sp: vm.Sp, Target: *ip + int(offset)})
+		case OP_END_TRY:
+			vm.Handlers = vm.Handlers[:len(vm.Handlers)-1]
+		case OP_YIELD:
+			if err := vm.suspendGenerator(vm.pop()); err != nil {
+				return err
+			}
+		case OP_AWAIT:
+			if err := vm.await(vm.pop()); err != nil {
+				return err
+			}
+		case OP_SPAWN:
+			numArgs := int(ReadUint8(instructions[*ip:]))
+			*ip += 1
+
+			thread, err := vm.spawn(numArgs)
+			if err != nil {
+				return err
+			}
+			vm.push(thread)
+		case OP_IMPORT:
+			module := vm.Constants[ReadUint16(instructions[*ip:])].(*ModuleObject)
+			*ip += 2
+
+			if vm.Namespaces[module.Namespace] != nil {
+				vm.push(module)
+				continue
+			}
+
+			// the body runs once, in globals of its own that share the
+			// program's Error class, and returns the module
+			globals := make([]Object, module.Size)
+			if vm.ErrorClass >= 0 && vm.ErrorClass < module.Size {
+				globals[vm.ErrorClass] = vm.Namespaces[0][vm.ErrorClass]
+			}
+			vm.Namespaces[module.Namespace] = globals
+
+			body := &Closure{Function: module.Body}
+			vm.push(body)
+			if err := vm.callFunction(body, 0, nil); err != nil {
+				return err
+			}
+		case OP_SELECT:
+			shape := vm.Constants[ReadUint16(instructions[*ip:])].(*SelectShape)
+			*ip += 2
+
+			if err := vm.selectCase(shape); err != nil {
+				return err
+			}
+		case OP_THROW:
+			value := vm.pop()
+			if instance, ok := value.(*CompiledInstanceObject); ok {
+				if class, ok := vm.errorClass(); ok && instance.Class.IsSubclassOf(class) {
+					fillStack(instance.Fields, vm.stackTrace())
+				}
+			}
+			return &ThrownError{Value: value}
 		case OP_CLOSURE:
 			index := ReadUint16(instructions[*ip:])
 			*ip += 2
@@ -121,7 +394,7 @@ func (vm *VM) run() error {
 			object := vm.Constants[index]
 			function, ok := object.(*CompiledFunction)
 			if !ok {
-				return fmt.Errorf("not a function +%v", object)
+				return fmt.Errorf("not a function, got %s", object.Type())
 			}
 
 			upvalues := make([]Object, nUpValues)
@@ -142,11 +415,22 @@ func (vm *VM) run() error {
 			*ip += 2
 		case OP_CALL:
 			numArgs := ReadUint8(instructions[*ip:])
-			err := vm.call(int(numArgs))
+			err := vm.call(int(numArgs), nil)
 			if err != nil {
 				return err
 			}
 			*ip += 1
+		case OP_CALL_EXTENDED:
+			shape := vm.Constants[ReadUint16(instructions[*ip:])].(*CallShape)
+			*ip += 2
+
+			numArgs, err := vm.spreadArguments(shape)
+			if err != nil {
+				return err
+			}
+			if err := vm.call(numArgs, shape.Names); err != nil {
+				return err
+			}
 		case OP_SET_PROPERTY:
 			index := ReadUint8(instructions[*ip:])
 			*ip += 1
@@ -157,14 +441,14 @@ func (vm *VM) run() error {
 			// Ensure it is a string
 			str, ok := name.(*StringObject)
 			if !ok {
-				return fmt.Errorf("property is not a string +%v", name)
+				return fmt.Errorf("property is not a string, got %s", name.Type())
 			}
 
 			object := vm.peek(1)
 
 			instance, ok := object.(*CompiledInstanceObject)
 			if !ok {
-				return fmt.Errorf("only instance have properties, got +%v", object)
+				return fmt.Errorf("only instance have properties, got %s", object.Type())
 			}
 
 			instance.Fields[str.Value] = vm.peek(0)
@@ -181,17 +465,55 @@ func (vm *VM) run() error {
 
 			str, ok := name.(*StringObject)
 			if !ok {
-				fmt.Println("called1")
-				return fmt.Errorf("property is not a string +%v", name)
+				vm.trace("called1\n")
+				return fmt.Errorf("property is not a string, got %s", name.Type())
+			}
+
+			if generator, ok := object.(*CompiledGenerator); ok && str.Value == NextMethod {
+				vm.pop()
+				vm.push(&GeneratorNext{Generator: generator})
+				continue
+			}
+
+			if module, ok := object.(*ModuleObject); ok {
+				index, ok := module.Exports[str.Value]
+				if !ok {
+					return fmt.Errorf("module %s has no export %s", module.Name, str.Value)
+				}
+				vm.pop()
+				vm.push(vm.Namespaces[module.Namespace][index])
+				continue
+			}
+
+			if fiber, ok := object.(*CompiledFiber); ok {
+				value, err := fiberProperty(fiber, str.Value)
+				if err != nil {
+					return err
+				}
+				vm.pop()
+				vm.push(value)
+				continue
+			}
+
+			if method, ok := syncMethod(object, str.Value); ok {
+				vm.pop()
+				vm.push(method)
+				continue
+			}
+
+			if builtin, ok := object.(*Builtin); ok && builtin == GetBuiltinByName(BuiltinFuncNameFiber) && str.Value == FiberYieldMethod {
+				vm.pop()
+				vm.push(&FiberMethod{Name: FiberYieldMethod})
+				continue
 			}
 
 			instance, ok := object.(*CompiledInstanceObject)
 			if !ok {
-				fmt.Println("called2")
-				return fmt.Errorf("only instance have properties, got +%v", object)
+				vm.trace("called2\n")
+				return fmt.Errorf("only instance have properties, got %s", object.Type())
 			}
 
-			fmt.Printf("%s value , %v Field", str.Value, instance.Fields[str.Value])
+			vm.trace("%s value , %v Field", str.Value, instance.Fields[str.Value])
 
 			if value, ok := instance.Fields[str.Value]; ok {
 				vm.pop()
@@ -237,7 +559,7 @@ func (vm *VM) run() error {
 
 			str, ok := name.(*StringObject)
 			if !ok {
-				return fmt.Errorf("function name is not a string +%v", name)
+				return fmt.Errorf("function name is not a string, got %s", name.Type())
 			}
 
 			method, ok := object.(*Closure)
@@ -254,6 +576,40 @@ func (vm *VM) run() error {
 
 			// pop compiled function
 			vm.pop()
+		case OP_INHERIT:
+			super, ok := vm.pop().(*CompiledClassObject)
+			if !ok {
+				return fmt.Errorf("Superclass must be a class.")
+			}
+			class, ok := vm.pop().(*CompiledClassObject)
+			if !ok {
+				return fmt.Errorf("not a Class: %+v", class)
+			}
+
+			class.SuperClass = super
+			for name, method := range super.Methods {
+				class.Methods[name] = method
+			}
+		case OP_GET_SUPER:
+			index := ReadUint16(instructions[*ip:])
+			*ip += 2
+			name := vm.Constants[index].(*StringObject).Value
+
+			class, ok := vm.pop().(*CompiledClassObject)
+			if !ok || class.SuperClass == nil {
+				return fmt.Errorf("Can't use 'super' in a class with no superclass.")
+			}
+			instance, ok := vm.pop().(*CompiledInstanceObject)
+			if !ok {
+				return fmt.Errorf("'super' needs an instance")
+			}
+			method, ok := class.SuperClass.Methods[name]
+			if !ok {
+				return fmt.Errorf("Undefined property %s", name)
+			}
+			if err := vm.push(&CompiledBoundMethod{Receiver: instance, Method: method}); err != nil {
+				return err
+			}
 		case OP_GET_LOCAL:
 			index := ReadUint8(instructions[*ip:])
 			err := vm.push(vm.Stack[frame.BasePointer+int(index)])
@@ -264,12 +620,18 @@ func (vm *VM) run() error {
 		case OP_SET_LOCAL:
 			index := ReadUint8(instructions[*ip:])
 			*ip += 1
-			value := vm.pop()
-			vm.Stack[frame.BasePointer+int(index)] = value
+			// assignment is an expression, the value stays on the stack
+			vm.Stack[frame.BasePointer+int(index)] = vm.peek(0)
 		case OP_SET_GLOBAL:
 			index := ReadUint16(instructions[*ip:])
-			vm.Globals[index] = vm.pop()
+			vm.Globals[index] = vm.peek(0)
 			*ip += 2
+		case OP_SET_UPVALUE:
+			index := ReadUint8(instructions[*ip:])
+			*ip += 1
+
+			closure := vm.currentFrame().Closure
+			closure.UpValues[index] = vm.peek(0)
 		case OP_DEFINE_GLOBAL:
 			index := ReadUint16(instructions[*ip:])
 			vm.Globals[index] = vm.pop()
@@ -313,6 +675,11 @@ func (vm *VM) run() error {
 			if err != nil {
 				return err
 			}
+		case OP_MODULO:
+			err := vm.executeBinary("%")
+			if err != nil {
+				return err
+			}
 		case OP_GREATER:
 			err := vm.executeBinary(">")
 			if err != nil {
@@ -352,105 +719,1096 @@ func (vm *VM) run() error {
 			offset := ReadUint16(instructions[*ip:])
 			*ip += 2
 			if !vm.isTruthy(vm.peek(0)) {
-				fmt.Printf("Jumping by offset %d because top of stack is falsey\n", offset)
+				vm.trace("Jumping by offset %d because top of stack is falsey\n", offset)
+				*ip += int(offset)
+			}
+		case OP_JUMP_IF_NOT_NIL:
+			offset := ReadUint16(instructions[*ip:])
+			*ip += 2
+			if vm.peek(0).Type() != NillObj {
 				*ip += int(offset)
-				vm.pop()
 			}
 		case OP_JUMP:
 			offset := ReadUint16(instructions[*ip:])
-			fmt.Printf("Unconditional jump by offset %d\n", offset)
+			vm.trace("Unconditional jump by offset %d\n", offset)
+			*ip += 2
 			*ip += int(offset)
+		case OP_LOOP_ENTER:
+			index := int(ReadUint8(instructions[*ip:]))
+			*ip += 1
+
+			frame.Loops = append(frame.Loops[:index], vm.Sp-frame.BasePointer)
+		case OP_UNWIND:
+			index := int(ReadUint8(instructions[*ip:]))
+			*ip += 1
+
+			vm.Sp = frame.BasePointer + frame.Loops[index]
 		case OP_LOOP:
 			offset := ReadUint16(instructions[*ip:])
-			fmt.Printf("Looping back by offset %d\n", offset)
+			vm.trace("Looping back by offset %d\n", offset)
 			*ip += 1
 			*ip -= int(offset)
-		}
+		case OP_DUP:
+			count := int(ReadUint8(instructions[*ip:]))
+			*ip += 1
+			for i := 0; i < count; i++ {
+				if err := vm.push(vm.peek(count - 1)); err != nil {
+					return err
+				}
+			}
+		case OP_BURY:
+			depth := int(ReadUint8(instructions[*ip:]))
+			*ip += 1
+			value := vm.peek(0)
+			copy(vm.Stack[vm.Sp-depth:vm.Sp], vm.Stack[vm.Sp-depth-1:vm.Sp-1])
+			vm.Stack[vm.Sp-depth-1] = value
+		case OP_ARRAY:
+			count := int(ReadUint16(instructions[*ip:]))
+			*ip += 2
 
-		fmt.Printf(", NewSp: %d, BP: %d, Stack: %s\n", vm.Sp, frame.BasePointer, vm.printStack())
-	}
-}
+			elements := make([]Object, count)
+			copy(elements, vm.Stack[vm.Sp-count:vm.Sp])
+			vm.Sp -= count
 
-func (vm *VM) call(numArgs int) error {
-	value := vm.Stack[vm.Sp-1-numArgs]
+			if err := vm.push(&Array{Elements: elements}); err != nil {
+				return err
+			}
+		case OP_HASH:
+			count := int(ReadUint16(instructions[*ip:]))
+			*ip += 2
 
-	switch callee := value.(type) {
-	case *CompiledBoundMethod:
-		return vm.callBoundMethod(callee, numArgs)
-	case *Closure:
-		return vm.callFunction(callee, numArgs)
-	case *Builtin:
-		return vm.callBuiltin(callee, numArgs)
-	case *CompiledClassObject:
-		return vm.callClass(callee, numArgs)
-	default:
-		return fmt.Errorf("%s is not a function", value.Inspect())
-	}
-}
+			hash, err := vm.buildHash(vm.Sp-2*count, vm.Sp)
+			if err != nil {
+				return err
+			}
+			vm.Sp -= 2 * count
 
-func (vm *VM) pushFrame(frame *CallFrame) {
-	vm.Frames[vm.FrameCount] = frame
-	vm.FrameCount++
-}
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+		case OP_INDEX:
+			index := vm.pop()
+			left := vm.pop()
 
-func (vm *VM) popFrame() *CallFrame {
-	vm.FrameCount--
-	return vm.Frames[vm.FrameCount]
-}
+			if err := vm.executeIndexExpression(left, index); err != nil {
+				return err
+			}
+		case OP_TO_STRING:
+			if err := vm.toString(); err != nil {
+				return err
+			}
+		case OP_INTERPOLATE:
+			count := int(ReadUint16(instructions[*ip:]))
+			*ip += 2
 
-func (vm *VM) bindMethod(instance *CompiledInstanceObject, methodName string) error {
-	if method, ok := instance.Class.Methods[methodName]; !ok {
-		return fmt.Errorf("Undefined property %s.", methodName)
-	} else {
-		bound := &CompiledBoundMethod{Receiver: instance, Method: method}
+			var str strings.Builder
+			for _, part := range vm.Stack[vm.Sp-count : vm.Sp] {
+				str.WriteString(part.Inspect())
+			}
+			vm.Sp -= count
 
-		// pop the instance
-		vm.pop()
+			if err := vm.push(&StringObject{Value: str.String()}); err != nil {
+				return err
+			}
+		case OP_SET_INDEX:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
 
-		// push bound method on top of stack
-		vm.push(bound)
-		return nil
-	}
-}
+			if err := vm.executeSetIndex(left, index, value); err != nil {
+				return err
+			}
+		case OP_ITERATOR:
+			keyed := ReadUint8(instructions[*ip:]) == 1
+			*ip += 1
 
-func (vm *VM) callClass(class *CompiledClassObject, numArgs int) error {
-	// check if the inti methods match
-	instance := &CompiledInstanceObject{Class: class, Fields: make(map[string]Object)}
+			iterator, err := vm.newIterator(vm.pop(), keyed)
+			if err != nil {
+				return err
+			}
 
-	// Check if the class has an "init" method (constructor)
-	if initMethod, ok := class.Methods["init"]; ok {
-		if initMethod.Function.NumParameters != numArgs {
-			return fmt.Errorf("wrong number of arguments: want=%d, got=%d", initMethod.Function.NumParameters, numArgs)
-		}
+			if err := vm.push(iterator); err != nil {
+				return err
+			}
+		case OP_ITER_NEXT:
+			start := *ip - 1
+			offset := int(ReadUint16(instructions[*ip:]))
+			*ip += 2
 
-		vm.Stack[vm.Sp-1-numArgs] = instance
-		err := vm.callBoundMethod(&CompiledBoundMethod{Receiver: instance, Method: initMethod}, numArgs)
-		if err != nil {
-			return err
-		}
-		vm.Stack[vm.Sp-1] = instance
-		// account for this need to pop it
-		return nil
-	}
+			done, err := vm.iterNext(start)
+			if err != nil {
+				return err
+			}
+			if done {
+				*ip += offset
+			}
+		case OP_RANGE:
+			inclusive := ReadUint8(instructions[*ip:]) == 1
+			*ip += 1
 
-	vm.Stack[vm.Sp-1-numArgs] = instance
-	// If there is no constructor, just adjust the stack pointer
-	vm.Sp -= numArgs
-	return nil
-}
+			step := vm.pop()
+			end := vm.pop()
+			start := vm.pop()
 
-func (vm *VM) NewFrame(closure *Closure, bp int) *CallFrame {
-	return &CallFrame{
-		Closure:     closure,
-		Ip:          0,
+			rng, err := NewRange(start, end, step, inclusive)
+			if err != nil {
+				return err
+			}
+
+			if err := vm.push(rng); err != nil {
+				return err
+			}
+		case OP_IN:
+			container := vm.pop()
+			item := vm.pop()
+
+			found, ok := containsObject(container, item)
+			if !ok {
+				return fmt.Errorf("unsupported types for in: %s %s", item.Type(), container.Type())
+			}
+
+			if err := vm.push(&BooleanObject{Value: found}); err != nil {
+				return err
+			}
+		case OP_MATCH_EQUAL:
+			right := vm.pop()
+			left := vm.pop()
+
+			if err := vm.push(&BooleanObject{Value: objectsEqual(left, right)}); err != nil {
+				return err
+			}
+		case OP_MATCH_ARRAY:
+			length := int(ReadUint16(instructions[*ip:]))
+			*ip += 2
+
+			array, ok := vm.pop().(*Array)
+			if err := vm.push(&BooleanObject{Value: ok && len(array.Elements) == length}); err != nil {
+				return err
+			}
+		case OP_MATCH_KEY:
+			key := vm.pop()
+			value := vm.pop()
+
+			if err := vm.push(&BooleanObject{Value: matchKey(value, key)}); err != nil {
+				return err
+			}
+		case OP_MATCH_CLASS:
+			fields := int(ReadUint8(instructions[*ip:]))
+			*ip += 1
+
+			class, ok := vm.pop().(*CompiledClassObject)
+			if !ok {
+				return fmt.Errorf("%s in class pattern", notClassError)
+			}
+			value := vm.pop()
+
+			if err := vm.push(&BooleanObject{Value: matchClass(value, class, fields)}); err != nil {
+				return err
+			}
+		case OP_MATCH_FIELD:
+			field := int(ReadUint8(instructions[*ip:]))
+			*ip += 1
+
+			instance := vm.pop().(*CompiledInstanceObject)
+			name := instance.Class.Methods["init"].Function.ParameterNames[field]
+
+			value, ok := instance.Fields[name]
+			if !ok {
+				value = Null
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+		case OP_NO_MATCH:
+			return fmt.Errorf("%s: %s", noMatchError, vm.peek(0).Inspect())
+		case OP_JUMP_TABLE:
+			index := ReadUint16(instructions[*ip:])
+			*ip += 2
+
+			table := vm.Constants[index].(*JumpTable)
+			if number, ok := vm.peek(0).(*FloatObject); ok && number.Value == math.Trunc(number.Value) {
+				slot := int(number.Value) - table.Min
+				if slot >= 0 && slot < len(table.Targets) && table.Targets[slot] >= 0 {
+					*ip = table.Targets[slot]
+				}
+			}
+		case OP_DESTRUCTURE_ARRAY:
+			required := int(ReadUint16(instructions[*ip:]))
+			max := int(ReadUint16(instructions[*ip+2:]))
+			rest := ReadUint8(instructions[*ip+4:]) == 1
+			*ip += 5
+
+			array, ok := vm.peek(0).(*Array)
+			if !ok {
+				return fmt.Errorf("%s: expected an array, got %s", destructureError, vm.peek(0).Type())
+			}
+			if msg := arrayShapeError(len(array.Elements), required, max, rest); msg != "" {
+				return fmt.Errorf("%s: %s", destructureError, msg)
+			}
+		case OP_DESTRUCTURE_HASH:
+			if _, _, ok := destructureKey(vm.peek(0), ""); !ok {
+				return fmt.Errorf("%s: expected a hash, got %s", destructureError, vm.peek(0).Type())
+			}
+		case OP_DESTRUCTURE_INDEX:
+			index := int(ReadUint16(instructions[*ip:]))
+			offset := ReadUint16(instructions[*ip+2:])
+			*ip += 4
+
+			// a missing element falls through to its default
+			array := vm.peek(0).(*Array)
+			if index < len(array.Elements) {
+				if err := vm.push(array.Elements[index]); err != nil {
+					return err
+				}
+				*ip += int(offset)
+			}
+		case OP_DESTRUCTURE_KEY:
+			key := vm.Constants[ReadUint16(instructions[*ip:])].(*StringObject)
+			offset := ReadUint16(instructions[*ip+2:])
+			*ip += 4
+
+			if value, found, _ := destructureKey(vm.peek(0), key.Value); found {
+				if err := vm.push(value); err != nil {
+					return err
+				}
+				*ip += int(offset)
+			}
+		case OP_DESTRUCTURE_MISSING:
+			key := vm.Constants[ReadUint16(instructions[*ip:])].(*StringObject)
+			return fmt.Errorf("%s: missing key %s", destructureError, key.Value)
+		case OP_SLICE:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+
+			if err := vm.executeSlice(left, start, end); err != nil {
+				return err
+			}
+		case OP_SET_SLICE:
+			value := vm.pop()
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+
+			if err := vm.executeSetSlice(left, start, end, value); err != nil {
+				return err
+			}
+		}
+
+		if vm.tracing() {
+			fmt.Fprintf(vm.Stdout, ", NewSp: %d, BP: %d, Stack: %s\n", vm.Sp, frame.BasePointer, vm.printStack())
+		}
+	}
+}
+
+// call calls the value below the top numArgs values on the stack, the last
+// len(names) of which are named arguments.
+func (vm *VM) call(numArgs int, names []string) error {
+	value := vm.Stack[vm.Sp-1-numArgs]
+
+	switch callee := value.(type) {
+	case *CompiledBoundMethod:
+		return vm.callBoundMethod(callee, numArgs, names)
+	case *Closure:
+		return vm.callFunction(callee, numArgs, names)
+	case *GeneratorNext:
+		if len(names) > 0 {
+			return fmt.Errorf("%s takes no named arguments", NextMethod)
+		}
+		return vm.resumeGenerator(callee.Generator, numArgs)
+	case *FiberMethod:
+		if len(names) > 0 {
+			return fmt.Errorf("%s takes no named arguments", callee.Name)
+		}
+		if callee.Fiber == nil {
+			return vm.yieldFiber(numArgs)
+		}
+		return vm.resumeFiber(callee.Fiber, numArgs)
+	case *SyncMethod:
+		if len(names) > 0 {
+			return fmt.Errorf("%s takes no named arguments", callee.Name)
+		}
+		return vm.callSyncMethod(callee, numArgs)
+	case *Builtin:
+		if len(names) > 0 {
+			return fmt.Errorf("builtins take no named arguments")
+		}
+		return vm.callBuiltin(callee, numArgs)
+	case *CompiledClassObject:
+		return vm.callClass(callee, numArgs, names)
+	default:
+		return fmt.Errorf("%s is not a function", value.Inspect())
+	}
+}
+
+// spreadArguments expands the spread arguments of a call in place, leaving
+// the positional arguments followed by the named ones, and returns how many
+// there are.
+func (vm *VM) spreadArguments(shape *CallShape) (int, error) {
+	count := len(shape.Spread) + len(shape.Names)
+	start := vm.Sp - count
+
+	args, err := spreadArguments(vm.Stack[start:vm.Sp], shape.Spread)
+	if err != nil {
+		return 0, err
+	}
+	if start+len(args) > STACK_MAX {
+		return 0, fmt.Errorf("stack overflow")
+	}
+
+	copy(vm.Stack[start:], args)
+	vm.Sp = start + len(args)
+	return len(args), nil
+}
+
+// bindArguments rearranges the top numArgs values on the stack into the
+// parameters of function, and returns how many slots they now take.
+func (vm *VM) bindArguments(function *CompiledFunction, numArgs int, names []string) (int, error) {
+	if len(names) == 0 && numArgs == function.NumParameters && !function.Variadic {
+		return numArgs, nil
+	}
+
+	start := vm.Sp - numArgs
+	args := make([]Object, numArgs)
+	copy(args, vm.Stack[start:vm.Sp])
+
+	bound, err := bindArguments(function.ParameterNames, function.NumRequired, function.Variadic, args, names)
+	if err != nil {
+		return 0, err
+	}
+	if start+len(bound) > STACK_MAX {
+		return 0, fmt.Errorf("stack overflow")
+	}
+
+	copy(vm.Stack[start:], bound)
+	vm.Sp = start + len(bound)
+	return len(bound), nil
+}
+
+func (vm *VM) pushFrame(frame *CallFrame) {
+	vm.Frames[vm.FrameCount] = frame
+	vm.FrameCount++
+}
+
+func (vm *VM) popFrame() *CallFrame {
+	vm.FrameCount--
+	return vm.Frames[vm.FrameCount]
+}
+
+func (vm *VM) bindMethod(instance *CompiledInstanceObject, methodName string) error {
+	if method, ok := instance.Class.Methods[methodName]; !ok {
+		return fmt.Errorf("Undefined property %s.", methodName)
+	} else {
+		bound := &CompiledBoundMethod{Receiver: instance, Method: method}
+
+		// pop the instance
+		vm.pop()
+
+		// push bound method on top of stack
+		vm.push(bound)
+		return nil
+	}
+}
+
+func (vm *VM) callClass(class *CompiledClassObject, numArgs int, names []string) error {
+	instance := &CompiledInstanceObject{Class: class, Fields: make(map[string]Object)}
+
+	// Check if the class has an "init" method (constructor)
+	if initMethod, ok := class.Methods["init"]; ok {
+		// init returns the receiver, which replaces the class on the stack
+		vm.Stack[vm.Sp-1-numArgs] = instance
+		return vm.callBoundMethod(&CompiledBoundMethod{Receiver: instance, Method: initMethod}, numArgs, names)
+	}
+
+	vm.Stack[vm.Sp-1-numArgs] = instance
+	// If there is no constructor, just adjust the stack pointer
+	vm.Sp -= numArgs
+	return nil
+}
+
+// resumeGenerator is a call to the generator's next method, with the
+// callee and numArgs arguments on the stack. The generator's frame is
+// pushed back with its stack slots restored above the callee's slot, where
+// the value it yields or returns ends up. The argument of next() becomes
+// the value of the yield the generator is suspended at.
+func (vm *VM) resumeGenerator(generator *CompiledGenerator, numArgs int) error {
+	if numArgs > 1 {
+		return fmt.Errorf("wrong number of arguments: want=0 to 1, got=%d", numArgs)
+	}
+
+	var value Object = Null
+	if numArgs == 1 {
+		value = vm.pop()
+	}
+
+	if generator.Running {
+		return fmt.Errorf("generator %s is already running", generator.Closure.Function.Name)
+	}
+	if generator.Done {
+		vm.Stack[vm.Sp-1] = Null
+		return nil
+	}
+
+	bp := vm.Sp
+	if bp+len(generator.Stack)+1 > STACK_MAX {
+		return fmt.Errorf("stack overflow")
+	}
+	copy(vm.Stack[bp:], generator.Stack)
+	vm.Sp = bp + len(generator.Stack)
+	if generator.Started {
+		vm.push(value)
+	}
+
+	generator.Started = true
+	generator.Running = true
+	vm.pushFrame(&CallFrame{Closure: generator.Closure, Ip: generator.Ip, BasePointer: bp, Generator: generator, Loops: generator.Loops})
+
+	for _, handler := range generator.Handlers {
+		vm.Handlers = append(vm.Handlers, ExceptionHandler{FrameCount: vm.FrameCount, Sp: bp + handler.Sp, Target: handler.Target})
+	}
+	generator.Handlers = nil
+
+	return nil
+}
+
+func fiberProperty(fiber *CompiledFiber, name string) (Object, error) {
+	switch name {
+	case FiberResumeMethod:
+		return &FiberMethod{Fiber: fiber, Name: FiberResumeMethod}, nil
+	case FiberIsDone:
+		return &BooleanObject{Value: fiber.State == FiberDone}, nil
+	case FiberError:
+		if fiber.Error == nil {
+			return Null, nil
+		}
+		return fiber.Error, nil
+	default:
+		return nil, fmt.Errorf("undefined property %s on %s", name, fiber.Inspect())
+	}
+}
+
+// switchFiber parks the running fiber's stack, frames and handlers and
+// makes the ones of to current.
+func (vm *VM) switchFiber(to *CompiledFiber) {
+	from := vm.Fiber
+	from.Stack, from.Sp, from.Frames, from.FrameCount, from.Handlers = vm.Stack, vm.Sp, vm.Frames, vm.FrameCount, vm.Handlers
+	vm.Stack, vm.Sp, vm.Frames, vm.FrameCount, vm.Handlers = to.Stack, to.Sp, to.Frames, to.FrameCount, to.Handlers
+	vm.Fiber = to
+}
+
+// resumeFiber runs fiber until it yields or finishes. The value passed in
+// becomes the argument of its function on the first resume, and the result
+// of Fiber.yield after that.
+func (vm *VM) resumeFiber(fiber *CompiledFiber, numArgs int) error {
+	if numArgs > 1 {
+		return fmt.Errorf("wrong number of arguments: want=0 to 1, got=%d", numArgs)
+	}
+
+	var value Object = Null
+	if numArgs == 1 {
+		value = vm.pop()
+	}
+
+	switch fiber.State {
+	case FiberRunning:
+		return fmt.Errorf("fiber %s is already running", fiber.Closure.Function.Name)
+	case FiberDone:
+		return fmt.Errorf("cannot resume finished fiber %s", fiber.Closure.Function.Name)
+	}
+
+	// the callee slot; the fiber's answer is pushed in its place
+	vm.pop()
+
+	fiber.Caller = vm.Fiber
+	vm.switchFiber(fiber)
+
+	if fiber.State == FiberSuspended {
+		fiber.State = FiberRunning
+		return vm.push(value)
+	}
+
+	fiber.State = FiberRunning
+	vm.push(fiber.Closure)
+	args := 0
+	if fiber.Closure.Function.NumParameters > 0 {
+		vm.push(value)
+		args = 1
+	}
+	return vm.callFunction(fiber.Closure, args, nil)
+}
+
+// yieldFiber suspends the running fiber and hands value to the one that
+// resumed it.
+func (vm *VM) yieldFiber(numArgs int) error {
+	if numArgs > 1 {
+		return fmt.Errorf("wrong number of arguments: want=0 to 1, got=%d", numArgs)
+	}
+
+	fiber := vm.Fiber
+	if fiber.Caller == nil {
+		return fmt.Errorf("can't yield from the main fiber")
+	}
+
+	var value Object = Null
+	if numArgs == 1 {
+		value = vm.pop()
+	}
+	vm.pop()
+
+	caller := fiber.Caller
+	fiber.Caller = nil
+	fiber.State = FiberSuspended
+	vm.switchFiber(caller)
+
+	return vm.push(value)
+}
+
+// finishFiber ends the running fiber and returns value to the one that
+// resumed it.
+func (vm *VM) finishFiber(value Object) {
+	fiber := vm.Fiber
+
+	for idx := 0; idx < vm.FrameCount; idx++ {
+		if generator := vm.Frames[idx].Generator; generator != nil {
+			generator.Running = false
+			generator.Done = true
+		}
+	}
+	vm.FrameCount = 0
+	vm.Sp = 0
+	vm.Handlers = nil
+
+	caller := fiber.Caller
+	fiber.Caller = nil
+	fiber.State = FiberDone
+	vm.switchFiber(caller)
+
+	vm.push(value)
+}
+
+func (vm *VM) loop() *EventLoop {
+	if vm.Loop == nil {
+		vm.Loop = NewEventLoop()
+	}
+	return vm.Loop
+}
+
+// callAsync sets up the call of an async function on a fiber of its own,
+// queues it on the event loop and leaves a promise of its result in the
+// callee's place.
+func (vm *VM) callAsync(callee *Closure, numArgs int) error {
+	bp := vm.Sp - numArgs
+
+	fiber := NewFiber(callee)
+	fiber.Promise = &Promise{}
+	copy(fiber.Stack, vm.Stack[bp-1:vm.Sp])
+	fiber.Frames[0] = &CallFrame{Closure: callee, BasePointer: 1}
+	fiber.FrameCount = 1
+	fiber.Sp = 1 + callee.Function.NumLocals
+	fiber.State = FiberSuspended
+
+	loop := vm.loop()
+	loop.Ready = append(loop.Ready, Task{Fiber: fiber})
+
+	vm.Sp = bp - 1
+	return vm.push(fiber.Promise)
+}
+
+// await pushes the result of a settled promise, or any value that isn't a
+// promise. Otherwise it parks the running fiber until the promise settles
+// and lets the event loop run something else.
+func (vm *VM) await(value Object) error {
+	promise, ok := value.(*Promise)
+	if !ok {
+		return vm.push(value)
+	}
+
+	promise.Handled = true
+	switch promise.State {
+	case PromiseFulfilled:
+		return vm.push(promise.Value)
+	case PromiseRejected:
+		return &ThrownError{Value: promise.Value}
+	}
+
+	fiber := vm.Fiber
+	if fiber.Caller != nil {
+		return fmt.Errorf("can't await inside a fiber")
+	}
+
+	loop := vm.loop()
+	fiber.State = FiberSuspended
+	loop.Waiting[fiber] = promise
+	promise.Then(func(settled *Promise) {
+		if loop.Waiting[fiber] != settled {
+			return
+		}
+		delete(loop.Waiting, fiber)
+		loop.Ready = append(loop.Ready, Task{Fiber: fiber, Promise: settled})
+	})
+
+	return vm.schedule()
+}
+
+// schedule switches to the next fiber the event loop has ready, waiting
+// for jobs to finish while there is none. When nothing is left to run it
+// switches back to the main fiber; if fibers are still waiting on
+// promises nothing can settle now, they are given up and the error is
+// raised there.
+func (vm *VM) schedule() error {
+	loop := vm.loop()
+
+	for len(loop.Ready) == 0 && loop.Jobs > 0 {
+		settle := <-loop.Completions
+		loop.Jobs--
+		settle()
+	}
+
+	if len(loop.Ready) == 0 {
+		if vm.Fiber != vm.Main {
+			vm.switchFiber(vm.Main)
+		}
+		if len(loop.Waiting) == 0 {
+			return loop.unhandledRejection()
+		}
+
+		var names []string
+		for fiber := range loop.Waiting {
+			names = append(names, fiber.Closure.Function.Name)
+			if fiber != vm.Main {
+				fiber.State = FiberDone
+			}
+		}
+		sort.Strings(names)
+		loop.Waiting = make(map[*CompiledFiber]*Promise)
+
+		if vm.Main.State == FiberSuspended {
+			vm.Main.State = FiberRunning
+		}
+		return fmt.Errorf("await never resolved in %s", strings.Join(names, ", "))
+	}
+
+	task := loop.Ready[0]
+	loop.Ready = loop.Ready[1:]
+
+	vm.switchFiber(task.Fiber)
+	task.Fiber.State = FiberRunning
+
+	switch {
+	case task.Promise == nil:
+		return nil
+	case task.Promise.State == PromiseRejected:
+		return &ThrownError{Value: task.Promise.Value}
+	default:
+		return vm.push(task.Promise.Value)
+	}
+}
+
+func (loop *EventLoop) unhandledRejection() error {
+	rejected := loop.Rejected
+	loop.Rejected = nil
+
+	for _, promise := range rejected {
+		if !promise.Handled {
+			return fmt.Errorf("unhandled rejection: %s", thrownMessage(promise.Value))
+		}
+	}
+	return nil
+}
+
+// finishAsync ends the running async fiber, whose promise is settled, and
+// moves on to whatever the event loop has next.
+func (vm *VM) finishAsync() error {
+	for idx := 0; idx < vm.FrameCount; idx++ {
+		if generator := vm.Frames[idx].Generator; generator != nil {
+			generator.Running = false
+			generator.Done = true
+		}
+	}
+	vm.FrameCount = 0
+	vm.Sp = 0
+	vm.Handlers = nil
+	vm.Fiber.State = FiberDone
+
+	return vm.schedule()
+}
+
+// startJob runs the job of promise on a goroutine of its own. The promise
+// is settled back on the VM's goroutine, when schedule gets to it.
+func (vm *VM) startJob(promise *Promise) {
+	loop := vm.loop()
+	job := promise.Job
+	promise.Job = nil
+
+	loop.Jobs++
+	go func() {
+		value, err := job()
+		loop.Completions <- func() {
+			if err != nil {
+				promise.Settle(vm.exceptionValue(err), true)
+				return
+			}
+			promise.Settle(value, false)
+		}
+	}()
+}
+
+// spawn starts the call below the top numArgs values on a VM of its own.
+// The new thread gets copies of the function, its arguments and the
+// globals of every namespace, so all it shares with this one are immutable values, classes
+// and channels. Globals that can't be copied are left out.
+func (vm *VM) spawn(numArgs int) (*Thread, error) {
+	values := vm.Stack[vm.Sp-1-numArgs : vm.Sp]
+	if _, ok := values[0].(*Closure); !ok {
+		return nil, fmt.Errorf("can only spawn a function, got %s", values[0].Type())
+	}
+
+	copies := make(map[Object]Object)
+	args := make([]Object, len(values))
+	for idx, value := range values {
+		shared, err := shareValue(value, copies)
+		if err != nil {
+			return nil, err
+		}
+		args[idx] = shared
+	}
+
+	namespaces := make([][]Object, len(vm.Namespaces))
+	for ns, globals := range vm.Namespaces {
+		if globals == nil {
+			continue
+		}
+		namespaces[ns] = make([]Object, len(globals))
+		for idx, value := range globals {
+			if value == nil {
+				continue
+			}
+			if shared, err := shareValue(value, copies); err == nil {
+				namespaces[ns][idx] = shared
+			}
+		}
+	}
+
+	closure := args[0].(*Closure)
+	root := &Closure{Function: &CompiledFunction{Name: closure.Function.Name}}
+
+	thread := &VM{Constants: vm.Constants, ErrorClass: vm.ErrorClass, Namespaces: namespaces, Globals: namespaces[0]}
+	thread.Stack = make([]Object, STACK_MAX)
+	thread.Frames = make([]*CallFrame, FRAMES_MAX)
+	thread.pushFrame(&CallFrame{Closure: root})
+	thread.Fiber = &CompiledFiber{Closure: root, State: FiberRunning}
+	thread.Main = thread.Fiber
+
+	for _, arg := range args {
+		thread.push(arg)
+	}
+	if err := thread.callFunction(closure, numArgs, nil); err != nil {
+		return nil, err
+	}
+	vm.Sp -= numArgs + 1
+
+	handle := &Thread{Name: closure.Function.Name, Done: make(chan struct{})}
+	go func() {
+		defer close(handle.Done)
+		if err := thread.run(); err != nil {
+			handle.Err = err
+			return
+		}
+		handle.Result = thread.peek(0)
+	}()
+
+	return handle, nil
+}
+
+// shareValue copies value for another thread. Immutable values, classes,
+// modules, channels, wait groups and threads are passed as they are; arrays,
+// hashes, instances and closures are copied, keeping whatever aliasing
+// there is between the values copied with the same copies map.
+func shareValue(value Object, copies map[Object]Object) (Object, error) {
+	switch value := value.(type) {
+	case *FloatObject, *StringObject, *BooleanObject, *NilObject, *Range, *Builtin,
+		*CompiledFunction, *CompiledClassObject, *Channel, *WaitGroup, *Thread, *SyncMethod, *ModuleObject:
+		return value, nil
+	}
+
+	if copied, ok := copies[value]; ok {
+		return copied, nil
+	}
+
+	switch value := value.(type) {
+	case *Array:
+		array := &Array{Elements: make([]Object, len(value.Elements))}
+		copies[value] = array
+		for idx, element := range value.Elements {
+			shared, err := shareValue(element, copies)
+			if err != nil {
+				return nil, err
+			}
+			array.Elements[idx] = shared
+		}
+		return array, nil
+	case *Hash:
+		hash := &Hash{Pairs: make(map[HashKey]HashPair, len(value.Pairs))}
+		copies[value] = hash
+		for key, pair := range value.Pairs {
+			shared, err := shareValue(pair.Value, copies)
+			if err != nil {
+				return nil, err
+			}
+			hash.Pairs[key] = HashPair{Key: pair.Key, Value: shared}
+		}
+		return hash, nil
+	case *CompiledInstanceObject:
+		instance := &CompiledInstanceObject{Class: value.Class, Fields: make(map[string]Object, len(value.Fields))}
+		copies[value] = instance
+		for name, field := range value.Fields {
+			shared, err := shareValue(field, copies)
+			if err != nil {
+				return nil, err
+			}
+			instance.Fields[name] = shared
+		}
+		return instance, nil
+	case *Closure:
+		closure := &Closure{Function: value.Function, UpValues: make([]Object, len(value.UpValues))}
+		copies[value] = closure
+		for idx, upvalue := range value.UpValues {
+			shared, err := shareValue(upvalue, copies)
+			if err != nil {
+				return nil, err
+			}
+			closure.UpValues[idx] = shared
+		}
+		return closure, nil
+	case *CompiledBoundMethod:
+		receiver, err := shareValue(value.Receiver, copies)
+		if err != nil {
+			return nil, err
+		}
+		return &CompiledBoundMethod{Receiver: receiver.(*CompiledInstanceObject), Method: value.Method}, nil
+	default:
+		return nil, fmt.Errorf("can't share %s between threads", value.Type())
+	}
+}
+
+func syncMethod(object Object, name string) (*SyncMethod, bool) {
+	var methods []string
+	switch object.(type) {
+	case *Channel:
+		methods = []string{ChannelSendMethod, ChannelRecvMethod, ChannelCloseMethod}
+	case *WaitGroup:
+		methods = []string{WaitGroupAddMethod, WaitGroupDoneMethod, WaitGroupWaitMethod}
+	case *Thread:
+		methods = []string{ThreadJoinMethod}
+	case *Promise:
+		methods = []string{PromiseResolveMethod, PromiseRejectMethod}
+	}
+
+	for _, method := range methods {
+		if method == name {
+			return &SyncMethod{Receiver: object, Name: name}, true
+		}
+	}
+	return nil, false
+}
+
+func (vm *VM) callSyncMethod(method *SyncMethod, numArgs int) error {
+	args := vm.Stack[vm.Sp-numArgs : vm.Sp]
+
+	min, max := 0, 0
+	switch method.Name {
+	case ChannelSendMethod:
+		min, max = 1, 1
+	case WaitGroupAddMethod, PromiseResolveMethod, PromiseRejectMethod:
+		max = 1
+	}
+	if numArgs < min || numArgs > max {
+		if min == max {
+			return fmt.Errorf("wrong number of arguments: want=%d, got=%d", min, numArgs)
+		}
+		return fmt.Errorf("wrong number of arguments: want=%d to %d, got=%d", min, max, numArgs)
+	}
+
+	var result Object = Null
+	var err error
+
+	switch receiver := method.Receiver.(type) {
+	case *Channel:
+		switch method.Name {
+		case ChannelSendMethod:
+			var value Object
+			if value, err = shareValue(args[0], make(map[Object]Object)); err == nil {
+				err = recoverPanic("send on closed channel", func() { receiver.Values <- value })
+			}
+		case ChannelRecvMethod:
+			if value, ok := <-receiver.Values; ok {
+				result = value
+			}
+		case ChannelCloseMethod:
+			err = recoverPanic("channel is already closed", func() { close(receiver.Values) })
+		}
+	case *WaitGroup:
+		switch method.Name {
+		case WaitGroupAddMethod:
+			delta := 1
+			if numArgs == 1 {
+				number, ok := args[0].(*FloatObject)
+				if !ok {
+					return fmt.Errorf("%s: %s must be a number, got %s", typeMissMatchError, WaitGroupAddMethod, args[0].Type())
+				}
+				delta = int(number.Value)
+			}
+			err = recoverPanic("negative wait group counter", func() { receiver.Group.Add(delta) })
+		case WaitGroupDoneMethod:
+			err = recoverPanic("negative wait group counter", receiver.Group.Done)
+		case WaitGroupWaitMethod:
+			receiver.Group.Wait()
+		}
+	case *Thread:
+		<-receiver.Done
+		if receiver.Err != nil {
+			err = receiver.Err
+		} else {
+			result = receiver.Result
+		}
+	case *Promise:
+		var value Object = Null
+		if numArgs == 1 {
+			value = args[0]
+		}
+		receiver.Settle(value, method.Name == PromiseRejectMethod)
+	}
+	if err != nil {
+		return err
+	}
+
+	vm.Sp -= numArgs + 1
+	return vm.push(result)
+}
+
+// recoverPanic runs fn and turns a panic in it, like sending on a closed
+// channel, into an error.
+func recoverPanic(message string, fn func()) (err error) {
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("%s", message)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// selectCase waits for one of the cases described by shape and pushes the
+// value it received, or nil, then its index. The default case, if any,
+// comes after the others.
+func (vm *VM) selectCase(shape *SelectShape) error {
+	count := len(shape.Sends)
+	for _, send := range shape.Sends {
+		if send {
+			count++
+		}
+	}
+	operands := vm.Stack[vm.Sp-count : vm.Sp]
+
+	cases := make([]reflect.SelectCase, 0, len(shape.Sends)+1)
+	for _, send := range shape.Sends {
+		channel, ok := operands[0].(*Channel)
+		if !ok {
+			return fmt.Errorf("select needs a channel, got %s", operands[0].Type())
+		}
+		operands = operands[1:]
+
+		selectCase := reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(channel.Values)}
+		if send {
+			value, err := shareValue(operands[0], make(map[Object]Object))
+			if err != nil {
+				return err
+			}
+			operands = operands[1:]
+
+			selectCase.Dir = reflect.SelectSend
+			selectCase.Send = reflect.ValueOf(value)
+		}
+		cases = append(cases, selectCase)
+	}
+	if shape.Default {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+	vm.Sp -= count
+
+	var chosen int
+	var received reflect.Value
+	var ok bool
+	if err := recoverPanic("send on closed channel", func() { chosen, received, ok = reflect.Select(cases) }); err != nil {
+		return err
+	}
+
+	var value Object = Null
+	if ok {
+		value = received.Interface().(Object)
+	}
+
+	vm.push(value)
+	return vm.push(&FloatObject{Value: float64(chosen)})
+}
+
+// suspendGenerator saves the current generator frame, along with its stack
+// slots and exception handlers, and returns value to whoever resumed it.
+func (vm *VM) suspendGenerator(value Object) error {
+	frame := vm.currentFrame()
+	generator := frame.Generator
+	if generator == nil {
+		return fmt.Errorf("can't yield outside a generator")
+	}
+
+	bp := frame.BasePointer
+	generator.Ip = frame.Ip
+	generator.Stack = append([]Object(nil), vm.Stack[bp:vm.Sp]...)
+	generator.Loops = frame.Loops
+	generator.Running = false
+
+	first := len(vm.Handlers)
+	for first > 0 && vm.Handlers[first-1].FrameCount == vm.FrameCount {
+		first--
+	}
+	for _, handler := range vm.Handlers[first:] {
+		generator.Handlers = append(generator.Handlers, ExceptionHandler{Sp: handler.Sp - bp, Target: handler.Target})
+	}
+	vm.Handlers = vm.Handlers[:first]
+
+	vm.popFrame()
+	vm.Sp = bp - 1
+	return vm.push(value)
+}
+
+func (vm *VM) NewFrame(closure *Closure, bp int) *CallFrame {
+	return &CallFrame{
+		Closure:     closure,
+		Ip:          0,
 		BasePointer: bp,
 	}
 }
 
-func (vm *VM) callFunction(callee *Closure, numArgs int) error {
+func (vm *VM) callFunction(callee *Closure, numArgs int, names []string) error {
 	function := callee.Function
-	if numArgs != function.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", function.NumParameters, numArgs)
+
+	numArgs, err := vm.bindArguments(function, numArgs, names)
+	if err != nil {
+		return err
+	}
+
+	if function.IsAsync {
+		return vm.callAsync(callee, numArgs)
+	}
+
+	if function.IsGenerator {
+		// the arguments become the generator's saved locals and the
+		// generator takes the callee's place
+		bp := vm.Sp - numArgs
+		generator := &CompiledGenerator{Closure: callee, Stack: make([]Object, function.NumLocals)}
+		copy(generator.Stack, vm.Stack[bp:vm.Sp])
+		vm.Sp = bp - 1
+		return vm.push(generator)
 	}
 
 	frame := &CallFrame{
@@ -461,16 +1819,19 @@ func (vm *VM) callFunction(callee *Closure, numArgs int) error {
 
 	vm.pushFrame(frame)
 	vm.Sp = frame.BasePointer + function.NumLocals
-	fmt.Printf("\nElement at bp %v, Element at sp %v\n", vm.Stack[frame.BasePointer], vm.Stack[vm.Sp])
+	// the locals past the arguments hold nothing until they are defined
+	clear(vm.Stack[frame.BasePointer+numArgs : vm.Sp])
+	vm.trace("\nElement at bp %v, Element at sp %v\n", vm.Stack[frame.BasePointer], vm.Stack[vm.Sp])
 	return nil
 }
 
-func (vm *VM) callBoundMethod(callee *CompiledBoundMethod, numArgs int) error {
+func (vm *VM) callBoundMethod(callee *CompiledBoundMethod, numArgs int, names []string) error {
 	closure := callee.Method
 	function := closure.Function
 
-	if numArgs != function.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", function.NumParameters, numArgs)
+	numArgs, err := vm.bindArguments(function, numArgs, names)
+	if err != nil {
+		return err
 	}
 
 	frame := &CallFrame{
@@ -481,20 +1842,47 @@ func (vm *VM) callBoundMethod(callee *CompiledBoundMethod, numArgs int) error {
 
 	copy(vm.Stack[frame.BasePointer+1:], vm.Stack[frame.BasePointer:vm.Sp])
 	vm.Stack[frame.BasePointer] = callee.Receiver
+
+	if function.IsGenerator {
+		// the receiver and the arguments become the generator's saved
+		// locals and the generator takes the bound method's place
+		generator := &CompiledGenerator{Closure: closure, Stack: make([]Object, function.NumLocals)}
+		copy(generator.Stack, vm.Stack[frame.BasePointer:frame.BasePointer+1+numArgs])
+		vm.Sp = frame.BasePointer - 1
+		return vm.push(generator)
+	}
+
 	vm.Sp = frame.BasePointer + function.NumLocals
+	clear(vm.Stack[frame.BasePointer+1+numArgs : vm.Sp])
 
 	vm.pushFrame(frame)
-	fmt.Printf("BP: %d | SP: %d | STACK: %v\n", frame.BasePointer, vm.Sp, vm.printStack())
+	if vm.tracing() {
+		fmt.Fprintf(vm.Stdout, "BP: %d | SP: %d | STACK: %v\n", frame.BasePointer, vm.Sp, vm.printStack())
+	}
 	return nil
 }
 
 func (vm *VM) callBuiltin(builtin *Builtin, numArgs int) error {
 	args := vm.Stack[vm.Sp-numArgs : vm.Sp]
-	fmt.Println(args)
+	vm.trace("%v\n", args)
 
-	result := builtin.Fn(args...)
+	var result Object
+	if builtin == GetBuiltinByName(BuiltinFuncNamePrint) {
+		// print writes to the VM's output rather than the process's
+		result = printObjects(vm.Stdout, args...)
+	} else {
+		result = builtin.Fn(args...)
+	}
 	vm.Sp = vm.Sp - numArgs - 1
 
+	if promise, ok := result.(*Promise); ok && promise.Job != nil {
+		vm.startJob(promise)
+	}
+
+	if err, ok := result.(*ErrorObject); ok {
+		return fmt.Errorf("%s", err.Message)
+	}
+
 	if result != nil {
 		vm.push(result)
 	} else {
@@ -547,16 +1935,10 @@ func (vm *VM) equal() error {
 func (vm *VM) not() error {
 	operand := vm.pop()
 
-	switch operand {
-	case True:
-		return vm.push(False)
-	case False:
-		return vm.push(True)
-	case Null:
-		return vm.push(True)
-	default:
+	if vm.isTruthy(operand) {
 		return vm.push(False)
 	}
+	return vm.push(True)
 }
 
 func (vm *VM) negate() error {
@@ -647,7 +2029,15 @@ func (vm *VM) executeBinaryArithmeticOperations(left Object, op string, right Ob
 	case "*":
 		result = leftValue.Value * rightValue.Value
 	case "/":
+		if rightValue.Value == 0 {
+			return fmt.Errorf("%s", divisionByZeroMessage(leftValue, op, rightValue))
+		}
 		result = leftValue.Value / rightValue.Value
+	case "%":
+		if rightValue.Value == 0 {
+			return fmt.Errorf("%s", divisionByZeroMessage(leftValue, op, rightValue))
+		}
+		result = math.Mod(leftValue.Value, rightValue.Value)
 	default:
 		return fmt.Errorf("unknown operator: %s", op)
 	}
@@ -655,6 +2045,341 @@ func (vm *VM) executeBinaryArithmeticOperations(left Object, op string, right Ob
 	return vm.push(&FloatObject{Value: result})
 }
 
+func (vm *VM) buildHash(start, end int) (Object, error) {
+	pairs := make(map[HashKey]HashPair)
+
+	for i := start; i < end; i += 2 {
+		key := vm.Stack[i]
+		value := vm.Stack[i+1]
+
+		hashKey, ok := key.(Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		pairs[hashKey.HashKey()] = HashPair{Key: key, Value: value}
+	}
+
+	return &Hash{Pairs: pairs}, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index Object) error {
+	switch {
+	case left.Type() == ArrayObj && index.Type() == FloatObj:
+		array := left.(*Array)
+		number := index.(*FloatObject)
+
+		idx, ok := resolveIndex(number, len(array.Elements))
+		if !ok {
+			return fmt.Errorf("%s: index %s, length %d", indexOutOfRange, number.Inspect(), len(array.Elements))
+		}
+		return vm.push(array.Elements[idx])
+	case left.Type() == StringObj && index.Type() == FloatObj:
+		runes := []rune(left.(*StringObject).Value)
+		number := index.(*FloatObject)
+
+		idx, ok := resolveIndex(number, len(runes))
+		if !ok {
+			return fmt.Errorf("%s: index %s, length %d", indexOutOfRange, number.Inspect(), len(runes))
+		}
+		return vm.push(&StringObject{Value: string(runes[idx])})
+	case left.Type() == RangeObj && index.Type() == FloatObj:
+		rng := left.(*Range)
+		number := index.(*FloatObject)
+
+		idx, ok := resolveIndex(number, rng.Len())
+		if !ok {
+			return fmt.Errorf("%s: index %s, length %d", indexOutOfRange, number.Inspect(), rng.Len())
+		}
+		return vm.push(&FloatObject{Value: rng.At(idx)})
+	case left.Type() == HashObj:
+		hash := left.(*Hash)
+
+		key, ok := index.(Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+
+		pair, ok := hash.Pairs[key.HashKey()]
+		if !ok {
+			return vm.push(Null)
+		}
+		return vm.push(pair.Value)
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeSetIndex(left, index, value Object) error {
+	switch {
+	case left.Type() == ArrayObj && index.Type() == FloatObj:
+		array := left.(*Array)
+		number := index.(*FloatObject)
+
+		idx, ok := resolveIndex(number, len(array.Elements))
+		if !ok {
+			return fmt.Errorf("%s: index %s, length %d", indexOutOfRange, number.Inspect(), len(array.Elements))
+		}
+		array.Elements[idx] = value
+	case left.Type() == HashObj:
+		hash := left.(*Hash)
+
+		key, ok := index.(Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		hash.Pairs[key.HashKey()] = HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
+func (vm *VM) executeSlice(left, start, end Object) error {
+	switch left := left.(type) {
+	case *Array:
+		low, high, ok := resolveSlice(start, end, len(left.Elements))
+		if !ok {
+			return fmt.Errorf("slice bounds must be numbers")
+		}
+
+		elements := make([]Object, high-low)
+		copy(elements, left.Elements[low:high])
+		return vm.push(&Array{Elements: elements})
+	case *StringObject:
+		runes := []rune(left.Value)
+		low, high, ok := resolveSlice(start, end, len(runes))
+		if !ok {
+			return fmt.Errorf("slice bounds must be numbers")
+		}
+		return vm.push(&StringObject{Value: string(runes[low:high])})
+	default:
+		return fmt.Errorf("slice operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeSetSlice(left, start, end, value Object) error {
+	array, ok := left.(*Array)
+	if !ok {
+		return fmt.Errorf("slice assignment not supported: %s", left.Type())
+	}
+
+	replacement, ok := value.(*Array)
+	if !ok {
+		return fmt.Errorf("can only assign an array to a slice, got %s", value.Type())
+	}
+
+	low, high, ok := resolveSlice(start, end, len(array.Elements))
+	if !ok {
+		return fmt.Errorf("slice bounds must be numbers")
+	}
+
+	array.Elements = spliceElements(array.Elements, low, high, replacement.Elements)
+	return vm.push(value)
+}
+
+func (vm *VM) newIterator(iterable Object, keyed bool) (*Iterator, error) {
+	iterator := &Iterator{Keyed: keyed}
+
+	switch iterable := iterable.(type) {
+	case *Array:
+		iterator.Values = append(iterator.Values, iterable.Elements...)
+	case *StringObject:
+		for _, r := range iterable.Value {
+			iterator.Values = append(iterator.Values, &StringObject{Value: string(r)})
+		}
+	case *Hash:
+		for _, pair := range iterable.SortedPairs() {
+			if !keyed {
+				iterator.Values = append(iterator.Values, pair.Key)
+				continue
+			}
+			iterator.Keys = append(iterator.Keys, pair.Key)
+			iterator.Values = append(iterator.Values, pair.Value)
+		}
+	case *Range:
+		iterator.Range = iterable
+	case *CompiledGenerator:
+		iterator.Generator = iterable
+	case *CompiledInstanceObject:
+		iterator.Instance = iterable
+		if _, ok := iterable.Class.Methods[IteratorMethod]; ok {
+			iterator.State = IteratorPending
+		} else if _, ok := iterable.Class.Methods[NextMethod]; !ok {
+			return nil, fmt.Errorf("%s: %s", notIterableError, iterable.Inspect())
+		}
+	default:
+		return nil, fmt.Errorf("%s: %s", notIterableError, iterable.Type())
+	}
+
+	return iterator, nil
+}
+
+// iterNext advances the for-in iterator on top of the stack, pushing the key
+// (when keyed) and the value, and reports whether it is exhausted. User
+// iterators are driven by calling their methods with the frame's ip rewound
+// to start, so this instruction runs again with the method's result on top of
+// the iterator.
+func (vm *VM) iterNext(start int) (bool, error) {
+	if vm.Sp >= 2 {
+		if iterator, ok := vm.peek(1).(*Iterator); ok && (iterator.State == IteratorAwaitingIterator || iterator.State == IteratorAwaitingNext) {
+			return vm.resumeIterator(iterator, start)
+		}
+	}
+
+	iterator, ok := vm.peek(0).(*Iterator)
+	if !ok {
+		return false, fmt.Errorf("%s: %s", notIterableError, vm.peek(0).Type())
+	}
+
+	switch {
+	case iterator.Generator != nil:
+		iterator.State = IteratorAwaitingNext
+		if err := vm.push(&GeneratorNext{Generator: iterator.Generator}); err != nil {
+			return false, err
+		}
+		vm.currentFrame().Ip = start
+		return false, vm.resumeGenerator(iterator.Generator, 0)
+	case iterator.Instance == nil:
+		if iterator.Index >= iterator.Len() {
+			return true, nil
+		}
+
+		if iterator.Keyed {
+			var key Object = &FloatObject{Value: float64(iterator.Index)}
+			if iterator.Keys != nil {
+				key = iterator.Keys[iterator.Index]
+			}
+			if err := vm.push(key); err != nil {
+				return false, err
+			}
+		}
+
+		value := iterator.At(iterator.Index)
+		iterator.Index++
+		return false, vm.push(value)
+	case iterator.State == IteratorPending:
+		iterator.State = IteratorAwaitingIterator
+		return false, vm.callIteratorMethod(iterator.Instance, IteratorMethod, start)
+	default:
+		iterator.State = IteratorAwaitingNext
+		return false, vm.callIteratorMethod(iterator.Instance, NextMethod, start)
+	}
+}
+
+func (vm *VM) resumeIterator(iterator *Iterator, start int) (bool, error) {
+	result := vm.pop()
+
+	if iterator.State == IteratorAwaitingIterator {
+		if generator, ok := result.(*CompiledGenerator); ok {
+			iterator.Instance = nil
+			iterator.Generator = generator
+			iterator.State = IteratorReady
+			return vm.iterNext(start)
+		}
+
+		instance, ok := result.(*CompiledInstanceObject)
+		if !ok {
+			return false, fmt.Errorf("%s: %s() returned %s", notIterableError, IteratorMethod, result.Type())
+		}
+
+		iterator.Instance = instance
+		iterator.State = IteratorReady
+		return vm.iterNext(start)
+	}
+
+	iterator.State = IteratorReady
+	if iterator.Generator != nil && iterator.Generator.Done {
+		return true, nil
+	}
+	if iterator.Generator == nil && result.Type() == NillObj {
+		return true, nil
+	}
+
+	if iterator.Keyed {
+		if err := vm.push(&FloatObject{Value: float64(iterator.Index)}); err != nil {
+			return false, err
+		}
+	}
+	iterator.Index++
+
+	return false, vm.push(result)
+}
+
+func (vm *VM) callIteratorMethod(instance *CompiledInstanceObject, name string, start int) error {
+	method, ok := instance.Class.Methods[name]
+	if !ok {
+		return fmt.Errorf("%s: %s has no %s() method", notIterableError, instance.Inspect(), name)
+	}
+
+	bound := &CompiledBoundMethod{Receiver: instance, Method: method}
+	if err := vm.push(bound); err != nil {
+		return err
+	}
+
+	vm.currentFrame().Ip = start
+	return vm.callBoundMethod(bound, 0, nil)
+}
+
+// matchKey reports whether value is a hash holding key; a nil key only
+// checks that value is a hash.
+func matchKey(value, key Object) bool {
+	hash, ok := value.(*Hash)
+	if !ok {
+		return false
+	}
+	if key == Null {
+		return true
+	}
+
+	hashable, ok := key.(Hashable)
+	if !ok {
+		return false
+	}
+	_, ok = hash.Pairs[hashable.HashKey()]
+	return ok
+}
+
+// matchClass reports whether value is an instance of class whose fields can
+// be matched positionally against the given number of sub-patterns.
+func matchClass(value Object, class *CompiledClassObject, fields int) bool {
+	instance, ok := value.(*CompiledInstanceObject)
+	if !ok || !instance.Class.IsSubclassOf(class) {
+		return false
+	}
+	if fields == 0 {
+		return true
+	}
+
+	init, ok := instance.Class.Methods["init"]
+	return ok && len(init.Function.ParameterNames) == fields
+}
+
+// toString replaces the value on top of the stack with its string form. For an
+// instance whose class defines toString, the method is called and its return
+// value takes the place of the instance once the frame returns.
+func (vm *VM) toString() error {
+	value := vm.peek(0)
+
+	if instance, ok := value.(*CompiledInstanceObject); ok {
+		if method, ok := instance.Class.Methods[ToStringMethod]; ok {
+			bound := &CompiledBoundMethod{Receiver: instance, Method: method}
+			vm.Stack[vm.Sp-1] = bound
+			return vm.callBoundMethod(bound, 0, nil)
+		}
+	}
+
+	vm.Stack[vm.Sp-1] = &StringObject{Value: value.Inspect()}
+	return nil
+}
+
+// LastPoppedStackElem returns the value most recently popped off the stack,
+// which is the result of the last expression statement.
+func (vm *VM) LastPoppedStackElem() Object {
+	return vm.Stack[vm.Sp]
+}
+
 func (vm *VM) push(value Object) error {
 	if vm.Sp >= STACK_MAX {
 		return fmt.Errorf("stack overflow")
@@ -673,6 +2398,19 @@ func (vm *VM) pop() Object {
 	return vm.Stack[vm.Sp]
 }
 
+// tracing reports whether the VM prints a trace of the instructions it
+// runs: only when asked to, and never while profiling, so the samples
+// measure the program rather than the printing.
+func (vm *VM) tracing() bool {
+	return vm.Trace && vm.Profiler == nil
+}
+
+func (vm *VM) trace(format string, args ...interface{}) {
+	if vm.tracing() {
+		fmt.Fprintf(vm.Stdout, format, args...)
+	}
+}
+
 func (vm *VM) printStack() string {
 	var str strings.Builder
 
@@ -694,18 +2432,7 @@ func (vm *VM) printStack() string {
 }
 
 func (vm *VM) isTruthy(value Object) bool {
-	switch v := value.(type) {
-	case *StringObject:
-		return len(v.Value) > 0
-	case *FloatObject:
-		return v.Value > 0
-	case *BooleanObject:
-		return v.Value
-	case *NilObject:
-		return false
-	default:
-		return true
-	}
+	return isTruthy(value)
 }
 
 func (vm *VM) readConstant(index int) Object {
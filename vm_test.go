@@ -0,0 +1,1154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runVM(input []byte) (Object, error) {
+	return runVMFile(input, "")
+}
+
+// runVMFile runs input as if read from path, which imports are resolved
+// against.
+func runVMFile(input []byte, path string) (Object, error) {
+	scanner := NewScanner(input)
+	scanner.scanTokens()
+
+	if scanner.Errors().HasErrors() {
+		return nil, scanner.Errors().Errors[0]
+	}
+
+	parser := NewParser(scanner.Tokens())
+	program := parser.parse()
+
+	if parser.Errors().HasErrors() {
+		return nil, parser.Errors().Errors[0]
+	}
+
+	compiler := NewCompiler()
+	compiler.Path = path
+	if err := compiler.Compile(program); err != nil {
+		return nil, err
+	}
+
+	vm := NewVM(compiler.ByteCode())
+	if err := vm.run(); err != nil {
+		return nil, err
+	}
+
+	return vm.LastPoppedStackElem(), nil
+}
+
+type vmTestCase struct {
+	code     string
+	expected interface{}
+}
+
+func runVMTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, test := range tests {
+		result, err := runVM([]byte(test.code))
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", test.code, err)
+		}
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestVMControlFlow(t *testing.T) {
+	tests := []vmTestCase{
+		{"var a = 0; if (a < 1) { a = 10; } else { a = 20; } a;", float64(10)},
+		{"var a = 0; if (a > 1) { a = 10; } else { a = 20; } a;", float64(20)},
+		{"var i = 0; while (i < 5) { i = i + 1; } i;", float64(5)},
+		{"var s = 0; for (var i = 0; i < 4; i = i + 1) { s = s + i; } s;", float64(6)},
+		{"false and 1;", false},
+		{"true and 1;", float64(1)},
+		{"false or 2;", float64(2)},
+		{"1 != 2;", true},
+		{"var a; var b = a = 3; b;", float64(3)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMFunctionsAndClasses(t *testing.T) {
+	tests := []vmTestCase{
+		{"function add(a, b) { return a + b; } add(1, 2);", float64(3)},
+		{"function noop() {} var r = noop(); r == nil;", true},
+		{
+			`class Point {
+				init(x) {
+					this.x = x;
+				}
+				get() {
+					return this.x;
+				}
+			}
+			var p = Point(7);
+			p.get();`,
+			float64(7),
+		},
+		{"class Empty {} var e = Empty(); e.a = 1; e.a;", float64(1)},
+		{"var add = function(a, b) { return a + b; }; add(1, 2);", float64(3)},
+		{"function apply(f, x) { return f(x); } var n = 3; apply(function(x) { return x * n; }, 4);", float64(12)},
+		{"function adder(n) { return function(x) { return x + n; }; } adder(1)(2);", float64(3)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMCompoundAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"var a = 5; a += 2; a;", float64(7)},
+		{"var a = 5; a -= 2; a;", float64(3)},
+		{"var a = 5; a *= 2; a;", float64(10)},
+		{"var a = 5; a /= 2; a;", float64(2.5)},
+		{"var a = 5; a %= 2; a;", float64(1)},
+		{"var a = nil; a ??= 4; a;", float64(4)},
+		{"var a = 1; a ??= 4; a;", float64(1)},
+		{"function f() { var a = 1; a += 2; return a; } f();", float64(3)},
+		{
+			`function counter() {
+				var count = 0;
+				function inc() {
+					count += 1;
+					return count;
+				}
+				return inc;
+			}
+			var c = counter();
+			c();
+			c();`,
+			float64(2),
+		},
+		{"class C {} var c = C(); c.n = 1; c.n += 10; c.n;", float64(11)},
+		{"var arr = [1, 2, 3]; arr[1] *= 10; arr[1];", float64(20)},
+		{`var h = {"a": 1}; h["a"] += 1; h["a"];`, float64(2)},
+		{`var h = {"a": 1}; h["b"] ??= 3; h["b"];`, float64(3)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMUpdateExpression(t *testing.T) {
+	tests := []vmTestCase{
+		{"var a = 1; ++a;", float64(2)},
+		{"var a = 1; a++;", float64(1)},
+		{"var a = 1; a++; a;", float64(2)},
+		{"var a = 1; --a;", float64(0)},
+		{"var a = 1; a--;", float64(1)},
+		{"var arr = [1, 2]; arr[0]++;", float64(1)},
+		{"var arr = [1, 2]; arr[0]++; arr[0];", float64(2)},
+		{"class P {} var p = P(); p.x = 1; var old = p.x++; old + p.x * 10;", float64(21)},
+		{"var s = 0; for (var i = 0; i < 3; i++) { s += i; } s;", float64(3)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMInterpolation(t *testing.T) {
+	tests := []vmTestCase{
+		{`var count = 2; "you have ${count + 1} items";`, "you have 3 items"},
+		{`var x = 2.5; "${x}";`, "2.5"},
+		{`"${"nested ${"quotes"}"}";`, "nested quotes"},
+		{`var h = {"k": "v"}; "value: ${h["k"]}";`, "value: v"},
+		{`"${true} ${[1, 2]}";`, "true [1, 2]"},
+		{
+			`class Point {
+				init(x, y) {
+					this.x = x;
+					this.y = y;
+				}
+				toString() {
+					return "(" + "${this.x}" + ", " + "${this.y}" + ")";
+				}
+			}
+			"p = ${Point(1, 2)}";`,
+			"p = (1, 2)",
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMIndexAndSlice(t *testing.T) {
+	tests := []vmTestCase{
+		{`var a = [1, 2, 3]; a[-1];`, float64(3)},
+		{`var a = [1, 2, 3]; a[0] = 10; a[0];`, float64(10)},
+		{`var a = [1, 2, 3]; a[-1] = 30; a[2];`, float64(30)},
+		{`var h = {"a": 1}; h["b"] = 2; h["b"];`, float64(2)},
+		{`var a = [[1, 2], [3, 4]]; a[1][0];`, float64(3)},
+		{`var a = [1, 2, 3, 4]; "${a[1:3]}";`, "[2, 3]"},
+		{`var a = [1, 2, 3, 4]; "${a[:-1]}";`, "[1, 2, 3]"},
+		{`var a = [1, 2, 3, 4]; a[1:3] = [9]; "${a}";`, "[1, 9, 4]"},
+		{`"hello"[-1];`, "o"},
+		{`"hello"[2:];`, "llo"},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMIndexOutOfRange(t *testing.T) {
+	tests := []string{
+		`var a = [1, 2, 3]; a[3];`,
+		`var a = [1, 2, 3]; a[-4] = 1;`,
+		`"abc"[3];`,
+	}
+
+	for _, code := range tests {
+		if _, err := runVM([]byte(code)); err == nil {
+			t.Errorf("Expected runtime error for %q", code)
+		}
+	}
+}
+
+func TestVMForIn(t *testing.T) {
+	tests := []vmTestCase{
+		{`var s = 0; for (var x in [1, 2, 3]) { s += x; } s;`, float64(6)},
+		{`var s = 0; for (var i, x in [10, 20]) { s += i * x; } s;`, float64(20)},
+		{`var s = ""; for (var k in {"b": 2, "a": 1}) { s += k; } s;`, "ab"},
+		{`var s = ""; for (var k, v in {"b": 2, "a": 1}) { s += "${k}=${v};"; } s;`, "a=1;b=2;"},
+		{`var s = ""; for (var c in "héllo") { s = c + s; } s;`, "olléh"},
+		{`var s = 0; for (var x in [1, 2]) { for (var y in [10, 20]) { s += x * y; } } s;`, float64(90)},
+		{`function sum(items) { var s = 0; for (var x in items) { s += x; } return s; } sum([4, 5]);`, float64(9)},
+		{
+			`class Countdown {
+				init(n) {
+					this.n = n;
+				}
+				next() {
+					if (this.n == 0) {
+						return nil;
+					}
+					this.n -= 1;
+					return this.n + 1;
+				}
+			}
+			var s = "";
+			for (var x in Countdown(3)) { s += "${x}"; }
+			s;`,
+			"321",
+		},
+		{
+			`class Cursor {
+				init(items) {
+					this.items = items;
+					this.i = 0;
+				}
+				next() {
+					if (this.i == 2) {
+						return nil;
+					}
+					this.i += 1;
+					return this.items[this.i - 1];
+				}
+			}
+			class Pair {
+				iterator() {
+					return Cursor(["a", "b"]);
+				}
+			}
+			var s = "";
+			for (var i, x in Pair()) { s += "${i}${x}"; }
+			s;`,
+			"0a1b",
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+// TestLoopBreakAndContinue runs the same loops on the VM and the
+// tree-walker, which must agree.
+func TestLoopBreakAndContinue(t *testing.T) {
+	tests := []vmTestCase{
+		{`var s = 0; for (var x in [1, 2, 3]) { s = s + x; if (x == 1) { break; } } s;`, float64(1)},
+		{`var s = 0; for (var x in [1, 2, 3, 4]) { if (x == 2) { continue; } s = s + x; } s;`, float64(8)},
+		{`var s = 0; for (var k, v in {"a": 1, "b": 2}) { if (k == "b") { break; } s = s + v; } s;`, float64(1)},
+		{`var s = 0; for (var x in [1, 2, 3]) { for (var y in [1, 2, 3]) { if (y == 2) { break; } s = s + 1; } } s;`, float64(3)},
+		{`var s = 0; for (var x in [1, 2, 3]) { for (var y in [1, 2, 3]) { if (y == 2) { continue; } s = s + y; } } s;`, float64(12)},
+		{`var i = 0; var s = 0; while (i < 10) { i = i + 1; if (i % 2 == 0) { continue; } if (i > 7) { break; } s = s + i; } s;`, float64(16)},
+		{`var s = 0; for (var i = 0; i < 10; i = i + 1) { if (i == 1) { continue; } if (i == 4) { break; } s = s + i; } s;`, float64(5)},
+		{`var s = 0; var i = 0; while (i < 3) { i = i + 1; var j = 0; while (true) { j = j + 1; if (j == 2) { break; } } s = s + j; } s;`, float64(6)},
+		{`function first(xs) { var found; for (var x in xs) { if (x > 1) { found = x; break; } } return found; } first([1, 5, 7]);`, float64(5)},
+		// what the body left on the stack goes with it
+		{`var s = 0; for (var x in [1, 2, 3]) { match (x) { 2 => { break; }, _ => { s = s + x; } } } s;`, float64(1)},
+		{`var s = 0; for (var x in [1, 2, 3]) { match (x) { 2 => { continue; }, _ => { s = s + x; } } } s;`, float64(4)},
+		{`var s = ""; for (var x in [1, 2]) { try { if (x == 2) { break; } s = s + "t"; } finally { s = s + "f"; } } s;`, "tff"},
+		{`var s = ""; for (var x in [1, 2, 3]) { try { if (x == 2) { continue; } s = s + "${x}"; } catch (e) { s = s + "!"; } } s;`, "13"},
+	}
+
+	for _, test := range tests {
+		result, err := runVM([]byte(test.code))
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", test.code, err)
+		}
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input on the VM: %q", test.code)
+		}
+
+		if !testLiteralObject(t, runInterpreter([]byte(test.code)), test.expected) {
+			t.Errorf("failing input on the tree-walker: %q", test.code)
+		}
+	}
+
+	for _, input := range []string{`break;`, `continue;`, `while (true) { function f() { break; } }`} {
+		if _, err := runVM([]byte(input)); err == nil || !strings.Contains(err.Error(), "not within loop") {
+			t.Errorf("Expected %q not to compile, got=%v", input, err)
+		}
+	}
+}
+
+func TestForInClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{`var fs = {}; for (var x in [1, 2, 3]) { function g() { return x; } fs[x] = g; } fs[1]() + fs[3]();`, float64(4)},
+		{`var fs = {}; for (let i, x in ["a", "b"]) { function g() { return "${i}${x}"; } fs[i] = g; } fs[0]() + fs[1]();`, "0a1b"},
+		{`var fs = {}; for (var x in [1, 2]) { for (var y in [10, 20]) { function g() { return x + y; } fs[x + y] = g; } } fs[21]() + fs[12]();`, float64(33)},
+		{`function f() { var fs = {}; for (var x in [1, 2, 3]) { function g() { return x; } fs[x] = g; } return fs[1](); } f();`, float64(1)},
+		{`var x = "outer"; for (var x in [1]) {} x;`, "outer"},
+	}
+
+	for _, test := range tests {
+		result, err := runVM([]byte(test.code))
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", test.code, err)
+		}
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input on the VM: %q", test.code)
+		}
+
+		if !testLiteralObject(t, runInterpreter([]byte(test.code)), test.expected) {
+			t.Errorf("failing input on the tree-walker: %q", test.code)
+		}
+	}
+}
+
+func TestReturnInsideLoop(t *testing.T) {
+	tests := []vmTestCase{
+		{`function f() { for (var x in [1, 2, 3]) { if (x == 2) { return x; } } } f();`, float64(2)},
+		{`function f() { var i = 0; while (true) { i = i + 1; if (i == 3) { return i; } } } f();`, float64(3)},
+		{`function f() { for (var i = 0; i < 10; i = i + 1) { for (var x in [1, 2]) { if (i + x == 4) { return i; } } } } f();`, float64(2)},
+		{`class A { init() { this.xs = [1, 2, 3]; } find(n) { for (var x in this.xs) { if (x == n) { return this.xs[0] + x; } } } } A().find(3);`, float64(4)},
+	}
+
+	for _, test := range tests {
+		result, err := runVM([]byte(test.code))
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", test.code, err)
+		}
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input on the VM: %q", test.code)
+		}
+
+		if !testLiteralObject(t, runInterpreter([]byte(test.code)), test.expected) {
+			t.Errorf("failing input on the tree-walker: %q", test.code)
+		}
+	}
+
+	if result := runInterpreter([]byte(`for (var x in [1]) { return x; }`)); result.Type() != ErrorObj {
+		t.Errorf("Expected a return outside of a function to fail, got=%s", result.Inspect())
+	}
+}
+
+func TestDivisionByZeroMessage(t *testing.T) {
+	tests := []vmTestCase{
+		{`var r; try { 1 / 0; } catch (e) { r = e.message; } r;`, "divide by zero: 1 / 0"},
+		{`var r; try { 7.5 % 0; } catch (e) { r = e.message; } r;`, "divide by zero: 7.5 % 0"},
+	}
+
+	for _, test := range tests {
+		result, err := runVM([]byte(test.code))
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", test.code, err)
+		}
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input on the VM: %q", test.code)
+		}
+
+		if !testLiteralObject(t, runInterpreter([]byte(test.code)), test.expected) {
+			t.Errorf("failing input on the tree-walker: %q", test.code)
+		}
+	}
+}
+
+func TestVMForInNotIterable(t *testing.T) {
+	if _, err := runVM([]byte(`for (var x in 12) {}`)); err == nil {
+		t.Errorf("Expected runtime error")
+	}
+}
+
+func TestVMRange(t *testing.T) {
+	tests := []vmTestCase{
+		{`var s = 0; for (var i in 0..4) { s += i; } s;`, float64(10)},
+		{`var s = 0; for (var i in 0..<4) { s += i; } s;`, float64(6)},
+		{`var s = ""; for (var i in 10..0 step -5) { s += "${i},"; } s;`, "10,5,0,"},
+		{`var s = ""; for (var i, x in 5..<7) { s += "${i}:${x} "; } s;`, "0:5 1:6 "},
+		{`len(0..<10 step 3);`, float64(4)},
+		{`(0..10 step 2)[-1];`, float64(10)},
+		{`4 in 0..10 step 2;`, true},
+		{`10 in 0..<10;`, false},
+		{`2 in [1, 2, 3];`, true},
+		{`len([1, 2]) + len("ab");`, float64(4)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMRangeErrors(t *testing.T) {
+	tests := []string{
+		`0..10 step 0;`,
+		`(0..3)[4];`,
+		`len(1);`,
+	}
+
+	for _, code := range tests {
+		if _, err := runVM([]byte(code)); err == nil {
+			t.Errorf("Expected runtime error for %q", code)
+		}
+	}
+}
+
+func TestVMMatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`match (2) { 1, 2 => "small", _ => "big" };`, "small"},
+		{`match (7) { 1, 2 => "small", _ => "big" };`, "big"},
+		{`match ("a") { "a" => 1, "b" => 2, _ => 3 };`, float64(1)},
+		{`match (-1) { -1 => "neg", _ => "other" };`, "neg"},
+		{`match (nil) { nil => "nil", _ => "other" };`, "nil"},
+		{`match ([1, 2]) { [x] => x, [x, y] => x + y, _ => 0 };`, float64(3)},
+		{`match ([1, [2, 3]]) { [_, [a, b]] => a * b, _ => 0 };`, float64(6)},
+		{`match ({"k": 5, "j": 1}) { {"k": v} => v, _ => 0 };`, float64(5)},
+		{`match ({"j": 1}) { {"k": v} => v, {} => "hash", _ => 0 };`, "hash"},
+		{`match (3) { x if x > 5 => "big", x if x > 1 => "medium", _ => "small" };`, "medium"},
+		{`match (3) { 1, x if x == 3 => "three", _ => "other" };`, "three"},
+		{`var r = 0; match (4) { 4 => { r = 40; } _ => { r = -1; } } r;`, float64(40)},
+		{`match ("a") { 1 => "one", _ => "other" };`, "other"},
+		{`var s = ""; for (var i in 0..5) { s += match (i) { 0 => "a", 1 => "b", 2 => "c", 3 => "d", _ => "?" }; } s;`, "abcd??"},
+		{`var s = ""; for (var i in -1..3) { s += match (i) { -1 => "m", 0, 1 => "z", 2 => "t", 3 => "h" }; } s;`, "mzzth"},
+		// bindings are the arm's own and leave the variables around it alone
+		{`var x = 100; match (1) { x => x }; x;`, float64(100)},
+		{`var x = 100; var r = match (1) { x => x }; r;`, float64(1)},
+		{`function f() { var x = 100; var r = match ([1, 2]) { [x, 3] => 0, [1, x] => x }; return x + r; } f();`, float64(102)},
+		{`var x = 5; match (3) { 1 => "a", 2 => "b", 4 => "c", 5 => "d", x => x }; x;`, float64(5)},
+		{`var v = 9; match ({"k": 2}) { {"k": v} if v > 1 => v, _ => 0 }; v;`, float64(9)},
+		{
+			`class Point {
+				init(x, y) {
+					this.x = x;
+					this.y = y;
+				}
+			}
+			class Other {}
+			function describe(p) {
+				return match (p) {
+					Point(0, 0) => "origin",
+					Point(x, y) if x > 0 => "right ${y}",
+					Point() => "point",
+					Other() => "other",
+					_ => "nothing"
+				};
+			}
+			describe(Point(0, 0)) + "," + describe(Point(2, 7)) + "," + describe(Point(-1, 0)) + "," + describe(Other()) + "," + describe(1);`,
+			"origin,right 7,point,other,nothing",
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMMatchNoArm(t *testing.T) {
+	tests := []string{
+		`match (3) { 1 => "one" };`,
+		`match (9) { 1 => "a", 2 => "b", 3 => "c", 4 => "d" };`,
+	}
+
+	for _, code := range tests {
+		if _, err := runVM([]byte(code)); err == nil {
+			t.Errorf("Expected runtime error for %q", code)
+		}
+	}
+}
+
+func TestMatchWarnings(t *testing.T) {
+	tests := []struct {
+		code     string
+		warnings int
+	}{
+		{`match (3) { 1 => "one" };`, 1},
+		{`match (3) { 1 => "one", _ => "other" };`, 0},
+		{`match (true) { true => 1, false => 0 };`, 0},
+	}
+
+	for _, test := range tests {
+		scanner := NewScanner([]byte(test.code))
+		scanner.scanTokens()
+		parser := NewParser(scanner.Tokens())
+		program := parser.parse()
+
+		compiler := NewCompiler()
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("compile error for %q: %s", test.code, err)
+		}
+
+		if len(compiler.Warnings().Errors) != test.warnings {
+			t.Errorf("Expected %d warnings for %q, got=%d", test.warnings, test.code, len(compiler.Warnings().Errors))
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected []string
+	}{
+		{`function f(a) { var b = 1; return 2; }`, []string{
+			"variable 'b' is never used",
+			"parameter 'a' is never used",
+		}},
+		{`function f(_a) { var _b = 1; return 2; }`, nil},
+		{`var g = 1; function f() { var c = 2; return c; }`, nil},
+		{`function f() { return 1; print(2); print(3); }`, []string{"unreachable code"}},
+		{`function f(x) { if (x) { return 1; } else { throw "no"; } x = 2; }`, []string{"unreachable code"}},
+		{`while (true) { break; print(1); }`, []string{"unreachable code"}},
+		{`var x = 1; function f() { var x = 2; return x; }`, []string{"variable 'x' shadows the one declared on line 1"}},
+		{`function f(n) { if (n) { let n = 1; return n; } return n; }`, []string{"variable 'n' shadows the one declared on line 1"}},
+		{`class A { m() { } m() { } }`, []string{"method 'm' is already defined in class A"}},
+		{`class A { static m() { } m() { } }`, []string{"method 'm' is already defined in class A"}},
+		{`function f(a, b = 1) { return a + b; } f(); f(1); f(1, 2); f(1, 2, 3);`, []string{
+			"f takes at least 1 arguments, called with 0",
+			"f takes at most 2 arguments, called with 3",
+		}},
+		{`function f(a, ...rest) { return [a, rest]; } f(1, 2, 3); f(...[1]);`, nil},
+		{`class P { init(x) { this.x = x; } } P(); P(1);`, []string{"P takes at least 1 arguments, called with 0"}},
+		{`function f(a) { return a; } f = print; f(1, 2);`, nil},
+		{`function f() { return 1; print(2); } // lox:ignore`, nil},
+		{`function f(a) { return 1; } // lox:ignore unused`, nil},
+		{`function f(a) { return 1; print(2); } // lox:ignore unused`, []string{"unreachable code"}},
+		{`match (3) { 1 => "one" }; // lox:ignore match`, nil},
+	}
+
+	for _, test := range tests {
+		scanner := NewScanner([]byte(test.code))
+		scanner.scanTokens()
+		parser := NewParser(scanner.Tokens())
+		program := parser.parse()
+
+		compiler := NewCompiler()
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("compile error for %q: %s", test.code, err)
+		}
+
+		warnings := compiler.Warnings().Errors
+		if len(warnings) != len(test.expected) {
+			t.Errorf("Expected %d warnings for %q, got=%v", len(test.expected), test.code, warnings)
+			continue
+		}
+		for idx, warning := range warnings {
+			if warning.Severity != SeverityWarning {
+				t.Errorf("Expected a warning for %q, got=%v", test.code, warning)
+			}
+			if warning.Message != test.expected[idx] {
+				t.Errorf("Expected warning %q for %q, got=%q", test.expected[idx], test.code, warning.Message)
+			}
+		}
+	}
+}
+
+func TestMatchJumpTable(t *testing.T) {
+	scanner := NewScanner([]byte(`match (2) { 0 => "a", 1 => "b", 2 => "c", 3 => "d", _ => "?" };`))
+	scanner.scanTokens()
+	parser := NewParser(scanner.Tokens())
+	program := parser.parse()
+
+	compiler := NewCompiler()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	found := false
+	for _, constant := range compiler.Constants {
+		if table, ok := constant.(*JumpTable); ok {
+			found = true
+			if table.Min != 0 || len(table.Targets) != 4 {
+				t.Errorf("Expected table over 0..3, got=%s", table.Inspect())
+			}
+		}
+	}
+
+	if !found {
+		t.Errorf("Expected a jump table for a dense match")
+	}
+}
+
+func TestVMDestructuring(t *testing.T) {
+	tests := []vmTestCase{
+		{`var [a, b] = [1, 2]; a + b;`, float64(3)},
+		{`var [a, ...rest] = [1, 2, 3]; rest[0] * 10 + rest[1];`, float64(23)},
+		{`var [a, ...rest] = [1]; len(rest);`, float64(0)},
+		{`var [a, b = 5] = [1]; a + b;`, float64(6)},
+		{`var [a, [b, c]] = [1, [2, 3]]; a + b + c;`, float64(6)},
+		{`var {name, age = 30} = {"name": "bob"}; name + " ${age}";`, "bob 30"},
+		{`var {name: n, missing: m = "none"} = {"name": "bob"}; n + m;`, "bobnone"},
+		{`var a = 1; var b = 2; [a, b] = [b, a]; a - b;`, float64(1)},
+		{`var a = 0; var b = 0; ({a, b} = {"a": 3, "b": 4}); a * b;`, float64(12)},
+		{`var arr = [0, 0]; [arr[0], arr[1]] = [5, 6]; arr[0] + arr[1];`, float64(11)},
+		{`var a = 0; var r = ([a] = [7]); len(r) + a;`, float64(8)},
+		{`function f([x, y], {z}) { return x + y + z; } f([1, 2], {"z": 3});`, float64(6)},
+		{
+			`class Point {
+				init(x, y) {
+					this.x = x;
+					this.y = y;
+				}
+			}
+			function swap(p) {
+				var {x, y} = p;
+				[p.x, p.y] = [y, x];
+				return p;
+			}
+			var p = swap(Point(1, 2));
+			"${p.x},${p.y}";`,
+			"2,1",
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMDestructuringShapeMismatch(t *testing.T) {
+	tests := []string{
+		`var [a, b] = [1];`,
+		`var [a] = [1, 2];`,
+		`var [a, b] = 5;`,
+		`var {a} = {"b": 1};`,
+		`var {a} = [1];`,
+		`function f([a, b]) { return a; } f([1, 2, 3]);`,
+	}
+
+	for _, code := range tests {
+		if _, err := runVM([]byte(code)); err == nil {
+			t.Errorf("Expected runtime error for %q", code)
+		}
+	}
+}
+
+func TestVMDefaultVariadicAndNamedArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{`function f(a, b = 2) { return a + b; } f(1);`, float64(3)},
+		{`function f(a, b = 2) { return a + b; } f(1, 5);`, float64(6)},
+		{`function f(a, b = a * 10) { return a + b; } f(2);`, float64(22)},
+		{`function f(a, ...rest) { return len(rest); } f(1, 2, 3, 4);`, float64(3)},
+		{`function f(a, ...rest) { return len(rest); } f(1);`, float64(0)},
+		{`function f(a, b, c) { return a + b + c; } f(...[1, 2, 3]);`, float64(6)},
+		{`function f(a, b, c) { return "${a}${b}${c}"; } f(1, ...[2], ...[3]);`, "123"},
+		{`function f(a, b) { return a - b; } f(b: 1, a: 5);`, float64(4)},
+		{`function f(a, b = 2, c = 3) { return "${a}${b}${c}"; } f(1, c: 9);`, "129"},
+		{`function f(a, ...rest) { return rest[1]; } f(...[1, 2, 3]);`, float64(3)},
+		{`len(...["abc"]);`, float64(3)},
+		{
+			`class Point {
+				init(x, y = 0) {
+					this.x = x;
+					this.y = y;
+				}
+				sum(extra = 0) {
+					return this.x + this.y + extra;
+				}
+			}
+			var p = Point(y: 2, x: 1);
+			p.sum() + p.sum(extra: 10);`,
+			float64(16),
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMArgumentErrors(t *testing.T) {
+	tests := []string{
+		`function f(a, b = 1) { return a; } f();`,
+		`function f(a) { return a; } f(1, 2);`,
+		`function f(a) { return a; } f(c: 1);`,
+		`function f(a) { return a; } f(1, a: 2);`,
+		`function f(a, b) { return a; } f(b: 2);`,
+		`function f(a) { return a; } f(...5);`,
+		`function f(a, ...rest) { return a; } f();`,
+		`len(x: "abc");`,
+	}
+
+	for _, code := range tests {
+		if _, err := runVM([]byte(code)); err == nil {
+			t.Errorf("Expected runtime error for %q", code)
+		}
+	}
+}
+
+func TestCompiledFunctionParameters(t *testing.T) {
+	program := createParseProgram(`function f(a, b = 1, c = 2, ...rest) { return a; }`)
+
+	compiler := NewCompiler()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	var function *CompiledFunction
+	for _, constant := range compiler.ByteCode().Constants {
+		if fn, ok := constant.(*CompiledFunction); ok {
+			function = fn
+		}
+	}
+
+	if function == nil {
+		t.Fatalf("Expected a compiled function constant")
+	}
+	if function.NumParameters != 3 || function.NumRequired != 1 || !function.Variadic {
+		t.Errorf("Expected 3 parameters, 1 required and variadic, got=%d, %d, %t", function.NumParameters, function.NumRequired, function.Variadic)
+	}
+	if strings.Join(function.ParameterNames, ",") != "a,b,c" {
+		t.Errorf("Expected parameter names a,b,c, got=%v", function.ParameterNames)
+	}
+}
+
+func TestVMExceptions(t *testing.T) {
+	tests := []vmTestCase{
+		{`var r = ""; try { throw "boom"; } catch (e) { r = e; } r;`, "boom"},
+		{`var r = ""; try { throw Error("boom"); } catch (e) { r = e.toString(); } r;`, "Error: boom"},
+		{`var r = ""; try { throw "a"; } catch (e) { r = e; } finally { r = r + "b"; } r;`, "ab"},
+		{`var r = ""; try { r = "a"; } catch (e) { r = "caught"; } finally { r = r + "b"; } r;`, "ab"},
+		{`var r = ""; try { throw "x"; } catch { r = "caught"; } r;`, "caught"},
+		{`var r; try { throw Error("outer", Error("inner")); } catch (e) { r = e.cause.message; } r;`, "inner"},
+		// built-in runtime errors are caught as Error instances
+		{`var r; try { 1 / 0; } catch (e) { r = e.toString(); } r;`, "Error: divide by zero: 1 / 0"},
+		{`var r; try { 1 - "a"; } catch (e) { r = e.message; } r;`, "unsupported types for binary operation: Float String"},
+		{`class A {} var r; try { A().missing; } catch (e) { r = "caught"; } r;`, "caught"},
+		{`var r; try { (1).x; } catch (e) { r = e.message; } r;`, "only instance have properties, got Float"},
+		{`var r; try { var n = 2; n.x = 1; } catch (e) { r = e.message; } r;`, "only instance have properties, got Float"},
+		// unwinding through frames, with the stack at the throw
+		{`function f() { throw Error("deep"); } function g() { f(); } var r; try { g(); } catch (e) { r = "${e.stack}"; } r;`, "[f, g, main]"},
+		{`function f() { [1][5]; } var r; try { f(); } catch (e) { r = "${e.stack}"; } r;`, "[f, main]"},
+		{`function f(n) { if (n == 0) { throw "bottom"; } return f(n - 1); } var r; try { f(10); } catch (e) { r = e; } r;`, "bottom"},
+		{`function f() { var a = 1; try { [1][5]; } catch (e) { return a + 10; } } f();`, float64(11)},
+		{`class A { init(x) { if (x < 0) { throw Error("negative"); } this.x = x; } } var r; try { A(-1); } catch (e) { r = e.message; } r;`, "negative"},
+		{`var r = ""; try { try { throw "a"; } finally { r = r + "1"; } } catch (e) { r = r + e; } r;`, "1a"},
+		{`var r = ""; try { try { throw "a"; } catch (e) { throw e + "b"; } finally { r = r + "1"; } } catch (e) { r = r + e; } r;`, "1ab"},
+		{`var log = ""; function f() { try { return "r"; } finally { log = "f"; } } var r = f(); log + r;`, "fr"},
+		{`function f() { try { return 1; } finally { return 2; } } f();`, float64(2)},
+		{`var sum = 0; for (var i in [1, 2, 3]) { try { if (i == 2) { throw i; } sum = sum + i; } catch (e) { sum = sum + 100; } } sum;`, float64(104)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMUncaughtExceptions(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`throw "boom";`, "uncaught boom"},
+		{`throw Error("boom");`, "uncaught Error: boom"},
+		{`function f() { throw Error("x"); } try { f(); } finally { }`, "uncaught Error: x"},
+		{`try { throw "a"; } catch (e) { throw "b"; }`, "uncaught b"},
+		{`try { 1 / 0; } finally { }`, "uncaught Error: divide by zero: 1 / 0"},
+		{`1 / 0;`, "divide by zero: 1 / 0"},
+	}
+
+	for _, test := range tests {
+		_, err := runVM([]byte(test.code))
+		if err == nil {
+			t.Errorf("Expected runtime error for %q", test.code)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("Expected error %q, got=%q", test.expected, err.Error())
+		}
+	}
+}
+
+func TestVMGenerators(t *testing.T) {
+	tests := []vmTestCase{
+		{`function* count(n) { var i = 0; while (i < n) { yield i; i = i + 1; } } var s = 0; for (var x in count(5)) { s = s + x; } s;`, float64(10)},
+		{`function* nat() { var i = 0; while (true) { yield i; i = i + 1; } } var g = nat(); g.next(); g.next(); g.next();`, float64(2)},
+		{`function* echo() { var got = yield "first"; yield "got ${got}"; } var g = echo(); g.next(); g.next("x");`, "got x"},
+		{`function* two() { yield 1; yield 2; } var g = two(); g.next(); g.next(); "${g.next()}";`, "nill"},
+		{`function* g(a, b = 5) { yield a + b; } g(1).next();`, float64(6)},
+		{`function* g() { yield 1; yield 2; } var s = ""; for (var k, v in g()) { s = s + "${k}:${v} "; } s;`, "0:1 1:2 "},
+		{`function* inner() { yield 1; yield 2; } function* outer() { for (var x in inner()) { yield x * 10; } yield 99; } var s = 0; for (var v in outer()) { s = s + v; } s;`, float64(129)},
+		{
+			`function* each(xs) { for (var x in xs) { yield x * 2; } }
+			class Bag {
+				init() { this.items = [1, 2, 3]; }
+				iterator() { return each(this.items); }
+			}
+			var s = 0;
+			for (var v in Bag()) { s = s + v; }
+			s;`,
+			float64(12),
+		},
+		// handlers set up inside a generator survive its suspension
+		{`function* g() { try { yield 1; throw "x"; } catch (e) { yield "caught ${e}"; } } var gen = g(); gen.next(); gen.next();`, "caught x"},
+		{`function* bad() { yield 1; throw Error("inside"); } var g = bad(); g.next(); var r; try { g.next(); } catch (e) { r = e.message; } "${r} ${g.next()}";`, "inside nill"},
+		// an endless generator is stopped by leaving the loop
+		{`function* nat() { var n = 0; while (true) { yield n; n = n + 1; } } var s = 0; for (var v in nat()) { if (v > 3) { break; } s = s + v; } s;`, float64(6)},
+		{`class Bag { init(items) { this.items = items; } *each(scale) { for (var x in this.items) { yield x * scale; } } } var s = 0; for (var v in Bag([1, 2, 3]).each(10)) { s = s + v; } s;`, float64(60)},
+		{`class Bag { init() { this.items = [4, 5]; } *iterator() { for (var x in this.items) { yield x; } } } var s = ""; for (var v in Bag()) { s = s + "${v}"; } s;`, "45"},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMFibers(t *testing.T) {
+	tests := []vmTestCase{
+		{`function body(a) { var b = Fiber.yield(a + 1); return b * 2; } var f = Fiber(body); var x = f.resume(1); var y = f.resume(10); "${x} ${y} ${f.isDone}";`, "2 20 true"},
+		{`function body() { var i = 0; while (true) { Fiber.yield(i); i = i + 1; } } var f = Fiber(body); var s = 0; var n = 0; while (n < 100) { s = s + f.resume(); n = n + 1; } s;`, float64(4950)},
+		{`function body() { Fiber.yield(); } var f = Fiber(body); f.resume(); f.isDone;`, false},
+		{`function body() { return 1; } var f = Fiber(body); "${f.resume()} ${f.error}";`, "1 nill"},
+		{
+			`function inner() { Fiber.yield(1); Fiber.yield(2); }
+			function outer() { var g = Fiber(inner); var s = g.resume() + g.resume(); Fiber.yield(s); return "end"; }
+			var o = Fiber(outer);
+			"${o.resume()} ${o.resume()}";`,
+			"3 end",
+		},
+		// an error no handler inside the fiber catches ends up at resume
+		{`function body() { 1 / 0; } var f = Fiber(body); var r; try { f.resume(); } catch (e) { r = e.message; } "${r} ${f.isDone} ${f.error.message}";`, "divide by zero: 1 / 0 true divide by zero: 1 / 0"},
+		{`function body() { try { throw "x"; } catch (e) { Fiber.yield("caught ${e}"); } } var f = Fiber(body); f.resume();`, "caught x"},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMFiberErrors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`function body() { return 1; } var f = Fiber(body); f.resume(); f.resume();`, "cannot resume finished fiber body"},
+		{`Fiber.yield(1);`, "can't yield from the main fiber"},
+		{`var f; function body() { f.resume(); } f = Fiber(body); f.resume();`, "uncaught Error: fiber body is already running"},
+		{`function body() { throw "bad"; } var f = Fiber(body); f.resume();`, "uncaught bad"},
+		{`Fiber(1);`, "argument to `Fiber` must be a function, got Float"},
+	}
+
+	for _, test := range tests {
+		_, err := runVM([]byte(test.code))
+		if err == nil {
+			t.Errorf("Expected runtime error for %q", test.code)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("Expected error %q, got=%q", test.expected, err.Error())
+		}
+	}
+}
+
+func TestVMThreads(t *testing.T) {
+	tests := []vmTestCase{
+		{`function square(x) { return x * x; } var t = spawn square(7); t.join();`, float64(49)},
+		{
+			`function produce(ch, n) { var i = 0; while (i < n) { ch.send(i); i = i + 1; } ch.close(); }
+			var ch = Channel();
+			spawn produce(ch, 5);
+			var s = 0;
+			var i = 0;
+			while (i < 5) { s = s + ch.recv(); i = i + 1; }
+			"${s} ${ch.recv()}";`,
+			"10 nill",
+		},
+		{
+			`var wg = WaitGroup();
+			var out = Channel(4);
+			function work(i, wg, out) { out.send(i * 2); wg.done(); }
+			var i = 0;
+			while (i < 4) { wg.add(); spawn work(i, wg, out); i = i + 1; }
+			wg.wait();
+			var s = 0;
+			i = 0;
+			while (i < 4) { s = s + out.recv(); i = i + 1; }
+			s;`,
+			float64(12),
+		},
+		// threads get copies of mutable values and globals
+		{`var xs = [0]; function bump(a) { a[0] = 99; return a[0]; } var t = spawn bump(xs); "${t.join()} ${xs[0]}";`, "99 0"},
+		{`var g = 1; function set() { g = 5; return g; } var t = spawn set(); "${t.join()} ${g}";`, "5 1"},
+		{`class P { init(x) { this.x = x; } } function f(p) { p.x = 2; return p.x; } var p = P(1); var t = spawn f(p); "${t.join()} ${p.x}";`, "2 1"},
+		{`var ch = Channel(1); var xs = [1]; ch.send(xs); xs[0] = 2; ch.recv()[0];`, float64(1)},
+		// an error that ends a thread is thrown again by join
+		{`function boom() { 1 / 0; } var t = spawn boom(); var r; try { t.join(); } catch (e) { r = e.message; } r;`, "divide by zero: 1 / 0"},
+		{`var a = Channel(1); var b = Channel(1); b.send("hi"); var r; select { var x = a.recv() => { r = "a"; } var y = b.recv() => { r = y; } } r;`, "hi"},
+		{`var a = Channel(); var r; select { a.recv() => { r = "got"; } _ => { r = "none"; } } r;`, "none"},
+		{`var a = Channel(1); var r; select { a.send(3) => { r = a.recv(); } } r;`, float64(3)},
+		{`function f() { var a = Channel(1); a.send(2); var r; select { var x = a.recv() => { r = x; } } return r; } f();`, float64(2)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMThreadErrors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`function f() { return 1; } var fb = Fiber(f); function g(x) { return x; } spawn g(fb);`, "can't share Fiber between threads"},
+		{`var x = 1; spawn len(x);`, "can only spawn a function, got Builtin"},
+		{`var c = Channel(); c.close(); c.send(1);`, "send on closed channel"},
+		{`var c = Channel(); c.close(); c.close();`, "channel is already closed"},
+		{`var wg = WaitGroup(); wg.done();`, "negative wait group counter"},
+		{`Channel(-1);`, "argument to `Channel` must be a non-negative integer, got -1"},
+		{`var c = 1; select { c.recv() => { } }`, "select needs a channel, got Float"},
+	}
+
+	for _, test := range tests {
+		_, err := runVM([]byte(test.code))
+		if err == nil {
+			t.Errorf("Expected runtime error for %q", test.code)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("Expected error %q, got=%q", test.expected, err.Error())
+		}
+	}
+}
+
+func TestVMAsync(t *testing.T) {
+	tests := []vmTestCase{
+		{`async function add(a, b) { return a + b; } await add(1, 2);`, float64(3)},
+		{`async function double(x) { await sleep(5); return x * 2; } var p = double(4); await p;`, float64(8)},
+		{`async function inner() { return 5; } async function outer() { var x = await inner(); return x + 1; } await outer();`, float64(6)},
+		{`await 3;`, float64(3)},
+		{`var p = Promise(); async function waiter() { return await p; } var w = waiter(); p.resolve(7); await w;`, float64(7)},
+		// the loop resumes whichever sleeper wakes first
+		{`var log = ""; async function task(name, ms) { await sleep(ms); log = log + name; } task("a", 20); task("b", 1); await sleep(40); log;`, "ba"},
+		{`async function after(x, ms) { await sleep(ms); return x; } var r = await all([after(1, 10), after(2, 1), 3]); "${r[0]} ${r[1]} ${r[2]}";`, "1 2 3"},
+		{`var r = await all([]); len(r);`, float64(0)},
+		{`async function after(x, ms) { await sleep(ms); return x; } await race([after("slow", 30), after("fast", 1)]);`, "fast"},
+		// errors thrown by async calls reach whoever awaits them
+		{`async function bad() { await sleep(1); throw Error("nope"); } var r; try { await bad(); } catch (e) { r = e.message; } r;`, "nope"},
+		{`async function f() { 1 / 0; } var r; try { await f(); } catch (e) { r = e.message; } r;`, "divide by zero: 1 / 0"},
+		{`async function ok() { return 1; } async function bad() { throw "x"; } var r; try { await all([ok(), bad()]); } catch (e) { r = e; } r;`, "x"},
+		{`var r; try { await Promise(); } catch (e) { r = e.message; } r;`, "await never resolved in main"},
+		// the loop finishes queued calls after the main code is done
+		{`var p = Promise(); async function f() { p.resolve(1); } f(); 2;`, float64(2)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMAsyncErrors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`await Promise();`, "await never resolved in main"},
+		{`async function stuck() { await Promise(); } stuck();`, "await never resolved in stuck"},
+		{`async function f() { throw "x"; } f();`, "unhandled rejection: x"},
+		{`sleep("a");`, "argument to `sleep` must be a non-negative number, got a"},
+		{`all(1);`, "argument to `all` must be an array, got Float"},
+		{`var p = Promise(); p.resolve(1, 2);`, "wrong number of arguments: want=0 to 1, got=2"},
+	}
+
+	for _, test := range tests {
+		_, err := runVM([]byte(test.code))
+		if err == nil {
+			t.Errorf("Expected runtime error for %q", test.code)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("Expected error %q, got=%q", test.expected, err.Error())
+		}
+	}
+}
+
+// writeModules writes each file under dir, creating the directories in
+// its path.
+func writeModules(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVMModules(t *testing.T) {
+	dir := t.TempDir()
+	writeModules(t, dir, map[string]string{
+		"math.lox": `export var PI = 3;
+			export function square(x) { return x * x; }
+			var hidden = 1;`,
+		"counter.lox": `export var runs = 0;
+			runs = runs + 1;
+			export function bump() { runs = runs + 1; return runs; }`,
+		"user.lox":       `import "counter" as c; export var seen = c.runs;`,
+		"lib/util.lox":   `import { twice } from "helper.lox"; export function four() { return twice(2); }`,
+		"lib/helper.lox": `export function twice(x) { return x * 2; }`,
+		"shapes.lox":     `export class Box { init(size) { this.size = size; } area() { return this.size * this.size; } }`,
+		"errors.lox":     `export function fail() { throw Error("bad"); }`,
+		"vendor/pkg.lox": `export var name = "pkg";`,
+	})
+	t.Setenv(ModulePathEnv, filepath.Join(dir, "vendor"))
+
+	tests := []vmTestCase{
+		{`import "math.lox" as m; m.square(m.PI);`, float64(9)},
+		{`import { square, PI } from "math"; square(PI) + 1;`, float64(10)},
+		{`import "counter" as a; import "counter" as b; a.runs + b.runs;`, float64(2)},
+		{`import "counter" as c; import "user" as u; u.seen + c.runs;`, float64(2)},
+		{`import "counter" as c; c.bump(); c.bump(); c.runs;`, float64(3)},
+		{`import { four } from "lib/util"; four();`, float64(4)},
+		{`import { Box } from "shapes"; Box(3).area();`, float64(9)},
+		{`import "errors" as e; var r = ""; try { e.fail(); } catch (err) { r = err.toString(); } r;`, "Error: bad"},
+		{`import { name } from "pkg"; name;`, "pkg"},
+	}
+
+	for _, test := range tests {
+		result, err := runVMFile([]byte(test.code), filepath.Join(dir, "main.lox"))
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", test.code, err)
+		}
+
+		if !testLiteralObject(t, result, test.expected) {
+			t.Errorf("failing input: %q", test.code)
+		}
+	}
+}
+
+func TestVMModuleErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeModules(t, dir, map[string]string{
+		"a.lox":    `import "b" as b; export var x = 1;`,
+		"b.lox":    `import "c" as c;`,
+		"c.lox":    `import "a" as a;`,
+		"self.lox": `import "self" as s;`,
+		"math.lox": `export var PI = 3; var hidden = 1;`,
+	})
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`import "a" as a;`, "in module a: in module b: in module c: import cycle: a.lox -> b.lox -> c.lox -> a.lox"},
+		{`import "self" as s;`, "in module self: import cycle: self.lox -> self.lox"},
+		{`import { hidden } from "math";`, "module math has no export hidden"},
+		{`import "math" as m; m.hidden;`, "module math has no export hidden"},
+		{`import "missing" as m;`, `module "missing.lox" not found`},
+	}
+
+	for _, test := range tests {
+		_, err := runVMFile([]byte(test.code), filepath.Join(dir, "main.lox"))
+		if err == nil {
+			t.Errorf("Expected error for %q", test.code)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("Expected error %q, got=%q", test.expected, err.Error())
+		}
+	}
+}
+
+func TestVMLetAndConst(t *testing.T) {
+	tests := []vmTestCase{
+		{`const a = 2; a * 3;`, float64(6)},
+		{`let x = 1; var r = 0; if (true) { let x = 2; r = x; } r + x;`, float64(3)},
+		{`function f() { let x = 1; if (true) { let x = 2; x = x + 1; } return x; } f();`, float64(1)},
+		{`var s = 0; for (let i = 0; i < 3; i = i + 1) { s = s + i; } for (let i = 0; i < 2; i = i + 1) { s = s + i; } s;`, float64(4)},
+		{`var s = 0; for (const v in [1, 2, 3]) { s = s + v; } s;`, float64(6)},
+		{`function f() { const [a, b] = [1, 2]; return a + b; } f();`, float64(3)},
+		{`if (true) { let x = 1; } if (true) { let x = 2; var r = x; } r;`, float64(2)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMConstErrors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`const a = 1; a = 2;`, "Can't assign to constant: a"},
+		{`const a = 1; a += 2;`, "Can't assign to constant: a"},
+		{`const a = 1; a++;`, "Can't assign to constant: a"},
+		{`function f() { const a = 1; function g() { a = 2; } }`, "Can't assign to constant: a"},
+		{`const [a, b] = [1, 2]; [a, b] = [3, 4];`, "Can't assign to constant: a"},
+		{`for (const i = 0; i < 3; i++) { }`, "Can't assign to constant: i"},
+		{`let a = 1; let a = 2;`, "Already variable with this name in this scope: a"},
+		{`if (true) { let a = 1; var a = 2; }`, "Already variable with this name in this scope: a"},
+		{`if (true) { let a = 1; } a;`, "Undefined variable: a"},
+		{`x = 1;`, "Undeclared identifier: x"},
+	}
+
+	for _, test := range tests {
+		_, err := runVM([]byte(test.code))
+		if err == nil {
+			t.Errorf("Expected error for %q", test.code)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("Expected error %q, got=%q", test.expected, err.Error())
+		}
+	}
+}
+
+func TestVMAssertions(t *testing.T) {
+	tests := []vmTestCase{
+		{`assert(1 < 2); assertEqual([1, {"a": nil}], [1, {"a": nil}]); "passed";`, "passed"},
+		{`var r; try { assertEqual(1 + 1, 3, "sum"); } catch (e) { r = e.message; } r;`, "sum: expected 3, got 2"},
+		{`function f() { throw Error("boom"); } assertThrows(f).message;`, "boom"},
+		{`function f() { } var r; try { assertThrows(f); } catch (e) { r = e.message; } r;`, "no error was thrown: assertion failed"},
+		// a test block is only compiled by "jlox test"
+		{`var r = 1; test "skipped" { r = 2; } r;`, float64(1)},
+	}
+
+	runVMTests(t, tests)
+
+	for code, expected := range map[string]string{
+		`assert(nil);`:                           "assertion failed",
+		`assert(false, "x is set");`:             "x is set: assertion failed",
+		`assertEqual("a", 1);`:                   "expected 1, got a",
+		`assertEqual([1, 2], [1]);`:              "expected [1], got [1, 2]",
+		`test "a" { } test "a" { }`:              `Test "a" is already declared`,
+		`function f() { } assertThrows(f, "f");`: "f: assertion failed",
+	} {
+		_, err := runVM([]byte(code))
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q for %q, got=%v", expected, code, err)
+		}
+	}
+}
+
+func TestVMInheritance(t *testing.T) {
+	tests := []vmTestCase{
+		{`class A { hi() { return "A"; } } class B extends A {} B().hi();`, "A"},
+		{`class A { hi() { return "A"; } who() { return this.hi(); } } class B extends A { hi() { return "B" + super.hi(); } } B().who();`, "BA"},
+		{`class A { init(x) { this.x = x; } } class B extends A { init(x) { super.init(x * 2); } } B(2).x;`, float64(4)},
+		{`class A { hi() { return "A"; } } class B extends A {} class C extends B { hi() { return "C" + super.hi(); } } C().hi();`, "CA"},
+		// errors thrown by subclasses of Error are Errors too
+		{`class MyErr extends Error {} var r; try { throw MyErr("boom"); } catch (e) { r = e.message; } r;`, "boom"},
+		{`class MyErr extends Error {} var r; try { throw MyErr("boom"); } catch (e) { r = "${e.stack}"; } r;`, "[main]"},
+		{`class MyErr extends Error { init(code) { super.init("code ${code}"); this.code = code; } } var r; try { throw MyErr(7); } catch (e) { r = e.toString(); } r;`, "Error: code 7"},
+		{`class MyErr extends Error {} match (MyErr("x")) { Error() => "error", _ => "other" };`, "error"},
+	}
+
+	runVMTests(t, tests)
+
+	for code, expected := range map[string]string{
+		`var A = 1; class B extends A {}`:                                           "Superclass must be a class.",
+		`class A { hi() { return super.hi(); } }`:                                   "Can't use 'super' in a class with no superclass.",
+		`class A {} class B extends A { f() { return super.missing(); } } B().f();`: "Undefined property missing",
+	} {
+		_, err := runVM([]byte(code))
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q for %q, got=%v", expected, code, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import "reflect"
+
+// Walk calls fn for node and then, as long as fn returns true for a node,
+// for each node below it. Expressions inside destructuring and match
+// patterns, such as defaults and literals, are walked too.
+func Walk(node Node, fn func(Node) bool) {
+	if isNilNode(node) || !fn(node) {
+		return
+	}
+
+	walk := func(nodes ...Node) {
+		for _, n := range nodes {
+			Walk(n, fn)
+		}
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, stmt := range node.Statements {
+			walk(stmt)
+		}
+	case *BlockStatement:
+		for _, stmt := range node.Statements {
+			walk(stmt)
+		}
+	case *ExpressionStatement:
+		walk(node.Expression)
+	case *VarStatement:
+		walk(node.Identifier, node.Expression)
+	case *DestructureStatement:
+		walkDestructure(node.Pattern, fn)
+		walk(node.Value)
+	case *FunctionDeclaration:
+		walkFunction(&node.FunctionCommon, fn)
+	case *MethodDeclaration:
+		walkFunction(&node.FunctionCommon, fn)
+	case *FunctionLiteral:
+		walkFunction(&node.FunctionCommon, fn)
+	case *ClassStatement:
+		walk(node.Name, node.SuperClass)
+		for _, method := range node.Methods {
+			walk(method)
+		}
+	case *ExportStatement:
+		walk(node.Declaration)
+	case *TestStatement:
+		walk(node.Body)
+	case *ImportStatement:
+		walk(node.Alias)
+		for _, name := range node.Names {
+			walk(name)
+		}
+	case *IfStatement:
+		walk(node.Condition, node.ThenBranch, node.ElseBranch)
+	case *While:
+		walk(node.Condition, node.Body)
+	case *For:
+		walk(node.Initializer, node.Condition, node.Increment, node.Body)
+	case *ForInStatement:
+		walk(node.Key, node.Value, node.Iterable, node.Body)
+	case *ReturnStatement:
+		walk(node.ReturnValue)
+	case *ThrowStatement:
+		walk(node.Value)
+	case *TryStatement:
+		walk(node.Body, node.CatchName, node.Catch, node.Finally)
+	case *SelectStatement:
+		for _, c := range node.Cases {
+			walk(c.Name, c.Channel, c.Value, c.Body)
+		}
+		walk(node.Default)
+	case *Assignment:
+		walk(&node.Identifier, node.Expression)
+	case *CompoundAssignment:
+		walk(node.Target, node.Value)
+	case *UpdateExpression:
+		walk(node.Target)
+	case *DestructureAssignment:
+		walkDestructure(node.Pattern, fn)
+		walk(node.Value)
+	case *Binary:
+		walk(node.Left, node.Right)
+	case *Logical:
+		walk(node.Left, node.Right)
+	case *Unary:
+		walk(node.Right)
+	case *GroupedExpression:
+		walk(node.Expression)
+	case *TernaryExpression:
+		walk(node.Condition, node.ThenBranch, node.ElseBranch)
+	case *CallExpression:
+		walk(node.Callee)
+		for _, arg := range node.Arguments {
+			walk(arg)
+		}
+		for _, named := range node.Named {
+			walk(named.Name, named.Value)
+		}
+	case *SpreadExpression:
+		walk(node.Value)
+	case *SpawnExpression:
+		walk(node.Call)
+	case *AwaitExpression:
+		walk(node.Value)
+	case *YieldExpression:
+		walk(node.Value)
+	case *GetExpression:
+		walk(node.Object)
+	case *SetExpression:
+		walk(node.Object, node.Value)
+	case *IndexExpression:
+		walk(node.Left, node.Index)
+	case *SliceExpression:
+		walk(node.Left, node.Start, node.End)
+	case *SetIndexExpression:
+		walk(node.Left, node.Index, node.Value)
+	case *SetSliceExpression:
+		walk(node.Left, node.Start, node.End, node.Value)
+	case *RangeExpression:
+		walk(node.Start, node.End, node.Step)
+	case *ArrayLiteral:
+		for _, element := range node.Elements {
+			walk(element)
+		}
+	case *HashLiteral:
+		for _, key := range node.Keys {
+			walk(key, node.Pairs[key])
+		}
+	case *InterpolationExpression:
+		for _, part := range node.Parts {
+			walk(part)
+		}
+	case *MatchExpression:
+		walk(node.Subject)
+		for _, arm := range node.Arms {
+			for _, pattern := range arm.Patterns {
+				walkPattern(pattern, fn)
+			}
+			walk(arm.Guard, arm.Body, arm.Block)
+		}
+	}
+}
+
+func walkFunction(fun *FunctionCommon, fn func(Node) bool) {
+	Walk(fun.Name, fn)
+	for idx, param := range fun.Params {
+		Walk(param, fn)
+		if idx < len(fun.Defaults) {
+			Walk(fun.Defaults[idx], fn)
+		}
+	}
+	Walk(fun.Rest, fn)
+	Walk(fun.Body, fn)
+}
+
+func walkDestructure(pattern Destructure, fn func(Node) bool) {
+	var elements []*DestructureElement
+	var rest Expression
+	switch pattern := pattern.(type) {
+	case *ArrayDestructure:
+		elements = pattern.Elements
+		rest = pattern.Rest
+	case *HashDestructure:
+		elements = pattern.Entries
+	}
+
+	for _, element := range elements {
+		if element.Pattern != nil {
+			walkDestructure(element.Pattern, fn)
+		} else {
+			Walk(element.Target, fn)
+		}
+		Walk(element.Default, fn)
+	}
+	Walk(rest, fn)
+}
+
+func walkPattern(pattern Pattern, fn func(Node) bool) {
+	switch pattern := pattern.(type) {
+	case *LiteralPattern:
+		Walk(pattern.Value, fn)
+	case *BindingPattern:
+		Walk(pattern.Name, fn)
+	case *ArrayPattern:
+		for _, element := range pattern.Elements {
+			walkPattern(element, fn)
+		}
+	case *HashPattern:
+		for idx, value := range pattern.Values {
+			Walk(pattern.Keys[idx], fn)
+			walkPattern(value, fn)
+		}
+	case *ClassPattern:
+		Walk(pattern.Class, fn)
+		for _, field := range pattern.Fields {
+			walkPattern(field, fn)
+		}
+	}
+}
+
+// isNilNode reports whether node is nil, or a nil pointer in a Node, as
+// optional parts of the tree such as a missing else branch are.
+func isNilNode(node Node) bool {
+	if node == nil {
+		return true
+	}
+	value := reflect.ValueOf(node)
+	return value.Kind() == reflect.Ptr && value.IsNil()
+}
+
+// nodeToken returns the token a node starts with, or was named after,
+// for reporting where it is.
+func nodeToken(node Node) Token {
+	if isNilNode(node) {
+		return Token{}
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(node))
+	if value.Kind() != reflect.Struct {
+		return Token{}
+	}
+	if field := value.FieldByName("Token"); field.IsValid() {
+		if token, ok := field.Interface().(Token); ok {
+			return token
+		}
+	}
+	return Token{}
+}